@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AccessLogConfig logs one line per request, in logfmt or JSON. File is
+// appended to; empty or "-" logs to standard error.
+type AccessLogConfig struct {
+	Format string `json:"format"` // "logfmt" (default) or "json"
+	File   string `json:"file"`
+}
+
+// accessEntry is one request in the access log
+type accessEntry struct {
+	Time       string  `json:"time"`
+	ID         string  `json:"id,omitempty"`
+	Remote     string  `json:"remote"`
+	Method     string  `json:"method"`
+	Path       string  `json:"path"`
+	Status     int     `json:"status"`
+	Bytes      int64   `json:"bytes"`
+	DurationMS float64 `json:"durationMs"`
+}
+
+// logfmt writes the entry as key=value pairs, quoting values that need it
+func (e accessEntry) logfmt() string {
+	pairs := []struct{ key, value string }{
+		{"time", e.Time},
+		{"id", e.ID},
+		{"remote", e.Remote},
+		{"method", e.Method},
+		{"path", e.Path},
+		{"status", strconv.Itoa(e.Status)},
+		{"bytes", strconv.FormatInt(e.Bytes, 10)},
+		{"duration_ms", strconv.FormatFloat(e.DurationMS, 'f', 3, 64)},
+	}
+	var b strings.Builder
+	for i, p := range pairs {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		value := p.value
+		if value == "" || strings.ContainsAny(value, " \"=\\") || strings.ContainsFunc(value, func(r rune) bool { return r < ' ' }) {
+			value = strconv.Quote(value)
+		}
+		b.WriteString(p.key + "=" + value)
+	}
+	return b.String()
+}
+
+// statusRecorder remembers the status and size of a response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if s.status == 0 {
+		s.status = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	n, err := s.ResponseWriter.Write(b)
+	s.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// withAccessLog logs every request h serves as the config says
+func withAccessLog(c *AccessLogConfig, h http.Handler) (http.Handler, error) {
+	var out io.Writer = os.Stderr
+	if c.File != "" && c.File != "-" {
+		f, err := os.OpenFile(c.File, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("access log: %w", err)
+		}
+		out = f
+	}
+
+	var mu sync.Mutex
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		defer func() {
+			if rec.status == 0 {
+				rec.status = http.StatusOK
+			}
+			e := accessEntry{
+				Time:       start.UTC().Format(time.RFC3339Nano),
+				ID:         requestID(r.Context()),
+				Remote:     r.RemoteAddr,
+				Method:     r.Method,
+				Path:       r.URL.RequestURI(),
+				Status:     rec.status,
+				Bytes:      rec.bytes,
+				DurationMS: float64(time.Since(start).Microseconds()) / 1000,
+			}
+			var line []byte
+			if c.Format == "json" {
+				line, _ = json.Marshal(e)
+			} else {
+				line = []byte(e.logfmt())
+			}
+			mu.Lock()
+			out.Write(append(line, '\n'))
+			mu.Unlock()
+		}()
+		h.ServeHTTP(rec, r)
+	}), nil
+}
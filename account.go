@@ -0,0 +1,480 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// AccountArchive is what a reader saved on one instance of the reader, to
+// take to another: as a ZIP of one JSON file for each kind, named like the
+// data files, or as one JSON document of them all
+type AccountArchive struct {
+	Manifest    ArchiveManifest        `json:"manifest"`
+	Bookmarks   []Bookmark             `json:"bookmarks"`
+	Favorites   []Favorite             `json:"favorites"`
+	Tags        map[string][]string    `json:"tags"` // by text
+	Vocabulary  []Lookup               `json:"vocab"`
+	Lookups     []Lookup               `json:"lookups"`
+	Reviews     map[string]*ReviewCard `json:"reviews"`
+	Reading     map[string]*ReadingDay `json:"reading"`
+	Settings    *UserSettings          `json:"settings,omitempty"`
+	Annotations []Annotation           `json:"annotations"` // notes and highlights
+}
+
+// ArchiveManifest tells what an archive is and where it comes from
+type ArchiveManifest struct {
+	Format   string    `json:"format"` // accountArchiveFormat
+	Version  int       `json:"version"`
+	User     string    `json:"user,omitempty"`
+	Site     string    `json:"site"`
+	Exported time.Time `json:"exported"`
+}
+
+// AccountImport counts what an import added
+type AccountImport struct {
+	Bookmarks   int  `json:"bookmarks"`
+	Favorites   int  `json:"favorites"`
+	Tagged      int  `json:"tagged"` // texts given tags they did not have
+	Vocabulary  int  `json:"vocab"`
+	Lookups     int  `json:"lookups"`
+	Reviews     int  `json:"reviews"`
+	Days        int  `json:"days"` // of the reading log
+	Annotations int  `json:"annotations"`
+	Settings    bool `json:"settings"`
+}
+
+const (
+	accountArchiveFormat  = "palireader-account"
+	accountArchiveVersion = 1
+	// maxAccountArchive caps the size of an archive to import, and eight
+	// times as much that of a file unpacked from it
+	maxAccountArchive = 32 << 20
+)
+
+// annotationIDPattern matches the IDs the server gives annotations
+var annotationIDPattern = regexp.MustCompile(`^[0-9a-f]{24}$`)
+
+// archivePart is a file of the ZIP archive and the value it holds
+type archivePart struct {
+	name  string
+	value any
+}
+
+func (a *AccountArchive) parts() []archivePart {
+	return []archivePart{
+		{"manifest.json", &a.Manifest},
+		{"bookmarks.json", &a.Bookmarks},
+		{"favorites.json", &a.Favorites},
+		{"tags.json", &a.Tags},
+		{"vocab.json", &a.Vocabulary},
+		{"lookups.json", &a.Lookups},
+		{"review.json", &a.Reviews},
+		{"reading.json", &a.Reading},
+		{"settings.json", &a.Settings},
+		{"annotations.json", &a.Annotations},
+	}
+}
+
+// exportAccount gathers what the reader of the request saved. Without logins
+// the annotations are everyone's.
+func exportAccount(r *http.Request) (*AccountArchive, error) {
+	user := currentUser(r)
+	a := &AccountArchive{Manifest: ArchiveManifest{
+		Format:   accountArchiveFormat,
+		Version:  accountArchiveVersion,
+		User:     user,
+		Site:     baseURL(r),
+		Exported: time.Now().UTC(),
+	}}
+	reads := []error{
+		bookmarks.of(r).Read(func(v *[]Bookmark) { a.Bookmarks = slices.Clone(*v) }),
+		favorites.of(r).Read(func(v *[]Favorite) { a.Favorites = slices.Clone(*v) }),
+		textTags.of(r).Read(func(v *map[string][]string) {
+			a.Tags = make(map[string][]string, len(*v))
+			for name, tags := range *v {
+				a.Tags[name] = slices.Clone(tags)
+			}
+		}),
+		vocabulary.of(r).Read(func(v *[]Lookup) { a.Vocabulary = slices.Clone(*v) }),
+		lookups.of(r).Read(func(v *[]Lookup) { a.Lookups = slices.Clone(*v) }),
+		reviews.of(r).Read(func(v *map[string]*ReviewCard) {
+			a.Reviews = make(map[string]*ReviewCard, len(*v))
+			for id, c := range *v {
+				copied := *c
+				a.Reviews[id] = &copied
+			}
+		}),
+		readingLog.of(r).Read(func(v *map[string]*ReadingDay) {
+			// A copy, since the days are shared with the log
+			data, _ := json.Marshal(*v)
+			json.Unmarshal(data, &a.Reading)
+		}),
+		readSettings(r, func(s *UserSettings) {
+			if !s.empty() {
+				copied := *s
+				a.Settings = &copied
+			}
+		}),
+		annotations.Read(func(v *[]Annotation) {
+			for _, an := range *v {
+				if config.Auth == nil || an.Creator == user {
+					a.Annotations = append(a.Annotations, an)
+				}
+			}
+		}),
+	}
+	return a, errors.Join(reads...)
+}
+
+// writeZip writes the archive as a ZIP of its parts
+func (a *AccountArchive) writeZip(w io.Writer) error {
+	zw := zip.NewWriter(w)
+	for _, p := range a.parts() {
+		f, err := zw.CreateHeader(&zip.FileHeader{Name: p.name, Method: zip.Deflate, Modified: a.Manifest.Exported})
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(p.value); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// readAccountArchive reads an archive written by writeZip, or its JSON
+// document
+func readAccountArchive(data []byte) (*AccountArchive, error) {
+	a := &AccountArchive{}
+	if !bytes.HasPrefix(data, []byte("PK")) {
+		if err := json.Unmarshal(data, a); err != nil {
+			return nil, fmt.Errorf("neither a ZIP nor a JSON archive: %w", err)
+		}
+	} else {
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range a.parts() {
+			f, err := zr.Open(p.name)
+			if err != nil {
+				// Parts left out are empty
+				continue
+			}
+			content, err := io.ReadAll(io.LimitReader(f, 8*maxAccountArchive+1))
+			f.Close()
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", p.name, err)
+			}
+			if len(content) > 8*maxAccountArchive {
+				return nil, fmt.Errorf("%s is too large", p.name)
+			}
+			if err := json.Unmarshal(content, p.value); err != nil {
+				return nil, fmt.Errorf("%s: %w", p.name, err)
+			}
+		}
+	}
+	if a.Manifest.Format != accountArchiveFormat {
+		return nil, errors.New("not an archive of a Pali Reader account")
+	}
+	if a.Manifest.Version > accountArchiveVersion {
+		return nil, fmt.Errorf("the archive is of version %d, newer than this reader's %d", a.Manifest.Version, accountArchiveVersion)
+	}
+	return a, nil
+}
+
+// importAccount adds what an archive holds to what the reader saved, leaving out
+// what is there already, so that importing an archive twice adds nothing
+// the second time. Review cards keep the schedule reviewed last, reading
+// days the longest time on each page, and settings those set here; known
+// words are added to.
+func importAccount(r *http.Request, a *AccountArchive) (AccountImport, error) {
+	user := currentUser(r)
+	var added AccountImport
+	var errs []error
+
+	errs = append(errs, bookmarks.of(r).Update(func(list *[]Bookmark) error {
+		added.Bookmarks = 0
+		have := make(map[string]bool)
+		for _, b := range *list {
+			have[b.Path+"#"+b.Anchor] = true
+		}
+		for _, b := range a.Bookmarks {
+			if name, err := corpusName(b.Path); err != nil || b.Path == "" || name != b.Path {
+				continue
+			}
+			if key := b.Path + "#" + b.Anchor; !have[key] {
+				have[key] = true
+				*list = append(*list, b)
+				added.Bookmarks++
+			}
+		}
+		return nil
+	}))
+
+	errs = append(errs, favorites.of(r).Update(func(list *[]Favorite) error {
+		added.Favorites = 0
+		for _, f := range a.Favorites {
+			if f.Path != "" && len(*list) < maxFavorites && !slices.ContainsFunc(*list, func(have Favorite) bool { return have.Path == f.Path }) {
+				f.Title = ""
+				*list = append(*list, f)
+				added.Favorites++
+			}
+		}
+		return nil
+	}))
+
+	errs = append(errs, textTags.of(r).Update(func(all *map[string][]string) error {
+		added.Tagged = 0
+		if *all == nil {
+			*all = make(map[string][]string)
+		}
+		for name, tags := range a.Tags {
+			if _, err := corpusName(name); err != nil || name == "" {
+				continue
+			}
+			have, tagged := (*all)[name]
+			if !tagged && len(*all) >= maxTaggedTexts {
+				continue
+			}
+			merged := cleanTags(strings.Join(append(slices.Clone(have), tags...), ","))
+			if len(merged) > len(have) {
+				(*all)[name] = merged
+				added.Tagged++
+			}
+		}
+		return nil
+	}))
+
+	// Words are cleaned and capped as when looked up or saved
+	mergeWords := func(words *userFile[[]Lookup], from []Lookup, limit int, count *int) error {
+		return words.of(r).Update(func(list *[]Lookup) error {
+			*count = 0
+			key := func(l Lookup) string {
+				return l.Word + "\x00" + l.Source + "\x00" + l.Time.UTC().Format(time.RFC3339Nano)
+			}
+			have := make(map[string]bool)
+			for _, l := range *list {
+				have[key(l)] = true
+			}
+			for _, l := range from {
+				l.Word = cleanWord(l.Word)
+				if p, _, _ := strings.Cut(l.Source, "#"); p != "" {
+					if name, err := corpusName(p); err != nil || name != p {
+						l.Source = ""
+					}
+				}
+				if !containsLetter(l.Word) || have[key(l)] || len(*list) >= limit {
+					continue
+				}
+				have[key(l)] = true
+				*list = append(*list, l)
+				(*count)++
+			}
+			return nil
+		})
+	}
+	errs = append(errs, mergeWords(vocabulary, a.Vocabulary, maxVocabulary, &added.Vocabulary))
+	errs = append(errs, mergeWords(lookups, a.Lookups, maxLookups, &added.Lookups))
+
+	errs = append(errs, reviews.of(r).Update(func(cards *map[string]*ReviewCard) error {
+		added.Reviews = 0
+		if *cards == nil {
+			*cards = make(map[string]*ReviewCard)
+		}
+		for _, c := range a.Reviews {
+			if c == nil || c.Word == "" {
+				continue
+			}
+			if old := (*cards)[c.ID()]; old == nil || c.Reviewed.After(old.Reviewed) {
+				copied := *c
+				(*cards)[c.ID()] = &copied
+				added.Reviews++
+			}
+		}
+		return nil
+	}))
+
+	errs = append(errs, readingLog.of(r).Update(func(days *map[string]*ReadingDay) error {
+		added.Days = 0
+		if *days == nil {
+			*days = make(map[string]*ReadingDay)
+		}
+		for key, day := range a.Reading {
+			if day == nil {
+				continue
+			}
+			if (*days)[key] == nil {
+				(*days)[key] = &ReadingDay{Texts: make(map[string]map[int]*PageReading)}
+				added.Days++
+			}
+			mine := (*days)[key]
+			if mine.Texts == nil {
+				mine.Texts = make(map[string]map[int]*PageReading)
+			}
+			for text, pages := range day.Texts {
+				if mine.Texts[text] == nil {
+					mine.Texts[text] = make(map[int]*PageReading)
+				}
+				for page, read := range pages {
+					if read == nil {
+						continue
+					}
+					if old := mine.Texts[text][page]; old == nil || read.Seconds > old.Seconds {
+						copied := *read
+						mine.Texts[text][page] = &copied
+					}
+				}
+			}
+		}
+		return nil
+	}))
+
+	if in := a.Settings; in != nil {
+		errs = append(errs, updateSettings(r, func(s *UserSettings) {
+			before := *s
+			if s.CSS == "" {
+				s.CSS = sanitizeCSS(in.CSS)
+			}
+			if s.Anusvara == "" && validAnusvaraSign(in.Anusvara) {
+				s.Anusvara, s.ClassNasals = in.Anusvara, in.ClassNasals
+			}
+			if s.KnownTop == 0 {
+				s.KnownTop = max(in.KnownTop, 0)
+			}
+			s.Known = knownWordList(strings.Join(append(slices.Clone(s.Known), in.Known...), "\n"))
+			added.Settings = s.CSS != before.CSS || s.Anusvara != before.Anusvara ||
+				s.ClassNasals != before.ClassNasals || s.KnownTop != before.KnownTop ||
+				!slices.Equal(s.Known, before.Known)
+		}))
+	}
+
+	// Annotations need a login where logins are configured, as when made
+	if config.Auth == nil || user != "" {
+		errs = append(errs, annotations.Update(func(all *[]Annotation) error {
+			added.Annotations = 0
+			have := make(map[string]bool)
+			for _, an := range *all {
+				have[an.ID] = true
+			}
+			for _, an := range a.Annotations {
+				if _, err := corpusName(an.Path); err != nil || an.Path == "" || have[an.ID] {
+					continue
+				}
+				if !annotationIDPattern.MatchString(an.ID) {
+					id := make([]byte, 12)
+					rand.Read(id)
+					an.ID = hex.EncodeToString(id)
+				}
+				an.Creator = user
+				have[an.ID] = true
+				*all = append(*all, an)
+				added.Annotations++
+			}
+			return nil
+		}))
+	}
+	return added, errors.Join(errs...)
+}
+
+// handleAccountExport downloads what the reader saved as a ZIP archive, or
+// with ?format=json as one JSON document
+func handleAccountExport(w http.ResponseWriter, r *http.Request) {
+	if err := takeQuota(w, r, "export"); err != nil {
+		httpError(w, r, err.Error(), http.StatusTooManyRequests)
+		return
+	}
+	user := currentUser(r)
+	a, err := exportAccount(r)
+	if err != nil {
+		refundQuota(r, "export")
+		logf(r.Context(), "Error exporting the data of %q: %v", user, err)
+		httpError(w, r, "Cannot export your data", http.StatusInternalServerError)
+		return
+	}
+	name := "palireader-" + a.Manifest.Exported.Format(time.DateOnly)
+	if user != "" {
+		name = "palireader-" + userDirName(user) + "-" + a.Manifest.Exported.Format(time.DateOnly)
+	}
+	w.Header().Set("Cache-Control", "private, no-store")
+	if r.URL.Query().Get("format") == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name + ".json"}))
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(a)
+		return
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name + ".zip"}))
+	if err := a.writeZip(w); err != nil {
+		logf(r.Context(), "Error writing the archive of %q: %v", user, err)
+	}
+}
+
+// handleAccountImport adds an archive made by /account/export, here or on
+// another instance, to what the reader saved: uploaded from the settings
+// page as the "archive" field of a form, or sent as the request body by a
+// script, which gets what was added as JSON
+func handleAccountImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeAPIError(w, r, http.StatusMethodNotAllowed, "Method not allowed", map[string]string{"allow": http.MethodPost})
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxAccountArchive)
+	form := strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data")
+	var body io.Reader = r.Body
+	if form {
+		f, _, err := r.FormFile("archive")
+		if err != nil {
+			httpError(w, r, "Choose an archive to import", http.StatusBadRequest)
+			return
+		}
+		defer f.Close()
+		body = f
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		writeAPIError(w, r, http.StatusRequestEntityTooLarge, "The archive is too large", nil)
+		return
+	}
+	a, err := readAccountArchive(data)
+	if err != nil {
+		if form {
+			httpError(w, r, "Cannot read the archive: "+err.Error(), http.StatusBadRequest)
+		} else {
+			writeAPIError(w, r, http.StatusBadRequest, "Cannot read the archive: "+err.Error(), nil)
+		}
+		return
+	}
+
+	user := currentUser(r)
+	added, err := importAccount(r, a)
+	if err != nil {
+		logf(r.Context(), "Error importing the data of %q: %v", user, err)
+		writeAPIError(w, r, http.StatusInternalServerError, "Cannot import the archive", nil)
+		return
+	}
+	if !form {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(added)
+		return
+	}
+	showSettings(w, r, PageData{Title: "Settings", Notice: fmt.Sprintf(
+		"Imported %d bookmarks, %d favorites, tags of %d texts, %d saved words, %d lookups, %d review cards, %d days of reading and %d annotations.",
+		added.Bookmarks, added.Favorites, added.Tagged, added.Vocabulary, added.Lookups, added.Reviews, added.Days, added.Annotations)})
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestImportAccount(t *testing.T) {
+	useConfig(t, Config{DataDir: t.TempDir()})
+	savedSettings, savedAnnotations := settings, annotations
+	settings = &jsonFile[map[string]*UserSettings]{name: "settings.json"}
+	annotations = &jsonFile[[]Annotation]{name: "annotations.json"}
+	t.Cleanup(func() { settings, annotations = savedSettings, savedAnnotations })
+
+	r := httptest.NewRequest(http.MethodPost, "/account/import", nil)
+	r = r.WithContext(context.WithValue(r.Context(), visitorKey{}, "0123456789abcdef"))
+	archive := &AccountArchive{
+		Bookmarks: []Bookmark{
+			{Path: "gretil/x.htm", Anchor: "p1"},
+			{Path: "../outside.htm"},
+			{Path: "gretil/../x.htm"},
+			{Path: ""},
+		},
+		Lookups: []Lookup{
+			{Word: "'Dhammo'", Source: "gretil/x.htm#p1"},
+			{Word: "dhamma", Source: "../outside.htm"},
+			{Word: "123"},
+		},
+		Settings: &UserSettings{Known: []string{"ca"}},
+	}
+
+	added, err := importAccount(r, archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added.Bookmarks != 1 {
+		t.Errorf("bookmarks added = %d; want only the one in the corpus", added.Bookmarks)
+	}
+	if added.Lookups != 2 || !added.Settings {
+		t.Errorf("added %d lookups, settings %t; want 2 and true", added.Lookups, added.Settings)
+	}
+	lookups.of(r).Read(func(list *[]Lookup) {
+		for _, l := range *list {
+			if l.Word != "dhammo" && l.Word != "dhamma" || l.Source == "../outside.htm" {
+				t.Errorf("imported lookup %+v", l)
+			}
+		}
+	})
+
+	// Importing twice changes nothing, and says so
+	added, err = importAccount(r, archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added != (AccountImport{}) {
+		t.Errorf("second import added %+v; want nothing", added)
+	}
+}
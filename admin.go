@@ -0,0 +1,431 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+)
+
+// maxUpload bounds the texts sent to the admin area at once
+const maxUpload = 64 << 20
+
+// AdminPage is a corpus folder as the admin area shows it
+type AdminPage struct {
+	Crumbs  []Breadcrumb // from the top of the corpus down to the folder
+	Entries []AdminEntry
+	IsAdmin bool // whether the user may announce the folder as published and invite people to it
+	Jobs    bool // whether the user may run jobs over the whole corpus
+	Invites []Invitation
+}
+
+// AdminEntry is a file or folder in the admin area
+type AdminEntry struct {
+	Name  string
+	Path  string
+	IsDir bool
+	Size  int64
+}
+
+// handleAdmin shows a corpus folder with forms to upload texts to it, to
+// create, rename and delete its entries, to announce it as published and to
+// re-index the corpus (GET), or does one of those (POST). Editors manage
+// the folders their role covers; announcing and jobs are for admins.
+func handleAdmin(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	folder, err := corpusName(strings.TrimPrefix(r.URL.Path, "/admin/"))
+	if err != nil {
+		httpError(w, r, "Invalid path", http.StatusBadRequest)
+		return
+	}
+	if !hasRole(user, roleEditor, folder) {
+		httpError(w, r, "Only editors and admins can manage these texts", http.StatusForbidden)
+		return
+	}
+	if info, err := fs.Stat(corpus, folder); err != nil || !info.IsDir() {
+		httpError(w, r, "Folder not found", http.StatusNotFound)
+		return
+	}
+
+	current := folder
+	if current == "." {
+		current = ""
+	}
+	data := PageData{
+		Title:       "Manage texts",
+		CurrentPath: current,
+	}
+
+	if r.Method == http.MethodPost {
+		r.Body = http.MaxBytesReader(w, r.Body, maxUpload)
+		done, err := adminAction(r, folder)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			data.Notice = err.Error()
+		} else {
+			logf(r.Context(), "%s: %s", user, done)
+			data.Notice = done
+		}
+	}
+
+	page := &AdminPage{
+		IsAdmin: hasRole(user, roleAdmin, folder),
+		Jobs:    hasRole(user, roleAdmin, "."),
+	}
+	if page.IsAdmin {
+		page.Invites = activeInvitations(current)
+	}
+	if current != "" {
+		for i, part := range strings.Split(current, "/") {
+			p := part
+			if i > 0 {
+				p = page.Crumbs[i-1].Path + "/" + part
+			}
+			page.Crumbs = append(page.Crumbs, Breadcrumb{Name: part, Path: p})
+		}
+	}
+	entries, err := fs.ReadDir(corpus, folder)
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		entry := AdminEntry{Name: e.Name(), Path: path.Join(current, e.Name()), IsDir: e.IsDir()}
+		if info, err := e.Info(); err == nil && !e.IsDir() {
+			entry.Size = info.Size()
+		}
+		page.Entries = append(page.Entries, entry)
+	}
+	data.Admin = page
+
+	err = templatesFor(r).ExecuteTemplate(w, "admin", data)
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// adminAction does what an admin form posted to folder asks, returning
+// what was done
+func adminAction(r *http.Request, folder string) (string, error) {
+	if err := r.ParseMultipartForm(maxUpload); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		return "", err
+	}
+	user := currentUser(r)
+
+	// Announcing and jobs change no texts
+	switch r.FormValue("action") {
+	case "publish":
+		if !hasRole(user, roleAdmin, folder) {
+			return "", errors.New("only admins can announce publications")
+		}
+		note := strings.TrimSpace(r.FormValue("note"))
+		event := CorpusEvent{Time: time.Now().UTC(), Kind: "published", Path: folder, User: user, Note: note}
+		if err := recordCorpusEvents(event); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Announced %s as published", humanizePath(folder)), nil
+
+	case "invite", "revoke":
+		if !hasRole(user, roleAdmin, folder) {
+			return "", errors.New("only admins can invite people")
+		}
+		current := folder
+		if current == "." {
+			current = ""
+		}
+		if r.FormValue("action") == "revoke" {
+			if err := revokeInvitation(current, r.FormValue("token")); err != nil {
+				return "", err
+			}
+			return "Revoked the invitation", nil
+		}
+		link, err := invite(r, current, user)
+		if err != nil {
+			return "", err
+		}
+		return "Invitation link: " + link, nil
+
+	case "reindex":
+		if !hasRole(user, roleAdmin, ".") {
+			return "", errors.New("only admins of the whole corpus can re-index it")
+		}
+		reindexCorpus()
+		return "Re-indexing the corpus; searches use the old index until it is done", nil
+	}
+
+	dir, err := diskPath(folder)
+	if err != nil {
+		return "", errors.New("texts can only be managed inside a library")
+	}
+	switch r.FormValue("action") {
+	case "upload":
+		if r.MultipartForm == nil || len(r.MultipartForm.File["files"]) == 0 {
+			return "", errors.New("choose the texts to upload")
+		}
+		replace := r.FormValue("replace") != ""
+		// Check every text first, so that a bad one uploads nothing
+		type upload struct {
+			name string
+			data []byte
+		}
+		var uploads []upload
+		for _, fh := range r.MultipartForm.File["files"] {
+			name, err := entryName(fh.Filename)
+			if err != nil {
+				return "", err
+			}
+			if !strings.HasSuffix(strings.ToLower(name), ".htm") {
+				return "", fmt.Errorf("%s: only .htm texts can be uploaded", name)
+			}
+			f, err := fh.Open()
+			if err != nil {
+				return "", err
+			}
+			data, err := io.ReadAll(f)
+			f.Close()
+			if err != nil {
+				return "", err
+			}
+			if err := checkText(data); err != nil {
+				return "", fmt.Errorf("%s: %w", name, err)
+			}
+			if _, err := os.Lstat(filepath.Join(dir, name)); err == nil && !replace {
+				return "", fmt.Errorf("%s already exists; tick Replace to overwrite it", name)
+			}
+			uploads = append(uploads, upload{name, data})
+		}
+		var names []string
+		for _, u := range uploads {
+			file := filepath.Join(dir, u.name)
+			if err := writeFileAtomic(file, u.data); err != nil {
+				return "", err
+			}
+			if err := os.Chmod(file, 0o644); err != nil {
+				return "", err
+			}
+			corpusChangeLog.attribute(path.Join(folder, u.name), user)
+			names = append(names, u.name)
+		}
+		return fmt.Sprintf("Uploaded %s to %s", strings.Join(names, ", "), humanizePath(folder)), nil
+
+	case "mkdir":
+		name, err := entryName(r.FormValue("name"))
+		if err != nil {
+			return "", err
+		}
+		if err := os.Mkdir(filepath.Join(dir, name), 0o755); err != nil {
+			if errors.Is(err, fs.ErrExist) {
+				return "", fmt.Errorf("%s already exists", name)
+			}
+			return "", err
+		}
+		return fmt.Sprintf("Created the folder %s", name), nil
+
+	case "rename":
+		from, err := entryName(r.FormValue("entry"))
+		if err != nil {
+			return "", err
+		}
+		to, err := entryName(r.FormValue("to"))
+		if err != nil {
+			return "", err
+		}
+		info, err := os.Lstat(filepath.Join(dir, from))
+		if err != nil {
+			return "", fmt.Errorf("%s does not exist", from)
+		}
+		if !info.IsDir() && !strings.HasSuffix(strings.ToLower(to), ".htm") {
+			return "", fmt.Errorf("%s: texts keep the .htm extension", to)
+		}
+		if _, err := os.Lstat(filepath.Join(dir, to)); err == nil {
+			return "", fmt.Errorf("%s already exists", to)
+		}
+		corpusChangeLog.attribute(path.Join(folder, from), user)
+		corpusChangeLog.attribute(path.Join(folder, to), user)
+		if err := os.Rename(filepath.Join(dir, from), filepath.Join(dir, to)); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Renamed %s to %s", from, to), nil
+
+	case "delete":
+		name, err := entryName(r.FormValue("entry"))
+		if err != nil {
+			return "", err
+		}
+		corpusChangeLog.attribute(path.Join(folder, name), user)
+		// A folder has to be emptied first, so a slip cannot take a
+		// whole collection with it
+		if err := os.Remove(filepath.Join(dir, name)); err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return "", fmt.Errorf("%s does not exist", name)
+			}
+			if info, statErr := os.Lstat(filepath.Join(dir, name)); statErr == nil && info.IsDir() {
+				return "", fmt.Errorf("%s is not empty", name)
+			}
+			return "", err
+		}
+		return fmt.Sprintf("Deleted %s", name), nil
+	}
+	return "", errors.New("unknown action")
+}
+
+// entryName checks a file or folder name given in a form
+func entryName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || name == "." || name == ".." || strings.HasPrefix(name, ".") ||
+		strings.ContainsAny(name, `/\`) || !utf8.ValidString(name) ||
+		strings.IndexFunc(name, unicode.IsControl) >= 0 {
+		return "", fmt.Errorf("%q is not a valid name", name)
+	}
+	return name, nil
+}
+
+// voidElements have no end tag
+var voidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true, "hr": true,
+	"img": true, "input": true, "link": true, "meta": true, "source": true,
+	"track": true, "wbr": true,
+}
+
+// unsafeElements are the elements texts cannot hold: those that run or
+// embed scripts, and those that send the reader elsewhere, change where
+// the page's links go or restyle the site around the text
+var unsafeElements = map[string]bool{
+	"script": true, "iframe": true, "frame": true, "frameset": true, "object": true,
+	"embed": true, "applet": true, "meta": true, "base": true, "form": true,
+	"link": true, "style": true, "svg": true, "math": true, "portal": true,
+}
+
+// urlAttributes are the attributes holding URLs, which may only be relative
+// or http(s)
+var urlAttributes = map[string]bool{
+	"href": true, "src": true, "srcset": true, "action": true, "formaction": true,
+	"xlink:href": true, "poster": true, "background": true, "data": true,
+	"cite": true, "longdesc": true, "lowsrc": true, "dynsrc": true, "ping": true,
+	"codebase": true, "manifest": true,
+}
+
+// safeURL reports whether a URL is relative or http(s). Browsers ignore
+// spaces and control characters in a scheme, so they are dropped first.
+func safeURL(u string) bool {
+	u = strings.Map(func(r rune) rune {
+		if r <= ' ' || unicode.IsControl(r) {
+			return -1
+		}
+		return unicode.ToLower(r)
+	}, u)
+	scheme, _, found := strings.Cut(u, ":")
+	if !found || strings.ContainsAny(scheme, "/?#") {
+		return true
+	}
+	return scheme == "http" || scheme == "https"
+}
+
+// checkText reports what keeps an uploaded text from being served: it has
+// to be UTF-8 HTML whose end tags each close an open element, and, as its
+// body is shown as it is, without scripts, links other than relative or
+// http(s) ones, or elements such as <meta>, <base>, <form> and <style>.
+// Elements left open, as GRETIL leaves its paragraphs, are fine.
+func checkText(data []byte) error {
+	if !utf8.Valid(data) {
+		return errors.New("not UTF-8")
+	}
+	if bytes.IndexByte(data, 0) >= 0 {
+		return errors.New("not a text file")
+	}
+	var open []string
+	line := 1
+	z := html.NewTokenizer(bytes.NewReader(data))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			if !errors.Is(z.Err(), io.EOF) {
+				return fmt.Errorf("line %d: %w", line, z.Err())
+			}
+			break
+		}
+		at := line
+		line += bytes.Count(z.Raw(), []byte("\n"))
+
+		switch tt {
+		case html.StartTagToken:
+			if name, _ := z.TagName(); !voidElements[string(name)] {
+				open = append(open, string(name))
+			}
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			i := len(open) - 1
+			for i >= 0 && open[i] != string(name) {
+				i--
+			}
+			if i < 0 {
+				return fmt.Errorf("line %d: </%s> closes nothing", at, name)
+			}
+			open = open[:i]
+		}
+	}
+
+	// The head is left out of pages, so only the body is read for what it
+	// may not hold, cut where pages cut it
+	start, end := bodyBounds(string(data))
+	return checkShown(data[start:end], 1+bytes.Count(data[:start], []byte("\n")))
+}
+
+// checkShown reports what the part of a text pages show may not hold, with
+// the line it starts on in the file
+func checkShown(body []byte, line int) error {
+	z := html.NewTokenizer(bytes.NewReader(body))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			if errors.Is(z.Err(), io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("line %d: %w", line, z.Err())
+		}
+		at := line
+		line += bytes.Count(z.Raw(), []byte("\n"))
+		if tt != html.StartTagToken && tt != html.SelfClosingTagToken {
+			continue
+		}
+
+		name, hasAttr := z.TagName()
+		tag := string(name)
+		if unsafeElements[tag] {
+			return fmt.Errorf("line %d: texts cannot hold <%s>", at, tag)
+		}
+		for hasAttr {
+			var key, value []byte
+			key, value, hasAttr = z.TagAttr()
+			attr := string(key)
+			switch {
+			case strings.HasPrefix(attr, "on"):
+				return fmt.Errorf("line %d: texts cannot hold scripts (%s)", at, attr)
+			case attr == "style" && unsafeCSS.Match(value):
+				return fmt.Errorf("line %d: texts cannot hold scripts (style)", at)
+			case attr == "srcset":
+				for _, candidate := range strings.Split(string(value), ",") {
+					if fields := strings.Fields(candidate); len(fields) > 0 && !safeURL(fields[0]) {
+						return fmt.Errorf("line %d: texts can only link to http(s) addresses (%s)", at, attr)
+					}
+				}
+			case urlAttributes[attr] && !safeURL(string(value)):
+				return fmt.Errorf("line %d: texts can only link to http(s) addresses (%s)", at, attr)
+			}
+		}
+	}
+}
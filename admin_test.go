@@ -0,0 +1,38 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckText(t *testing.T) {
+	tests := []struct {
+		text string
+		err  string // what the error says, "" for none
+	}{
+		{"<html><head><title>Dhp</title></head><body><p>evaṃ me sutaṃ<p>ekaṃ samayaṃ<br></body></html>", ""},
+		{"<html><head><style>p { margin: 0 }</style></head><body><p>a</p></body></html>", ""},
+		{`<body><p><a href="mn010.htm#p3">MN 10</a> <a href="https://suttacentral.net/mn10">SC</a> <img src="wheel.png"/></p></body>`, ""},
+		{"<body>\xff</body>", "not UTF-8"},
+		{"<body>a\x00b</body>", "not a text file"},
+		{"<body>\n<p>a</div>\n</body>", "line 2: </div> closes nothing"},
+		{"<body>\n\n<script>alert(1)</script></body>", "line 3: texts cannot hold <script>"},
+		{`<body><iframe src="https://example.com"></iframe></body>`, "texts cannot hold <iframe>"},
+		{`<body><img src="x.png" onerror="alert(1)"></body>`, "texts cannot hold scripts (onerror)"},
+		{`<body><p style="width: expression(alert(1))">a</p></body>`, "texts cannot hold scripts (style)"},
+		{`<body><a href="javascript:alert(1)">a</a></body>`, "texts can only link to http(s) addresses (href)"},
+		{"<body><a href=\" java\tscript:alert(1)\">a</a></body>", "texts can only link to http(s) addresses (href)"},
+		{`<body><img srcset="a.png 1x, data:x 2x"></body>`, "texts can only link to http(s) addresses (srcset)"},
+		{"<body><form><p>a</p></form></body>", "texts cannot hold <form>"},
+		{`<body><meta http-equiv="refresh" content="0; url=https://example.com"></body>`, "texts cannot hold <meta>"},
+	}
+	for _, tt := range tests {
+		err := checkText([]byte(tt.text))
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("checkText(%q) = %v; want nil", tt.text, err)
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("checkText(%q) = %v; want %q", tt.text, err, tt.err)
+		}
+	}
+}
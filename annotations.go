@@ -0,0 +1,401 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Annotation is a note or highlight on a text, made through the Web
+// Annotation Protocol. The annotation is kept as its client sent it, less
+// the properties the server sets.
+type Annotation struct {
+	ID       string                     `json:"id"`
+	Path     string                     `json:"path"` // of the text it targets
+	Creator  string                     `json:"creator,omitempty"`
+	Created  time.Time                  `json:"created"`
+	Modified time.Time                  `json:"modified"`
+	Body     map[string]json.RawMessage `json:"annotation"`
+}
+
+var annotations = &jsonFile[[]Annotation]{name: "annotations.json"}
+
+const (
+	annotationType    = `application/ld+json; profile="http://www.w3.org/ns/anno.jsonld"`
+	annotationContext = "http://www.w3.org/ns/anno.jsonld"
+
+	// annotationPageSize is how many annotations a page of the container
+	// holds
+	annotationPageSize = 100
+)
+
+// annotationServerProps are the properties of an annotation the server sets
+var annotationServerProps = []string{"@context", "id", "created", "modified", "creator"}
+
+// document returns the annotation as clients see it, under the container
+// at base
+func (a Annotation) document(base string) map[string]any {
+	doc := make(map[string]any, len(a.Body)+5)
+	for k, v := range a.Body {
+		doc[k] = v
+	}
+	doc["@context"] = annotationContext
+	doc["id"] = base + a.ID
+	doc["created"] = a.Created.Format(time.RFC3339)
+	doc["modified"] = a.Modified.Format(time.RFC3339)
+	if a.Creator != "" {
+		doc["creator"] = map[string]string{"type": "Person", "nickname": a.Creator}
+	}
+	return doc
+}
+
+// etag identifies a version of the annotation
+func (a Annotation) etag() string {
+	sum := sha256.Sum256([]byte(a.ID + a.Modified.Format(time.RFC3339Nano)))
+	return `W/"` + hex.EncodeToString(sum[:8]) + `"`
+}
+
+// handleAnnotations implements the Web Annotation Protocol: the container
+// at /annotations/ lists the annotations in pages and takes new ones, and
+// each annotation can be read, replaced and deleted at its own IRI
+func handleAnnotations(w http.ResponseWriter, r *http.Request) {
+	base := baseURL(r) + "/annotations/"
+	id := strings.TrimPrefix(r.URL.Path, "/annotations/")
+	if id == "" {
+		handleAnnotationContainer(w, r, base)
+		return
+	}
+
+	w.Header().Set("Allow", "GET, HEAD, PUT, DELETE, OPTIONS")
+	var a Annotation
+	found := false
+	err := annotations.Read(func(all *[]Annotation) {
+		for _, candidate := range *all {
+			if candidate.ID == id {
+				a, found = candidate, true
+			}
+		}
+	})
+	if err != nil {
+		writeAPIError(w, r, http.StatusInternalServerError, err.Error(), nil)
+		return
+	}
+	if !found || !canRead(r, a.Path) {
+		writeAPIError(w, r, http.StatusNotFound, "No such annotation", nil)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		w.Header().Set("Link", `<http://www.w3.org/ns/ldp#Resource>; rel="type"`)
+		w.Header().Set("ETag", a.etag())
+		writeAnnotationJSON(w, http.StatusOK, a.document(base))
+
+	case http.MethodOptions:
+		w.WriteHeader(http.StatusNoContent)
+
+	case http.MethodPut, http.MethodDelete:
+		if !mayChangeAnnotation(r, a) {
+			writeAPIError(w, r, http.StatusForbidden, "Only its creator can change an annotation", nil)
+			return
+		}
+		if match := r.Header.Get("If-Match"); match != "" && match != a.etag() && match != "*" {
+			writeAPIError(w, r, http.StatusPreconditionFailed, "The annotation has changed", nil)
+			return
+		}
+
+		var replaced Annotation
+		if r.Method == http.MethodPut {
+			body, path, err := readAnnotation(w, r)
+			if err != nil {
+				writeAPIError(w, r, http.StatusBadRequest, err.Error(), nil)
+				return
+			}
+			if sent, ok := body["id"]; ok {
+				var sentID string
+				if json.Unmarshal(sent, &sentID) != nil || sentID != base+a.ID {
+					writeAPIError(w, r, http.StatusBadRequest, "The id does not match the annotation's IRI", nil)
+					return
+				}
+			}
+			for _, k := range annotationServerProps {
+				delete(body, k)
+			}
+			replaced = a
+			replaced.Path, replaced.Body, replaced.Modified = path, body, time.Now().UTC()
+		}
+
+		err := annotations.Update(func(all *[]Annotation) error {
+			for i, candidate := range *all {
+				if candidate.ID != a.ID {
+					continue
+				}
+				if r.Method == http.MethodDelete {
+					*all = append((*all)[:i], (*all)[i+1:]...)
+				} else {
+					(*all)[i] = replaced
+				}
+				return nil
+			}
+			return fs.ErrNotExist
+		})
+		if errors.Is(err, fs.ErrNotExist) {
+			writeAPIError(w, r, http.StatusNotFound, "No such annotation", nil)
+			return
+		}
+		if err != nil {
+			logf(r.Context(), "Error saving annotation: %v", err)
+			writeAPIError(w, r, http.StatusInternalServerError, "Cannot save the annotation", nil)
+			return
+		}
+		if r.Method == http.MethodDelete {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("ETag", replaced.etag())
+		writeAnnotationJSON(w, http.StatusOK, replaced.document(base))
+
+	default:
+		writeAPIError(w, r, http.StatusMethodNotAllowed, "Method not allowed", map[string]string{"allow": w.Header().Get("Allow")})
+	}
+}
+
+// handleAnnotationContainer lists the annotations (GET), optionally only
+// those on one text with ?target=, or adds one (POST)
+func handleAnnotationContainer(w http.ResponseWriter, r *http.Request, base string) {
+	w.Header().Set("Allow", "GET, HEAD, POST, OPTIONS")
+	w.Header().Set("Accept-Post", annotationType+", application/json")
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+	case http.MethodOptions:
+		w.WriteHeader(http.StatusNoContent)
+		return
+	case http.MethodPost:
+		if config.Auth != nil && currentUser(r) == "" {
+			writeAPIError(w, r, http.StatusUnauthorized, "Log in to annotate", nil)
+			return
+		}
+		body, path, err := readAnnotation(w, r)
+		if err != nil {
+			writeAPIError(w, r, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+		for _, k := range annotationServerProps {
+			delete(body, k)
+		}
+		idBytes := make([]byte, 12)
+		rand.Read(idBytes)
+		now := time.Now().UTC()
+		a := Annotation{
+			ID:       hex.EncodeToString(idBytes),
+			Path:     path,
+			Creator:  currentUser(r),
+			Created:  now,
+			Modified: now,
+			Body:     body,
+		}
+		err = annotations.Update(func(all *[]Annotation) error {
+			*all = append(*all, a)
+			return nil
+		})
+		if err != nil {
+			logf(r.Context(), "Error saving annotation: %v", err)
+			writeAPIError(w, r, http.StatusInternalServerError, "Cannot save the annotation", nil)
+			return
+		}
+		w.Header().Set("Location", base+a.ID)
+		w.Header().Set("ETag", a.etag())
+		writeAnnotationJSON(w, http.StatusCreated, a.document(base))
+		return
+	default:
+		writeAPIError(w, r, http.StatusMethodNotAllowed, "Method not allowed", map[string]string{"allow": w.Header().Get("Allow")})
+		return
+	}
+
+	// Only the annotations on one text, for clients showing a page
+	var target string
+	if t := r.URL.Query().Get("target"); t != "" {
+		var err error
+		if target, err = annotationTextPath(t); err != nil {
+			writeAPIError(w, r, http.StatusBadRequest, err.Error(), map[string]string{"field": "target"})
+			return
+		}
+	}
+	var list []Annotation
+	err := annotations.Read(func(all *[]Annotation) {
+		for _, a := range *all {
+			if (target == "" || a.Path == target) && canRead(r, a.Path) {
+				list = append(list, a)
+			}
+		}
+	})
+	if err != nil {
+		writeAPIError(w, r, http.StatusInternalServerError, err.Error(), nil)
+		return
+	}
+
+	query := url.Values{}
+	if target != "" {
+		query.Set("target", r.URL.Query().Get("target"))
+	}
+	pageIRI := func(n int) string {
+		q := url.Values{"page": {strconv.Itoa(n)}}
+		for k, v := range query {
+			q[k] = v
+		}
+		return base + "?" + q.Encode()
+	}
+	pages := max(1, (len(list)+annotationPageSize-1)/annotationPageSize)
+	page := func(n int) map[string]any {
+		start := min(n*annotationPageSize, len(list))
+		end := min(start+annotationPageSize, len(list))
+		items := make([]map[string]any, 0, end-start)
+		for _, a := range list[start:end] {
+			items = append(items, a.document(base))
+		}
+		p := map[string]any{
+			"id":         pageIRI(n),
+			"type":       "AnnotationPage",
+			"startIndex": start,
+			"items":      items,
+		}
+		if n > 0 {
+			p["prev"] = pageIRI(n - 1)
+		}
+		if n+1 < pages {
+			p["next"] = pageIRI(n + 1)
+		}
+		return p
+	}
+
+	if s := r.URL.Query().Get("page"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 || n >= pages {
+			writeAPIError(w, r, http.StatusNotFound, "No such page", nil)
+			return
+		}
+		p := page(n)
+		p["@context"] = annotationContext
+		p["partOf"] = map[string]any{"id": base, "total": len(list)}
+		writeAnnotationJSON(w, http.StatusOK, p)
+		return
+	}
+
+	w.Header().Add("Link", `<http://www.w3.org/ns/ldp#BasicContainer>; rel="type"`)
+	w.Header().Add("Link", `<http://www.w3.org/TR/annotation-protocol/>; rel="http://www.w3.org/ns/ldp#constrainedBy"`)
+	container := map[string]any{
+		"@context": []string{annotationContext, "http://www.w3.org/ns/ldp.jsonld"},
+		"id":       base,
+		"type":     []string{"BasicContainer", "AnnotationCollection"},
+		"label":    "Pali Reader annotations",
+		"total":    len(list),
+		"first":    page(0),
+		"last":     pageIRI(pages - 1),
+	}
+	if target != "" {
+		container["id"] = base + "?" + query.Encode()
+	}
+	writeAnnotationJSON(w, http.StatusOK, container)
+}
+
+// readAnnotation reads the annotation sent in a request and the corpus
+// path of the text it targets
+func readAnnotation(w http.ResponseWriter, r *http.Request) (map[string]json.RawMessage, string, error) {
+	var body map[string]json.RawMessage
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&body); err != nil {
+		return nil, "", fmt.Errorf("not an annotation: %w", err)
+	}
+	var types any
+	json.Unmarshal(body["type"], &types)
+	isAnnotation := types == "Annotation"
+	if list, ok := types.([]any); ok {
+		for _, t := range list {
+			isAnnotation = isAnnotation || t == "Annotation"
+		}
+	}
+	if !isAnnotation {
+		return nil, "", errors.New(`the type must be "Annotation"`)
+	}
+
+	// The target is an IRI, an object with a source, or a list of those;
+	// the first names the text
+	var target any
+	if err := json.Unmarshal(body["target"], &target); err != nil || target == nil {
+		return nil, "", errors.New("an annotation needs a target")
+	}
+	if list, ok := target.([]any); ok && len(list) > 0 {
+		target = list[0]
+	}
+	var iri string
+	switch t := target.(type) {
+	case string:
+		iri = t
+	case map[string]any:
+		iri, _ = t["source"].(string)
+		if iri == "" {
+			iri, _ = t["id"].(string)
+		}
+	}
+	if iri == "" {
+		return nil, "", errors.New("the target needs a source")
+	}
+	path, err := annotationTextPath(iri)
+	if err != nil {
+		return nil, "", err
+	}
+	if !canRead(r, path) {
+		return nil, "", fmt.Errorf("no text at %s", iri)
+	}
+	return body, path, nil
+}
+
+// annotationTextPath returns the corpus path of the text a reader IRI,
+// such as https://example.org/read/1_tipit/.../dighan1u.htm#p17, shows
+func annotationTextPath(iri string) (string, error) {
+	u, err := url.Parse(iri)
+	if err != nil {
+		return "", fmt.Errorf("%q is not an IRI", iri)
+	}
+	rest, ok := strings.CutPrefix(strings.TrimPrefix(u.Path, config.BasePath), "/read/")
+	if !ok {
+		return "", fmt.Errorf("%s is not a text of the reader", iri)
+	}
+	name, err := corpusName(resolveSlugs(rest))
+	if err != nil {
+		return "", err
+	}
+	if info, err := fs.Stat(corpus, name); err != nil || info.IsDir() {
+		return "", fmt.Errorf("no text at %s", iri)
+	}
+	return name, nil
+}
+
+// mayChangeAnnotation reports whether the request may replace or delete an
+// annotation: its creator and admins may, and anyone on a site without
+// logins
+func mayChangeAnnotation(r *http.Request, a Annotation) bool {
+	if config.Auth == nil {
+		return true
+	}
+	user := currentUser(r)
+	return user != "" && (user == a.Creator || hasRole(user, roleEditor, a.Path))
+}
+
+func writeAnnotationJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", annotationType)
+	w.Header().Set("Vary", "Accept")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.Encode(v)
+}
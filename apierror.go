@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// APIError is the body of every JSON error response:
+//
+//	{"error": {"code": "not_found", "message": "...", "details": ..., "requestId": "..."}}
+type APIError struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	Details   any    `json:"details,omitempty"`
+	RequestID string `json:"requestId,omitempty"`
+}
+
+// apiErrorCodes names the HTTP statuses the server answers errors with
+var apiErrorCodes = map[int]string{
+	http.StatusBadRequest:            "bad_request",
+	http.StatusUnauthorized:          "unauthorized",
+	http.StatusForbidden:             "forbidden",
+	http.StatusNotFound:              "not_found",
+	http.StatusMethodNotAllowed:      "method_not_allowed",
+	http.StatusRequestEntityTooLarge: "too_large",
+	http.StatusTooManyRequests:       "rate_limited",
+	http.StatusInternalServerError:   "internal",
+	http.StatusBadGateway:            "bad_gateway",
+	http.StatusServiceUnavailable:    "unavailable",
+}
+
+// apiErrorCode returns the error code for an HTTP status
+func apiErrorCode(status int) string {
+	if code, ok := apiErrorCodes[status]; ok {
+		return code
+	}
+	return strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_")
+}
+
+// writeAPIError replies with a JSON error envelope. API endpoints use it
+// directly; httpError uses it for clients that accept JSON.
+func writeAPIError(w http.ResponseWriter, r *http.Request, status int, message string, details any) {
+	if status >= 500 {
+		logf(r.Context(), "%s %s: %d %s", r.Method, r.URL.Path, status, message)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]APIError{"error": {
+		Code:      apiErrorCode(status),
+		Message:   message,
+		Details:   details,
+		RequestID: requestID(r.Context()),
+	}})
+}
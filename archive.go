@@ -0,0 +1,95 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"testing/fstest"
+)
+
+// archiveFormats are the archives a library can be read from, by extension,
+// with the reader unpacking their tar stream (nil for zip)
+var archiveFormats = []struct {
+	ext        string
+	decompress func(io.Reader) (io.Reader, error)
+}{
+	{".zip", nil},
+	{".tar", func(r io.Reader) (io.Reader, error) { return r, nil }},
+	{".tar.gz", func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) }},
+	{".tgz", func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) }},
+	{".tar.bz2", func(r io.Reader) (io.Reader, error) { return bzip2.NewReader(r), nil }},
+	{".tbz2", func(r io.Reader) (io.Reader, error) { return bzip2.NewReader(r), nil }},
+}
+
+// isArchive reports whether a library's dir names an archive
+func isArchive(name string) bool {
+	name = strings.ToLower(name)
+	for _, f := range archiveFormats {
+		if strings.HasSuffix(name, f.ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// openArchive opens a zip or tar archive as a read-only folder. Zip members
+// are read when asked for; a tar archive can only be read through, so its
+// files are kept in memory.
+func openArchive(name string) (fs.FS, error) {
+	lower := strings.ToLower(name)
+	for _, f := range archiveFormats {
+		if !strings.HasSuffix(lower, f.ext) {
+			continue
+		}
+		if f.decompress == nil {
+			// Left open for as long as the server runs
+			return zip.OpenReader(name)
+		}
+		return readTar(name, f.decompress)
+	}
+	return nil, fmt.Errorf("%s: not an archive", name)
+}
+
+func readTar(name string, decompress func(io.Reader) (io.Reader, error)) (fs.FS, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	r, err := decompress(bufio.NewReader(file))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	// MapFS makes up the folders from the file names
+	files := make(fstest.MapFS)
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		member := strings.TrimPrefix(path.Clean("/"+hdr.Name), "/")
+		if hdr.Typeflag != tar.TypeReg || !fs.ValidPath(member) {
+			continue
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		files[member] = &fstest.MapFile{Data: data, Mode: fs.FileMode(hdr.Mode).Perm(), ModTime: hdr.ModTime}
+	}
+	return files, nil
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"math"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AskConfig points /ask at an OpenAI-compatible chat completions endpoint
+type AskConfig struct {
+	URL         string `json:"url"`
+	Model       string `json:"model"`
+	APIKeyEnv   string `json:"apiKeyEnv"`
+	MaxPassages int    `json:"maxPassages"`
+}
+
+// askCandidateTexts is how many texts lexical retrieval reads passages from
+const askCandidateTexts = 5
+
+// maxPassageChars caps the length of each passage sent to the model
+const maxPassageChars = 1500
+
+// CitedPassage is a corpus passage handed to the model, numbered for citation
+type CitedPassage struct {
+	Number    int
+	Path      string
+	Paragraph int
+	Text      string
+}
+
+// Anchor returns the reader anchor of the passage
+func (p CitedPassage) Anchor() string {
+	return fmt.Sprintf("p%d", p.Paragraph)
+}
+
+var askClient = &http.Client{Timeout: 3 * time.Minute}
+
+func handleAsk(w http.ResponseWriter, r *http.Request) {
+	question := strings.TrimSpace(r.FormValue("q"))
+	data := PageData{
+		Title: "Ask",
+		Query: question,
+	}
+
+	var quotaErr error
+	if question != "" {
+		quotaErr = takeQuota(w, r, "ask")
+	}
+	if quotaErr != nil {
+		w.WriteHeader(http.StatusTooManyRequests)
+		data.Notice = quotaErr.Error()
+	} else if question != "" {
+		passages, err := retrievePassages(r.Context(), question, config.Ask.MaxPassages)
+		passages = slices.DeleteFunc(passages, func(p CitedPassage) bool {
+			return !canRead(r, p.Path)
+		})
+		switch {
+		case err != nil:
+			refundQuota(r, "ask")
+			data.Notice = "Could not search the corpus: " + err.Error() + "."
+		case len(passages) == 0:
+			refundQuota(r, "ask")
+			data.Notice = "No passages in the corpus match the question."
+		default:
+			data.Passages = passages
+			answer, err := askModel(r.Context(), question, passages)
+			if err != nil {
+				refundQuota(r, "ask")
+				logf(r.Context(), "Error asking model: %v", err)
+				data.Notice = "The language model could not be reached; the retrieved passages are listed below."
+			} else {
+				data.Content = renderAnswer(answer, passages)
+			}
+		}
+	}
+
+	err := templatesFor(r).ExecuteTemplate(w, "ask", data)
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// retrievePassages finds the passages most relevant to the question, using
+// semantic search when it is available and the word index otherwise
+func retrievePassages(ctx context.Context, question string, limit int) ([]CitedPassage, error) {
+	if config.Semantic != nil && embeddings.Load() != nil {
+		results, err := semanticSearch(ctx, question, "")
+		if err == nil {
+			return citedFromResults(results, limit), nil
+		}
+		logf(ctx, "Semantic retrieval failed, falling back to the word index: %v", err)
+	}
+
+	idx := corpusIndex.Load()
+	if idx == nil {
+		return nil, errors.New("the corpus is still being indexed")
+	}
+
+	terms := make(map[string]bool)
+	forEachWord(question, func(word string) {
+		terms[word] = true
+	})
+
+	// Rank texts by how many of the question's words they contain, then
+	// pick the paragraphs with the most hits. Rare words weigh more.
+	weights := make(map[string]float64)
+	docHits := make(map[int]float64)
+	for term := range terms {
+		postings := idx.Postings[term]
+		if len(postings) == 0 {
+			continue
+		}
+		weights[term] = math.Log(1 + float64(len(idx.Paths))/float64(len(postings)))
+		for _, p := range postings {
+			docHits[p.Doc] += float64(p.Count) * weights[term]
+		}
+	}
+	docs := make([]int, 0, len(docHits))
+	for doc := range docHits {
+		docs = append(docs, doc)
+	}
+	sort.Slice(docs, func(i, j int) bool { return docHits[docs[i]] > docHits[docs[j]] })
+	if len(docs) > askCandidateTexts {
+		docs = docs[:askCandidateTexts]
+	}
+
+	type scored struct {
+		passage CitedPassage
+		score   float64
+	}
+	var candidates []scored
+	for _, doc := range docs {
+		content, err := fs.ReadFile(corpus, idx.Paths[doc])
+		if err != nil {
+			return nil, err
+		}
+		for i, text := range paragraphs(idx.Paths[doc], string(content)) {
+			score := 0.0
+			forEachWord(text, func(word string) {
+				score += weights[word]
+			})
+			if score > 0 {
+				candidates = append(candidates, scored{
+					passage: CitedPassage{Path: idx.Paths[doc], Paragraph: i, Text: truncateRunes(text, maxPassageChars)},
+					score:   score,
+				})
+			}
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].score > candidates[j].score })
+
+	var passages []CitedPassage
+	for _, c := range candidates {
+		if len(passages) == limit {
+			break
+		}
+		c.passage.Number = len(passages) + 1
+		passages = append(passages, c.passage)
+	}
+	return passages, nil
+}
+
+// citedFromResults numbers the top semantic search results
+func citedFromResults(results []SearchResult, limit int) []CitedPassage {
+	var passages []CitedPassage
+	for _, r := range results {
+		if len(passages) == limit {
+			break
+		}
+		paragraph, _ := strconv.Atoi(strings.TrimPrefix(r.Anchor, "p"))
+		passages = append(passages, CitedPassage{
+			Number:    len(passages) + 1,
+			Path:      r.Path,
+			Paragraph: paragraph,
+			Text:      r.Snippet,
+		})
+	}
+	return passages
+}
+
+// askModel sends the question and numbered passages to the chat endpoint
+func askModel(ctx context.Context, question string, passages []CitedPassage) (string, error) {
+	var prompt strings.Builder
+	prompt.WriteString("Passages from the Pali canon and its commentaries:\n\n")
+	for _, p := range passages {
+		fmt.Fprintf(&prompt, "[%d] (%s)\n%s\n\n", p.Number, citation(p.Path+"#"+p.Anchor()), p.Text)
+	}
+	prompt.WriteString("Question: ")
+	prompt.WriteString(question)
+
+	body, err := json.Marshal(map[string]any{
+		"model": config.Ask.Model,
+		"messages": []map[string]string{
+			{"role": "system", "content": "You answer questions about Pali texts using only the numbered passages provided. " +
+				"Cite every claim with the passage number in square brackets, like [2]. " +
+				"If the passages do not answer the question, say so."},
+			{"role": "user", "content": prompt.String()},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, config.Ask.URL, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if config.Ask.APIKeyEnv != "" {
+		req.Header.Set("Authorization", "Bearer "+os.Getenv(config.Ask.APIKeyEnv))
+	}
+
+	resp, err := askClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("chat request failed: %s", resp.Status)
+	}
+
+	var result struct {
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", err
+	}
+	if len(result.Choices) == 0 {
+		return "", errors.New("chat request returned no answer")
+	}
+	return result.Choices[0].Message.Content, nil
+}
+
+var citationPattern = regexp.MustCompile(`\[(\d+)\]`)
+
+// renderAnswer escapes the model's answer and turns its [n] citations into
+// links to the cited passages
+func renderAnswer(answer string, passages []CitedPassage) template.HTML {
+	var b strings.Builder
+	for _, para := range strings.Split(strings.TrimSpace(answer), "\n\n") {
+		escaped := template.HTMLEscapeString(strings.TrimSpace(para))
+		linked := citationPattern.ReplaceAllStringFunc(escaped, func(m string) string {
+			n, _ := strconv.Atoi(m[1 : len(m)-1])
+			if n < 1 || n > len(passages) {
+				return m
+			}
+			p := passages[n-1]
+			return fmt.Sprintf(`<a href="%s/read/%s#%s" class="citation">[%d]</a>`,
+				config.BasePath, template.HTMLEscapeString(slugPath(p.Path)), p.Anchor(), n)
+		})
+		b.WriteString("<p>")
+		b.WriteString(strings.ReplaceAll(linked, "\n", "<br>"))
+		b.WriteString("</p>")
+	}
+	return template.HTML(b.String())
+}
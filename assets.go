@@ -0,0 +1,119 @@
+package main
+
+import (
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// staticAsset is a stylesheet or script served under a name carrying a
+// fingerprint of its content, so browsers may keep it for good and still
+// fetch a new one as soon as it changes
+type staticAsset struct {
+	Name        string // versioned name, e.g. style.1a2b3c4d5e6f.css
+	ContentType string
+	Content     []byte
+}
+
+// staticFiles are the stylesheet and scripts, kept in static/ and built
+// into the binary
+//
+//go:embed static
+var staticFiles embed.FS
+
+// assetTypes are the content types of the assets by extension
+var assetTypes = map[string]string{
+	".css": "text/css; charset=utf-8",
+	".js":  "text/javascript; charset=utf-8",
+	".png": "image/png",
+	".svg": "image/svg+xml",
+}
+
+// staticAssets maps each asset's plain name to the asset, fingerprinted
+// when the server starts
+var staticAssets = fingerprintAssets(loadAssets())
+
+// loadAssets reads the files of static/, but the service worker, which is
+// served from /sw.js, and draws the icons of the web app manifest
+func loadAssets() map[string]staticAsset {
+	entries, err := staticFiles.ReadDir("static")
+	if err != nil {
+		panic(err)
+	}
+	assets := make(map[string]staticAsset)
+	for _, e := range entries {
+		if e.IsDir() || e.Name() == "sw.js" {
+			continue
+		}
+		content, err := staticFiles.ReadFile("static/" + e.Name())
+		if err != nil {
+			panic(err)
+		}
+		assets[e.Name()] = staticAsset{ContentType: assetTypes[path.Ext(e.Name())], Content: content}
+	}
+	assets["icon-192.png"] = staticAsset{ContentType: "image/png", Content: wheelIcon(192)}
+	assets["icon-512.png"] = staticAsset{ContentType: "image/png", Content: wheelIcon(512)}
+	return assets
+}
+
+// fingerprintAssets names every asset after its plain name and the start
+// of its content's SHA-256
+func fingerprintAssets(assets map[string]staticAsset) map[string]staticAsset {
+	for name, a := range assets {
+		sum := sha256.Sum256(a.Content)
+		ext := path.Ext(name)
+		a.Name = strings.TrimSuffix(name, ext) + "." + hex.EncodeToString(sum[:6]) + ext
+		assets[name] = a
+	}
+	return assets
+}
+
+// assetPath returns the versioned URL path of an asset given by its plain
+// name
+func assetPath(name string) string {
+	if a, ok := staticAssets[name]; ok {
+		return "/static/" + a.Name
+	}
+	return "/static/" + name
+}
+
+// handleStatic serves the assets. A versioned name never changes content,
+// so it is cached for a year without revalidation; the plain name, or that
+// of an earlier version, is still served, but only for revalidation.
+func handleStatic(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/static/")
+	for plain, a := range staticAssets {
+		if name == a.Name {
+			w.Header().Set("Content-Type", a.ContentType)
+			w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+			w.Write(a.Content)
+			return
+		}
+		if name == plain || versionOf(name, plain) {
+			w.Header().Set("Content-Type", a.ContentType)
+			w.Header().Set("Cache-Control", "no-cache")
+			w.Header().Set("ETag", `"`+a.Name+`"`)
+			if r.Header.Get("If-None-Match") == `"`+a.Name+`"` {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Write(a.Content)
+			return
+		}
+	}
+	http.NotFound(w, r)
+}
+
+// versionOf reports whether name is a versioned name of the asset plain,
+// e.g. style.0123456789ab.css for style.css
+func versionOf(name, plain string) bool {
+	ext := path.Ext(plain)
+	base := strings.TrimSuffix(plain, ext) + "."
+	if !strings.HasPrefix(name, base) || !strings.HasSuffix(name, ext) {
+		return false
+	}
+	return len(name) == len(base)+12+len(ext)
+}
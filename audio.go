@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// audioType returns the content type of a recording by its name, and
+// false for a file that is not one
+func audioType(name string) (string, bool) {
+	ext := strings.TrimPrefix(strings.ToLower(path.Ext(name)), ".")
+	if ext == "m4a" {
+		return "audio/mp4", true
+	}
+	t, ok := audioTypes[ext]
+	return t, ok
+}
+
+// attachAudio gives each text of a folder the recordings beside it: those
+// named like the text but for the extension, such as dn1.mp3 for dn1.htm
+func attachAudio(files []*FileInfo, entries []fs.DirEntry, dir string) {
+	byStem := make(map[string][]string)
+	for _, entry := range entries {
+		if _, ok := audioType(entry.Name()); ok && !entry.IsDir() {
+			stem := strings.TrimSuffix(entry.Name(), path.Ext(entry.Name()))
+			byStem[stem] = append(byStem[stem], path.Join(dir, entry.Name()))
+		}
+	}
+	if len(byStem) == 0 {
+		return
+	}
+	for _, f := range files {
+		f.Audio = byStem[strings.TrimSuffix(f.Name, path.Ext(f.Name))]
+	}
+}
+
+// textAudio returns the recordings beside the text at filePath
+func textAudio(filePath string) []string {
+	if entry := treeEntry(filePath); entry != nil {
+		return entry.Audio
+	}
+	return nil
+}
+
+// handleAudio serves a recording from the corpus, with ranges so that the
+// player can seek
+func handleAudio(w http.ResponseWriter, r *http.Request) {
+	filePath := strings.TrimPrefix(r.URL.Path, "/audio/")
+	contentType, ok := audioType(filePath)
+	name, err := corpusName(filePath)
+	if !ok || err != nil {
+		httpError(w, r, "Invalid path", http.StatusBadRequest)
+		return
+	}
+	f, err := corpus.Open(name)
+	if err != nil {
+		httpError(w, r, "File not found", http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		httpError(w, r, "File not found", http.StatusNotFound)
+		return
+	}
+
+	// Files in archives cannot seek, so are read whole
+	content, ok := f.(io.ReadSeeker)
+	if !ok {
+		data, err := io.ReadAll(f)
+		if err != nil {
+			httpError(w, r, "Cannot read file", http.StatusInternalServerError)
+			return
+		}
+		content = bytes.NewReader(data)
+	}
+	w.Header().Set("Content-Type", contentType)
+	http.ServeContent(w, r, "", info.ModTime(), content)
+}
+
+// Cue is where the recordings of a text reach one of its paragraphs
+type Cue struct {
+	Anchor string  `json:"anchor"` // the paragraph's anchor, like p3
+	Start  float64 `json:"start"`  // seconds into the recording
+}
+
+// timingName returns the name of the timing sidecar of a text: dn1.htm is
+// timed by dn1.timing.json
+func timingName(name string) string {
+	return strings.TrimSuffix(name, path.Ext(name)) + ".timing.json"
+}
+
+// hasTiming reports whether the text has a timing sidecar
+func hasTiming(name string) bool {
+	_, err := fs.Stat(corpus, timingName(name))
+	return err == nil
+}
+
+// textTiming reads the timing sidecar of the text, a JSON object from the
+// paragraphs to the time the recordings reach them, into cues in order.
+// Paragraphs are named by their anchor (p3), number (3) or segment ID, and
+// times are seconds or like 1:02.5 or 1:02:03.
+func textTiming(name string) ([]Cue, error) {
+	data, err := fs.ReadFile(corpus, timingName(name))
+	if err != nil {
+		return nil, err
+	}
+	var timing map[string]any
+	if err := json.Unmarshal(data, &timing); err != nil {
+		return nil, fmt.Errorf("%s: %w", timingName(name), err)
+	}
+	content, err := fs.ReadFile(corpus, name)
+	if err != nil {
+		return nil, err
+	}
+	count := len(paragraphs(name, string(content)))
+	segments := make(map[string]int, count)
+	for i := range count {
+		segments[segmentID(name, i)] = i
+	}
+
+	var cues []Cue
+	for key, value := range timing {
+		paragraph, err := strconv.Atoi(strings.TrimPrefix(key, "p"))
+		if i, ok := segments[key]; ok {
+			paragraph, err = i, nil
+		}
+		if err != nil || paragraph < 0 || paragraph >= count {
+			return nil, fmt.Errorf("%s: %q is not a paragraph of the text", timingName(name), key)
+		}
+		start, err := cueTime(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s: %w", timingName(name), key, err)
+		}
+		cues = append(cues, Cue{Anchor: fmt.Sprintf("p%d", paragraph), Start: start})
+	}
+	sort.Slice(cues, func(i, j int) bool { return cues[i].Start < cues[j].Start })
+	return cues, nil
+}
+
+// cueTime reads a time of the timing sidecar: seconds, or hours, minutes
+// and seconds separated by colons
+func cueTime(value any) (float64, error) {
+	switch v := value.(type) {
+	case float64:
+		if v >= 0 {
+			return v, nil
+		}
+	case string:
+		var seconds float64
+		for part := range strings.SplitSeq(v, ":") {
+			n, err := strconv.ParseFloat(part, 64)
+			if err != nil || n < 0 {
+				return 0, fmt.Errorf("%q is not a time", v)
+			}
+			seconds = seconds*60 + n
+		}
+		return seconds, nil
+	}
+	return 0, fmt.Errorf("%v is not a time", value)
+}
+
+// handleTiming serves the cues of a text's recordings for the player
+func handleTiming(w http.ResponseWriter, r *http.Request) {
+	filePath := strings.TrimPrefix(r.URL.Path, "/timing/")
+	name, err := corpusName(filePath)
+	if err != nil {
+		httpError(w, r, "Invalid path", http.StatusBadRequest)
+		return
+	}
+	cues, err := textTiming(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		httpError(w, r, "No timing for this text", http.StatusNotFound)
+		return
+	} else if err != nil {
+		logf(r.Context(), "Error reading timing: %v", err)
+		httpError(w, r, "Cannot read the timing of this text", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cues)
+}
@@ -0,0 +1,30 @@
+package main
+
+import "testing"
+
+func TestCueTime(t *testing.T) {
+	tests := []struct {
+		value   any
+		seconds float64
+		ok      bool
+	}{
+		{3.5, 3.5, true},
+		{0.0, 0, true},
+		{"75", 75, true},
+		{"1:15", 75, true},
+		{"1:02:03.5", 3723.5, true},
+		{"00:00:07", 7, true},
+		{-1.0, 0, false},
+		{"-5", 0, false},
+		{"1:x", 0, false},
+		{"", 0, false},
+		{true, 0, false},
+		{nil, 0, false},
+	}
+	for _, tt := range tests {
+		seconds, err := cueTime(tt.value)
+		if (err == nil) != tt.ok || seconds != tt.seconds {
+			t.Errorf("cueTime(%#v) = %v, %v; want %v, ok %v", tt.value, seconds, err, tt.seconds, tt.ok)
+		}
+	}
+}
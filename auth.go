@@ -0,0 +1,314 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// AuthConfig puts the whole site, or only some folders and texts of the
+// corpus, behind a login. Passwords are plain or bcrypt hashes.
+type AuthConfig struct {
+	Users        map[string]string `json:"users"`
+	Site         bool              `json:"site"`         // require a login for every page
+	Paths        []string          `json:"paths"`        // corpus paths only logged-in readers see
+	SessionHours int               `json:"sessionHours"` // how long a login lasts
+	Admins       []string          `json:"admins"`       // users who are admins of the whole corpus
+	Roles        []RoleGrant       `json:"roles"`        // what users may do, in which folders
+	OIDC         *OIDCConfig       `json:"oidc"`         // nil allows only local logins
+}
+
+const sessionCookie = "palireader_session"
+
+// checkPassword reports whether password is the one users holds for user
+func checkPassword(users map[string]string, user, password string) bool {
+	want, ok := users[user]
+	if !ok {
+		return false
+	}
+	if strings.HasPrefix(want, "$2") {
+		return bcrypt.CompareHashAndPassword([]byte(want), []byte(password)) == nil
+	}
+	return subtle.ConstantTimeCompare([]byte(password), []byte(want)) == 1
+}
+
+// sessionKey signs session cookies. It is kept in the data directory so
+// logins survive restarts, or in the shared state store so that every
+// replica takes the others' logins.
+var sessionKey = sync.OnceValues(func() ([]byte, error) {
+	if store, err := sharedState(); err != nil {
+		return nil, err
+	} else if store != nil {
+		return sharedSessionKey(store, newSessionKey)
+	}
+	keyFile := filepath.Join(config.DataDir, "session.key")
+	data, err := os.ReadFile(keyFile)
+	if err == nil {
+		return hex.DecodeString(strings.TrimSpace(string(data)))
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	key := newSessionKey()
+	return key, writeFileAtomic(keyFile, []byte(hex.EncodeToString(key)))
+})
+
+func newSessionKey() []byte {
+	key := make([]byte, 32)
+	rand.Read(key)
+	return key
+}
+
+// sign returns payload followed by its signature with the session key
+func sign(payload string) (string, error) {
+	key, err := sessionKey()
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(payload))
+	return payload + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// verify returns the payload of a value made by sign, if its signature holds
+func verify(value string) (string, bool) {
+	key, err := sessionKey()
+	if err != nil {
+		return "", false
+	}
+	i := strings.LastIndexByte(value, '.')
+	if i < 0 {
+		return "", false
+	}
+	payload, sig := value[:i], value[i+1:]
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(payload))
+	want := base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+	return payload, hmac.Equal([]byte(sig), []byte(want))
+}
+
+// signSession returns the cookie value logging user in until expires
+func signSession(user string, expires time.Time) (string, error) {
+	return sign(base64.RawURLEncoding.EncodeToString([]byte(user)) + "." + strconv.FormatInt(expires.Unix(), 10))
+}
+
+// sessionUser returns the user a session cookie value logs in, if it is
+// valid and unexpired
+func sessionUser(value string) string {
+	payload, ok := verify(value)
+	if !ok {
+		return ""
+	}
+
+	encoded, expiry, _ := strings.Cut(payload, ".")
+	expires, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return ""
+	}
+	user, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return ""
+	}
+	// Removing a user from the config ends their sessions
+	if !knownUser(string(user)) {
+		return ""
+	}
+	return string(user)
+}
+
+// currentUser returns who made the request: the user of an API token, of
+// the session cookie, or of the basic auth header sent by scripts
+func currentUser(r *http.Request) string {
+	if config.Auth == nil {
+		return ""
+	}
+	// A request with a token is the token's user's or no one's
+	if user, ok := tokenUser(r); ok {
+		return user
+	}
+	if c, err := r.Cookie(sessionCookie); err == nil {
+		if user := sessionUser(c.Value); user != "" {
+			return user
+		}
+	}
+	if user, password, ok := r.BasicAuth(); ok && checkLogin(user, password) {
+		return user
+	}
+	return ""
+}
+
+// protectedPath reports whether the corpus path is below one of the
+// protected paths
+func protectedPath(text string) bool {
+	if config.Auth == nil {
+		return false
+	}
+	text = strings.Trim(filepath.ToSlash(text), "/")
+	for _, p := range config.Auth.Paths {
+		p = strings.Trim(p, "/")
+		if text == p || strings.HasPrefix(text, p+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// canRead reports whether the request may see the corpus path
+func canRead(r *http.Request, text string) bool {
+	return !protectedPath(text) || hasRole(currentUser(r), roleReader, text)
+}
+
+// withAuth lets only logged-in readers through to the site, or to the
+// protected paths of the corpus
+func withAuth(next http.Handler) http.Handler {
+	if config.Auth == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/login" || strings.HasPrefix(r.URL.Path, "/login/") ||
+			strings.HasPrefix(r.URL.Path, "/static/") || strings.HasPrefix(r.URL.Path, "/invite/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if checkToken(w, r) {
+			return
+		}
+
+		var text string
+		for _, prefix := range []string{"/read/", "/print/", "/glossary/", "/listen/", "/audio/", "/timing/", "/cite/", "/offline/", "/reload/", "/tree/"} {
+			if rest, ok := strings.CutPrefix(r.URL.Path, prefix); ok {
+				text = path.Clean("/" + rest)
+			}
+		}
+		admin := strings.HasPrefix(r.URL.Path, "/admin/")
+		user := currentUser(r)
+		if (config.Auth.Site || admin || protectedPath(text)) && user == "" {
+			// Browsers go to the login form, scripts get a basic auth
+			// challenge
+			if r.Method == http.MethodGet && strings.Contains(r.Header.Get("Accept"), "text/html") {
+				http.Redirect(w, r, sitePath("/login?next="+url.QueryEscape(r.URL.RequestURI())), http.StatusSeeOther)
+				return
+			}
+			w.Header().Set("WWW-Authenticate", `Basic realm="Pali Reader", charset="UTF-8"`)
+			httpError(w, r, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if protectedPath(text) && !hasRole(user, roleReader, text) {
+			httpError(w, r, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// safeNext returns where to send a reader once logged in: only ever a page
+// of this site
+func safeNext(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
+
+// handleLogin shows the login form (GET) or logs in (POST)
+func handleLogin(w http.ResponseWriter, r *http.Request) {
+	next := safeNext(r.FormValue("next"))
+	data := PageData{
+		Title: "Log in",
+		Next:  next,
+	}
+
+	if r.Method == http.MethodPost {
+		user := r.FormValue("user")
+		if checkLogin(user, r.FormValue("password")) {
+			if err := startSession(w, r, user); err != nil {
+				logf(r.Context(), "Error signing session: %v", err)
+				httpError(w, r, "Cannot log in", http.StatusInternalServerError)
+				return
+			}
+			http.Redirect(w, r, sitePath(next), http.StatusSeeOther)
+			return
+		}
+		logf(r.Context(), "Failed login for %q", user)
+		w.WriteHeader(http.StatusUnauthorized)
+		data.Notice = "Wrong user name or password."
+	}
+
+	err := templatesFor(r).ExecuteTemplate(w, "login", data)
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// startSession logs user in with a session cookie
+func startSession(w http.ResponseWriter, r *http.Request, user string) error {
+	expires := time.Now().Add(time.Duration(config.Auth.SessionHours) * time.Hour)
+	value, err := signSession(user, expires)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    value,
+		Path:     sitePath("/"),
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// handleLogout ends the session
+func handleLogout(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Path: sitePath("/"), MaxAge: -1})
+	http.Redirect(w, r, sitePath("/"), http.StatusSeeOther)
+}
+
+// hideProtected drops the protected paths the request may not see from a
+// page's listing and breadcrumb menus
+func hideProtected(r *http.Request, data *PageData) {
+	if config.Auth == nil || len(config.Auth.Paths) == 0 {
+		return
+	}
+	dropProtected(data, currentUser(r))
+}
+
+// dropProtected drops the protected paths user may not read from a page's
+// listing and breadcrumb menus
+func dropProtected(data *PageData, user string) {
+	visible := func(files []*FileInfo) []*FileInfo {
+		var kept []*FileInfo
+		for _, f := range files {
+			if !protectedPath(f.Path) || hasRole(user, roleReader, f.Path) {
+				kept = append(kept, f)
+			}
+		}
+		return kept
+	}
+	if data.Files != nil {
+		files := *data.Files
+		files.Children = visible(files.Children)
+		data.Files = &files
+	}
+	for i := range data.Breadcrumbs {
+		data.Breadcrumbs[i].Siblings = visible(data.Breadcrumbs[i].Siblings)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// useConfig serves with c as the config until the test ends
+func useConfig(t *testing.T, c Config) {
+	saved := config
+	config = c
+	t.Cleanup(func() { config = saved })
+}
+
+func TestCheckPassword(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("sīla"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := map[string]string{"ann": "plain", "bob": string(hash)}
+	tests := []struct {
+		user, password string
+		ok             bool
+	}{
+		{"ann", "plain", true},
+		{"ann", "plain ", false},
+		{"ann", "", false},
+		{"bob", "sīla", true},
+		{"bob", string(hash), false},
+		{"eve", "plain", false},
+		{"eve", "", false},
+	}
+	for _, tt := range tests {
+		if ok := checkPassword(users, tt.user, tt.password); ok != tt.ok {
+			t.Errorf("checkPassword(%q, %q) = %v; want %v", tt.user, tt.password, ok, tt.ok)
+		}
+	}
+}
+
+func TestSessionUser(t *testing.T) {
+	useConfig(t, Config{DataDir: t.TempDir(), Auth: &AuthConfig{Users: map[string]string{"ann": "a"}}})
+
+	value, err := signSession("ann", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user := sessionUser(value); user != "ann" {
+		t.Errorf("sessionUser of a fresh session = %q; want ann", user)
+	}
+
+	expired, err := signSession("ann", time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gone, err := signSession("bob", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, value := range []string{
+		"",
+		"ann",
+		expired,
+		gone,
+		value[:len(value)-1],
+		"Ym9i" + value[4:], // another user with ann's signature
+	} {
+		if user := sessionUser(value); user != "" {
+			t.Errorf("sessionUser(%q) = %q; want none", value, user)
+		}
+	}
+}
+
+func TestWithAuth(t *testing.T) {
+	useConfig(t, Config{DataDir: t.TempDir(), Auth: &AuthConfig{
+		Users: map[string]string{"ann": "a"},
+		Paths: []string{"/private/"},
+	}})
+	handler := withAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		path, user, accept string
+		code               int
+	}{
+		{"/read/public/x.htm", "", "", http.StatusOK},
+		{"/read/private", "", "", http.StatusUnauthorized},
+		{"/read/private/x.htm", "", "", http.StatusUnauthorized},
+		{"/glossary/private/x.htm", "", "", http.StatusUnauthorized},
+		{"/read/public/../private/x.htm", "", "", http.StatusUnauthorized},
+		{"/read/private/x.htm", "", "text/html", http.StatusSeeOther},
+		{"/read/private/x.htm", "ann", "", http.StatusOK},
+		{"/read/privateer/x.htm", "", "", http.StatusOK},
+		{"/login", "", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		if tt.user != "" {
+			r.SetBasicAuth(tt.user, config.Auth.Users[tt.user])
+		}
+		if tt.accept != "" {
+			r.Header.Set("Accept", tt.accept)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != tt.code {
+			t.Errorf("GET %s as %q = %d; want %d", tt.path, tt.user, w.Code, tt.code)
+		}
+	}
+
+	// A site-wide login lets nobody through but to the login form
+	config.Auth.Site = true
+	for path, code := range map[string]int{"/": http.StatusUnauthorized, "/login": http.StatusOK, "/static/style.css": http.StatusOK} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != code {
+			t.Errorf("GET %s with a site-wide login = %d; want %d", path, w.Code, code)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"path/filepath"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// AutocertConfig gets certificates from Let's Encrypt for the listeners that
+// have none of their own. HTTPAddr answers the ACME HTTP challenge and
+// redirects everything else to HTTPS.
+type AutocertConfig struct {
+	Domains  []string `json:"domains"`
+	Email    string   `json:"email"`
+	CacheDir string   `json:"cacheDir"` // defaults to autocert in the data directory
+	HTTPAddr string   `json:"httpAddr"` // defaults to ":80"
+}
+
+// useAutocert serves the TCP listeners without a certificate over HTTPS with
+// certificates from Let's Encrypt, and starts the HTTP challenge listener
+func useAutocert(a *AutocertConfig, listeners []ListenerConfig) []ListenerConfig {
+	cacheDir := a.CacheDir
+	if cacheDir == "" {
+		cacheDir = filepath.Join(config.DataDir, "autocert")
+	}
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(a.Domains...),
+		Cache:      autocert.DirCache(cacheDir),
+		Email:      a.Email,
+	}
+
+	listeners = append([]ListenerConfig(nil), listeners...)
+	for i, l := range listeners {
+		if network, _ := splitListenAddr(l.Addr); network != "unix" && l.CertFile == "" {
+			listeners[i].tlsConfig = m.TLSConfig()
+		}
+	}
+
+	httpAddr := a.HTTPAddr
+	if httpAddr == "" {
+		httpAddr = ":80"
+	}
+	go func() {
+		// Without it certificates can still be had over TLS-ALPN on :443
+		srv := &http.Server{Addr: httpAddr, Handler: m.HTTPHandler(nil)}
+		log.Println("Autocert HTTP listener:", srv.ListenAndServe())
+	}()
+	return listeners
+}
@@ -0,0 +1,434 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Bookmark is a place in the corpus kept for coming back to
+type Bookmark struct {
+	Path   string    `json:"path"`
+	Anchor string    `json:"anchor,omitempty"` // paragraph anchor such as p12
+	Title  string    `json:"title,omitempty"`
+	Source string    `json:"source,omitempty"` // the URL or DPR location it was imported from
+	Time   time.Time `json:"time"`
+}
+
+var bookmarks = &userFile[[]Bookmark]{name: "bookmarks.json"}
+
+// handleBookmarks lists the bookmarks
+func handleBookmarks(w http.ResponseWriter, r *http.Request) {
+	var list []Bookmark
+	err := bookmarks.of(r).Read(func(all *[]Bookmark) {
+		for _, b := range *all {
+			if canRead(r, b.Path) {
+				list = append(list, b)
+			}
+		}
+	})
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := PageData{
+		Title:     "Bookmarks",
+		Bookmarks: list,
+	}
+	err = templatesFor(r).ExecuteTemplate(w, "bookmarks", data)
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// bookmarkRef is a reference found in an export: a URL or DPR location,
+// with the name it was given there
+type bookmarkRef struct {
+	Ref   string
+	Title string
+}
+
+// dprLocation matches the locations of the Digital Pali Reader, as in its
+// permalinks (?loc=d.0.0.0.0.0.0.m) and bookmark exports: the set, the book,
+// then meta, volume, vagga, sutta and section indexes and the hierarchy (m
+// for mūla, a for aṭṭhakathā, t for ṭīkā).
+var dprLocation = regexp.MustCompile(`\b([vdmsakyxbg])[.,#](\d+)[.,#](\d+)[.,#](\d+)[.,#](\d+)[.,#](\d+)[.,#](\d+)[.,#]([mat])\b`)
+
+// isBookmarkRef reports whether s looks like something resolveBookmark can
+// place
+func isBookmarkRef(s string) bool {
+	return strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://") || strings.HasPrefix(s, "/read/") || dprLocation.MatchString(s)
+}
+
+// readBookmarkRefs reads the references of a bookmark export: a browser's
+// HTML bookmarks file, an XML or JSON export such as the Digital Pali
+// Reader's, or a plain list with a URL or DPR location and optionally a
+// name on each line
+func readBookmarkRefs(r io.Reader) ([]bookmarkRef, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	trimmed := bytes.TrimSpace(data)
+	switch {
+	case len(trimmed) == 0:
+		return nil, errors.New("the file holds no bookmarks")
+	case bytes.Contains(bytes.ToUpper(trimmed[:min(len(trimmed), 512)]), []byte("NETSCAPE-BOOKMARK-FILE")):
+		return htmlBookmarkRefs(trimmed), nil
+	case trimmed[0] == '<':
+		return xmlBookmarkRefs(trimmed)
+	case trimmed[0] == '{' || trimmed[0] == '[':
+		var v any
+		if err := json.Unmarshal(trimmed, &v); err != nil {
+			return nil, err
+		}
+		var refs []bookmarkRef
+		jsonBookmarkRefs(v, &refs)
+		return refs, nil
+	}
+
+	var refs []bookmarkRef
+	scanner := bufio.NewScanner(bytes.NewReader(trimmed))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		ref, title, _ := strings.Cut(line, " ")
+		if i := strings.IndexByte(line, '\t'); i >= 0 && i < len(ref) {
+			ref, title = line[:i], line[i+1:]
+		}
+		refs = append(refs, bookmarkRef{Ref: ref, Title: strings.TrimSpace(title)})
+	}
+	return refs, scanner.Err()
+}
+
+// htmlBookmarkRefs reads the links of a browser's bookmarks file
+func htmlBookmarkRefs(data []byte) []bookmarkRef {
+	var refs []bookmarkRef
+	z := html.NewTokenizer(bytes.NewReader(data))
+	open := -1 // the link being read
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return refs
+		case html.StartTagToken:
+			t := z.Token()
+			if t.DataAtom != atom.A {
+				continue
+			}
+			for _, a := range t.Attr {
+				if strings.EqualFold(a.Key, "href") {
+					refs = append(refs, bookmarkRef{Ref: a.Val})
+					open = len(refs) - 1
+				}
+			}
+		case html.TextToken:
+			if open >= 0 {
+				refs[open].Title += string(z.Text())
+			}
+		case html.EndTagToken:
+			if t := z.Token(); t.DataAtom == atom.A && open >= 0 {
+				refs[open].Title = strings.TrimSpace(refs[open].Title)
+				open = -1
+			}
+		}
+	}
+}
+
+// xmlBookmarkRefs reads an XML export. Any element holding a reference,
+// in its text or an attribute, is a bookmark; a name or title element next
+// to the reference, or below the element, names it.
+func xmlBookmarkRefs(data []byte) ([]bookmarkRef, error) {
+	type element struct {
+		name  string
+		text  strings.Builder
+		title string
+		refs  []string
+	}
+	var refs []bookmarkRef
+	flush := func(e *element) {
+		for _, ref := range e.refs {
+			refs = append(refs, bookmarkRef{Ref: ref, Title: e.title})
+		}
+	}
+
+	stack := []*element{{}}
+	d := xml.NewDecoder(bytes.NewReader(data))
+	d.Strict = false
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			e := &element{name: strings.ToLower(t.Name.Local)}
+			for _, a := range t.Attr {
+				if isBookmarkRef(a.Value) {
+					e.refs = append(e.refs, a.Value)
+				}
+			}
+			stack = append(stack, e)
+		case xml.CharData:
+			stack[len(stack)-1].text.Write(t)
+		case xml.EndElement:
+			if len(stack) < 2 {
+				continue
+			}
+			e, parent := stack[len(stack)-1], stack[len(stack)-2]
+			stack = stack[:len(stack)-1]
+			text := strings.TrimSpace(e.text.String())
+			switch {
+			case e.name == "name" || e.name == "title":
+				if parent.title == "" {
+					parent.title = text
+				}
+			case isBookmarkRef(text):
+				parent.refs = append(parent.refs, text)
+			}
+			if e.title == "" {
+				e.title = parent.title
+			}
+			flush(e)
+		}
+	}
+	flush(stack[0])
+	return refs, nil
+}
+
+// jsonBookmarkRefs collects the references of a JSON export. Any object
+// holding a reference is a bookmark, named by its name or title field.
+func jsonBookmarkRefs(v any, refs *[]bookmarkRef) {
+	switch v := v.(type) {
+	case []any:
+		for _, item := range v {
+			jsonBookmarkRefs(item, refs)
+		}
+	case map[string]any:
+		var title string
+		for _, key := range []string{"name", "title"} {
+			if s, ok := v[key].(string); ok && title == "" {
+				title = s
+			}
+		}
+		for _, item := range v {
+			if s, ok := item.(string); ok && isBookmarkRef(s) {
+				*refs = append(*refs, bookmarkRef{Ref: s, Title: title})
+			} else {
+				jsonBookmarkRefs(item, refs)
+			}
+		}
+	case string:
+		if isBookmarkRef(v) {
+			*refs = append(*refs, bookmarkRef{Ref: v})
+		}
+	}
+}
+
+// resolveBookmark places a reference in the corpus. It understands links
+// into this reader, Digital Pali Reader locations and permalinks, and
+// SuttaCentral links; the last two only find the text, not the paragraph.
+func resolveBookmark(ref string) (path, anchor string, ok bool) {
+	if m := dprLocation.FindStringSubmatch(ref); m != nil && !strings.Contains(ref, "/read/") {
+		return dprText(m)
+	}
+
+	u, err := url.Parse(ref)
+	if err != nil {
+		return "", "", false
+	}
+	if _, rest, found := strings.Cut(u.Path, "/read/"); found {
+		name, err := corpusName(resolveSlugs(rest))
+		if err != nil || name == "." {
+			return "", "", false
+		}
+		if _, err := fs.Stat(corpus, name); err != nil {
+			return "", "", false
+		}
+		return name, u.Fragment, true
+	}
+	if strings.TrimPrefix(u.Hostname(), "www.") == "suttacentral.net" {
+		return suttaCentralText(strings.Trim(u.Path, "/"))
+	}
+	return "", "", false
+}
+
+// workTexts are the texts of the corpus a work's volumes are read in, for
+// placing references that only name the work
+var workTexts = map[string][]string{
+	"dn":       {"dighan1u", "dighan2u", "dighan3u"},
+	"mn":       {"majjhi1u", "majjhi2u", "majjhi3u"},
+	"sn":       {"samyut1u", "samyut2u", "samyut3u", "samyut4u", "samyut5u"},
+	"an":       {"angutt1u", "angutt2u", "angutt3u", "angutt4u", "angutt5u"},
+	"kp":       {"khuddaku"},
+	"dhp":      {"dhamma1u"},
+	"ud":       {"udana_u"},
+	"iti":      {"itivuttu"},
+	"snp":      {"suttaniu"},
+	"vv":       {"vimanavu"},
+	"pv":       {"petavatu"},
+	"thag":     {"theragau"},
+	"thig":     {"therigau"},
+	"tha-ap":   {"apadanau"},
+	"bv":       {"buddhavu"},
+	"cp":       {"cariyapu"},
+	"ja":       {"jataka1u"},
+	"mnd":      {"mahanidu"},
+	"cnd":      {"cullaniu"},
+	"ps":       {"patisa1u"},
+	"ds":       {"dhamsanu"},
+	"vb":       {"vibhangu"},
+	"dt":       {"dhatukau"},
+	"pp":       {"puggalau"},
+	"kv":       {"kathav1u"},
+	"ya":       {"yamaka1u"},
+	"patthana": {"pattha1u"},
+	"mil":      {"milind1u"},
+	"ne":       {"nettip_u"},
+	"pe":       {"petako_u"},
+}
+
+// workText returns the corpus path of a volume of a work, counting from 1
+func workText(uid string, volume int) (string, string, bool) {
+	texts := workTexts[uid]
+	if volume < 1 || volume > len(texts) {
+		return "", "", false
+	}
+	path, err := findText(texts[volume-1])
+	return path, "", err == nil
+}
+
+// Volumes of the Pali Text Society editions, which the corpus follows, by
+// sutta of the Dīgha and Majjhima, saṃyutta and nipāta
+func dnVolume(sutta int) int {
+	switch {
+	case sutta <= 13:
+		return 1
+	case sutta <= 23:
+		return 2
+	}
+	return 3
+}
+
+func mnVolume(sutta int) int {
+	switch {
+	case sutta <= 76:
+		return 1
+	case sutta <= 106:
+		return 2
+	}
+	return 3
+}
+
+func snVolume(samyutta int) int {
+	for volume, last := range []int{11, 21, 34, 44} {
+		if samyutta <= last {
+			return volume + 1
+		}
+	}
+	return 5
+}
+
+func anVolume(nipata int) int {
+	for volume, last := range []int{3, 4, 6, 9} {
+		if nipata <= last {
+			return volume + 1
+		}
+	}
+	return 5
+}
+
+// dprBooks are the works of the Digital Pali Reader's sets whose books map
+// one to one onto works, in DPR's book order
+var dprBooks = map[string][]string{
+	"y": {"ds", "vb", "dt", "pp", "kv", "ya", "patthana"},
+	"k": {"kp", "dhp", "ud", "iti", "snp", "vv", "pv", "thag", "thig", "tha-ap", "tha-ap",
+		"bv", "cp", "ja", "ja", "mnd", "cnd", "ps", "mil", "ne", "pe"},
+}
+
+// dprVinaya are the Vinaya books of the Digital Pali Reader
+var dprVinaya = []string{"paraji_u", "pacitt_u", "mahavg_u", "cullav_u", "pariva_u"}
+
+// dprText places a Digital Pali Reader location, given as the submatches
+// of dprLocation. Only the root texts are placed.
+func dprText(m []string) (string, string, bool) {
+	set, hier := m[1], m[8]
+	if hier != "m" {
+		return "", "", false
+	}
+	n := make([]int, 6)
+	for i := range n {
+		n[i], _ = strconv.Atoi(m[i+2])
+	}
+	book, vagga, sutta := n[0], n[3], n[4]
+
+	switch set {
+	case "v":
+		if book >= len(dprVinaya) {
+			return "", "", false
+		}
+		path, err := findText(dprVinaya[book])
+		return path, "", err == nil
+	case "d":
+		return workText("dn", book+1)
+	case "m":
+		// The Majjhima comes in three books of fifty suttas in vaggas of
+		// ten, but for the twelve of the Vibhaṅgavagga
+		number := book*50 + vagga*10 + sutta + 1
+		if book == 2 && vagga == 4 {
+			number += 2
+		}
+		return workText("mn", mnVolume(number))
+	case "s":
+		return workText("sn", book+1)
+	case "a":
+		return workText("an", anVolume(book+1))
+	}
+	if books := dprBooks[set]; book < len(books) {
+		return workText(books[book], 1)
+	}
+	return "", "", false
+}
+
+// suttaCentralID splits a SuttaCentral text ID such as mn10 or sn22.59
+var suttaCentralID = regexp.MustCompile(`^([a-z]+(?:-[a-z]+)*)(\d+)?`)
+
+// suttaCentralText places the path of a SuttaCentral link
+func suttaCentralText(p string) (string, string, bool) {
+	id, _, _ := strings.Cut(p, "/")
+	m := suttaCentralID.FindStringSubmatch(id)
+	if m == nil {
+		return "", "", false
+	}
+	uid := m[1]
+	number, _ := strconv.Atoi(m[2])
+	switch uid {
+	case "dn":
+		return workText(uid, dnVolume(number))
+	case "mn":
+		return workText(uid, mnVolume(number))
+	case "sn":
+		return workText(uid, snVolume(number))
+	case "an":
+		return workText(uid, anVolume(number))
+	}
+	return workText(uid, 1)
+}
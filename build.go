@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// runBuild writes the index, directory and reader pages as a static site
+func runBuild(args []string) error {
+	flags := flag.NewFlagSet("build", flag.ExitOnError)
+	outDir := flags.String("o", "site", "directory to write the site to")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: palireader build [-o dir]")
+		fmt.Fprintln(flags.Output(), "\nWrites the library as static HTML that any web server can host at the")
+		fmt.Fprintln(flags.Output(), "root of a site. Search, lookup history and the other features that need")
+		fmt.Fprintln(flags.Output(), "the server are left out; word links go to the configured dictionary.")
+		fmt.Fprintln(flags.Output(), "\nFlags:")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	start := time.Now()
+	write := func(path string, data []byte) error {
+		path = filepath.Join(*outDir, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return err
+		}
+		return os.WriteFile(path, data, 0o644)
+	}
+
+	// Pages link to the versioned names; the plain ones are for anything
+	// linking to the assets from outside
+	for plain, a := range staticAssets {
+		if err := write("static/"+a.Name, a.Content); err != nil {
+			return err
+		}
+		if err := write("static/"+plain, a.Content); err != nil {
+			return err
+		}
+	}
+	texts, err := renderSite(nil, write)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Wrote %d texts to %s in %s\n", texts, *outDir, time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// renderSite renders the index and the pages of every folder and text below
+// roots, or of the whole corpus without roots, as the static site has them,
+// and hands each to emit under its path in the site. It returns how many
+// texts it rendered.
+func renderSite(roots []string, emit func(path string, data []byte) error) (int, error) {
+	staticSite = true
+	if err := parseTemplates(); err != nil {
+		return 0, err
+	}
+	// The corpus is not expected to change while it is rendered, so the
+	// folder listings, with the titles of their texts, are read once
+	corpusCache.mu.Lock()
+	corpusCache.enabled = true
+	corpusCache.mu.Unlock()
+
+	render := func(path, name string, data PageData) error {
+		// The site is public, so protected paths are left out
+		dropProtected(&data, "")
+		var buf bytes.Buffer
+		if err := templates.ExecuteTemplate(&buf, name, data); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		return emit(path, buf.Bytes())
+	}
+
+	if len(roots) == 0 {
+		err := render("index.html", "index", PageData{
+			Title: "Pali Reader",
+			Files: buildFileTree(""),
+		})
+		if err != nil {
+			return 0, err
+		}
+		roots = []string{""}
+	}
+
+	// Pages keep the server's URLs: /read/<dir> is served from its
+	// index.html and /read/<text>.htm is the rendered text itself
+	texts := 0
+	for _, root := range roots {
+		err := walkCorpus(root, func(rel string, d fs.DirEntry) error {
+			if protectedPath(rel) {
+				if d.IsDir() {
+					return fs.SkipDir
+				}
+				return nil
+			}
+
+			if d.IsDir() {
+				files := buildFileTree(rel)
+				return render("read/"+rel+"/index.html", "directory", PageData{
+					Title:       files.DisplayName(),
+					Files:       files,
+					CurrentPath: rel,
+					Breadcrumbs: buildBreadcrumbs(rel),
+				})
+			}
+			if _, ok := audioType(d.Name()); ok {
+				// Recordings keep their URL too
+				data, err := fs.ReadFile(corpus, rel)
+				if err != nil {
+					return err
+				}
+				return emit("audio/"+rel, data)
+			}
+			if !strings.HasSuffix(strings.ToLower(d.Name()), ".htm") {
+				return nil
+			}
+
+			content, err := fs.ReadFile(corpus, rel)
+			if err != nil {
+				return err
+			}
+			texts++
+			timed := hasTiming(rel)
+			if timed {
+				cues, err := textTiming(rel)
+				if err != nil {
+					return err
+				}
+				data, err := json.Marshal(cues)
+				if err != nil {
+					return err
+				}
+				if err := emit("timing/"+rel, data); err != nil {
+					return err
+				}
+			}
+			return render("read/"+rel, "reader", PageData{
+				Title:       displayTitle(rel),
+				Content:     template.HTML(processHTMContent(rel, string(content))),
+				CurrentPath: rel,
+				Breadcrumbs: buildBreadcrumbs(rel),
+				Editions:    editionLinks(rel),
+				Audio:       textAudio(rel),
+				Timed:       timed,
+			})
+		})
+		if err != nil {
+			return texts, err
+		}
+	}
+	return texts, nil
+}
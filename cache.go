@@ -0,0 +1,378 @@
+package main
+
+import (
+	"bufio"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CacheConfig picks where rendered reader pages and dictionary entries are
+// cached. Several servers behind a load balancer share them through Redis.
+type CacheConfig struct {
+	Backend string `json:"backend"` // memory, disk or redis
+	MaxMB   int    `json:"maxMB"`   // bounds the memory backend, 256 if unset
+	Dir     string `json:"dir"`     // of the disk backend, defaults to cache/shared
+	Redis   string `json:"redis"`   // like redis://:password@host:6379/0, or host:port
+	Hours   int    `json:"hours"`   // how long the redis backend keeps an entry, a week if unset
+}
+
+// Cache keeps values by key. It is a cache: a value may be gone when it is
+// next asked for, and a backend that fails reports a miss.
+type Cache interface {
+	Get(key string) (CacheEntry, bool)
+	Put(key string, value []byte) error
+}
+
+// CacheEntry is a cached value and when it was stored
+type CacheEntry struct {
+	Value  []byte
+	Stored time.Time
+}
+
+// sharedCache is the backend of the "cache" config section, nil without
+// one
+var sharedCache Cache
+
+// openCache opens the configured backend
+func openCache(c *CacheConfig) (Cache, error) {
+	switch c.Backend {
+	case "memory":
+		return newMemoryCache(int64(c.MaxMB) << 20), nil
+	case "disk":
+		return &diskCache{dir: c.Dir}, nil
+	case "redis":
+		rc, err := newRedisCache(c.Redis)
+		if err != nil {
+			return nil, err
+		}
+		rc.expiry = time.Duration(c.Hours) * time.Hour
+		return rc, nil
+	}
+	return nil, fmt.Errorf("unknown cache backend %q", c.Backend)
+}
+
+// memoryCache keeps the entries used last, up to a number of bytes
+type memoryCache struct {
+	max int64
+
+	mu      sync.Mutex
+	size    int64
+	order   *list.List // of *memoryEntry, the one used last in front
+	entries map[string]*list.Element
+}
+
+type memoryEntry struct {
+	key string
+	CacheEntry
+}
+
+func newMemoryCache(max int64) *memoryCache {
+	return &memoryCache{max: max, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+func (c *memoryCache) Get(key string) (CacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return CacheEntry{}, false
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(*memoryEntry).CacheEntry, true
+}
+
+func (c *memoryCache) Put(key string, value []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok {
+		c.size -= int64(len(e.Value.(*memoryEntry).Value))
+		c.order.Remove(e)
+	}
+	c.entries[key] = c.order.PushFront(&memoryEntry{key, CacheEntry{value, time.Now()}})
+	c.size += int64(len(value))
+	for c.size > c.max && c.order.Len() > 1 {
+		last := c.order.Back()
+		entry := c.order.Remove(last).(*memoryEntry)
+		delete(c.entries, entry.key)
+		c.size -= int64(len(entry.Value))
+	}
+	return nil
+}
+
+// diskCache keeps every entry in a file of its own, named after the hash of
+// its key
+type diskCache struct {
+	dir string
+	ext string // of the files, such as ".html"
+}
+
+func (c *diskCache) file(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:])+c.ext)
+}
+
+func (c *diskCache) Get(key string) (CacheEntry, bool) {
+	file := c.file(key)
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return CacheEntry{}, false
+	}
+	entry := CacheEntry{Value: data}
+	if info, err := os.Stat(file); err == nil {
+		entry.Stored = info.ModTime()
+	}
+	return entry, true
+}
+
+func (c *diskCache) Put(key string, value []byte) error {
+	return writeFileAtomic(c.file(key), value)
+}
+
+// redisTimeout bounds each exchange with Redis, so that a server which
+// stopped answering slows pages down by no more than this
+const redisTimeout = 2 * time.Second
+
+// redisCache keeps the entries in Redis, each with its time stored in
+// front of the value. It speaks just enough of the protocol to get and
+// set them.
+type redisCache struct {
+	addr     string
+	password string
+	db       int
+	idle     chan *redisConn
+	expiry   time.Duration // of the entries set with Put; none if zero
+}
+
+type redisConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// redisError is an error reply, after which the connection is still good
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+func newRedisCache(address string) (*redisCache, error) {
+	c := &redisCache{addr: address, idle: make(chan *redisConn, 8)}
+	if strings.Contains(address, "://") {
+		u, err := url.Parse(address)
+		if err != nil || u.Scheme != "redis" || u.Host == "" {
+			return nil, fmt.Errorf("the redis address %q is not like redis://host:6379/0", address)
+		}
+		c.addr = u.Host
+		c.password, _ = u.User.Password()
+		if db := strings.Trim(u.Path, "/"); db != "" {
+			if c.db, err = strconv.Atoi(db); err != nil {
+				return nil, fmt.Errorf("the redis database %q is not a number", db)
+			}
+		}
+	}
+	if _, _, err := net.SplitHostPort(c.addr); err != nil {
+		c.addr = net.JoinHostPort(c.addr, "6379")
+	}
+	return c, nil
+}
+
+// conn returns an idle connection, or a new one
+func (c *redisCache) conn() (*redisConn, error) {
+	select {
+	case conn := <-c.idle:
+		return conn, nil
+	default:
+	}
+	nc, err := net.DialTimeout("tcp", c.addr, redisTimeout)
+	if err != nil {
+		return nil, err
+	}
+	conn := &redisConn{nc, bufio.NewReader(nc)}
+	if c.password != "" {
+		if _, err := conn.do("AUTH", c.password); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if c.db != 0 {
+		if _, err := conn.do("SELECT", strconv.Itoa(c.db)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// release keeps a connection for the next command, or closes it when
+// enough are kept or it failed
+func (c *redisCache) release(conn *redisConn, err error) {
+	if err != nil {
+		conn.Close()
+		return
+	}
+	select {
+	case c.idle <- conn:
+	default:
+		conn.Close()
+	}
+}
+
+// do sends a command and reads its reply: a string, nil, a []any for an
+// array, or for an error reply an error
+func (c *redisConn) do(args ...string) (any, error) {
+	c.SetDeadline(time.Now().Add(redisTimeout))
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+	}
+	if _, err := io.WriteString(c, b.String()); err != nil {
+		return nil, err
+	}
+	return c.reply()
+}
+
+// reply reads one reply
+func (c *redisConn) reply() (any, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+	switch line[0] {
+	case '+', ':':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case '$', '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("redis: bad reply %q", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		if line[0] == '*' {
+			// An error inside an array, as from EXEC, does not end it
+			items := make([]any, n)
+			for i := range items {
+				if items[i], err = c.reply(); err != nil && !isRedisError(err) {
+					return nil, err
+				}
+			}
+			return items, nil
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, data); err != nil {
+			return nil, err
+		}
+		return string(data[:n]), nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply %q", line)
+}
+
+// isRedisError reports whether err is an error reply
+func isRedisError(err error) bool {
+	var replyErr redisError
+	return errors.As(err, &replyErr)
+}
+
+func (c *redisCache) command(args ...string) (any, error) {
+	conn, err := c.conn()
+	if err != nil {
+		return nil, err
+	}
+	reply, err := conn.do(args...)
+	if isRedisError(err) {
+		c.release(conn, nil)
+	} else {
+		c.release(conn, err)
+	}
+	return reply, err
+}
+
+func (c *redisCache) Get(key string) (CacheEntry, bool) {
+	reply, err := c.command("GET", "palireader:"+key)
+	if err != nil {
+		log.Printf("Cache: %v", err)
+		return CacheEntry{}, false
+	}
+	s, ok := reply.(string)
+	if !ok {
+		return CacheEntry{}, false
+	}
+	stamp, value, ok := strings.Cut(s, "\n")
+	nanos, err := strconv.ParseInt(stamp, 10, 64)
+	if !ok || err != nil {
+		return CacheEntry{}, false
+	}
+	return CacheEntry{Value: []byte(value), Stored: time.Unix(0, nanos)}, true
+}
+
+func (c *redisCache) Put(key string, value []byte) error {
+	args := []string{"SET", "palireader:" + key, strconv.FormatInt(time.Now().UnixNano(), 10) + "\n" + string(value)}
+	if c.expiry > 0 {
+		args = append(args, "EX", strconv.Itoa(int(c.expiry.Seconds())))
+	}
+	_, err := c.command(args...)
+	return err
+}
+
+// renderSalt tells apart the pages rendered with different settings that
+// change how the same text is marked up: the dictionary links, the base
+// path, the references and the edition overlays, down to the state of
+// their files, so that a page is rendered afresh once one is edited
+func renderSalt() string {
+	h := sha256.New()
+	enc := json.NewEncoder(h)
+	for _, v := range []any{config.Providers, config.DictProxy, config.BasePath, config.References, config.Overlays} {
+		enc.Encode(v)
+	}
+	io.WriteString(h, overlayStamp())
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// renderedPage returns a processed reader page of the text at the corpus
+// name: from the shared cache when there is one, which knows the text by
+// its size and time, or else from the corpus cache
+func renderedPage(name string, info fs.FileInfo, n int) (string, bool) {
+	if sharedCache == nil {
+		overlayMarks() // which forgets the pages if an overlay file changed
+		return corpusCache.page(name, n)
+	}
+	entry, ok := sharedCache.Get(pageKey(name, info, n))
+	return string(entry.Value), ok
+}
+
+// keepRenderedPage caches a processed reader page for renderedPage
+func keepRenderedPage(name string, info fs.FileInfo, n int, page string, generation uint64) {
+	if sharedCache == nil {
+		corpusCache.storePage(name, n, page, generation)
+		return
+	}
+	if err := sharedCache.Put(pageKey(name, info, n), []byte(page)); err != nil {
+		log.Printf("Cannot cache %s: %v", name, err)
+	}
+}
+
+func pageKey(name string, info fs.FileInfo, n int) string {
+	return fmt.Sprintf("page:%s:%s:%d:%d:%d:%d", renderSalt(), name, info.Size(), info.ModTime().UnixNano(), readerPageSize, n)
+}
@@ -0,0 +1,453 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// canonNode is a division of the Tipiṭaka, as readers cite it rather than
+// as the corpus folders are named: a piṭaka, a nikāya, a vagga or book, or
+// a sutta
+type canonNode struct {
+	Name     string
+	ID       string   // how it is cited, such as DN, or MN 10 for a sutta
+	Texts    []string // file names, without .htm, of the volumes holding it in order
+	Heading  string   // its title in the text, or titles separated by |; "" to start at the top
+	Group    bool     // a vagga of suttas, read from the first
+	Children []*canonNode
+}
+
+// numbered returns the suttas of a vagga of the work uid, numbered on from
+// first and read in the volume of workTexts that volume gives. A name may
+// list the spellings of the title in the texts, separated by |.
+func numbered(abbr, uid string, first int, volume func(n int) int, names ...string) []*canonNode {
+	suttas := make([]*canonNode, len(names))
+	for i, name := range names {
+		n := first + i
+		display, _, _ := strings.Cut(name, "|")
+		suttas[i] = &canonNode{
+			Name:    display + "sutta",
+			ID:      fmt.Sprintf("%s %d", abbr, n),
+			Texts:   []string{workTexts[uid][volume(n)-1]},
+			Heading: strings.ReplaceAll(name, "|", "sutta|") + "sutta",
+		}
+	}
+	return suttas
+}
+
+// vagga is a group of suttas
+func vagga(name, id string, suttas []*canonNode) *canonNode {
+	return &canonNode{Name: name, ID: id, Group: true, Children: suttas}
+}
+
+// book is a division held in the texts named, its volumes
+func book(name, id string, texts ...string) *canonNode {
+	return &canonNode{Name: name, ID: id, Texts: texts}
+}
+
+// nipata is a book of the Aṅguttara, starting at its heading in its volume
+// unless it starts the volume
+func nipata(name string, n int, heading string) *canonNode {
+	return &canonNode{Name: name, ID: fmt.Sprintf("AN %d", n), Texts: []string{workTexts["an"][anVolume(n)-1]}, Heading: heading}
+}
+
+// canon is the Tipiṭaka. The volumes are those of the PTS edition the
+// GRETIL texts follow.
+var canon = &canonNode{Name: "Tipiṭaka", Children: []*canonNode{
+	{Name: "Vinayapiṭaka", ID: "Vin", Children: []*canonNode{
+		book("Pārājika", "Vin III", "paraji_u"),
+		book("Pācittiya", "Vin IV", "pacitt_u"),
+		book("Mahāvagga", "Vin I", "mahavg_u"),
+		book("Cullavagga", "Vin II", "cullav_u"),
+		book("Parivāra", "Vin V", "pariva_u"),
+	}},
+	{Name: "Suttapiṭaka", ID: "Sutta", Children: []*canonNode{
+		{Name: "Dīghanikāya", ID: "DN", Children: []*canonNode{
+			vagga("Sīlakkhandhavagga", "DN 1–13", numbered("DN", "dn", 1, dnVolume,
+				"Brahmajāla", "Sāmaññaphala", "Ambaṭṭha", "Soṇadaṇḍa", "Kūṭadanta", "Mahāli", "Jāliya",
+				"Mahāsīhanāda|Kassapasīhanāda|Sīhanāda", "Poṭṭhapāda", "Subha", "Kevaṭṭa|Kevaddha", "Lohicca", "Tevijja")),
+			vagga("Mahāvagga", "DN 14–23", numbered("DN", "dn", 14, dnVolume,
+				"Mahāpadāna", "Mahānidāna", "Mahāparinibbāna", "Mahāsudassana", "Janavasabha", "Mahāgovinda",
+				"Mahāsamaya", "Sakkapañha", "Mahāsatipaṭṭhāna", "Pāyāsi|Pāyāsirājañña")),
+			vagga("Pāthikavagga", "DN 24–34", numbered("DN", "dn", 24, dnVolume,
+				"Pāthika", "Udumbarika|Udumbarikasīhanāda", "Cakkavattisīhanāda|Cakkavatti", "Aggañña", "Sampasādanīya",
+				"Pāsādika", "Lakkhaṇa", "Siṅgāla|Sīgāla|Sigāla|Siṅgālovāda", "Āṭānāṭiya", "Saṅgīti", "Dasuttara")),
+		}},
+		{Name: "Majjhimanikāya", ID: "MN", Children: []*canonNode{
+			vagga("Mūlapaṇṇāsa", "MN 1–50", numbered("MN", "mn", 1, mnVolume,
+				"Mūlapariyāya", "Sabbāsava|Sabbasava", "Dhammadāyāda", "Bhayabherava", "Anaṅgaṇa", "Ākaṅkheyya",
+				"Vatthūpama|Vattha", "Sallekha", "Sammādiṭṭhi", "Satipaṭṭhāna", "Cūḷasīhanāda", "Mahāsīhanāda",
+				"Mahādukkhakkhandha", "Cūḷadukkhakkhandha", "Anumāna", "Cetokhila", "Vanapattha", "Madhupiṇḍika",
+				"Dvedhāvitakka", "Vitakkasaṇṭhāna", "Kakacūpama", "Alagaddūpama", "Vammika", "Rathavinīta",
+				"Nivāpa", "Pāsarāsi|Ariyapariyesana", "Cūḷahatthipadopama", "Mahāhatthipadopama", "Mahāsāropama",
+				"Cūḷasāropama", "Cūḷagosiṅga", "Mahāgosiṅga", "Mahāgopālaka", "Cūḷagopālaka", "Cūḷasaccaka",
+				"Mahāsaccaka", "Cūḷataṇhāsaṅkhaya", "Mahātaṇhāsaṅkhaya", "Mahāassapura", "Cūḷaassapura",
+				"Sāleyyaka", "Verañjaka", "Mahāvedalla", "Cūḷavedalla", "Cūḷadhammasamādāna",
+				"Mahādhammasamādāna", "Vīmaṃsaka", "Kosambiya", "Brahmanimantanika", "Māratajjanīya")),
+			vagga("Majjhimapaṇṇāsa", "MN 51–100", numbered("MN", "mn", 51, mnVolume,
+				"Kandaraka", "Aṭṭhakanāgara", "Sekha", "Potaliya", "Jīvaka", "Upāli", "Kukkuravatika|Kukkuravatiya",
+				"Abhayarājakumāra", "Bahuvedanīya|Bahuvedaniya", "Apaṇṇaka", "Ambalaṭṭhikarāhulovāda", "Mahārāhulovāda",
+				"Cūḷamālukya|Cūḷamāluṅkya", "Mahāmālukya|Mahāmāluṅkya", "Bhaddāli", "Laṭukikopama", "Cātuma",
+				"Naḷakapāna", "Goliyāni|Gulissāni", "Kīṭāgiri", "Tevijjavacchagotta|Tevijjavaccha",
+				"Aggivacchagotta|Aggivaccha", "Mahāvacchagotta|Mahāvaccha", "Dīghanakha", "Māgaṇḍiya", "Sandaka",
+				"Mahāsakuludāyi", "Samaṇamuṇḍika|Samaṇamaṇḍikā", "Cūḷasakuludāyi", "Vekhanassa", "Ghaṭikāra",
+				"Raṭṭhapāla", "Maghadeva|Makhādeva", "Madhura", "Bodhirājakumāra", "Aṅgulimāla", "Piyajātika",
+				"Bāhitika", "Dhammacetiya", "Kaṇṇakatthala", "Brahmāyu", "Sela", "Assalāyana", "Ghoṭamukha",
+				"Caṅkī", "Esukārī", "Dhānañjāni", "Vāseṭṭha", "Subha", "Saṅgārava")),
+			vagga("Uparipaṇṇāsa", "MN 101–152", numbered("MN", "mn", 101, mnVolume,
+				"Devadaha", "Pañcattaya", "Kinti", "Sāmagāma", "Sunakkhatta", "Āneñjasappāya", "Gaṇakamoggallāna",
+				"Gopakamoggallāna", "Mahāpuṇṇama", "Cūḷapuṇṇama", "Anupada", "Chabbisodhana", "Sappurisa",
+				"Sevitabbāsevitabba", "Bahudhātuka", "Isigili", "Mahācattārīsaka", "Ānāpānassati", "Kāyagatāsati",
+				"Saṅkhārupapatti", "Cūḷasuññata", "Mahāsuññata", "Acchariyabbhutadhamma|Acchariyabbhuta", "Bākula",
+				"Dantabhūmi", "Bhūmija", "Anuruddha", "Upakkilesa", "Bālapaṇḍita", "Devadūta", "Bhaddekaratta",
+				"Ānandabhaddekaratta", "Mahākaccānabhaddekaratta", "Lomasakaṅgiyabhaddekaratta",
+				"Cūḷakammavibhaṅga", "Mahākammavibhaṅga", "Saḷāyatanavibhaṅga", "Uddesavibhaṅga", "Araṇavibhaṅga",
+				"Dhātuvibhaṅga", "Saccavibhaṅga", "Dakkhiṇāvibhaṅga", "Anāthapiṇḍikovāda", "Channovāda",
+				"Puṇṇovāda", "Nandakovāda", "Cūḷarāhulovāda", "Chachakka", "Mahāsaḷāyatanika", "Nagaravindeyya",
+				"Piṇḍapātapārisuddhi", "Indriyabhāvanā")),
+		}},
+		{Name: "Saṃyuttanikāya", ID: "SN", Children: []*canonNode{
+			book("Sagāthāvagga", "SN 1–11", "samyut1u"),
+			book("Nidānavagga", "SN 12–21", "samyut2u"),
+			book("Khandhavagga", "SN 22–34", "samyut3u"),
+			book("Saḷāyatanavagga", "SN 35–44", "samyut4u"),
+			book("Mahāvagga", "SN 45–56", "samyut5u"),
+		}},
+		{Name: "Aṅguttaranikāya", ID: "AN", Children: []*canonNode{
+			nipata("Ekakanipāta", 1, ""),
+			nipata("Dukanipāta", 2, "Dukanipāt"),
+			nipata("Tikanipāta", 3, "Tikanipāt"),
+			nipata("Catukkanipāta", 4, ""),
+			nipata("Pañcakanipāta", 5, ""),
+			nipata("Chakkanipāta", 6, "Chakkanipāt"),
+			nipata("Sattakanipāta", 7, ""),
+			nipata("Aṭṭhakanipāta", 8, "Aṭṭhakanipāt"),
+			nipata("Navakanipāta", 9, "Navakanipāt"),
+			nipata("Dasakanipāta", 10, ""),
+			nipata("Ekādasakanipāta", 11, "Ekādasako nipāt"),
+		}},
+		{Name: "Khuddakanikāya", ID: "KN", Children: []*canonNode{
+			book("Khuddakapāṭha", "Kp", "khuddaku"),
+			book("Dhammapada", "Dhp", "dhamma1u", "dhamma2u"),
+			book("Udāna", "Ud", "udana_u"),
+			book("Itivuttaka", "Iti", "itivuttu"),
+			book("Suttanipāta", "Snp", "suttaniu"),
+			book("Vimānavatthu", "Vv", "vimanavu"),
+			book("Petavatthu", "Pv", "petavatu"),
+			book("Theragāthā", "Thag", "theragau"),
+			book("Therīgāthā", "Thig", "therigau"),
+			book("Apadāna", "Ap", "apadanau"),
+			book("Buddhavaṃsa", "Bv", "buddhavu"),
+			book("Cariyāpiṭaka", "Cp", "cariyapu"),
+			book("Jātaka", "Ja", "jataka1u", "jataka2u", "jataka3u", "jataka4u", "jataka5u", "jataka6u"),
+			book("Mahāniddesa", "Nidd I", "mahanidu"),
+			book("Cūḷaniddesa", "Nidd II", "cullaniu"),
+			book("Paṭisambhidāmagga", "Paṭis", "patisa1u", "patisa2u"),
+		}},
+	}},
+	{Name: "Abhidhammapiṭaka", ID: "Abhidh", Children: []*canonNode{
+		book("Dhammasaṅgaṇī", "Dhs", "dhamsanu"),
+		book("Vibhaṅga", "Vibh", "vibhangu"),
+		book("Dhātukathā", "Dhātuk", "dhatukau"),
+		book("Puggalapaññatti", "Pp", "puggalau"),
+		book("Kathāvatthu", "Kv", "kathav1u", "kathav2u"),
+		book("Yamaka", "Yam", "yamaka1u", "yamaka2u"),
+		book("Paṭṭhāna", "Paṭṭh", "pattha1u", "pattha2u"),
+	}},
+}}
+
+// foldHeading reduces a title to lower-case ASCII letters, so that
+// "Sallekha suttaṃ", "Sallekhasuttaṃ" and "Sallekha1 suttaṃ", with a note
+// mark, read alike
+func foldHeading(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || unicode.IsDigit(r) {
+			return -1
+		}
+		return r
+	}, slugify(s, true))
+}
+
+// canonIndex holds, for every node of the canon, its parent and the
+// nodes sharing its first volume in the canon's order, and the nodes by
+// the slug of their IDs
+var canonIndex = sync.OnceValue(func() (index struct {
+	parents map[*canonNode]*canonNode
+	byText  map[string][]*canonNode
+	bySlug  map[string]*canonNode
+}) {
+	index.parents = make(map[*canonNode]*canonNode)
+	index.byText = make(map[string][]*canonNode)
+	index.bySlug = make(map[string]*canonNode)
+	var visit func(n *canonNode)
+	visit = func(n *canonNode) {
+		if n.ID != "" {
+			index.bySlug[slugify(n.ID, true)] = n
+		}
+		if len(n.Texts) > 0 && n.Heading != "" {
+			index.byText[n.Texts[0]] = append(index.byText[n.Texts[0]], n)
+		}
+		for _, c := range n.Children {
+			index.parents[c] = n
+			visit(c)
+		}
+	}
+	visit(canon)
+	return index
+})
+
+// canonTexts finds the texts of the Tipiṭaka in the corpus by their file
+// names
+func canonTexts() map[string]string {
+	var names []string
+	walkCorpus("", func(rel string, d fs.DirEntry) error {
+		if !d.IsDir() && strings.HasSuffix(strings.ToLower(rel), ".htm") && strings.HasPrefix(textPath(rel), "1_tipit/") {
+			names = append(names, rel)
+		}
+		return nil
+	})
+	sort.Strings(names)
+	texts := make(map[string]string)
+	var visit func(n *canonNode)
+	visit = func(n *canonNode) {
+		for _, text := range n.Texts {
+			if _, ok := texts[text]; ok {
+				continue
+			}
+			for _, name := range names {
+				if strings.EqualFold(path.Base(name), text+".htm") {
+					texts[text] = name
+					break
+				}
+			}
+		}
+		for _, c := range n.Children {
+			visit(c)
+		}
+	}
+	visit(canon)
+	return texts
+}
+
+// canonAnchors keeps where the headings of the canon are found in a text,
+// while it is unchanged
+var canonAnchors = struct {
+	sync.Mutex
+	texts map[string]headingAnchors // by corpus name
+}{texts: make(map[string]headingAnchors)}
+
+type headingAnchors struct {
+	modified time.Time
+	anchors  map[*canonNode]int // paragraph; missing if not found
+}
+
+// headingAnchor returns the paragraph the heading of n starts at in the
+// text at name, which holds its first volume
+func headingAnchor(n *canonNode, name string) (int, bool) {
+	info, err := fs.Stat(corpus, name)
+	if err != nil {
+		return 0, false
+	}
+	canonAnchors.Lock()
+	defer canonAnchors.Unlock()
+	cached, ok := canonAnchors.texts[name]
+	if !ok || !cached.modified.Equal(info.ModTime()) {
+		cached = headingAnchors{modified: info.ModTime(), anchors: findHeadings(name, canonIndex().byText[n.Texts[0]])}
+		canonAnchors.texts[name] = cached
+	}
+	p, ok := cached.anchors[n]
+	return p, ok
+}
+
+// headingWords is how far into a paragraph a heading may start, past a
+// number or a page reference such as "10. " or "[PTS Page 055]"
+const headingWords = 6
+
+// findHeadings looks for the headings of nodes, in order, in the text at
+// name. A heading is a paragraph opening with the title, give or take a case
+// ending, such as "Brahmajālasuttaṃ" but not "Brahmajālasuttaṃ niṭṭhitaṃ
+// paṭhamaṃ" nor "Brahmajālasuttantaṃ". The lines of a paragraph run
+// together, so the heading may be followed by the text it heads.
+func findHeadings(name string, nodes []*canonNode) map[*canonNode]int {
+	anchors := make(map[*canonNode]int)
+	content, err := fs.ReadFile(corpus, name)
+	if err != nil {
+		return anchors
+	}
+	parts := paragraphs(name, string(content))
+	opening := make([][]string, len(parts))
+	for p, part := range parts {
+		words := strings.Fields(part)
+		words = words[:min(len(words), headingWords+2)]
+		for i := range words {
+			words[i] = foldHeading(words[i])
+		}
+		opening[p] = words
+	}
+	from := 0
+	for _, n := range nodes {
+		titles := strings.Split(n.Heading, "|")
+		for i := range titles {
+			titles[i] = foldHeading(titles[i])
+		}
+		for p := from; p < len(parts); p++ {
+			if opensWith(opening[p], titles) {
+				anchors[n] = p
+				from = p + 1
+				break
+			}
+		}
+	}
+	return anchors
+}
+
+// opensWith reports whether words, folded, spell one of the titles from one
+// of the first headingWords, with at most a case ending after it
+func opensWith(words, titles []string) bool {
+	for start := 0; start < min(len(words), headingWords); start++ {
+		spelled := ""
+		for _, w := range words[start:] {
+			spelled += w
+			for _, t := range titles {
+				if strings.HasPrefix(spelled, t) && len(spelled)-len(t) <= 3 {
+					return true
+				}
+			}
+			if !slices.ContainsFunc(titles, func(t string) bool { return strings.HasPrefix(t, spelled) }) {
+				break
+			}
+		}
+	}
+	return false
+}
+
+// CanonEntry is a division of the canon as the canon page lists it
+type CanonEntry struct {
+	Name     string
+	ID       string
+	URL      string // below the base: its own page, or where it starts in the corpus; "" if it is not there
+	Volumes  []CanonEntry
+	Children []CanonEntry
+}
+
+// CanonPage is the canon page: a division, the way to it and what is in it
+type CanonPage struct {
+	Trail   []CanonEntry
+	Entries []CanonEntry
+}
+
+// canonReference reads a citation such as "MN 10", "mn10", "Vin II" or "Dhp" as the
+// slug of a division's ID
+var canonReference = regexp.MustCompile(`^\s*(\pL+)\.?\s*([0-9]+|[IVXivx]+)?\s*$`)
+
+// handleCanon browses the Tipiṭaka by its divisions: /canon lists the
+// piṭakas and their books, /canon/dn the suttas of the Dīgha Nikāya, and
+// /canon/mn-10, or /canon?go=MN+10, opens the sutta
+func handleCanon(w http.ResponseWriter, r *http.Request) {
+	slug := strings.Trim(strings.TrimPrefix(r.URL.Path, "/canon"), "/")
+	if goTo := r.FormValue("go"); goTo != "" {
+		slug = ""
+		if m := canonReference.FindStringSubmatch(goTo); m != nil {
+			slug = slugify(strings.TrimSpace(m[1]+" "+m[2]), true)
+		}
+		if _, ok := canonIndex().bySlug[slug]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			renderCanon(w, r, canon, fmt.Sprintf("No division of the canon is cited as “%s”.", goTo))
+			return
+		}
+	}
+	node := canon
+	if slug != "" {
+		var ok bool
+		if node, ok = canonIndex().bySlug[slug]; !ok {
+			httpError(w, r, "No such division of the canon", http.StatusNotFound)
+			return
+		}
+	}
+
+	if node.Texts != nil || node.Group {
+		url := canonURL(r, node, canonTexts())
+		if url == "" {
+			httpError(w, r, node.Name+" is not in the corpus", http.StatusNotFound)
+			return
+		}
+		http.Redirect(w, r, sitePath(url), http.StatusFound)
+		return
+	}
+	renderCanon(w, r, node, "")
+}
+
+// canonURL returns where a division is read, below the base, or for one
+// made of others its page; "" if the corpus does not have it or the user
+// may not read it
+func canonURL(r *http.Request, n *canonNode, texts map[string]string) string {
+	switch {
+	case n == canon:
+		return "/canon"
+	case n.Group:
+		return canonURL(r, n.Children[0], texts)
+	case n.Texts == nil:
+		return "/canon/" + slugify(n.ID, true)
+	}
+	name, ok := texts[n.Texts[0]]
+	if !ok || !canRead(r, name) {
+		return ""
+	}
+	url := "/read/" + slugPath(name)
+	if n.Heading != "" {
+		if p, ok := headingAnchor(n, name); ok {
+			url += fmt.Sprintf("#p%d", p)
+		}
+	}
+	return url
+}
+
+// renderCanon lists a division three levels down: the canon down to the
+// vaggas and books, or a nikāya down to its suttas
+func renderCanon(w http.ResponseWriter, r *http.Request, node *canonNode, notice string) {
+	texts := canonTexts()
+	var entry func(n *canonNode, depth int) CanonEntry
+	entry = func(n *canonNode, depth int) CanonEntry {
+		e := CanonEntry{Name: n.Name, ID: n.ID, URL: canonURL(r, n, texts)}
+		if len(n.Texts) > 1 {
+			for i, text := range n.Texts {
+				if name, ok := texts[text]; ok && canRead(r, name) {
+					e.Volumes = append(e.Volumes, CanonEntry{Name: fmt.Sprint(i + 1), URL: "/read/" + slugPath(name)})
+				}
+			}
+		}
+		if depth > 0 {
+			for _, c := range n.Children {
+				e.Children = append(e.Children, entry(c, depth-1))
+			}
+		}
+		return e
+	}
+
+	page := &CanonPage{}
+	for p := canonIndex().parents[node]; p != nil; p = canonIndex().parents[p] {
+		page.Trail = append([]CanonEntry{{Name: p.Name, URL: canonURL(r, p, texts)}}, page.Trail...)
+	}
+	for _, c := range node.Children {
+		page.Entries = append(page.Entries, entry(c, 2))
+	}
+
+	data := PageData{
+		Title:  node.Name,
+		Notice: notice,
+		Canon:  page,
+	}
+	err := templatesFor(r).ExecuteTemplate(w, "canon", data)
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"html"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// lookalikes are the Cyrillic and Greek letters that pass for Latin ones
+var lookalikes = map[rune]rune{
+	'а': 'a', 'е': 'e', 'о': 'o', 'р': 'p', 'с': 'c', 'у': 'y', 'х': 'x',
+	'і': 'i', 'ј': 'j', 'ѕ': 's', 'ԁ': 'd', 'һ': 'h', 'ӏ': 'l',
+	'А': 'A', 'В': 'B', 'Е': 'E', 'К': 'K', 'М': 'M', 'Н': 'H', 'О': 'O',
+	'Р': 'P', 'С': 'C', 'Т': 'T', 'Х': 'X', 'І': 'I', 'Ј': 'J', 'Ѕ': 'S',
+	'α': 'a', 'ο': 'o', 'ν': 'v', 'ι': 'i', 'ρ': 'p', 'κ': 'k', 'τ': 't',
+	'Α': 'A', 'Β': 'B', 'Ε': 'E', 'Ζ': 'Z', 'Η': 'H', 'Ι': 'I', 'Κ': 'K',
+	'Μ': 'M', 'Ν': 'N', 'Ο': 'O', 'Ρ': 'P', 'Τ': 'T', 'Υ': 'Y', 'Χ': 'X',
+}
+
+// alternativeLetters are letters transcriptions use in place of the
+// corpus's usual ones
+var alternativeLetters = map[rune]rune{'ṁ': 'ṃ', 'Ṁ': 'Ṃ', 'ŋ': 'ṅ'}
+
+// charUsage is how one codepoint is used in the corpus
+type charUsage struct {
+	r        rune
+	count    int
+	texts    int
+	samples  []string
+	composes map[rune]int // precomposed letters a combining mark makes, with their count
+	note     string
+}
+
+// runChars implements the chars command: it lists the codepoints of the
+// texts and flags the ones that are likely transcription errors
+func runChars(args []string) error {
+	flags := flag.NewFlagSet("chars", flag.ExitOnError)
+	in := flags.String("in", "", "only look at the texts below this corpus folder")
+	suspicious := flags.Bool("suspicious", false, "only list the suspicious characters")
+	samples := flags.Int("samples", 2, "number of sample contexts shown for each character")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: palireader chars [-in folder] [-suspicious] [-samples n]")
+		fmt.Fprintln(flags.Output(), "\nLists every character used in the texts, with its count, the number of")
+		fmt.Fprintln(flags.Output(), "texts using it and where it occurs. Characters that are likely")
+		fmt.Fprintln(flags.Output(), "transcription errors are flagged with a !: combining marks where the")
+		fmt.Fprintln(flags.Output(), "corpus also uses precomposed letters, Cyrillic or Greek lookalikes of")
+		fmt.Fprintln(flags.Output(), "Latin letters, invisible and private use characters. Use 'palireader")
+		fmt.Fprintln(flags.Output(), "replace' to fix them.")
+		fmt.Fprintln(flags.Output(), "\nFlags:")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	usage := make(map[rune]*charUsage)
+	err := walkCorpus(*in, func(rel string, d fs.DirEntry) error {
+		if d.IsDir() || !strings.HasSuffix(strings.ToLower(d.Name()), ".htm") {
+			return nil
+		}
+		content, err := fs.ReadFile(corpus, rel)
+		if err != nil {
+			return err
+		}
+		// Reference markers are part of the transcription, so they stay
+		text := html.UnescapeString(tagPattern.ReplaceAllString(rawBody(string(content)), " "))
+
+		seen := make(map[rune]bool)
+		prev := rune(-1)
+		for i, r := range text {
+			u := usage[r]
+			if u == nil {
+				u = &charUsage{r: r}
+				usage[r] = u
+			}
+			u.count++
+			if !seen[r] {
+				seen[r] = true
+				u.texts++
+			}
+			if len(u.samples) < *samples {
+				u.samples = append(u.samples, rel+": "+charContext(text, i))
+			}
+			if unicode.Is(unicode.Mn, r) && prev >= 0 {
+				if composed := []rune(norm.NFC.String(string([]rune{prev, r}))); len(composed) == 1 {
+					if u.composes == nil {
+						u.composes = make(map[rune]int)
+					}
+					u.composes[composed[0]]++
+				}
+			}
+			prev = r
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var list []*charUsage
+	for _, u := range usage {
+		u.note = suspiciousChar(u, usage)
+		if !*suspicious || u.note != "" {
+			list = append(list, u)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].r < list[j].r })
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	flagged := 0
+	for _, u := range list {
+		mark := " "
+		if u.note != "" {
+			mark = "!"
+			flagged++
+		}
+		fmt.Fprintf(out, "%s U+%04X %-3s %-10s %-2s %9d in %d texts", mark, u.r, charDisplay(u.r), charScript(u.r), charCategory(u.r), u.count, u.texts)
+		if u.note != "" {
+			fmt.Fprintf(out, ": %s", u.note)
+		}
+		fmt.Fprintln(out)
+		for _, s := range u.samples {
+			fmt.Fprintf(out, "      %s\n", s)
+		}
+	}
+	fmt.Fprintf(out, "\n%d characters, %d suspicious\n", len(usage), flagged)
+	return nil
+}
+
+// suspiciousChar says what is wrong with a character, or "" if nothing is
+func suspiciousChar(u *charUsage, usage map[rune]*charUsage) string {
+	r := u.r
+	switch {
+	case r == utf8.RuneError:
+		return "replacement character, text lost in a conversion"
+	case unicode.Is(unicode.Co, r):
+		return "private use character, only shown right by the font it was made for"
+	case unicode.Is(unicode.Cf, r):
+		return "invisible formatting character"
+	case unicode.IsControl(r) && r != '\n' && r != '\r' && r != '\t':
+		return "control character"
+	case unicode.Is(unicode.Mn, r):
+		var mixed []string
+		for c := range u.composes {
+			if usage[c] != nil {
+				mixed = append(mixed, string(c))
+			}
+		}
+		sort.Strings(mixed)
+		if len(mixed) > 0 {
+			return "combining mark, while the corpus also has precomposed " + strings.Join(mixed, " ")
+		}
+		return "combining mark"
+	}
+	if latin, ok := lookalikes[r]; ok {
+		return fmt.Sprintf("%s letter that looks like Latin %c", charScript(r), latin)
+	}
+	if unicode.In(r, unicode.Cyrillic, unicode.Greek) {
+		return charScript(r) + " letter in Latin text"
+	}
+	if usual, ok := alternativeLetters[r]; ok && usage[usual] != nil {
+		return fmt.Sprintf("the corpus mostly writes %c", usual)
+	}
+	return ""
+}
+
+// charContext returns the text around byte offset i on one line, with
+// invisible characters spelled out
+func charContext(text string, i int) string {
+	start, end := i, i
+	for n := 0; n < 20 && start > 0; n++ {
+		_, size := utf8.DecodeLastRuneInString(text[:start])
+		start -= size
+	}
+	_, size := utf8.DecodeRuneInString(text[end:])
+	end += size
+	for n := 0; n < 20 && end < len(text); n++ {
+		_, size := utf8.DecodeRuneInString(text[end:])
+		end += size
+	}
+	var b strings.Builder
+	for _, r := range strings.Join(strings.Fields(text[start:end]), " ") {
+		if charDisplay(r) == "" && r != ' ' {
+			fmt.Fprintf(&b, "<U+%04X>", r)
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return "…" + b.String() + "…"
+}
+
+// charDisplay returns a character as it can be shown in a listing
+func charDisplay(r rune) string {
+	switch {
+	case unicode.Is(unicode.Mn, r):
+		// On a dotted circle, as in the Unicode charts
+		return "◌" + string(r)
+	case unicode.IsControl(r) || unicode.Is(unicode.Cf, r) || unicode.IsSpace(r):
+		return ""
+	}
+	return string(r)
+}
+
+// charScript returns the name of the script a character belongs to
+func charScript(r rune) string {
+	for name, table := range unicode.Scripts {
+		if name != "Common" && unicode.Is(table, r) {
+			return name
+		}
+	}
+	return "Common"
+}
+
+// charCategory returns the Unicode general category of a character, like Ll
+func charCategory(r rune) string {
+	for name, table := range unicode.Categories {
+		// LC lumps the cased letters together
+		if len(name) == 2 && name != "LC" && unicode.Is(table, r) {
+			return name
+		}
+	}
+	return ""
+}
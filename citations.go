@@ -0,0 +1,317 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Citation places a standard citation, such as SN 56.11 or Vin I 1, at a
+// paragraph of a text of the corpus
+type Citation struct {
+	Doc       int // in the index's Paths
+	Paragraph int
+}
+
+// Citations are keyed as follows, the PTS volume being its marker in the
+// texts without spaces or dashes, such as v1 for [PTS Vol V - 1]:
+//
+//	pts:v1:1   a page of a PTS volume (Vin I 1)
+//	sn:56      a saṃyutta, and sn:56.11 a sutta in it
+//	an:4.10    a sutta of an Aṅguttara nipāta
+//	dhp:183    a verse of the Dhammapada
+//
+// The suttas are counted as the texts head or number them, so a sutta left
+// out in an abbreviated (peyyāla) run moves the count of those after it.
+
+// samyuttaHeading matches the heading of a saṃyutta, numbered within its
+// volume, such as "12. Saccasaṃyuttaṃ" or "5. Sammappadhāna saṃyuttaṃ"
+var samyuttaHeading = regexp.MustCompile(`^(\d+)\.\s*\pL+\s?saṃyuttaṃ\.?$`)
+
+// suttaNumbers matches the numbers some texts open a sutta with, alone or
+// before its name, such as "1. 1. 1. 2" or "1. 3. 9 Sappāyasuttaṃ"
+var suttaNumbers = regexp.MustCompile(`^\d+\.\s*\d+\.\s*\d+(?:\.\s*\d+)?\.?(?:\s|$)`)
+
+// verseNumber matches the number ending the first line of a verse
+var verseNumber = regexp.MustCompile(`\s(\d+)$`)
+
+// The first saṃyutta and nipāta of each volume of the Saṃyutta and
+// Aṅguttara Nikāyas
+var (
+	samyuttaStarts = []int{1, 12, 22, 35, 45}
+	nipataStarts   = []int{1, 4, 5, 7, 10}
+)
+
+// nipataNames are the Aṅguttara's nipātas as their headings begin, folded
+var nipataNames = []string{"ekaka", "duka", "tika", "catukka", "pancaka", "chakka",
+	"sattaka", "atthaka", "navaka", "dasaka", "ekadasa"}
+
+// ptsVolume reduces a PTS volume marker, such as "V - 1" or "Nd1-1", to its
+// key: v1, nd11
+func ptsVolume(marker string) string {
+	return strings.Map(func(r rune) rune {
+		if r < utf8.RuneSelf && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, marker)
+}
+
+// ptsAnchor returns the ID of a PTS page marker in the reader, as its
+// citation key with dashes: pts-d1-87, or pts-87 before any volume marker.
+// It is "" for a marker of no page, or where the volume is not followed.
+func ptsAnchor(volume *string, page string) string {
+	if volume == nil || page == "" {
+		return ""
+	}
+	if n, err := strconv.Atoi(page); err == nil {
+		page = strconv.Itoa(n)
+	}
+	if *volume == "" {
+		return "pts-" + page
+	}
+	return "pts-" + *volume + "-" + page
+}
+
+// textCitations finds the citations of the text at path, calling add with
+// the key and paragraph of each
+func textCitations(path, content string, add func(key string, paragraph int)) {
+	patterns := referencePatterns(path)
+	volume := ""          // key of the PTS volume read
+	work, number := "", 0 // the saṃyutta or nipāta read, such as sn and 56
+	sutta, verse := 0, 0  // the last sutta and verse seen
+	numbered := false     // whether the line before numbered a sutta
+	for p, part := range paragraphBreak.Split(extractBody(content), -1) {
+		for _, ref := range findReferences(patterns, part) {
+			switch {
+			case ref.volume != "":
+				volume = ptsVolume(ref.volume)
+				work, number, sutta, verse = volumeStart(volume)
+				if work != "" {
+					add(fmt.Sprintf("%s:%d", work, number), p)
+				}
+			case ref.page != "" && volume != "":
+				page, _ := strconv.Atoi(ref.page)
+				add(fmt.Sprintf("pts:%s:%d", volume, page), p)
+			}
+		}
+		if work == "" && volume != "dh" {
+			continue
+		}
+
+		for _, line := range lineBreak.Split(stripReferences(patterns, part), -1) {
+			line = strings.TrimSpace(html.UnescapeString(tagPattern.ReplaceAllString(line, " ")))
+			switch {
+			case volume == "dh":
+				// Verses are numbered in order; other numbers ending a line
+				// are notes
+				if m := verseNumber.FindStringSubmatch(line); m != nil {
+					if n, _ := strconv.Atoi(m[1]); n > verse && n <= verse+3 {
+						verse = n
+						add(fmt.Sprintf("dhp:%d", n), p)
+					}
+				}
+			case work == "sn" && samyuttaHeading.MatchString(line):
+				n, _ := strconv.Atoi(samyuttaHeading.FindStringSubmatch(line)[1])
+				if volume == "s2" && n > 1 {
+					// The second volume heads the Nidāna and Abhisamaya
+					// Saṃyuttas (12 and 13) as one
+					n++
+				}
+				number, sutta = samyuttaStarts[volumeNumber(volume)-1]+n-1, 0
+				add(fmt.Sprintf("sn:%d", number), p)
+			case work == "an" && nipataHeading(line) > 0:
+				number, sutta = nipataHeading(line), 0
+				add(fmt.Sprintf("an:%d", number), p)
+			case suttaNumbers.MatchString(line) || suttaHeading(line) && !numbered:
+				// A name after the numbers heads the same sutta
+				sutta++
+				add(fmt.Sprintf("%s:%d.%d", work, number, sutta), p)
+				numbered = suttaNumbers.MatchString(line) && !suttaHeading(suttaNumbers.ReplaceAllString(line, ""))
+				continue
+			}
+			if line != "" {
+				numbered = false
+			}
+		}
+	}
+}
+
+// volumeStart returns the work, saṃyutta or nipāta, sutta and verse a
+// PTS volume of the Saṃyutta or Aṅguttara starts at; work is "" for other
+// volumes
+func volumeStart(volume string) (work string, number, sutta, verse int) {
+	n := volumeNumber(volume)
+	switch {
+	case n == 0:
+	case volume[0] == 's' && n <= len(samyuttaStarts):
+		return "sn", samyuttaStarts[n-1], 0, 0
+	case volume[0] == 'a' && n <= len(nipataStarts):
+		return "an", nipataStarts[n-1], 0, 0
+	}
+	return "", 0, 0, 0
+}
+
+// volumeNumber returns the number of a PTS volume of one letter, such as 5
+// for s5, or 0
+func volumeNumber(volume string) int {
+	if len(volume) != 2 {
+		return 0
+	}
+	n, _ := strconv.Atoi(volume[1:])
+	return n
+}
+
+// nipataHeading returns the number of the Aṅguttara nipāta a line heads,
+// as "Catukkanipāto" does, or 0
+func nipataHeading(line string) int {
+	if utf8.RuneCountInString(line) > 30 || strings.Contains(line, "niṭṭhit") {
+		return 0
+	}
+	folded := foldHeading(line)
+	if !strings.Contains(folded, "nipat") {
+		return 0
+	}
+	for i, name := range nipataNames {
+		if strings.HasPrefix(folded, name) {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// suttaHeading reports whether a line heads a sutta, as "Avijjāsuttaṃ",
+// "Dutiya bhikkhusuttaṃ" or "(Yogasuttaṃ)" do
+func suttaHeading(line string) bool {
+	line = strings.Trim(line, " ().")
+	return strings.HasSuffix(line, "suttaṃ") && len(strings.Fields(line)) <= 3 &&
+		!strings.Contains(line, "niṭṭhit")
+}
+
+// ptsWorks are the PTS volume keys of the works, by the abbreviations
+// citations give them; the volume's number, if it has several, follows
+var ptsWorks = map[string]string{
+	"vin": "v", "d": "d", "dn": "d", "m": "m", "mn": "m", "s": "s", "sn": "s", "a": "a", "an": "a",
+	"kp": "kh1", "khp": "kh1", "dhp": "dh", "ud": "ud", "it": "it", "iti": "it", "snp": "sn",
+	"vv": "vv", "pv": "pv", "th": "th1", "thag": "th1", "thig": "th2", "ap": "ap", "bv": "bu",
+	"cp": "cp", "j": "j", "ja": "j", "nidd": "nd1", "mnd": "nd1", "cnd": "nd2", "ps": "ps",
+	"dhs": "dhs", "vibh": "vbh", "vbh": "vbh", "dhk": "dhatu", "dhatu": "dhatu", "pp": "pug",
+	"pug": "pug", "kv": "kvu", "kvu": "kvu", "yam": "yam", "patth": "pat", "pat": "pat",
+	"mil": "mil", "ne": "net", "nett": "net", "pet": "peta", "vism": "vism",
+}
+
+// citationReference reads a citation: a work, a PTS volume in Roman numerals
+// and a page, or a number and a sutta in it, as in "Vin I 1", "D ii 290",
+// "SN 56.11", "sn56.11", "Dhp 183" or "MN 10". Pages are also read as
+// secondary literature gives them, such as "PTS D.i.87", "M I, 56f" or
+// "S iii 66–68", the first page counting.
+var citationReference = regexp.MustCompile(`^\s*(?i:pts\s+)?([A-Za-z]+)\.?\s*(?:([IVXivx]+)\b\.?\s*,?\s*)?(\d+)?(?:\s*[.:]\s*(\d+))?(?:\s*(?:ff?|[-–]\s*\d+))?\.?\s*$`)
+
+// romanNumber reads a Roman numeral
+func romanNumber(s string) int {
+	values := map[byte]int{'i': 1, 'v': 5, 'x': 10}
+	s = strings.ToLower(s)
+	n := 0
+	for i := range len(s) {
+		v := values[s[i]]
+		if i+1 < len(s) && values[s[i+1]] > v {
+			n -= v
+		} else {
+			n += v
+		}
+	}
+	return n
+}
+
+// citationKeys returns the keys a citation may be found by in the index,
+// in the order to try them; none for a citation the canon places
+func citationKeys(work, volume, first, second string) []string {
+	work = strings.ToLower(work)
+	switch {
+	case volume != "" && first != "":
+		if code, ok := ptsWorks[work]; ok {
+			return []string{fmt.Sprintf("pts:%s%d:%s", code, romanNumber(volume), strings.TrimLeft(first, "0"))}
+		}
+	case (work == "sn" || work == "s" || work == "an" || work == "a") && second != "":
+		return []string{fmt.Sprintf("%cn:%s.%s", work[0], first, second)}
+	case (work == "sn" || work == "s") && first != "":
+		return []string{"sn:" + first}
+	case work == "dhp" && first != "":
+		return []string{"dhp:" + first}
+	case first != "" && volume == "" && second == "" && canonIndex().bySlug[slugify(work+" "+first, true)] == nil:
+		// A page of a work in one volume, or split in two with the pages
+		// running on, as Vism 123
+		if code, ok := ptsWorks[work]; ok {
+			return []string{"pts:" + code + ":" + first, "pts:" + code + "1:" + first, "pts:" + code + "2:" + first}
+		}
+	}
+	return nil
+}
+
+// resolveCitation returns where a citation is read, below the base
+func resolveCitation(r *http.Request, citation string) (string, error) {
+	m := citationReference.FindStringSubmatch(citation)
+	if m == nil {
+		return "", fmt.Errorf("“%s” is not a citation like MN 10, SN 56.11, Dhp 183 or Vin I 1.", citation)
+	}
+	if keys := citationKeys(m[1], m[2], m[3], m[4]); keys != nil {
+		idx := corpusIndex.Load()
+		if idx == nil {
+			return "", errors.New("The corpus is still being indexed. Please try again in a moment.")
+		}
+		for _, key := range keys {
+			c, ok := idx.Citations[key]
+			if !ok || !canRead(r, idx.Paths[c.Doc]) {
+				continue
+			}
+			name := idx.Paths[c.Doc]
+			if !strings.HasPrefix(key, "pts:") {
+				return fmt.Sprintf("/read/%s#p%d", slugPath(name), c.Paragraph), nil
+			}
+			// Land on the page marker itself, on the reader page holding it
+			url := "/read/" + slugPath(name)
+			page, err := paragraphPage(name, c.Paragraph)
+			if err != nil {
+				return "", err
+			}
+			if page > 0 {
+				url += "?page=" + strconv.Itoa(page)
+			}
+			return url + "#" + strings.ReplaceAll(key, ":", "-"), nil
+		}
+		return "", fmt.Errorf("%s is not in the corpus.", strings.TrimSpace(citation))
+	}
+
+	node := canonIndex().bySlug[slugify(strings.Join(strings.Fields(m[1]+" "+m[2]+" "+m[3]), " "), true)]
+	if node == nil {
+		return "", fmt.Errorf("Nothing in the canon is cited as “%s”.", strings.TrimSpace(citation))
+	}
+	url := canonURL(r, node, canonTexts())
+	if url == "" {
+		return "", fmt.Errorf("%s is not in the corpus.", node.Name)
+	}
+	return url, nil
+}
+
+// handleGo opens the text a citation such as MN 10 or SN 56.11 points to,
+// as typed in the box in the header: /go?q=SN+56.11
+func handleGo(w http.ResponseWriter, r *http.Request) {
+	q := strings.TrimSpace(r.FormValue("q"))
+	if q == "" {
+		http.Redirect(w, r, sitePath("/canon"), http.StatusFound)
+		return
+	}
+	url, err := resolveCitation(r, q)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		renderCanon(w, r, canon, err.Error())
+		return
+	}
+	http.Redirect(w, r, sitePath(url), http.StatusFound)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestCitationKeys(t *testing.T) {
+	tests := []struct {
+		citation string
+		keys     []string
+	}{
+		{"Vin I 1", []string{"pts:v1:1"}},
+		{"D ii 290", []string{"pts:d2:290"}},
+		{"PTS D.i.87", []string{"pts:d1:87"}},
+		{"M I, 56f", []string{"pts:m1:56"}},
+		{"S iii 66–68", []string{"pts:s3:66"}},
+		{"A iv 012", []string{"pts:a4:12"}},
+		{"SN 56.11", []string{"sn:56.11"}},
+		{"sn56.11", []string{"sn:56.11"}},
+		{"S 22:59", []string{"sn:22.59"}},
+		{"AN 4.10", []string{"an:4.10"}},
+		{"SN 56", []string{"sn:56"}},
+		{"Dhp 183", []string{"dhp:183"}},
+		{"Vism 123", []string{"pts:vism:123", "pts:vism1:123", "pts:vism2:123"}},
+		// Placed by the canon rather than the index
+		{"MN 10", nil},
+		{"Dhp", nil},
+	}
+	for _, tt := range tests {
+		m := citationReference.FindStringSubmatch(tt.citation)
+		if m == nil {
+			t.Errorf("%q is not read as a citation", tt.citation)
+			continue
+		}
+		if keys := citationKeys(m[1], m[2], m[3], m[4]); !slices.Equal(keys, tt.keys) {
+			t.Errorf("citationKeys of %q = %q; want %q", tt.citation, keys, tt.keys)
+		}
+	}
+
+	for _, s := range []string{"", "evaṃ me sutaṃ", "MN 10 and 11", "12"} {
+		if citationReference.MatchString(s) {
+			t.Errorf("%q is read as a citation", s)
+		}
+	}
+}
+
+func TestRomanNumber(t *testing.T) {
+	tests := []struct {
+		numeral string
+		n       int
+	}{
+		{"i", 1}, {"ii", 2}, {"IV", 4}, {"v", 5}, {"vi", 6}, {"ix", 9}, {"xi", 11}, {"XIV", 14},
+	}
+	for _, tt := range tests {
+		if n := romanNumber(tt.numeral); n != tt.n {
+			t.Errorf("romanNumber(%q) = %d; want %d", tt.numeral, n, tt.n)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"io/fs"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ParagraphCitation is how a paragraph of a text is quoted
+type ParagraphCitation struct {
+	Title     string `json:"title"`
+	Section   string `json:"section,omitempty"`   // the heading of the sutta or vagga the paragraph is in
+	Reference string `json:"reference,omitempty"` // as the canon is cited, like MN 10, SN 56.11 or Dhp 183
+	PTS       string `json:"pts,omitempty"`       // the PTS page the paragraph is on, like D I 87
+	URL       string `json:"url"`
+	Text      string `json:"text"` // all of the above on one line
+}
+
+// citedWorks are the abbreviations of the works in the keys of the index's
+// citations, as they are written
+var citedWorks = map[string]string{"sn": "SN", "an": "AN", "dhp": "Dhp"}
+
+// citeParagraph cites the paragraph p of the text at name, whose content
+// is given, linking to it below base
+func citeParagraph(name, content string, p int, base string) (*ParagraphCitation, error) {
+	c := &ParagraphCitation{Title: displayTitle(name)}
+
+	// The last heading and PTS page up to the paragraph
+	refs := referencePatterns(name)
+	volume := ""
+	for i, part := range paragraphBreak.Split(extractBody(content), -1) {
+		if i > p {
+			break
+		}
+		for _, ref := range findReferences(refs, part) {
+			switch {
+			case ref.volume != "":
+				volume = ptsVolumeName(ref.volume)
+			case ref.page != "":
+				c.PTS = strings.TrimSpace(volume + " " + strings.TrimLeft(ref.page, "0"))
+			}
+		}
+		var lines []string
+		for _, line := range lineBreak.Split(stripReferences(refs, part), -1) {
+			line = strings.Join(strings.Fields(html.UnescapeString(tagPattern.ReplaceAllString(line, " "))), " ")
+			if line != "" {
+				lines = append(lines, line)
+			}
+		}
+		if isSectionHeading(lines) {
+			c.Section = strings.TrimRight(lines[0], ".: ")
+		}
+	}
+	if c.Section == c.Title {
+		c.Section = ""
+	}
+
+	// The division of the canon the paragraph is in: a sutta of the canon
+	// page headed before it, or a saṃyutta, sutta or verse the index cites
+	at := -1
+	for _, n := range canonIndex().byText[strings.ToLower(textTitle(name))] {
+		if start, ok := headingAnchor(n, name); ok && n.ID != "" && start <= p && start >= at {
+			c.Reference, at = n.ID, start
+		}
+	}
+	textCitations(name, content, func(key string, paragraph int) {
+		work, number, _ := strings.Cut(key, ":")
+		if abbr, ok := citedWorks[work]; ok && paragraph <= p && paragraph > at {
+			c.Reference, at = abbr+" "+number, paragraph
+		}
+	})
+
+	page, err := paragraphPage(name, p)
+	if err != nil {
+		return nil, err
+	}
+	c.URL = base + "/read/" + slugPath(name)
+	if page > 0 {
+		c.URL += "?page=" + strconv.Itoa(page)
+	}
+	c.URL += fmt.Sprintf("#p%d", p)
+
+	c.Text = c.Title
+	if c.Section != "" {
+		c.Text += ", " + c.Section
+	}
+	var where []string
+	for _, s := range []string{c.Reference, c.PTS} {
+		if s != "" {
+			where = append(where, s)
+		}
+	}
+	if len(where) > 0 {
+		c.Text += " (" + strings.Join(where, "; ") + ")"
+	}
+	c.Text += ". " + c.URL
+	return c, nil
+}
+
+// handleCite cites a paragraph of a text (?p=N, as in its #pN anchor) for
+// quoting: as JSON, or as one line of text with ?format=text
+func handleCite(w http.ResponseWriter, r *http.Request) {
+	filePath := strings.TrimPrefix(r.URL.Path, "/cite/")
+	name, err := corpusName(filePath)
+	if err != nil {
+		writeAPIError(w, r, http.StatusBadRequest, "Invalid path", nil)
+		return
+	}
+	content, err := fs.ReadFile(corpus, name)
+	if err != nil {
+		writeAPIError(w, r, http.StatusNotFound, "File not found", nil)
+		return
+	}
+	p, err := strconv.Atoi(r.URL.Query().Get("p"))
+	if err != nil || p < 0 || p >= len(paragraphs(name, string(content))) {
+		writeAPIError(w, r, http.StatusNotFound, "No such paragraph", map[string]string{"field": "p"})
+		return
+	}
+	c, err := citeParagraph(name, string(content), p, baseURL(r))
+	if err != nil {
+		logf(r.Context(), "Error citing %s: %v", name, err)
+		writeAPIError(w, r, http.StatusInternalServerError, "Cannot cite this paragraph", nil)
+		return
+	}
+	if r.URL.Query().Get("format") == "text" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintln(w, c.Text)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c)
+}
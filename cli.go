@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// parseInterspersed parses flags that may appear before, between or after
+// the positional arguments, returning the positional ones
+func parseInterspersed(flags *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		flags.Parse(args)
+		args = flags.Args()
+		if len(args) == 0 {
+			return positional
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// runGrep prints the paragraphs of the corpus containing every word of the
+// query
+func runGrep(args []string) error {
+	flags := flag.NewFlagSet("grep", flag.ExitOnError)
+	filesOnly := flags.Bool("l", false, "only list the texts that match")
+	prefix := flags.Bool("prefix", false, "match words starting with the query words, e.g. to catch inflected forms")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: palireader grep [-l] [-prefix] query")
+		fmt.Fprintln(flags.Output(), "\nPrints every paragraph containing all the words of the query as")
+		fmt.Fprintln(flags.Output(), "path#pN: text, where pN is the paragraph anchor used by the reader.")
+		fmt.Fprintln(flags.Output(), "\nFlags:")
+		flags.PrintDefaults()
+	}
+	query := strings.Join(parseInterspersed(flags, args), " ")
+
+	var terms []string
+	forEachWord(query, func(word string) {
+		terms = append(terms, word)
+	})
+	if len(terms) == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	matches := func(word, term string) bool {
+		if *prefix {
+			return strings.HasPrefix(word, term)
+		}
+		return word == term
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+
+	found := false
+	err := walkCorpus("", func(rel string, d fs.DirEntry) error {
+		if d.IsDir() || !strings.HasSuffix(strings.ToLower(d.Name()), ".htm") {
+			return nil
+		}
+		content, err := fs.ReadFile(corpus, rel)
+		if err != nil {
+			return err
+		}
+
+		for i, text := range paragraphs(rel, string(content)) {
+			hits := make([]bool, len(terms))
+			forEachWord(text, func(word string) {
+				for t, term := range terms {
+					if matches(word, term) {
+						hits[t] = true
+					}
+				}
+			})
+			all := true
+			for _, hit := range hits {
+				all = all && hit
+			}
+			if !all {
+				continue
+			}
+
+			found = true
+			if *filesOnly {
+				fmt.Fprintln(out, rel)
+				return nil
+			}
+			fmt.Fprintf(out, "%s#p%d: %s\n", rel, i, text)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if !found {
+		// Like grep, exit with status 1 when nothing matched
+		out.Flush()
+		os.Exit(1)
+	}
+	return nil
+}
+
+// runCat prints a text, or one of its paragraphs, without starting the server
+func runCat(args []string) error {
+	flags := flag.NewFlagSet("cat", flag.ExitOnError)
+	format := flags.String("format", "txt", "output format: txt (plain paragraphs) or html (as shown by the reader)")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: palireader cat [-format txt|html] text[#pN]")
+		fmt.Fprintln(flags.Output(), "\nThe text is a path below 2_pali, with or without .htm, or just a file")
+		fmt.Fprintln(flags.Output(), "name such as dighan2u. #pN limits the output to paragraph N.")
+		fmt.Fprintln(flags.Output(), "\nFlags:")
+		flags.PrintDefaults()
+	}
+	names := parseInterspersed(flags, args)
+	if len(names) != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	if *format != "txt" && *format != "html" {
+		return fmt.Errorf("unknown format %q", *format)
+	}
+
+	name, anchor, _ := strings.Cut(names[0], "#")
+	rel, err := findText(name)
+	if err != nil {
+		return err
+	}
+	content, err := fs.ReadFile(corpus, rel)
+	if err != nil {
+		return err
+	}
+
+	paragraph := -1
+	if anchor != "" {
+		paragraph, err = strconv.Atoi(strings.TrimPrefix(anchor, "p"))
+		if err != nil || paragraph < 0 {
+			return fmt.Errorf("invalid paragraph anchor %q", anchor)
+		}
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+
+	if *format == "html" {
+		body := processHTMContent(rel, string(content))
+		if paragraph >= 0 {
+			parts := paragraphBreak.Split(extractBody(string(content)), -1)
+			if paragraph >= len(parts) {
+				return fmt.Errorf("%s has paragraphs 0 to %d", rel, len(parts)-1)
+			}
+			var b strings.Builder
+			writeParagraph(&b, rel, referencePatterns(rel), overlayMarks()[rel][paragraph], paragraph, parts[paragraph], nil)
+			body = b.String()
+		}
+		fmt.Fprintln(out, body)
+		return nil
+	}
+
+	paras := paragraphs(rel, string(content))
+	if paragraph >= 0 {
+		if paragraph >= len(paras) {
+			return fmt.Errorf("%s has paragraphs 0 to %d", rel, len(paras)-1)
+		}
+		fmt.Fprintln(out, paras[paragraph])
+		return nil
+	}
+	first := true
+	for _, text := range paras {
+		if text == "" {
+			continue
+		}
+		if !first {
+			fmt.Fprintln(out)
+		}
+		fmt.Fprintln(out, text)
+		first = false
+	}
+	return nil
+}
+
+// findText resolves a path or file name given on the command line to a path
+// relative to the corpus
+func findText(name string) (string, error) {
+	name = strings.Trim(filepath.ToSlash(name), "/")
+	if !strings.HasSuffix(strings.ToLower(name), ".htm") {
+		name += ".htm"
+	}
+	if clean, err := corpusName(name); err == nil {
+		if info, err := fs.Stat(corpus, clean); err == nil && !info.IsDir() {
+			return clean, nil
+		}
+	}
+
+	// Otherwise look for a text of that file name anywhere in the corpus
+	var found []string
+	err := walkCorpus("", func(rel string, d fs.DirEntry) error {
+		if !d.IsDir() && strings.EqualFold(d.Name(), path.Base(name)) {
+			found = append(found, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	switch len(found) {
+	case 0:
+		return "", fmt.Errorf("no text %q in the corpus", strings.TrimSuffix(name, ".htm"))
+	case 1:
+		return found[0], nil
+	}
+	return "", errors.New("ambiguous text name, use one of: " + strings.Join(found, ", "))
+}
@@ -0,0 +1,396 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"time"
+)
+
+// command is a subcommand of the palireader binary. Commands parse their
+// own flags; the config file has been loaded when run is called.
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) error
+}
+
+var commands []command
+
+func init() {
+	// Assigned here because runHelp refers back to the table
+	commands = []command{
+		{"serve", "run the web server (default)", runServe},
+		{"build", "write the library as a static site", runBuild},
+		{"render", "snapshot the rendered pages, or check them against snapshots", runRender},
+		{"index", "build the word index and check every text", runIndex},
+		{"import", "add words from a word list or CSV file to the vocabulary", runImport},
+		{"export", "write looked-up or saved words as Anki cards or CSV", runExport},
+		{"stats", "show the size of the corpus and of your reading data", runStats},
+		{"grep", "print the paragraphs containing the given words", runGrep},
+		{"cat", "print a text as plain text or HTML", runCat},
+		{"chars", "list the characters of the texts and flag suspicious ones", runChars},
+		{"anusvara", "write the niggahīta of the texts in one convention", runAnusvara},
+		{"replace", "find and replace a pattern across the texts", runReplace},
+		{"sync", "clone or pull the texts from their git repository", runSync},
+		{"fetch-dict", "download a dictionary dataset for offline lookups", runFetchDict},
+		{"embed", "build the passage embeddings for semantic search", runEmbed},
+		{"tui", "read the corpus in the terminal", runTUI},
+		{"mcp", "serve search, dictionary and text tools over JSON-RPC (MCP)", runMCP},
+		{"help", "show help for a command", runHelp},
+	}
+}
+
+func findCommand(name string) *command {
+	for i := range commands {
+		if commands[i].name == name {
+			return &commands[i]
+		}
+	}
+	return nil
+}
+
+// usage lists the commands and global flags
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [-config file] [-theme-dir dir] [command] [arguments]\n\n", os.Args[0])
+	fmt.Fprintln(out, "Commands:")
+	for _, c := range commands {
+		fmt.Fprintf(out, "  %-11s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintln(out, "\nRun 'palireader help <command>' for the command's flags.")
+	fmt.Fprintln(out, "\nFlags:")
+	flag.PrintDefaults()
+}
+
+func runHelp(args []string) error {
+	if len(args) == 0 {
+		flag.CommandLine.SetOutput(os.Stdout)
+		usage()
+		return nil
+	}
+	cmd := findCommand(args[0])
+	if cmd == nil || cmd.name == "help" {
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+	// Every command's flag set prints its usage for -h
+	return cmd.run([]string{"-h"})
+}
+
+func runIndex(args []string) error {
+	flags := flag.NewFlagSet("index", flag.ExitOnError)
+	check := flags.Bool("check", false, "only report whether the stored index is up to date")
+	full := flags.Bool("full", false, "tokenize every text again, not only those that changed")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: palireader index [-check] [-full]")
+		fmt.Fprintln(flags.Output(), "\nBuilds the word index the server uses for search and occurrences, reports")
+		fmt.Fprintln(flags.Output(), "its size and keeps it in the data directory, where the server reads it on")
+		fmt.Fprintln(flags.Output(), "start instead of building it again. Only the texts that changed since the")
+		fmt.Fprintln(flags.Output(), "index was kept are read into it again. Any text that cannot be read is")
+		fmt.Fprintln(flags.Output(), "reported as an error.")
+		fmt.Fprintln(flags.Output(), "\nFlags:")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	fingerprint, err := corpusFingerprint()
+	if err != nil {
+		return err
+	}
+	path := corpusIndexPath()
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if *check {
+		if err != nil {
+			return fmt.Errorf("no index is kept in %s; run 'palireader index' to build it", path)
+		}
+		idx, stored, err := decodeCorpusIndex(data)
+		if err != nil {
+			return fmt.Errorf("%s: %w; run 'palireader index' to build it anew", path, err)
+		}
+		if stored != fingerprint {
+			return fmt.Errorf("%s is out of date, the texts have changed; run 'palireader index' to update it", path)
+		}
+		fmt.Printf("%s is up to date: version %d, %d texts (%d word forms)\n",
+			path, corpusIndexVersion, len(idx.Paths), len(idx.forms))
+		return nil
+	}
+
+	start := time.Now()
+	var old *CorpusIndex
+	if !*full {
+		// An index that cannot be read is built anew
+		old, _, _ = decodeCorpusIndex(data)
+	}
+	idx, tokenized, err := updateCorpusIndex(old)
+	if err != nil {
+		return err
+	}
+	if err := saveCorpusIndex(idx, fingerprint); err != nil {
+		return err
+	}
+	fmt.Printf("Indexed %d texts (%d word forms, %d texts read anew) in %s, kept in %s\n",
+		len(idx.Paths), len(idx.forms), tokenized, time.Since(start).Round(time.Millisecond), path)
+	return nil
+}
+
+func runExport(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	from := flags.String("from", "lookups", "words to export: lookups or vocab")
+	format := flags.String("format", "anki", "output format: anki or csv")
+	base := flags.String("base", "", "URL the reader is served at, for links back to the texts (default http://localhost:<port>)")
+	user := flags.String("user", "", "whose words to export, when logins are configured")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: palireader export [-from lookups|vocab] [-format anki|csv] [-base url] [-user name] > file")
+		fmt.Fprintln(flags.Output(), "\nWrites the same files as /export/flashcards to standard output.")
+		fmt.Fprintln(flags.Output(), "\nFlags:")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if err := checkUser(*user); err != nil {
+		return err
+	}
+
+	var words *jsonFile[[]Lookup]
+	switch *from {
+	case "lookups":
+		words = lookups.forUser(*user)
+	case "vocab":
+		words = vocabulary.forUser(*user)
+	default:
+		return fmt.Errorf("unknown word list %q", *from)
+	}
+	if *base == "" {
+		*base = "http://localhost:" + config.Port + config.BasePath
+	}
+	if err := loadConfiguredDictionary(); err != nil {
+		return fmt.Errorf("loading dictionary: %w", err)
+	}
+
+	cards, err := flashcards(words)
+	if err != nil {
+		return err
+	}
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	switch *format {
+	case "anki":
+		writeFlashcardsAnki(out, cards, strings.TrimSuffix(*base, "/"))
+	case "csv":
+		writeFlashcardsCSV(out, cards, strings.TrimSuffix(*base, "/"))
+	default:
+		return fmt.Errorf("unknown format %q", *format)
+	}
+	return nil
+}
+
+func runImport(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: palireader import [-bookmarks] [-user name] file")
+		fmt.Fprintln(flags.Output(), "\nAdds words to the vocabulary from a file with one word per line, or from")
+		fmt.Fprintln(flags.Output(), "a CSV file with a word column and optionally a link column, like the")
+		fmt.Fprintln(flags.Output(), "files written by 'palireader export -format csv'. Use - for standard input.")
+		fmt.Fprintln(flags.Output(), "\nWith -bookmarks, adds bookmarks from a Digital Pali Reader or browser")
+		fmt.Fprintln(flags.Output(), "bookmark export, or from a list of URLs and DPR locations.")
+		flags.PrintDefaults()
+	}
+	importBookmarks := flags.Bool("bookmarks", false, "import bookmarks instead of words")
+	user := flags.String("user", "", "whose vocabulary or bookmarks to add to, when logins are configured")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	if err := checkUser(*user); err != nil {
+		return err
+	}
+
+	var r io.Reader = os.Stdin
+	if name := flags.Arg(0); name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	if *importBookmarks {
+		return addBookmarks(r, bookmarks.forUser(*user))
+	}
+	entries, err := readWordList(r)
+	if err != nil {
+		return err
+	}
+
+	words := vocabulary.forUser(*user)
+	err = words.Update(func(list *[]Lookup) error {
+		*list = append(*list, entries...)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Added %d words to %s\n", len(entries), words.where())
+	return nil
+}
+
+// addBookmarks adds the bookmarks of an export that place in the corpus to
+// file, skipping those imported before
+func addBookmarks(r io.Reader, file *jsonFile[[]Bookmark]) error {
+	refs, err := readBookmarkRefs(r)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	var added []Bookmark
+	var skipped []string
+	for _, ref := range refs {
+		path, anchor, ok := resolveBookmark(ref.Ref)
+		if !ok {
+			skipped = append(skipped, ref.Ref)
+			continue
+		}
+		added = append(added, Bookmark{Path: path, Anchor: anchor, Title: ref.Title, Source: ref.Ref, Time: now})
+	}
+
+	count := 0
+	err = file.Update(func(list *[]Bookmark) error {
+		count = 0
+		kept := make(map[string]bool)
+		for _, b := range *list {
+			kept[b.Source] = true
+		}
+		for _, b := range added {
+			if !kept[b.Source] {
+				kept[b.Source] = true
+				*list = append(*list, b)
+				count++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, ref := range skipped {
+		fmt.Fprintf(os.Stderr, "No text for %s\n", ref)
+	}
+	fmt.Printf("Added %d bookmarks to %s\n", count, file.where())
+	return nil
+}
+
+// readWordList reads words, one per line or as the word column of a CSV file
+// with a header row
+func readWordList(r io.Reader) ([]Lookup, error) {
+	rows, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, errors.New("the file holds no words")
+	}
+
+	wordCol, linkCol := 0, -1
+	header := rows[0]
+	for i, name := range header {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "word":
+			wordCol = i
+		case "link":
+			linkCol = i
+		}
+	}
+	if len(header) > 1 || strings.EqualFold(strings.TrimSpace(header[0]), "word") {
+		rows = rows[1:]
+	}
+
+	now := time.Now().UTC()
+	var entries []Lookup
+	for _, row := range rows {
+		if wordCol >= len(row) {
+			continue
+		}
+		word := cleanWord(strings.TrimSpace(row[wordCol]))
+		if word == "" || !containsLetter(word) {
+			continue
+		}
+		entry := Lookup{Word: word, Time: now}
+		if linkCol >= 0 && linkCol < len(row) {
+			if _, path, ok := strings.Cut(row[linkCol], "/read/"); ok {
+				entry.Source = path
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func runStats(args []string) error {
+	flags := flag.NewFlagSet("stats", flag.ExitOnError)
+	user := flags.String("user", "", "whose reading data to show, when logins are configured")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: palireader stats [-user name]")
+		fmt.Fprintln(flags.Output(), "\nShows the size of the corpus, the dictionary and your reading data.")
+		fmt.Fprintln(flags.Output(), "\nFlags:")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if err := checkUser(*user); err != nil {
+		return err
+	}
+
+	idx, err := buildCorpusIndex()
+	if err != nil {
+		return err
+	}
+	words := 0
+	for _, postings := range idx.Postings {
+		for _, p := range postings {
+			words += p.Count
+		}
+	}
+	fmt.Printf("Texts:        %d\n", len(idx.Paths))
+	fmt.Printf("Words:        %d\n", words)
+	fmt.Printf("Word forms:   %d\n", len(idx.forms))
+
+	if err := loadConfiguredDictionary(); err != nil {
+		return fmt.Errorf("loading dictionary: %w", err)
+	}
+	if dictionary != nil {
+		fmt.Printf("Dictionary:   %d headwords\n", len(dictionary.glosses))
+	}
+
+	var looked, saved int
+	if err := lookups.forUser(*user).Read(func(list *[]Lookup) { looked = len(*list) }); err != nil {
+		return err
+	}
+	if err := vocabulary.forUser(*user).Read(func(list *[]Lookup) { saved = len(*list) }); err != nil {
+		return err
+	}
+	fmt.Printf("Lookups:      %d\n", looked)
+	fmt.Printf("Saved words:  %d\n", saved)
+
+	due := 0
+	err = reviews.forUser(*user).Read(func(cards *map[string]*ReviewCard) {
+		for _, c := range *cards {
+			if !c.Due.After(time.Now()) {
+				due++
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Reviews due:  %d\n", due)
+	return nil
+}
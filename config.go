@@ -0,0 +1,425 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+const defaultConfigFile = "palireader.json"
+
+// Config holds the settings read from the optional JSON config file
+type Config struct {
+	Port       string               `json:"port"`
+	BasePath   string               `json:"basePath"`  // URL prefix the site is served under, like "/pali"
+	Listeners  []ListenerConfig     `json:"listeners"` // defaults to one on Port
+	Autocert   *AutocertConfig      `json:"autocert"`  // nil leaves certificates to the listeners
+	Auth       *AuthConfig          `json:"auth"`      // nil leaves the site open to everyone
+	Timeouts   TimeoutConfig        `json:"timeouts"`
+	AccessLog  *AccessLogConfig     `json:"accessLog"` // nil logs no requests
+	RateLimit  *RateLimitConfig     `json:"rateLimit"` // nil lets clients call as often as they like
+	Quotas     *QuotaConfig         `json:"quotas"`    // nil sets no quotas
+	Cache      *CacheConfig         `json:"cache"`     // nil keeps pages in memory while the corpus is watched
+	State      *StateConfig         `json:"state"`     // nil keeps users' data in data files
+	Warmup     *WarmupConfig        `json:"warmup"`    // nil starts serving without processing anything first
+	Stats      StatsConfig          `json:"stats"`
+	Private    bool                 `json:"private"` // keeps no record of what readers read, look up or save
+	Providers  []DictionaryProvider `json:"providers"`
+	Editions   []EditionSite        `json:"editions"`  // linked from each text
+	Libraries  []Library            `json:"libraries"` // defaults to the GRETIL texts alone
+	Git        *GitSource           `json:"git"`       // where sync gets the GRETIL texts from, without libraries
+	Overlays   []OverlayConfig      `json:"overlays"`  // pages of other printed editions
+	References []ReferenceConfig    `json:"references"`
+	DataDir    string               `json:"dataDir"`
+	ThemeDir   string               `json:"themeDir"`   // templates and assets overriding the built-in ones
+	Dictionary string               `json:"dictionary"` // local dictionary file, optional
+	DictProxy  *DictProxyConfig     `json:"dictProxy"`  // nil disables the upstream lookup
+	Semantic   *SemanticConfig      `json:"semantic"`   // nil disables semantic search
+	Ask        *AskConfig           `json:"ask"`        // nil disables /ask
+	TTS        *TTSConfig           `json:"tts"`        // nil hides the Listen button
+	Gemini     *GeminiConfig        `json:"gemini"`     // nil disables the Gemini listener
+
+	// Dictionaries names dataset URLs for fetch-dict
+	Dictionaries map[string]string `json:"dictionaries"`
+}
+
+// DictProxyConfig controls the server-side dictionary proxy. Upstream is a
+// URL template like a provider's; CacheHours of zero keeps entries forever.
+type DictProxyConfig struct {
+	Upstream   string `json:"upstream"`
+	CacheDir   string `json:"cacheDir"`
+	CacheHours int    `json:"cacheHours"`
+}
+
+// TimeoutConfig bounds the web server's connections, in seconds; zero means
+// no limit. Shutdown is how long in-flight requests may take to finish once
+// the server is asked to stop.
+type TimeoutConfig struct {
+	Read     int `json:"read"`
+	Write    int `json:"write"`
+	Idle     int `json:"idle"`
+	Shutdown int `json:"shutdown"`
+}
+
+// DictionaryProvider is an external dictionary a word can be looked up in.
+// URL is a template in which {word} is replaced by the escaped word.
+type DictionaryProvider struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+// LookupURL returns the provider's URL for the given word, composed as
+// dictionaries spell their headwords
+func (p DictionaryProvider) LookupURL(word string) string {
+	return strings.ReplaceAll(p.URL, "{word}", url.QueryEscape(norm.NFC.String(word)))
+}
+
+var config Config
+
+// defaultConfig returns the configuration used when no config file exists
+func defaultConfig() Config {
+	return Config{
+		Port:    "8000",
+		DataDir: "data",
+		// Write leaves room for /ask, which waits up to three minutes on
+		// the model
+		Timeouts: TimeoutConfig{Read: 30, Write: 300, Idle: 120, Shutdown: 30},
+		Providers: []DictionaryProvider{
+			{Name: "DPD", URL: "https://dpdict.net/?tab=dpd&q={word}"},
+			{Name: "PTS", URL: "https://dsal.uchicago.edu/cgi-bin/app/pali_query.py?qs={word}&searchhws=yes"},
+			{Name: "SuttaCentral", URL: "https://suttacentral.net/define/{word}"},
+		},
+		Editions: []EditionSite{
+			{Name: "SuttaCentral", URL: "https://suttacentral.net/{uid}"},
+			{Name: "tipitaka.org", URL: "https://tipitaka.org/romn/cscd/{cscd}.mul0.xml"},
+			{Name: "Ancient Buddhist Texts", URL: "https://www.ancient-buddhist-texts.net/Texts-and-Translations/{abt}/index.htm"},
+		},
+		References: defaultReferences(),
+		State:      &StateConfig{Backend: "sqlite"},
+	}
+}
+
+// loadConfig reads the config file at path, filling in defaults for anything
+// left unset. A missing file is only an error when required is true.
+func loadConfig(path string, required bool) (Config, error) {
+	cfg := defaultConfig()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) && !required {
+			return cfg, nil
+		}
+		return cfg, err
+	}
+
+	// Fields missing from the file keep their defaults
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("%s: %w", path, err)
+	}
+
+	if cfg.Private {
+		if cfg.AccessLog != nil {
+			return cfg, fmt.Errorf("%s: private keeps no record of requests; remove accessLog", path)
+		}
+		cfg.Stats.Off = true
+	}
+
+	if len(cfg.Providers) == 0 {
+		return cfg, fmt.Errorf("%s: at least one provider is required", path)
+	}
+	for _, p := range cfg.Providers {
+		if p.Name == "" || !strings.Contains(p.URL, "{word}") {
+			return cfg, fmt.Errorf("%s: provider %q needs a name and a url containing {word}", path, p.Name)
+		}
+	}
+
+	if cfg.BasePath = strings.TrimRight(cfg.BasePath, "/"); cfg.BasePath != "" && !strings.HasPrefix(cfg.BasePath, "/") {
+		return cfg, fmt.Errorf("%s: basePath must start with /", path)
+	}
+
+	for _, e := range cfg.Editions {
+		if e.Name == "" || e.URL == "" {
+			return cfg, fmt.Errorf("%s: edition %q needs a name and a url", path, e.Name)
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, l := range cfg.Libraries {
+		if l.Name == "" || l.Dir == "" || strings.ContainsAny(l.Name, "/\\") || l.Name == "." || l.Name == ".." {
+			return cfg, fmt.Errorf("%s: library %q needs a name without slashes and a dir", path, l.Name)
+		}
+		if seen[l.Name] {
+			return cfg, fmt.Errorf("%s: library %q is listed twice", path, l.Name)
+		}
+		seen[l.Name] = true
+		if l.Git != nil && (l.Git.Repo == "" || isArchive(l.Dir)) {
+			return cfg, fmt.Errorf("%s: library %q needs a git repo and a folder, not an archive, to sync", path, l.Name)
+		}
+	}
+	if g := cfg.Git; g != nil {
+		if g.Repo == "" {
+			return cfg, fmt.Errorf("%s: git needs a repo", path)
+		}
+		if len(cfg.Libraries) > 0 {
+			return cfg, fmt.Errorf("%s: with libraries, set git on each library to sync", path)
+		}
+	}
+
+	overlays := make(map[string]bool)
+	for _, o := range cfg.Overlays {
+		if !referenceClass.MatchString(o.Name) || o.File == "" {
+			return cfg, fmt.Errorf("%s: overlay %q needs a name of letters, digits and dashes and a file", path, o.Name)
+		}
+		if overlays[o.Name] {
+			return cfg, fmt.Errorf("%s: overlay %q is listed twice", path, o.Name)
+		}
+		overlays[o.Name] = true
+	}
+
+	for i := range cfg.References {
+		for j := range cfg.References[i].Patterns {
+			if err := cfg.References[i].Patterns[j].compile(); err != nil {
+				return cfg, fmt.Errorf("%s: %w", path, err)
+			}
+		}
+	}
+
+	for _, l := range cfg.Listeners {
+		if l.Addr == "" || (l.CertFile == "") != (l.KeyFile == "") {
+			return cfg, fmt.Errorf("%s: listener %q needs an addr and both certFile and keyFile, or neither", path, l.Addr)
+		}
+	}
+
+	if a := cfg.Auth; a != nil {
+		if len(a.Users) == 0 && a.OIDC == nil {
+			return cfg, fmt.Errorf("%s: auth needs at least one user", path)
+		}
+		if a.SessionHours <= 0 {
+			a.SessionHours = 30 * 24
+		}
+		// Single sign-on users are only known once they log in
+		for _, admin := range a.Admins {
+			if _, ok := a.Users[admin]; !ok && a.OIDC == nil {
+				return cfg, fmt.Errorf("%s: admin %q is not one of the users", path, admin)
+			}
+		}
+		for _, g := range a.Roles {
+			if _, ok := a.Users[g.User]; !ok && a.OIDC == nil {
+				return cfg, fmt.Errorf("%s: role for %q, who is not one of the users", path, g.User)
+			}
+			if roleRanks[g.Role] == 0 {
+				return cfg, fmt.Errorf("%s: role %q for %s must be reader, editor or admin", path, g.Role, g.User)
+			}
+		}
+	}
+
+	if a := cfg.Auth; a != nil && a.OIDC != nil {
+		o := a.OIDC
+		if o.Issuer == "" || o.ClientID == "" {
+			return cfg, fmt.Errorf("%s: oidc needs the issuer and the clientID", path)
+		}
+		if o.Name == "" {
+			o.Name = "single sign-on"
+		}
+		if len(o.Scopes) == 0 {
+			o.Scopes = []string{"openid", "email", "profile"}
+		}
+		if o.UserClaim == "" {
+			o.UserClaim = "email"
+		}
+		for i, d := range o.Domains {
+			o.Domains[i] = strings.ToLower(strings.TrimPrefix(d, "@"))
+		}
+		for _, rc := range o.RoleClaims {
+			if rc.Claim == "" || rc.Value == "" || roleRanks[rc.Role] == 0 {
+				return cfg, fmt.Errorf("%s: oidc roleClaims need a claim, a value and a role of reader, editor or admin", path)
+			}
+		}
+	}
+
+	if a := cfg.Autocert; a != nil && len(a.Domains) == 0 {
+		return cfg, fmt.Errorf("%s: autocert needs the domains to get certificates for", path)
+	}
+
+	if t := cfg.TTS; t != nil {
+		if err := t.validate(); err != nil {
+			return cfg, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	if t := cfg.Timeouts; t.Read < 0 || t.Write < 0 || t.Idle < 0 || t.Shutdown < 0 {
+		return cfg, fmt.Errorf("%s: timeouts cannot be negative", path)
+	}
+
+	if l := cfg.AccessLog; l != nil {
+		if l.Format == "" {
+			l.Format = "logfmt"
+		}
+		if l.Format != "logfmt" && l.Format != "json" {
+			return cfg, fmt.Errorf("%s: accessLog format must be logfmt or json", path)
+		}
+	}
+
+	if l := cfg.RateLimit; l != nil {
+		if l.PerMinute <= 0 {
+			l.PerMinute = 60
+		}
+		if l.Burst <= 0 {
+			l.Burst = 20
+		}
+		if l.Paths == nil {
+			l.Paths = defaultRateLimitPaths
+		}
+		if l.ProxyHops < 0 {
+			return cfg, fmt.Errorf("%s: rateLimit proxyHops cannot be negative", path)
+		}
+	}
+
+	if q := cfg.Quotas; q != nil {
+		for kind, quota := range map[string]*Quota{"ask": q.Ask, "export": q.Export, "analysis": q.Analysis, "speech": q.Speech} {
+			if quota == nil {
+				continue
+			}
+			if quota.Limit <= 0 || quota.Hours < 0 {
+				return cfg, fmt.Errorf("%s: the %s quota needs a limit above 0 and hours of 0 or more", path, kind)
+			}
+			if quota.Hours == 0 {
+				quota.Hours = 24
+			}
+		}
+	}
+
+	if c := cfg.Cache; c != nil {
+		switch c.Backend {
+		case "memory":
+			if c.MaxMB <= 0 {
+				c.MaxMB = 256
+			}
+		case "disk":
+			if c.Dir == "" {
+				c.Dir = filepath.Join("cache", "shared")
+			}
+		case "redis":
+			if c.Redis == "" {
+				return cfg, fmt.Errorf("%s: the redis cache needs the address of the server", path)
+			}
+			if c.Hours < 0 {
+				return cfg, fmt.Errorf("%s: cache hours cannot be negative", path)
+			}
+			if c.Hours == 0 {
+				c.Hours = 7 * 24
+			}
+		default:
+			return cfg, fmt.Errorf("%s: cache backend must be memory, disk or redis", path)
+		}
+	}
+
+	if s := cfg.State; s != nil {
+		switch s.Backend {
+		case "sqlite":
+		case "files":
+			cfg.State = nil
+		case "redis":
+			if s.Redis == "" {
+				return cfg, fmt.Errorf("%s: the redis state store needs the address of the server", path)
+			}
+			if _, err := newRedisCache(s.Redis); err != nil {
+				return cfg, fmt.Errorf("%s: %v", path, err)
+			}
+		default:
+			return cfg, fmt.Errorf("%s: state backend must be sqlite, redis or files", path)
+		}
+	}
+
+	if w := cfg.Warmup; w != nil {
+		if w.Texts < 0 || w.Words < 0 {
+			return cfg, fmt.Errorf("%s: warmup texts and words cannot be negative", path)
+		}
+		if w.Texts == 0 {
+			w.Texts = 20
+		}
+		if w.Words == 0 {
+			w.Words = 100
+		}
+	}
+
+	if p := cfg.DictProxy; p != nil {
+		if p.Upstream == "" {
+			p.Upstream = cfg.Providers[0].URL
+		}
+		if !strings.Contains(p.Upstream, "{word}") {
+			return cfg, fmt.Errorf("%s: dictProxy upstream needs a url containing {word}", path)
+		}
+		if p.CacheDir == "" {
+			p.CacheDir = filepath.Join("cache", "dict")
+		}
+	}
+
+	if a := cfg.Ask; a != nil {
+		if a.URL == "" {
+			return cfg, fmt.Errorf("%s: ask needs the url of a chat completions endpoint", path)
+		}
+		if a.MaxPassages <= 0 {
+			a.MaxPassages = 8
+		}
+	}
+
+	if g := cfg.Gemini; g != nil {
+		if g.Addr == "" {
+			g.Addr = ":1965"
+		}
+		if g.Hostname == "" {
+			g.Hostname = "localhost"
+		}
+		if (g.CertFile == "") != (g.KeyFile == "") {
+			return cfg, fmt.Errorf("%s: gemini needs both certFile and keyFile, or neither", path)
+		}
+	}
+
+	return cfg, nil
+}
+
+// dictionaryPath returns the local dictionary to load: the configured file,
+// or the one installed by fetch-dict if present
+func (c Config) dictionaryPath() string {
+	if c.Dictionary != "" {
+		return c.Dictionary
+	}
+	if _, err := os.Stat(c.installedDictionaryPath()); err == nil {
+		return c.installedDictionaryPath()
+	}
+	return ""
+}
+
+// installedDictionaryPath is where fetch-dict writes its dictionary
+func (c Config) installedDictionaryPath() string {
+	return filepath.Join(c.DataDir, "dictionary.tsv")
+}
+
+// listeners returns the configured listeners, or a single one on Port (on
+// the HTTPS port with autocert)
+func (c Config) listeners() []ListenerConfig {
+	if len(c.Listeners) == 0 {
+		if c.Autocert != nil {
+			return []ListenerConfig{{Addr: ":443"}}
+		}
+		return []ListenerConfig{{Addr: ":" + c.Port}}
+	}
+	return c.Listeners
+}
+
+// sitePath returns the URL path of a page of the site, below the base path
+func sitePath(p string) string {
+	return config.BasePath + p
+}
+
+// defaultProvider returns the provider used for plain word links
+func (c Config) defaultProvider() DictionaryProvider {
+	return c.Providers[0]
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// maxReverseResults caps how many candidates a reverse lookup returns
+const maxReverseResults = 100
+
+// Dictionary is a local Pali-English dictionary loaded from disk
+type Dictionary struct {
+	glosses map[string][]string // headword -> glosses
+	reverse map[string][]string // English term -> headwords
+}
+
+// DictEntry is a single headword and its gloss
+type DictEntry struct {
+	Word  string `json:"word"`
+	Gloss string `json:"gloss"`
+}
+
+// ReverseMatch is a candidate Pali word for an English query
+type ReverseMatch struct {
+	Word  string
+	Gloss string
+	score int
+}
+
+// dictionary is nil when no local dictionary is configured
+var dictionary *Dictionary
+
+// loadDictionary reads a dictionary file into memory
+func loadDictionary(path string) (*Dictionary, error) {
+	entries, err := readDictionaryEntries(path)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &Dictionary{
+		glosses: make(map[string][]string),
+		reverse: make(map[string][]string),
+	}
+	for _, e := range entries {
+		d.add(e)
+	}
+	return d, nil
+}
+
+// loadConfiguredDictionary loads the configured or installed dictionary, if
+// there is one
+func loadConfiguredDictionary() error {
+	path := config.dictionaryPath()
+	if path == "" {
+		return nil
+	}
+	var err error
+	dictionary, err = loadDictionary(path)
+	return err
+}
+
+// readDictionaryEntries reads the entries of a dictionary file. Files ending
+// in .json hold either an array of {"word", "gloss"} objects or an object
+// mapping words to glosses; .csv files need word and gloss columns; anything
+// else is read as tab-separated "word<TAB>gloss" lines.
+func readDictionaryEntries(path string) ([]DictEntry, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return readJSONDictionary(path)
+	case ".csv":
+		return readCSVDictionary(path)
+	default:
+		return readTSVDictionary(path)
+	}
+}
+
+func readTSVDictionary(path string) ([]DictEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []DictEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		word, gloss, ok := strings.Cut(scanner.Text(), "\t")
+		if !ok || strings.HasPrefix(word, "#") {
+			continue
+		}
+		entries = append(entries, DictEntry{Word: word, Gloss: gloss})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return entries, nil
+}
+
+func readJSONDictionary(path string) ([]DictEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []DictEntry
+	if err := json.Unmarshal(data, &entries); err == nil {
+		return entries, nil
+	}
+
+	var byWord map[string]string
+	if err := json.Unmarshal(data, &byWord); err != nil {
+		return nil, fmt.Errorf("%s: expected an array of entries or an object of glosses: %w", path, err)
+	}
+	for word, gloss := range byWord {
+		entries = append(entries, DictEntry{Word: word, Gloss: gloss})
+	}
+	return entries, nil
+}
+
+// readCSVDictionary reads a CSV file whose header names the word column
+// (word, headword, pali or lemma) and the gloss column (gloss, meaning or
+// definition). Without a recognizable header the first two columns are used.
+func readCSVDictionary(path string) ([]DictEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	wordCol, glossCol := -1, -1
+	for i, name := range records[0] {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "word", "headword", "pali", "lemma", "lemma_1":
+			if wordCol < 0 {
+				wordCol = i
+			}
+		case "gloss", "meaning", "meaning_1", "definition":
+			if glossCol < 0 {
+				glossCol = i
+			}
+		}
+	}
+	if wordCol < 0 || glossCol < 0 {
+		wordCol, glossCol = 0, 1
+	} else {
+		records = records[1:]
+	}
+
+	var entries []DictEntry
+	for _, record := range records {
+		if len(record) <= wordCol || len(record) <= glossCol {
+			continue
+		}
+		entries = append(entries, DictEntry{Word: record[wordCol], Gloss: record[glossCol]})
+	}
+	return entries, nil
+}
+
+// add records an entry in both the forward and reverse maps
+func (d *Dictionary) add(e DictEntry) {
+	word := cleanWord(strings.TrimSpace(e.Word))
+	gloss := strings.TrimSpace(e.Gloss)
+	if word == "" || gloss == "" {
+		return
+	}
+
+	d.glosses[word] = append(d.glosses[word], gloss)
+
+	seen := make(map[string]bool)
+	for _, term := range glossTerms(gloss) {
+		if seen[term] {
+			continue
+		}
+		seen[term] = true
+		d.reverse[term] = append(d.reverse[term], word)
+	}
+}
+
+// glossTerms splits English gloss text into lowercase terms
+func glossTerms(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// Lookup returns the glosses recorded for a word
+func (d *Dictionary) Lookup(word string) []string {
+	return d.glosses[cleanWord(word)]
+}
+
+// CommonGloss returns the gloss given most often for word, preferring the
+// first on ties
+func (d *Dictionary) CommonGloss(word string) string {
+	glosses := d.Lookup(word)
+	counts := make(map[string]int)
+	best := ""
+	for _, g := range glosses {
+		counts[g]++
+		if counts[g] > counts[best] {
+			best = g
+		}
+	}
+	return best
+}
+
+// ReverseLookup finds Pali words whose glosses contain every term of the
+// English query. Terms also match glosses sharing their stem, so
+// "impermanence" finds words glossed "impermanent".
+func (d *Dictionary) ReverseLookup(query string) []ReverseMatch {
+	terms := glossTerms(query)
+	if len(terms) == 0 {
+		return nil
+	}
+
+	var scores map[string]int
+	for _, term := range terms {
+		termScores := d.matchTerm(term)
+		if scores == nil {
+			scores = termScores
+			continue
+		}
+		for word, score := range scores {
+			if s, ok := termScores[word]; ok {
+				scores[word] = score + s
+			} else {
+				delete(scores, word)
+			}
+		}
+	}
+
+	matches := make([]ReverseMatch, 0, len(scores))
+	for word, score := range scores {
+		gloss := strings.Join(d.glosses[word], "; ")
+		// Prefer words whose gloss is mostly about the query
+		if len(glossTerms(gloss)) <= len(terms)+2 {
+			score++
+		}
+		matches = append(matches, ReverseMatch{Word: word, Gloss: gloss, score: score})
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].score != matches[j].score {
+			return matches[i].score > matches[j].score
+		}
+		if len(matches[i].Word) != len(matches[j].Word) {
+			return len(matches[i].Word) < len(matches[j].Word)
+		}
+		return matches[i].Word < matches[j].Word
+	})
+
+	if len(matches) > maxReverseResults {
+		matches = matches[:maxReverseResults]
+	}
+	return matches
+}
+
+// matchTerm scores headwords for one query term: exact gloss terms count
+// double, terms sharing a stem count once
+func (d *Dictionary) matchTerm(term string) map[string]int {
+	scores := make(map[string]int)
+	for _, word := range d.reverse[term] {
+		scores[word] = 2
+	}
+
+	stem := englishStem(term)
+	if len(stem) < 4 {
+		return scores
+	}
+	for glossTerm, words := range d.reverse {
+		if glossTerm == term || !strings.HasPrefix(glossTerm, stem) || len(glossTerm) > len(stem)+5 {
+			continue
+		}
+		for _, word := range words {
+			if _, ok := scores[word]; !ok {
+				scores[word] = 1
+			}
+		}
+	}
+	return scores
+}
+
+// englishStem strips a few common English suffixes
+func englishStem(term string) string {
+	for _, suffix := range []string{"ness", "ence", "ance", "ment", "tion", "ity", "ing", "ent", "ant", "ed", "es", "s", "e"} {
+		if strings.HasSuffix(term, suffix) && len(term)-len(suffix) >= 4 {
+			return strings.TrimSuffix(term, suffix)
+		}
+	}
+	return term
+}
+
+func handleReverse(w http.ResponseWriter, r *http.Request) {
+	if dictionary == nil {
+		httpError(w, r, "No local dictionary is configured", http.StatusNotFound)
+		return
+	}
+
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	data := PageData{
+		Title: "English → Pali",
+		Query: query,
+	}
+	if query != "" {
+		data.ReverseResults = dictionary.ReverseLookup(query)
+	}
+
+	err := templatesFor(r).ExecuteTemplate(w, "reverse", data)
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// maxUpstreamBytes caps how much of an upstream dictionary page is read
+const maxUpstreamBytes = 4 << 20
+
+var upstreamClient = &http.Client{Timeout: 15 * time.Second}
+
+// dictProxyProvider is the provider entry that points at the local proxy
+var dictProxyProvider = DictionaryProvider{Name: "Reader", URL: "/dict/{word}"}
+
+// handleDict renders a word's entry inside the reader layout: glosses from
+// the local dictionary, plus the upstream page when the proxy is configured,
+// served from the disk cache when possible
+func handleDict(w http.ResponseWriter, r *http.Request) {
+	word := cleanWord(strings.TrimPrefix(r.URL.Path, "/dict/"))
+	if word == "" {
+		http.Redirect(w, r, sitePath("/"), http.StatusFound)
+		return
+	}
+
+	data := PageData{
+		Title: word,
+		Query: word,
+	}
+	if dictionary != nil {
+		data.Glosses = dictionary.Lookup(word)
+	}
+
+	if config.DictProxy != nil {
+		upstream := strings.ReplaceAll(config.DictProxy.Upstream, "{word}", url.QueryEscape(word))
+		entry, fetched, err := cachedDefinition(upstream)
+		data.UpstreamURL = upstream
+		data.Content = template.HTML(entry)
+
+		switch {
+		case err != nil && entry == "" && len(data.Glosses) == 0:
+			logf(r.Context(), "Dictionary lookup for %q failed: %v", word, err)
+			data.Notice = "The dictionary could not be reached and this word is not cached yet."
+			w.WriteHeader(http.StatusBadGateway)
+		case err != nil && entry == "":
+			logf(r.Context(), "Dictionary lookup for %q failed: %v", word, err)
+		case err != nil:
+			data.Notice = fmt.Sprintf("The dictionary could not be reached; showing the copy cached on %s.",
+				fetched.Format("2 Jan 2006"))
+		}
+	} else if len(data.Glosses) == 0 {
+		data.Notice = "This word is not in the local dictionary."
+	}
+
+	err := templatesFor(r).ExecuteTemplate(w, "dict", data)
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// privateDictCache keeps the upstream entries of a private site
+var privateDictCache = newMemoryCache(32 << 20)
+
+// cachedDefinition returns the sanitized upstream page, fetching it only when
+// the cached copy is missing or stale. A stale copy is still returned
+// alongside the error when the upstream cannot be reached.
+func cachedDefinition(upstream string) (string, time.Time, error) {
+	// Entries go to the shared cache when there is one, or else to the
+	// proxy's own folder, or to memory on a private site, where the words
+	// looked up are not to be found on disk
+	var cache Cache = &diskCache{dir: config.DictProxy.CacheDir, ext: ".html"}
+	key := upstream
+	switch {
+	case sharedCache != nil:
+		cache, key = sharedCache, "dict:"+upstream
+	case config.Private:
+		cache = privateDictCache
+	}
+
+	cached, found := cache.Get(key)
+	if found {
+		maxAge := time.Duration(config.DictProxy.CacheHours) * time.Hour
+		if maxAge <= 0 || time.Since(cached.Stored) < maxAge {
+			return string(cached.Value), cached.Stored, nil
+		}
+	}
+
+	entry, err := fetchDefinition(upstream)
+	if err != nil {
+		return string(cached.Value), cached.Stored, err
+	}
+
+	if err := cache.Put(key, []byte(entry)); err != nil {
+		log.Printf("Cannot cache dictionary entry: %v", err)
+	}
+	return entry, time.Now(), nil
+}
+
+// fetchDefinition downloads an upstream page and reduces it to safe markup
+func fetchDefinition(upstream string) (string, error) {
+	base, err := url.Parse(upstream)
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequest(http.MethodGet, upstream, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("User-Agent", "palireader")
+
+	resp, err := upstreamClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s: %s", upstream, resp.Status)
+	}
+
+	doc, err := html.Parse(io.LimitReader(resp.Body, maxUpstreamBytes))
+	if err != nil {
+		return "", err
+	}
+	return sanitizeHTML(doc, base), nil
+}
+
+// writeFileAtomic writes data next to path and renames it into place
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Elements whose content is kept when rendering upstream pages
+var allowedElements = map[atom.Atom]bool{
+	atom.A: true, atom.B: true, atom.Br: true, atom.Dd: true, atom.Div: true,
+	atom.Dl: true, atom.Dt: true, atom.Em: true, atom.H1: true, atom.H2: true,
+	atom.H3: true, atom.H4: true, atom.H5: true, atom.H6: true, atom.Hr: true,
+	atom.I: true, atom.Li: true, atom.Ol: true, atom.P: true, atom.Small: true,
+	atom.Span: true, atom.Strong: true, atom.Sub: true, atom.Sup: true,
+	atom.Table: true, atom.Tbody: true, atom.Td: true, atom.Th: true,
+	atom.Thead: true, atom.Tr: true, atom.U: true, atom.Ul: true,
+}
+
+// Elements dropped together with everything inside them
+var droppedElements = map[atom.Atom]bool{
+	atom.Button: true, atom.Embed: true, atom.Form: true, atom.Head: true,
+	atom.Iframe: true, atom.Input: true, atom.Nav: true, atom.Noscript: true,
+	atom.Object: true, atom.Script: true, atom.Select: true, atom.Style: true,
+	atom.Svg: true, atom.Template: true, atom.Textarea: true,
+}
+
+// sanitizeHTML renders the body of doc keeping only allowed elements and
+// attributes. Links are made absolute against base and open in the lookup
+// window.
+func sanitizeHTML(doc *html.Node, base *url.URL) string {
+	var buf bytes.Buffer
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		switch n.Type {
+		case html.TextNode:
+			buf.WriteString(html.EscapeString(n.Data))
+			return
+		case html.ElementNode:
+			if droppedElements[n.DataAtom] {
+				return
+			}
+			if allowedElements[n.DataAtom] {
+				writeStartTag(&buf, n, base)
+				for c := n.FirstChild; c != nil; c = c.NextSibling {
+					walk(c)
+				}
+				if n.DataAtom != atom.Br && n.DataAtom != atom.Hr {
+					fmt.Fprintf(&buf, "</%s>", n.Data)
+				}
+				return
+			}
+		case html.CommentNode, html.DoctypeNode:
+			return
+		}
+		// Unknown elements are unwrapped
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+	return buf.String()
+}
+
+func writeStartTag(buf *bytes.Buffer, n *html.Node, base *url.URL) {
+	buf.WriteString("<" + n.Data)
+	for _, attr := range n.Attr {
+		switch attr.Key {
+		case "colspan", "rowspan":
+			fmt.Fprintf(buf, ` %s="%s"`, attr.Key, html.EscapeString(attr.Val))
+		case "href":
+			if n.DataAtom != atom.A {
+				continue
+			}
+			link, err := safeLink(base, attr.Val)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(buf, ` href="%s" target="other" rel="noopener"`, html.EscapeString(link))
+		}
+	}
+	buf.WriteString(">")
+}
+
+// safeLink resolves ref against base, allowing only http(s) targets
+func safeLink(base *url.URL, ref string) (string, error) {
+	u, err := base.Parse(strings.TrimSpace(ref))
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", errors.New("unsupported link scheme")
+	}
+	return u.String(), nil
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CorpusEvent is a change to the corpus, for following the progress of a
+// transcription project
+type CorpusEvent struct {
+	Time time.Time `json:"time"`
+	Kind string    `json:"kind"` // added, edited, deleted or published
+	Path string    `json:"path"`
+	User string    `json:"user,omitempty"` // who made it, when known
+	Note string    `json:"note,omitempty"`
+}
+
+// Verb describes the event for the activity page and feed
+func (e CorpusEvent) Verb() string {
+	switch e.Kind {
+	case "added":
+		return "Added"
+	case "edited":
+		return "Edited"
+	case "deleted":
+		return "Deleted"
+	case "published":
+		return "Published"
+	}
+	return e.Kind
+}
+
+var corpusEvents = &jsonFile[[]CorpusEvent]{name: "events.json"}
+
+const (
+	// maxCorpusEvents is how many events the log keeps
+	maxCorpusEvents = 5000
+
+	// activityEvents is how many events the activity page and feed show
+	activityEvents = 200
+
+	// eventSettle is how long a path has to stay unchanged before its
+	// change is logged, so that a save is one event
+	eventSettle = 2 * time.Second
+)
+
+// recordCorpusEvents adds events to the log, dropping the oldest beyond
+// maxCorpusEvents
+func recordCorpusEvents(events ...CorpusEvent) error {
+	return corpusEvents.Update(func(all *[]CorpusEvent) error {
+		*all = append(*all, events...)
+		if extra := len(*all) - maxCorpusEvents; extra > 0 {
+			*all = slices.Delete(*all, 0, extra)
+		}
+		return nil
+	})
+}
+
+// changeLog turns what the watcher sees into events: it knows the texts of
+// the corpus, and once a changed path settles, compares them with what is
+// there now
+type changeLog struct {
+	mu      sync.Mutex
+	texts   map[string]bool        // corpus names of the texts known
+	pending map[string]*time.Timer // paths waiting to settle
+	authors map[string]author      // who is about to change a path
+}
+
+// author is a user expected to change a path shortly
+type author struct {
+	user  string
+	until time.Time
+}
+
+var corpusChangeLog *changeLog
+
+// startChangeLog notes the texts of the corpus, for logging the changes
+// the watcher reports from now on
+func startChangeLog() {
+	l := &changeLog{
+		texts:   make(map[string]bool),
+		pending: make(map[string]*time.Timer),
+		authors: make(map[string]author),
+	}
+	for _, name := range corpusTexts(".") {
+		l.texts[name] = true
+	}
+	corpusChangeLog = l
+}
+
+// corpusTexts returns the names of the texts at or below a corpus name
+func corpusTexts(name string) []string {
+	info, err := fs.Stat(corpus, name)
+	if err != nil {
+		return nil
+	}
+	if !info.IsDir() {
+		if strings.HasSuffix(strings.ToLower(name), ".htm") {
+			return []string{name}
+		}
+		return nil
+	}
+	var names []string
+	walkCorpus(name, func(rel string, d fs.DirEntry) error {
+		if !d.IsDir() && strings.HasSuffix(strings.ToLower(d.Name()), ".htm") {
+			names = append(names, rel)
+		}
+		return nil
+	})
+	return names
+}
+
+// attribute credits user with the changes to name, or below it, seen in
+// the next minute
+func (l *changeLog) attribute(name, user string) {
+	if l == nil || user == "" {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.authors[name] = author{user, time.Now().Add(time.Minute)}
+}
+
+// changed notes that the watcher saw name change
+func (l *changeLog) changed(name string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// A folder waiting to settle covers what is in it, as when a folder
+	// is copied in
+	for p := name; ; p = path.Dir(p) {
+		if t, ok := l.pending[p]; ok {
+			t.Reset(eventSettle)
+			return
+		}
+		if p == "." {
+			break
+		}
+	}
+	l.pending[name] = time.AfterFunc(eventSettle, func() { l.settle(name) })
+}
+
+// settle logs what changed at or below name
+func (l *changeLog) settle(name string) {
+	now := corpusTexts(name)
+
+	l.mu.Lock()
+	delete(l.pending, name)
+	for p, t := range l.pending {
+		if strings.HasPrefix(p, name+"/") || name == "." {
+			t.Stop()
+			delete(l.pending, p)
+		}
+	}
+	var events []CorpusEvent
+	seen := make(map[string]bool)
+	for _, text := range now {
+		seen[text] = true
+		kind := "edited"
+		if !l.texts[text] {
+			kind = "added"
+			l.texts[text] = true
+		} else if text != name {
+			// Only the folder changed, as when a text is added next
+			// to this one
+			continue
+		}
+		events = append(events, CorpusEvent{Kind: kind, Path: text})
+	}
+	for text := range l.texts {
+		if (text == name || strings.HasPrefix(text, name+"/")) && !seen[text] {
+			delete(l.texts, text)
+			events = append(events, CorpusEvent{Kind: "deleted", Path: text})
+		}
+	}
+	at := time.Now().UTC()
+	for i := range events {
+		events[i].Time = at
+		events[i].User = l.authorOf(events[i].Path, at)
+	}
+	l.mu.Unlock()
+
+	if len(events) == 0 {
+		return
+	}
+	slices.SortFunc(events, func(a, b CorpusEvent) int { return strings.Compare(a.Path, b.Path) })
+	if err := recordCorpusEvents(events...); err != nil {
+		log.Println("Error logging corpus changes:", err)
+	}
+}
+
+// authorOf returns who was expected to change name, or a folder above it
+func (l *changeLog) authorOf(name string, at time.Time) string {
+	for p := name; ; p = path.Dir(p) {
+		if a, ok := l.authors[p]; ok {
+			if at.Before(a.until) {
+				return a.user
+			}
+			delete(l.authors, p)
+		}
+		if p == "." || p == "/" {
+			return ""
+		}
+	}
+}
+
+// recentEvents returns the latest events the request may see, newest first
+func recentEvents(r *http.Request) ([]CorpusEvent, error) {
+	var events []CorpusEvent
+	err := corpusEvents.Read(func(all *[]CorpusEvent) {
+		for i := len(*all) - 1; i >= 0 && len(events) < activityEvents; i-- {
+			if canRead(r, (*all)[i].Path) {
+				events = append(events, (*all)[i])
+			}
+		}
+	})
+	return events, err
+}
+
+// handleActivity lists the latest changes to the corpus
+func handleActivity(w http.ResponseWriter, r *http.Request) {
+	events, err := recentEvents(r)
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	data := PageData{
+		Title:  "Activity",
+		Events: events,
+	}
+	err = templatesFor(r).ExecuteTemplate(w, "activity", data)
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// handleActivityFeed publishes the latest changes to the corpus as an
+// Atom feed
+func handleActivityFeed(w http.ResponseWriter, r *http.Request) {
+	events, err := recentEvents(r)
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	base := baseURL(r)
+	feed := atomFeed{
+		Base:    base + "/",
+		Title:   "Pali Reader: corpus activity",
+		ID:      base + "/activity.xml",
+		Updated: time.Now().UTC().Format(time.RFC3339),
+		Links: []atomLink{
+			{Href: base + "/activity.xml", Rel: "self"},
+			{Href: base + "/activity"},
+		},
+	}
+	if len(events) > 0 {
+		feed.Updated = events[0].Time.Format(time.RFC3339)
+	}
+	for _, e := range events {
+		link := base + "/read/" + slugPath(e.Path)
+		if e.Kind == "deleted" {
+			link = base + "/activity"
+		}
+		var body strings.Builder
+		fmt.Fprintf(&body, "%s %s", e.Verb(), e.Path)
+		if e.User != "" {
+			fmt.Fprintf(&body, " by %s", e.User)
+		}
+		if e.Note != "" {
+			fmt.Fprintf(&body, ": %s", e.Note)
+		}
+		feed.Entries = append(feed.Entries, atomEntry{
+			Title:   e.Verb() + " " + humanizePath(e.Path),
+			ID:      fmt.Sprintf("%s/activity.xml#%d-%s", base, e.Time.UnixNano(), e.Path),
+			Updated: e.Time.Format(time.RFC3339),
+			Link:    atomLink{Href: link},
+			Content: atomContent{Type: "text", Body: body.String()},
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	fmt.Fprint(w, xml.Header)
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(feed); err != nil {
+		logf(r.Context(), "Error writing feed: %v", err)
+	}
+}
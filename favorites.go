@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// Favorite is a text a reader starred, to keep at hand on the home page.
+// Unlike a bookmark it stands for the whole text, not a place in it.
+type Favorite struct {
+	Path  string    `json:"path"`
+	Time  time.Time `json:"time"`
+	Title string    `json:"title,omitempty"` // filled in when listed
+}
+
+var favorites = &userFile[[]Favorite]{name: "favorites.json"}
+
+// maxFavorites caps how many texts a reader can star
+const maxFavorites = 500
+
+// starredTexts returns the reader's favorites that are still in the corpus
+// and theirs to read, the latest starred first
+func starredTexts(r *http.Request) []Favorite {
+	if config.Private || staticSite {
+		return nil
+	}
+	var list []Favorite
+	err := favorites.of(r).Read(func(all *[]Favorite) {
+		for _, f := range slices.Backward(*all) {
+			if canRead(r, f.Path) && textExists(f.Path) {
+				f.Title = displayTitle(f.Path)
+				list = append(list, f)
+			}
+		}
+	})
+	if err != nil {
+		logf(r.Context(), "Error reading the favorites: %v", err)
+	}
+	return list
+}
+
+// starredSet returns the paths of the reader's favorites, for the stars of
+// a page
+func starredSet(r *http.Request) map[string]bool {
+	if config.Private || staticSite {
+		return nil
+	}
+	set := make(map[string]bool)
+	favorites.of(r).Read(func(all *[]Favorite) {
+		for _, f := range *all {
+			set[f.Path] = true
+		}
+	})
+	return set
+}
+
+// handleFavorites stars a text or takes its star away (POST with path and
+// starred=1 or 0), or lists the favorites as JSON (GET)
+func handleFavorites(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "private, no-cache")
+		list := starredTexts(r)
+		if list == nil {
+			list = []Favorite{}
+		}
+		json.NewEncoder(w).Encode(list)
+		return
+	}
+
+	name, err := corpusName(resolveSlugs(r.FormValue("path")))
+	if err != nil || !textExists(name) || !canRead(r, name) {
+		writeAPIError(w, r, http.StatusBadRequest, "Unknown text", map[string]string{"field": "path"})
+		return
+	}
+	starred := r.FormValue("starred") == "1"
+	err = favorites.of(r).Update(func(list *[]Favorite) error {
+		i := slices.IndexFunc(*list, func(f Favorite) bool { return f.Path == name })
+		switch {
+		case starred && i < 0 && len(*list) < maxFavorites:
+			*list = append(*list, Favorite{Path: name, Time: time.Now().UTC()})
+		case !starred && i >= 0:
+			*list = slices.Delete(*list, i, i+1)
+		}
+		return nil
+	})
+	if err != nil {
+		logf(r.Context(), "Error saving favorite: %v", err)
+		writeAPIError(w, r, http.StatusInternalServerError, "Cannot save favorite", nil)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// feedSectionChars is roughly how much text one day's reading holds;
+// longer texts are split into sections of this size at paragraph breaks
+const feedSectionChars = 20000
+
+// feedDays is how many days of readings the feed lists
+const feedDays = 7
+
+// readingSection is one day's reading: a range of paragraphs of a text
+type readingSection struct {
+	Path       string
+	Start, End int // paragraph range, end exclusive
+	Part, Of   int // position of the section within the text
+}
+
+// readingSchedule lists every section of the corpus in a fixed order. Day n
+// since the Unix epoch reads section n modulo the number of sections.
+var readingSchedule = sync.OnceValues(func() ([]readingSection, error) {
+	var sections []readingSection
+	err := walkCorpus("", func(rel string, d fs.DirEntry) error {
+		if d.IsDir() || !strings.HasSuffix(strings.ToLower(d.Name()), ".htm") {
+			return nil
+		}
+		content, err := fs.ReadFile(corpus, rel)
+		if err != nil {
+			return err
+		}
+
+		first := len(sections)
+		start, size := 0, 0
+		// The raw paragraphs are close enough in size to their text
+		parts := paragraphBreak.Split(extractBody(string(content)), -1)
+		for i, part := range parts {
+			size += len(part)
+			if size >= feedSectionChars || i == len(parts)-1 {
+				sections = append(sections, readingSection{Path: rel, Start: start, End: i + 1})
+				start, size = i+1, 0
+			}
+		}
+		for i := first; i < len(sections); i++ {
+			sections[i].Part = i - first + 1
+			sections[i].Of = len(sections) - first
+		}
+		return nil
+	})
+	return sections, err
+})
+
+// Title names the section for the feed
+func (s readingSection) Title() string {
+	if s.Of == 1 {
+		return textTitle(s.Path)
+	}
+	return fmt.Sprintf("%s (%d/%d)", textTitle(s.Path), s.Part, s.Of)
+}
+
+// render returns the section's paragraphs processed as on the reader page
+func (s readingSection) render() (string, error) {
+	content, err := fs.ReadFile(corpus, s.Path)
+	if err != nil {
+		return "", err
+	}
+	parts := paragraphBreak.Split(extractBody(string(content)), -1)
+	refs := referencePatterns(s.Path)
+	var b strings.Builder
+	for i := s.Start; i < s.End && i < len(parts); i++ {
+		if strings.TrimSpace(parts[i]) == "" {
+			continue
+		}
+		b.WriteString("<p>")
+		// Feed readers have no style sheet to show other editions' pages
+		writeParagraph(&b, s.Path, refs, nil, i, parts[i], nil)
+		b.WriteString("</p>\n")
+	}
+	return b.String(), nil
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Base    string      `xml:"xml:base,attr"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+}
+
+type atomEntry struct {
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Link    atomLink    `xml:"link"`
+	Content atomContent `xml:"content"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+// handleFeed publishes the daily readings of the last week as an Atom feed
+func handleFeed(w http.ResponseWriter, r *http.Request) {
+	sections, err := readingSchedule()
+	if err != nil || len(sections) == 0 {
+		logf(r.Context(), "Error building reading schedule: %v", err)
+		httpError(w, r, "No readings available", http.StatusInternalServerError)
+		return
+	}
+
+	base := baseURL(r)
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	feed := atomFeed{
+		Base:    base + "/",
+		Title:   "Pali Reader: daily reading",
+		ID:      base + "/feed.xml",
+		Updated: today.Format(time.RFC3339),
+		Links: []atomLink{
+			{Href: base + "/feed.xml", Rel: "self"},
+			{Href: base + "/"},
+		},
+	}
+	for i := range feedDays {
+		day := today.AddDate(0, 0, -i)
+		s := sections[int(day.Unix()/86400)%len(sections)]
+		if !canRead(r, s.Path) {
+			continue
+		}
+		body, err := s.render()
+		if err != nil {
+			logf(r.Context(), "Error rendering daily reading: %v", err)
+			continue
+		}
+		link := fmt.Sprintf("%s/read/%s#p%d", base, slugPath(s.Path), s.Start)
+		feed.Entries = append(feed.Entries, atomEntry{
+			Title:   s.Title(),
+			ID:      fmt.Sprintf("%s/feed.xml#%s", base, day.Format(time.DateOnly)),
+			Updated: day.Format(time.RFC3339),
+			Link:    atomLink{Href: link},
+			Content: atomContent{Type: "html", Body: body},
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	fmt.Fprint(w, xml.Header)
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(feed); err != nil {
+		logf(r.Context(), "Error writing feed: %v", err)
+	}
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// dictionaryExtensions are the dataset formats readDictionaryEntries accepts
+var dictionaryExtensions = []string{".tsv", ".txt", ".csv", ".json"}
+
+// builtinDictionaries are the datasets fetch-dict knows by name, besides
+// those of the "dictionaries" section of the config file, which may also
+// point a name elsewhere
+var builtinDictionaries = map[string]string{
+	// The Digital Pāḷi Dictionary's headwords and meanings, as a TSV
+	"dpd": "https://github.com/digitalpalidictionary/dpd-db/releases/latest/download/dpd.tsv.zip",
+}
+
+// runFetchDict implements the fetch-dict command: it downloads a dictionary
+// dataset, unpacks it if needed and installs it as the local dictionary
+func runFetchDict(args []string) error {
+	flags := flag.NewFlagSet("fetch-dict", flag.ExitOnError)
+	member := flags.String("file", "", "name of the dataset file inside an archive (default: the largest supported file)")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: palireader fetch-dict [-file name] <url or name>")
+		fmt.Fprintln(flags.Output(), "\nDownloads a dictionary dataset (.tsv, .csv or .json, optionally inside a")
+		fmt.Fprintln(flags.Output(), ".zip, .tar.gz, .tar.bz2 or .gz) and installs it for offline lookups.")
+		fmt.Fprintf(flags.Output(), "Names are %s, or those of the \"dictionaries\" section of the config file.\n", strings.Join(slices.Sorted(maps.Keys(builtinDictionaries)), ", "))
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	source := flags.Arg(0)
+	if u, ok := config.Dictionaries[source]; ok {
+		source = u
+	} else if u, ok := builtinDictionaries[source]; ok {
+		source = u
+	}
+
+	tmpDir, err := os.MkdirTemp("", "palireader-dict-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	fmt.Printf("Downloading %s\n", source)
+	archivePath, err := download(source, tmpDir)
+	if err != nil {
+		return err
+	}
+
+	datasetPath, err := unpackDataset(archivePath, tmpDir, *member)
+	if err != nil {
+		return err
+	}
+
+	entries, err := readDictionaryEntries(datasetPath)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return fmt.Errorf("%s contains no dictionary entries", filepath.Base(datasetPath))
+	}
+
+	target := config.installedDictionaryPath()
+	if err := writeDictionaryTSV(target, entries); err != nil {
+		return err
+	}
+	fmt.Printf("Installed %d entries to %s\n", len(entries), target)
+	if config.Dictionary != "" {
+		fmt.Printf("Note: the config file's \"dictionary\" setting (%s) takes precedence over it.\n", config.Dictionary)
+	}
+	return nil
+}
+
+// download fetches source, which may also be a local file path, into dir
+func download(source, dir string) (string, error) {
+	u, err := url.Parse(source)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		// Not a URL: accept an already downloaded file
+		if _, statErr := os.Stat(source); statErr == nil {
+			return source, nil
+		}
+		return "", fmt.Errorf("%s is neither an http(s) URL nor an existing file", source)
+	}
+
+	client := &http.Client{Timeout: 30 * time.Minute}
+	resp, err := client.Get(source)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s: %s", source, resp.Status)
+	}
+
+	name := path.Base(u.Path)
+	if name == "/" || name == "." {
+		name = "dictionary"
+	}
+	dest := filepath.Join(dir, name)
+	f, err := os.Create(dest)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		return "", err
+	}
+	return dest, f.Close()
+}
+
+// unpackDataset extracts the dataset file from an archive into dir. Plain
+// dataset files are returned unchanged.
+func unpackDataset(archivePath, dir, member string) (string, error) {
+	name := strings.ToLower(filepath.Base(archivePath))
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		return unpackZip(archivePath, dir, member)
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return unpackTar(archivePath, dir, member, func(r io.Reader) (io.Reader, error) {
+			return gzip.NewReader(r)
+		})
+	case strings.HasSuffix(name, ".tar.bz2"), strings.HasSuffix(name, ".tbz2"):
+		return unpackTar(archivePath, dir, member, func(r io.Reader) (io.Reader, error) {
+			return bzip2.NewReader(r), nil
+		})
+	case strings.HasSuffix(name, ".gz"):
+		f, err := os.Open(archivePath)
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return "", err
+		}
+		return extractTo(dir, strings.TrimSuffix(filepath.Base(archivePath), filepath.Ext(archivePath)), gz)
+	case isDictionaryFile(name):
+		return archivePath, nil
+	}
+	return "", fmt.Errorf("unsupported dataset format: %s", filepath.Base(archivePath))
+}
+
+func unpackZip(archivePath, dir, member string) (string, error) {
+	zr, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return "", err
+	}
+	defer zr.Close()
+
+	var best *zip.File
+	for _, f := range zr.File {
+		if !matchesMember(f.Name, member) {
+			continue
+		}
+		if best == nil || f.UncompressedSize64 > best.UncompressedSize64 {
+			best = f
+		}
+	}
+	if best == nil {
+		return "", noDatasetError(archivePath, member)
+	}
+
+	rc, err := best.Open()
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+	return extractTo(dir, path.Base(best.Name), rc)
+}
+
+func unpackTar(archivePath, dir, member string, decompress func(io.Reader) (io.Reader, error)) (string, error) {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	r, err := decompress(bufio.NewReader(f))
+	if err != nil {
+		return "", err
+	}
+
+	// Tar archives can only be read once, so keep the largest match on disk
+	var bestPath string
+	var bestSize int64 = -1
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		if hdr.Typeflag != tar.TypeReg || !matchesMember(hdr.Name, member) || hdr.Size <= bestSize {
+			continue
+		}
+		extracted, err := extractTo(dir, path.Base(hdr.Name), tr)
+		if err != nil {
+			return "", err
+		}
+		if bestPath != "" && bestPath != extracted {
+			os.Remove(bestPath)
+		}
+		bestPath, bestSize = extracted, hdr.Size
+	}
+	if bestPath == "" {
+		return "", noDatasetError(archivePath, member)
+	}
+	return bestPath, nil
+}
+
+// matchesMember reports whether an archive entry is the requested dataset
+// file, or any supported dataset file when none was requested
+func matchesMember(name, member string) bool {
+	if member != "" {
+		return path.Base(name) == member || name == member
+	}
+	return isDictionaryFile(name)
+}
+
+func isDictionaryFile(name string) bool {
+	ext := strings.ToLower(path.Ext(name))
+	for _, e := range dictionaryExtensions {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+func noDatasetError(archivePath, member string) error {
+	if member != "" {
+		return fmt.Errorf("%s does not contain %s", filepath.Base(archivePath), member)
+	}
+	return fmt.Errorf("%s contains no %s file", filepath.Base(archivePath), strings.Join(dictionaryExtensions, ", "))
+}
+
+// extractTo copies r into dir under the base of name
+func extractTo(dir, name string, r io.Reader) (string, error) {
+	dest := filepath.Join(dir, filepath.Base(name))
+	f, err := os.Create(dest)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, r); err != nil {
+		return "", err
+	}
+	return dest, f.Close()
+}
+
+// writeDictionaryTSV stores entries in the tab-separated dictionary format
+func writeDictionaryTSV(target string, entries []DictEntry) error {
+	var b strings.Builder
+	b.WriteString("# word\tgloss\n")
+	for _, e := range entries {
+		word := strings.TrimSpace(e.Word)
+		gloss := strings.Join(strings.Fields(e.Gloss), " ")
+		if word == "" || gloss == "" {
+			continue
+		}
+		b.WriteString(word)
+		b.WriteByte('\t')
+		b.WriteString(gloss)
+		b.WriteByte('\n')
+	}
+	return writeFileAtomic(target, []byte(b.String()))
+}
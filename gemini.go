@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"math/big"
+	"net"
+	"net/url"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// GeminiConfig enables serving the corpus over the Gemini protocol. Without
+// a certificate a self-signed one for Hostname is created in the data
+// directory and reused, as Gemini clients pin certificates on first use.
+type GeminiConfig struct {
+	Addr     string `json:"addr"`
+	Hostname string `json:"hostname"`
+	CertFile string `json:"certFile"`
+	KeyFile  string `json:"keyFile"`
+}
+
+// geminiTimeout bounds how long a client may take over one request
+const geminiTimeout = 30 * time.Second
+
+// startGemini listens for Gemini requests in the background
+func startGemini() {
+	if config.Auth != nil && config.Auth.Site {
+		log.Println("Not serving Gemini: the site requires a login, which Gemini clients cannot give")
+		return
+	}
+	cert, err := geminiCertificate(config.Gemini)
+	if err != nil {
+		log.Fatal("Error loading Gemini certificate:", err)
+	}
+	ln, err := tls.Listen("tcp", config.Gemini.Addr, &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	})
+	if err != nil {
+		log.Fatal("Error starting Gemini listener:", err)
+	}
+	fmt.Printf("Gemini capsule on gemini://%s%s/\n", config.Gemini.Hostname, config.Gemini.Addr)
+
+	go func() {
+		if err := acceptGemini(ln, handleGemini); err != nil {
+			log.Println("Gemini capsule stopped:", err)
+		}
+	}()
+}
+
+// acceptGemini hands each connection accepted on ln to handle, until ln is
+// closed. As net/http's server does, it waits after a temporary failure,
+// such as running out of file descriptors, longer each time up to a second,
+// rather than trying again at once, and gives up on any other.
+func acceptGemini(ln net.Listener, handle func(net.Conn)) error {
+	var delay time.Duration
+	for {
+		conn, err := ln.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+		if err != nil {
+			var ne net.Error
+			if !errors.As(err, &ne) || !ne.Temporary() {
+				return err
+			}
+			delay = min(max(2*delay, 5*time.Millisecond), time.Second)
+			log.Printf("Gemini accept: %v; retrying in %v", err, delay)
+			time.Sleep(delay)
+			continue
+		}
+		delay = 0
+		go handle(conn)
+	}
+}
+
+// geminiCertificate loads the configured certificate, or the self-signed one
+// kept in the data directory, creating it on first use
+func geminiCertificate(g *GeminiConfig) (tls.Certificate, error) {
+	if g.CertFile != "" {
+		return tls.LoadX509KeyPair(g.CertFile, g.KeyFile)
+	}
+
+	certFile := filepath.Join(config.DataDir, "gemini-cert.pem")
+	keyFile := filepath.Join(config.DataDir, "gemini-key.pem")
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if !errors.Is(err, fs.ErrNotExist) {
+		return cert, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return cert, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: g.Hostname},
+		DNSNames:     []string{g.Hostname},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(10, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return cert, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return cert, err
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err := writeFileAtomic(certFile, certPEM); err != nil {
+		return cert, err
+	}
+	if err := writeFileAtomic(keyFile, keyPEM); err != nil {
+		return cert, err
+	}
+	log.Println("Created self-signed Gemini certificate", certFile)
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
+
+// handleGemini answers the single request of a Gemini connection
+func handleGemini(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(geminiTimeout))
+
+	// Requests are one absolute URL of at most 1024 bytes plus CRLF
+	line, err := bufio.NewReaderSize(io.LimitReader(conn, 1026), 1026).ReadString('\n')
+	if err != nil {
+		fmt.Fprint(conn, "59 Bad request\r\n")
+		return
+	}
+	u, err := url.Parse(strings.TrimRight(line, "\r\n"))
+	if err != nil {
+		fmt.Fprint(conn, "59 Bad request\r\n")
+		return
+	}
+	if u.Scheme != "gemini" {
+		fmt.Fprint(conn, "53 Only gemini:// URLs are served here\r\n")
+		return
+	}
+
+	w := bufio.NewWriter(conn)
+	defer w.Flush()
+	serveGemini(w, u)
+}
+
+// serveGemini writes the response for a request URL
+func serveGemini(w io.Writer, u *url.URL) {
+	query, _ := url.QueryUnescape(u.RawQuery)
+	query = strings.TrimSpace(query)
+
+	switch {
+	case u.Path == "" || u.Path == "/":
+		fmt.Fprint(w, "20 text/gemini; charset=utf-8\r\n")
+		fmt.Fprint(w, "# Pali Reader\n\n")
+		fmt.Fprint(w, "=> /search Search the texts\n")
+		if dictionary != nil {
+			fmt.Fprint(w, "=> /lookup Look up a word\n")
+		}
+		fmt.Fprint(w, "\n## Texts\n\n")
+		geminiDirectory(w, "")
+
+	case u.Path == "/search":
+		if query == "" {
+			fmt.Fprint(w, "10 Pali words to search for\r\n")
+			return
+		}
+		idx := corpusIndex.Load()
+		if idx == nil {
+			fmt.Fprint(w, "41 The corpus is still being indexed\r\n")
+			return
+		}
+		results := idx.Search(query, "")
+		fmt.Fprint(w, "20 text/gemini; charset=utf-8\r\n")
+		fmt.Fprintf(w, "# Search: %s\n\n", query)
+		if len(results) == 0 {
+			fmt.Fprint(w, "Nothing found.\n")
+		}
+		// Gemini clients cannot log in
+		results = slices.DeleteFunc(results, func(r SearchResult) bool {
+			return protectedPath(r.Path)
+		})
+		if len(results) > maxSearchResults {
+			results = results[:maxSearchResults]
+		}
+		addSnippets(results, query)
+		for _, r := range results {
+			fmt.Fprintf(w, "=> %s %s (%.2f)\n", geminiReadLink(r.Path), r.Path, r.Score)
+			if r.Snippet != "" {
+				fmt.Fprintf(w, "> %s\n", r.Snippet)
+			}
+		}
+		fmt.Fprint(w, "\n=> /search Search again\n")
+
+	case u.Path == "/lookup" && dictionary != nil:
+		if query == "" {
+			fmt.Fprint(w, "10 Pali word\r\n")
+			return
+		}
+		word := cleanWord(query)
+		fmt.Fprint(w, "20 text/gemini; charset=utf-8\r\n")
+		fmt.Fprintf(w, "# %s\n\n", word)
+		glosses := dictionary.Lookup(word)
+		if len(glosses) == 0 {
+			fmt.Fprint(w, "Not in the local dictionary.\n")
+		}
+		for _, g := range glosses {
+			fmt.Fprintf(w, "* %s\n", g)
+		}
+		fmt.Fprint(w, "\n=> /lookup Look up another word\n")
+
+	case strings.HasPrefix(u.Path, "/read/"):
+		filePath := strings.Trim(strings.TrimPrefix(u.Path, "/read/"), "/")
+		name, err := corpusName(filePath)
+		if err != nil {
+			fmt.Fprint(w, "59 Invalid path\r\n")
+			return
+		}
+		info, err := fs.Stat(corpus, name)
+		if err != nil || protectedPath(path.Clean(filePath)) {
+			fmt.Fprint(w, "51 Not found\r\n")
+			return
+		}
+		if info.IsDir() {
+			fmt.Fprint(w, "20 text/gemini; charset=utf-8\r\n")
+			fmt.Fprintf(w, "# %s\n\n", filePath)
+			geminiDirectory(w, filePath)
+			return
+		}
+		content, err := fs.ReadFile(corpus, name)
+		if err != nil {
+			fmt.Fprint(w, "40 Cannot read the text\r\n")
+			return
+		}
+		fmt.Fprint(w, "20 text/gemini; charset=utf-8\r\n")
+		fmt.Fprintf(w, "# %s\n\n", textTitle(filePath))
+		fmt.Fprintf(w, "=> %s Back to %s\n\n", geminiReadLink(filepath.ToSlash(filepath.Dir(filePath))), filepath.Dir(filePath))
+		for _, text := range paragraphs(filePath, string(content)) {
+			if text != "" {
+				fmt.Fprintf(w, "%s\n\n", gemtextLine(text))
+			}
+		}
+
+	default:
+		fmt.Fprint(w, "51 Not found\r\n")
+	}
+}
+
+// geminiDirectory lists a corpus directory as gemtext links
+func geminiDirectory(w io.Writer, dir string) {
+	if dir != "" {
+		parent := filepath.ToSlash(filepath.Dir(dir))
+		if parent == "." {
+			fmt.Fprint(w, "=> / Up\n")
+		} else {
+			fmt.Fprintf(w, "=> %s Up\n", geminiReadLink(parent))
+		}
+	}
+	for _, child := range buildFileTree(dir).Children {
+		if protectedPath(child.Path) {
+			continue
+		}
+		name := child.Name
+		if child.IsDir {
+			name += "/"
+		}
+		fmt.Fprintf(w, "=> %s %s\n", geminiReadLink(filepath.ToSlash(child.Path)), name)
+	}
+}
+
+func geminiReadLink(path string) string {
+	return (&url.URL{Path: "/read/" + path}).EscapedPath()
+}
+
+// gemtextLine keeps a line of text from being read as a heading, link, list
+// item, quote or preformatting toggle
+func gemtextLine(text string) string {
+	for _, prefix := range []string{"#", "=>", "*", ">", "```"} {
+		if strings.HasPrefix(text, prefix) {
+			return " " + text
+		}
+	}
+	return text
+}
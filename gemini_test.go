@@ -0,0 +1,44 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+// failingListener fails to accept with each of errs in turn
+type failingListener struct {
+	net.Listener
+	errs []error
+}
+
+func (l *failingListener) Accept() (net.Conn, error) {
+	err := l.errs[0]
+	l.errs = l.errs[1:]
+	return nil, err
+}
+
+// temporaryError is a failure to accept that may pass, as EMFILE
+type temporaryError struct{}
+
+func (temporaryError) Error() string   { return "too many open files" }
+func (temporaryError) Timeout() bool   { return false }
+func (temporaryError) Temporary() bool { return true }
+
+func TestAcceptGemini(t *testing.T) {
+	handle := func(net.Conn) { t.Error("a connection was handled") }
+
+	ln := &failingListener{errs: []error{temporaryError{}, temporaryError{}, net.ErrClosed}}
+	if err := acceptGemini(ln, handle); err != nil {
+		t.Errorf("acceptGemini on a closed listener = %v", err)
+	}
+	if len(ln.errs) != 0 {
+		t.Errorf("acceptGemini stopped before the listener was closed")
+	}
+
+	failed := errors.New("failed")
+	ln = &failingListener{errs: []error{failed, net.ErrClosed}}
+	if err := acceptGemini(ln, handle); err != failed {
+		t.Errorf("acceptGemini = %v; want %v", err, failed)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// GlossaryEntry is one distinct word of a text
+type GlossaryEntry struct {
+	Word  string
+	Gloss string
+	Count int
+}
+
+// glossary lists the distinct words of a text with their most common gloss,
+// most frequent first
+func glossary(path, content string) []GlossaryEntry {
+	counts := make(map[string]int)
+	forEachWord(plainText(path, content), func(word string) {
+		counts[word]++
+	})
+
+	entries := make([]GlossaryEntry, 0, len(counts))
+	for word, count := range counts {
+		e := GlossaryEntry{Word: word, Count: count}
+		if dictionary != nil {
+			e.Gloss = dictionary.CommonGloss(word)
+		}
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Count != entries[j].Count {
+			return entries[i].Count > entries[j].Count
+		}
+		return entries[i].Word < entries[j].Word
+	})
+	return entries
+}
+
+// handleGlossary shows the glossary tab of a text, sorted by frequency or
+// alphabetically (?sort=alpha), or downloads it as CSV (?format=csv)
+func handleGlossary(w http.ResponseWriter, r *http.Request) {
+	filePath := strings.TrimPrefix(r.URL.Path, "/glossary/")
+	name, err := corpusName(filePath)
+	if err != nil {
+		httpError(w, r, "Invalid path", http.StatusBadRequest)
+		return
+	}
+	info, err := fs.Stat(corpus, name)
+	if err != nil || info.IsDir() {
+		httpError(w, r, "File not found", http.StatusNotFound)
+		return
+	}
+	content, err := fs.ReadFile(corpus, name)
+	if err != nil {
+		httpError(w, r, "Cannot read file", http.StatusInternalServerError)
+		return
+	}
+
+	entries := glossary(filePath, string(content))
+	order := r.URL.Query().Get("sort")
+	if order == "alpha" {
+		sort.SliceStable(entries, func(i, j int) bool {
+			return paliLess(entries[i].Word, entries[j].Word)
+		})
+	} else {
+		order = "frequency"
+	}
+
+	if r.URL.Query().Get("format") == "csv" {
+		if err := takeQuota(w, r, "export"); err != nil {
+			httpError(w, r, err.Error(), http.StatusTooManyRequests)
+			return
+		}
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-glossary.csv"`, textTitle(filePath)))
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"word", "gloss", "count"})
+		for _, e := range entries {
+			cw.Write([]string{e.Word, e.Gloss, fmt.Sprint(e.Count)})
+		}
+		cw.Flush()
+		return
+	}
+
+	data := PageData{
+		Title:       displayTitle(filePath),
+		CurrentPath: filePath,
+		Breadcrumbs: buildBreadcrumbs(filePath),
+		Sort:        order,
+		Glossary:    entries,
+	}
+	hideProtected(r, &data)
+	err = templatesFor(r).ExecuteTemplate(w, "glossary", data)
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusInternalServerError)
+	}
+}
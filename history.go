@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxHistory is how many of the latest lookups the history page lists; the
+// export has them all
+const maxHistory = 500
+
+// HistoryDay lists the lookups made on one day, the latest first
+type HistoryDay struct {
+	Date    string
+	Lookups []HistoryEntry
+}
+
+// HistoryEntry is one recorded lookup with the dictionary's gloss
+type HistoryEntry struct {
+	Lookup
+	Gloss string
+}
+
+// handleHistory lists the recorded lookups by day, the latest first, or
+// exports them all as CSV (?format=csv)
+func handleHistory(w http.ResponseWriter, r *http.Request) {
+	var list []Lookup
+	err := lookups.of(r).Read(func(l *[]Lookup) {
+		list = append(list, *l...)
+	})
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if r.URL.Query().Get("format") == "csv" {
+		if err := takeQuota(w, r, "export"); err != nil {
+			httpError(w, r, err.Error(), http.StatusTooManyRequests)
+			return
+		}
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="pali-lookups.csv"`)
+		writeHistoryCSV(w, list, baseURL(r))
+		return
+	}
+
+	data := PageData{
+		Title:   "Lookup history",
+		History: historyDays(list, maxHistory),
+	}
+	if len(list) > maxHistory {
+		data.Notice = fmt.Sprintf("Showing the latest %d of %d lookups; the export has them all.", maxHistory, len(list))
+	}
+	err = templatesFor(r).ExecuteTemplate(w, "history", data)
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// historyDays groups the latest limit lookups by the day they were made on,
+// in the server's time zone, the latest first
+func historyDays(list []Lookup, limit int) []HistoryDay {
+	var days []HistoryDay
+	for i := len(list) - 1; i >= 0 && len(list)-i <= limit; i-- {
+		entry := HistoryEntry{Lookup: list[i]}
+		entry.Time = entry.Time.Local()
+		if dictionary != nil {
+			entry.Gloss = strings.Join(dictionary.Lookup(entry.Word), "; ")
+		}
+		date := entry.Time.Format("Monday, 2 January 2006")
+		if len(days) == 0 || days[len(days)-1].Date != date {
+			days = append(days, HistoryDay{Date: date})
+		}
+		day := &days[len(days)-1]
+		day.Lookups = append(day.Lookups, entry)
+	}
+	return days
+}
+
+// writeHistoryCSV writes every lookup in the order made, linking sources
+// below base
+func writeHistoryCSV(w io.Writer, list []Lookup, base string) {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"time", "word", "gloss", "source", "link"})
+	for _, l := range list {
+		gloss, link := "", ""
+		if dictionary != nil {
+			gloss = strings.Join(dictionary.Lookup(l.Word), "; ")
+		}
+		if l.Source != "" {
+			link = base + "/read/" + l.Source
+		}
+		cw.Write([]string{l.Time.UTC().Format(time.RFC3339), l.Word, gloss, citation(l.Source), link})
+	}
+	cw.Flush()
+}
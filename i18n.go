@@ -0,0 +1,210 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"html"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/text/language"
+)
+
+// Catalog is a language's translations of the messages of the interface,
+// which are keyed by their English text. A message missing from a catalog
+// is shown in English.
+type Catalog struct {
+	Tag      string            `json:"-"`    // BCP 47, like "si", from the file's name
+	Name     string            `json:"name"` // the language's own name for itself
+	Messages map[string]string `json:"messages"`
+}
+
+// localeFiles are the catalogs built into the binary, one
+// locales/<tag>.json for each language
+//
+//go:embed locales
+var localeFiles embed.FS
+
+// languageCookie keeps the language a reader chose over their browser's
+const languageCookie = "lang"
+
+var (
+	// catalogs are the languages of the interface, English first
+	catalogs []*Catalog
+	// themeCatalogs are those of the theme directory, which add to the
+	// built-in ones or replace them
+	themeCatalogs []*Catalog
+	// localizedTemplates are the templates in each language by tag;
+	// templates are those in English
+	localizedTemplates map[string]*template.Template
+	languageMatcher    language.Matcher
+)
+
+// readCatalogs reads the <tag>.json catalogs at the top of fsys
+func readCatalogs(fsys fs.FS) ([]*Catalog, error) {
+	files, err := fs.Glob(fsys, "*.json")
+	if err != nil {
+		return nil, err
+	}
+	var read []*Catalog
+	for _, file := range files {
+		tag, err := language.Parse(strings.TrimSuffix(file, ".json"))
+		if err != nil {
+			return nil, fmt.Errorf("%s: not named after a language: %w", file, err)
+		}
+		data, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, err
+		}
+		c := &Catalog{}
+		if err := json.Unmarshal(data, c); err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		c.Tag = tag.String()
+		if c.Name == "" {
+			c.Name = c.Tag
+		}
+		read = append(read, c)
+	}
+	return read, nil
+}
+
+// loadCatalogs gathers the built-in catalogs and the theme's, and the
+// matcher choosing among them
+func loadCatalogs() error {
+	sub, err := fs.Sub(localeFiles, "locales")
+	if err != nil {
+		return err
+	}
+	builtIn, err := readCatalogs(sub)
+	if err != nil {
+		return err
+	}
+	byTag := map[string]*Catalog{"en": {Tag: "en", Name: "English"}}
+	for _, c := range append(builtIn, themeCatalogs...) {
+		byTag[c.Tag] = c
+	}
+	catalogs = []*Catalog{byTag["en"]}
+	delete(byTag, "en")
+	for _, c := range byTag {
+		catalogs = append(catalogs, c)
+	}
+	sort.Slice(catalogs[1:], func(i, j int) bool { return catalogs[1+i].Tag < catalogs[1+j].Tag })
+
+	tags := make([]language.Tag, len(catalogs))
+	for i, c := range catalogs {
+		tags[i] = language.Make(c.Tag)
+	}
+	languageMatcher = language.NewMatcher(tags)
+	return nil
+}
+
+// translate returns a message in the catalog's language, formatted with
+// args like fmt.Sprintf if there are any
+func (c *Catalog) translate(msg string, args ...any) string {
+	if m := c.Messages[msg]; m != "" {
+		msg = m
+	}
+	if len(args) == 0 {
+		return msg
+	}
+	return fmt.Sprintf(msg, args...)
+}
+
+// translateHTML is translate for a message into which markup such as links
+// is put: args of type template.HTML go in as they are, strings escaped
+func (c *Catalog) translateHTML(msg string, args ...any) template.HTML {
+	values := make([]any, len(args))
+	for i, a := range args {
+		switch a := a.(type) {
+		case template.HTML:
+			values[i] = string(a)
+		case string:
+			values[i] = html.EscapeString(a)
+		default:
+			values[i] = a
+		}
+	}
+	msg = html.EscapeString(c.translate(msg))
+	if len(values) == 0 {
+		return template.HTML(msg)
+	}
+	return template.HTML(fmt.Sprintf(msg, values...))
+}
+
+// link returns a link to href for translateHTML
+func link(href, text string) template.HTML {
+	return template.HTML(`<a href="` + html.EscapeString(href) + `">` + html.EscapeString(text) + `</a>`)
+}
+
+// code returns text as code for translateHTML, for what is typed as it is
+// whatever the language
+func code(text string) template.HTML {
+	return template.HTML("<code>" + html.EscapeString(text) + "</code>")
+}
+
+// pali returns a Pali word for translateHTML, set apart as in headings
+func pali(word string) template.HTML {
+	return template.HTML(`<span class="pali-heading">` + html.EscapeString(word) + `</span>`)
+}
+
+// requestLanguage returns the tag of the language to show a request the
+// interface in: the one the reader chose, or the best for their browser
+func requestLanguage(r *http.Request) string {
+	if c, err := r.Cookie(languageCookie); err == nil {
+		if _, ok := localizedTemplates[c.Value]; ok {
+			return c.Value
+		}
+	}
+	tags, _, _ := language.ParseAcceptLanguage(r.Header.Get("Accept-Language"))
+	_, i, confidence := languageMatcher.Match(tags...)
+	if confidence == language.No {
+		return catalogs[0].Tag
+	}
+	return catalogs[i].Tag
+}
+
+// templatesFor returns the templates in the language of the request
+func templatesFor(r *http.Request) *template.Template {
+	if localizedTemplates == nil {
+		return templates
+	}
+	if t, ok := localizedTemplates[requestLanguage(r)]; ok {
+		return t
+	}
+	return templates
+}
+
+// handleLanguage switches the interface to the language given, or back to
+// the browser's without one, and returns to the page the switch was made on:
+// /language?lang=si
+func handleLanguage(w http.ResponseWriter, r *http.Request) {
+	tag := r.FormValue("lang")
+	if tag == "" {
+		http.SetCookie(w, &http.Cookie{Name: languageCookie, Path: sitePath("/"), MaxAge: -1})
+	} else {
+		if _, ok := localizedTemplates[tag]; !ok {
+			httpError(w, r, "No such language", http.StatusNotFound)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     languageCookie,
+			Value:    tag,
+			Path:     sitePath("/"),
+			Expires:  time.Now().AddDate(1, 0, 0),
+			Secure:   r.TLS != nil,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+	back := sitePath("/")
+	if ref, err := url.Parse(r.Referer()); err == nil && ref.Host == r.Host && ref.Path != "" {
+		back = safeNext(ref.RequestURI())
+	}
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
@@ -0,0 +1,382 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"html"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// CorpusIndex maps every word form in the corpus to the texts it occurs in,
+// and standard citations to where they are read
+type CorpusIndex struct {
+	Paths     []string             // corpus paths of the texts
+	Postings  map[string][]Posting // word form -> texts containing it
+	Positions []byte               // where the words of the postings occur
+	Lengths   []int                // how many words each text has
+	Citations map[string]Citation  // citation key -> text and paragraph
+	Hashes    []string             // content hash of each text, to re-index only those that change
+	forms     []string             // sorted word forms, for prefix queries
+}
+
+// Posting records how often a word form occurs in one text, and where: the
+// numbers of the words it is among the text's words, from zero, start at
+// Offset in the index's Positions, each stored as a uvarint of how far it
+// is from the one before
+type Posting struct {
+	Doc    int
+	Count  int
+	Offset int
+}
+
+// addPosting records that word occurs in the text doc at positions, which
+// are in order
+func (idx *CorpusIndex) addPosting(word string, doc int, positions []int) {
+	idx.Postings[word] = append(idx.Postings[word], Posting{Doc: doc, Count: len(positions), Offset: len(idx.Positions)})
+	last := 0
+	for _, pos := range positions {
+		idx.Positions = binary.AppendUvarint(idx.Positions, uint64(pos-last))
+		last = pos
+	}
+}
+
+// positions returns the numbers of the words the posting's word is among
+// its text's words, in order
+func (idx *CorpusIndex) positions(p Posting) []int {
+	positions := make([]int, p.Count)
+	offset, last := p.Offset, 0
+	for i := range positions {
+		delta, n := binary.Uvarint(idx.Positions[offset:])
+		offset += n
+		last += int(delta)
+		positions[i] = last
+	}
+	return positions
+}
+
+// Occurrence summarizes the matching word forms found in one text
+type Occurrence struct {
+	Path  string
+	Count int
+	Forms []string
+}
+
+// corpusIndex is nil until the background build finishes
+var corpusIndex atomic.Pointer[CorpusIndex]
+
+// startCorpusIndex reads the corpus index in the background from the data
+// directory, or with a shared state store from there, building it when the
+// one kept is missing or out of date
+func startCorpusIndex() {
+	go func() {
+		start := time.Now()
+		var idx *CorpusIndex
+		var err error
+		if store, _ := sharedState(); store != nil {
+			// An index built but not stored is still used
+			if idx, err = sharedCorpusIndex(store); err != nil {
+				log.Println("Error sharing corpus index:", err)
+			}
+		} else if idx, err = storedCorpusIndex(); err != nil {
+			log.Println("Error storing corpus index:", err)
+		}
+		if idx == nil {
+			if idx, err = buildCorpusIndex(); err != nil {
+				log.Println("Error building corpus index:", err)
+				return
+			}
+		}
+		corpusIndex.Store(idx)
+		log.Printf("Indexed %d texts (%d word forms) in %s",
+			len(idx.Paths), len(idx.forms), time.Since(start).Round(time.Millisecond))
+	}()
+}
+
+// reindexOnHangup rebuilds the corpus index whenever the server gets SIGHUP,
+// as after palireader sync. Searches use the old index until the new one is
+// ready.
+func reindexOnHangup() {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	go func() {
+		for range hangup {
+			reindexCorpus()
+		}
+	}()
+}
+
+// reindexCorpus reads the corpus afresh and rebuilds its index
+func reindexCorpus() {
+	log.Println("Re-indexing the corpus")
+	corpusCache.invalidate(".")
+	startCorpusIndex()
+}
+
+// buildCorpusIndex tokenizes every text of the corpus
+func buildCorpusIndex() (*CorpusIndex, error) {
+	idx, _, err := updateCorpusIndex(nil)
+	return idx, err
+}
+
+// updateCorpusIndex indexes the corpus anew, tokenizing only the texts that
+// are not in old with the same content; old may be nil. It returns the new
+// index and how many texts it tokenized.
+func updateCorpusIndex(old *CorpusIndex) (*CorpusIndex, int, error) {
+	idx := &CorpusIndex{Postings: make(map[string][]Posting), Citations: make(map[string]Citation)}
+
+	// The words and citations of each text of the old index, gathered from
+	// its postings when the first text is found unchanged
+	var oldDocs map[string]int
+	var oldWords [][]wordPosting
+	var oldCitations [][]paragraphCitation
+	if old != nil && len(old.Hashes) == len(old.Paths) {
+		oldDocs = make(map[string]int, len(old.Paths))
+		for doc, path := range old.Paths {
+			oldDocs[path] = doc
+		}
+	}
+	gatherOld := func() {
+		oldWords = make([][]wordPosting, len(old.Paths))
+		for word, postings := range old.Postings {
+			for _, p := range postings {
+				oldWords[p.Doc] = append(oldWords[p.Doc], wordPosting{word, p})
+			}
+		}
+		oldCitations = make([][]paragraphCitation, len(old.Paths))
+		for key, c := range old.Citations {
+			oldCitations[c.Doc] = append(oldCitations[c.Doc], paragraphCitation{key, c.Paragraph})
+		}
+	}
+
+	tokenized := 0
+	err := walkCorpus("", func(rel string, d fs.DirEntry) error {
+		if d.IsDir() || !strings.HasSuffix(strings.ToLower(d.Name()), ".htm") {
+			return nil
+		}
+
+		content, err := fs.ReadFile(corpus, rel)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(content)
+		hash := hex.EncodeToString(sum[:])
+
+		doc := len(idx.Paths)
+		idx.Paths = append(idx.Paths, rel)
+		idx.Hashes = append(idx.Hashes, hash)
+
+		if oldDoc, ok := oldDocs[rel]; ok && old.Hashes[oldDoc] == hash {
+			if oldWords == nil {
+				gatherOld()
+			}
+			idx.Lengths = append(idx.Lengths, old.Lengths[oldDoc])
+			for _, w := range oldWords[oldDoc] {
+				idx.addPosting(w.word, doc, old.positions(w.posting))
+			}
+			for _, c := range oldCitations[oldDoc] {
+				if _, ok := idx.Citations[c.key]; !ok {
+					idx.Citations[c.key] = Citation{Doc: doc, Paragraph: c.paragraph}
+				}
+			}
+			return nil
+		}
+
+		tokenized++
+		positions := make(map[string][]int)
+		length := 0
+		forEachWord(plainText(rel, string(content)), func(word string) {
+			positions[word] = append(positions[word], length)
+			length++
+		})
+		idx.Lengths = append(idx.Lengths, length)
+		for word, at := range positions {
+			idx.addPosting(word, doc, at)
+		}
+		textCitations(rel, string(content), func(key string, paragraph int) {
+			if _, ok := idx.Citations[key]; !ok {
+				idx.Citations[key] = Citation{Doc: doc, Paragraph: paragraph}
+			}
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, tokenized, err
+	}
+
+	// A citation found in several texts resolves to the first, and the old
+	// index kept only that one. When a text it resolved to changed or is
+	// gone, the others may be first now, so every text is looked at again.
+	if oldCitations != nil {
+		seen := make(map[string]bool, len(idx.Paths))
+		for doc, path := range idx.Paths {
+			seen[path+"\x00"+idx.Hashes[doc]] = true
+		}
+		recite := false
+		for doc, path := range old.Paths {
+			if len(oldCitations[doc]) > 0 && !seen[path+"\x00"+old.Hashes[doc]] {
+				recite = true
+				break
+			}
+		}
+		if recite {
+			clear(idx.Citations)
+			for doc, path := range idx.Paths {
+				content, err := fs.ReadFile(corpus, path)
+				if err != nil {
+					return nil, tokenized, err
+				}
+				textCitations(path, string(content), func(key string, paragraph int) {
+					if _, ok := idx.Citations[key]; !ok {
+						idx.Citations[key] = Citation{Doc: doc, Paragraph: paragraph}
+					}
+				})
+			}
+		}
+	}
+
+	idx.sortForms()
+	return idx, tokenized, nil
+}
+
+// wordPosting is a word form occurring in a text of an old index
+type wordPosting struct {
+	word    string
+	posting Posting
+}
+
+// paragraphCitation is a citation resolving to a paragraph of a text of an
+// old index
+type paragraphCitation struct {
+	key       string
+	paragraph int
+}
+
+// sortForms lists the word forms of the postings in order
+func (idx *CorpusIndex) sortForms() {
+	idx.forms = make([]string, 0, len(idx.Postings))
+	for word := range idx.Postings {
+		idx.forms = append(idx.forms, word)
+	}
+	sort.Strings(idx.forms)
+}
+
+// plainText reduces the text file at path to its readable body text,
+// dropping reference markers and tags
+func plainText(path, content string) string {
+	text := stripReferences(referencePatterns(path), extractBody(content))
+	text = tagPattern.ReplaceAllString(text, " ")
+	return html.UnescapeString(text)
+}
+
+// paragraphBreak separates paragraphs: blank lines made of <br> tags, or
+// paragraph tags
+var paragraphBreak = regexp.MustCompile(`(?i)(?:<br\s*/?>\s*){2,}|</?p(?:\s[^>]*)?>`)
+
+// paragraphs splits the text file at path into the plain text of its
+// paragraphs. Empty paragraphs are kept so that indexes stay stable.
+func paragraphs(path, content string) []string {
+	refs := referencePatterns(path)
+	parts := paragraphBreak.Split(extractBody(content), -1)
+	texts := make([]string, len(parts))
+	for i, part := range parts {
+		text := stripReferences(refs, part)
+		text = tagPattern.ReplaceAllString(text, " ")
+		texts[i] = strings.Join(strings.Fields(html.UnescapeString(text)), " ")
+	}
+	return texts
+}
+
+// forEachWord calls fn with the cleaned form of every word in text
+func forEachWord(text string, fn func(word string)) {
+	runes := []rune(text)
+	i := 0
+	for i < len(runes) {
+		if !isWordChar(runes[i]) {
+			i++
+			continue
+		}
+		start := i
+		for i < len(runes) && isWordChar(runes[i]) {
+			i++
+		}
+		word := cleanWord(string(runes[start:i]))
+		if len(word) > 0 && containsLetter(word) {
+			fn(word)
+		}
+	}
+}
+
+// Occurrences finds the texts containing word forms that begin with prefix,
+// most frequent first
+func (idx *CorpusIndex) Occurrences(prefix string) []Occurrence {
+	byDoc := make(map[int]*Occurrence)
+
+	for i := sort.SearchStrings(idx.forms, prefix); i < len(idx.forms); i++ {
+		form := idx.forms[i]
+		if !strings.HasPrefix(form, prefix) {
+			break
+		}
+		for _, p := range idx.Postings[form] {
+			occ, ok := byDoc[p.Doc]
+			if !ok {
+				occ = &Occurrence{Path: idx.Paths[p.Doc]}
+				byDoc[p.Doc] = occ
+			}
+			occ.Count += p.Count
+			occ.Forms = append(occ.Forms, form)
+		}
+	}
+
+	occurrences := make([]Occurrence, 0, len(byDoc))
+	for _, occ := range byDoc {
+		occurrences = append(occurrences, *occ)
+	}
+	sort.Slice(occurrences, func(i, j int) bool {
+		if occurrences[i].Count != occurrences[j].Count {
+			return occurrences[i].Count > occurrences[j].Count
+		}
+		return occurrences[i].Path < occurrences[j].Path
+	})
+	return occurrences
+}
+
+func handleOccurrences(w http.ResponseWriter, r *http.Request) {
+	word := cleanWord(strings.TrimSpace(r.URL.Query().Get("word")))
+	if word == "" {
+		http.Redirect(w, r, sitePath("/"), http.StatusFound)
+		return
+	}
+
+	data := PageData{
+		Title: word,
+		Query: word,
+	}
+	if err := takeQuota(w, r, "analysis"); err != nil {
+		w.WriteHeader(http.StatusTooManyRequests)
+		data.Notice = err.Error()
+	} else if idx := corpusIndex.Load(); idx != nil {
+		data.Occurrences = slices.DeleteFunc(idx.Occurrences(word), func(o Occurrence) bool {
+			return !canRead(r, o.Path)
+		})
+	} else {
+		refundQuota(r, "analysis")
+		data.Notice = "The corpus is still being indexed. Please try again in a moment."
+	}
+
+	err := templatesFor(r).ExecuteTemplate(w, "occurrences", data)
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusInternalServerError)
+	}
+}
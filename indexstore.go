@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// corpusIndexVersion is raised whenever CorpusIndex, or how texts are split
+// into words, changes, so that an index stored by an earlier version is
+// built anew rather than read
+const corpusIndexVersion = 5
+
+var (
+	// errStaleIndex is returned for a stored index of another version
+	errStaleIndex = errors.New("the stored index is of another version")
+	// errIndexReferences is returned for a stored index built with other
+	// reference markers, which decide what words and citations texts have
+	errIndexReferences = errors.New("the stored index was built with other reference markers")
+)
+
+func corpusIndexPath() string {
+	return filepath.Join(config.DataDir, "corpus-index.gob")
+}
+
+// indexSalt tells apart the indexes built with different reference
+// markers, which are kept out of the words of the texts and give them
+// their citations
+func indexSalt() string {
+	h := sha256.New()
+	json.NewEncoder(h).Encode(config.References)
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// encodeCorpusIndex writes the index after a line with its version, the
+// salt of the settings and the fingerprint of the corpus it was built from
+func encodeCorpusIndex(idx *CorpusIndex, fingerprint string) ([]byte, error) {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%d %s %s\n", corpusIndexVersion, indexSalt(), fingerprint)
+	if err := gob.NewEncoder(&b).Encode(idx); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// decodeCorpusIndex reads an index written by encodeCorpusIndex and the
+// fingerprint of the corpus it was built from, or returns errStaleIndex if
+// it is not of this version and errIndexReferences if it was built with
+// other reference markers
+func decodeCorpusIndex(data []byte) (*CorpusIndex, string, error) {
+	header, encoded, found := bytes.Cut(data, []byte("\n"))
+	version, rest, _ := bytes.Cut(header, []byte(" "))
+	salt, fingerprint, _ := bytes.Cut(rest, []byte(" "))
+	if n, err := strconv.Atoi(string(version)); !found || err != nil || n != corpusIndexVersion {
+		return nil, "", errStaleIndex
+	}
+	if string(salt) != indexSalt() {
+		return nil, "", errIndexReferences
+	}
+	idx := &CorpusIndex{}
+	if err := gob.NewDecoder(bytes.NewReader(encoded)).Decode(idx); err != nil {
+		return nil, "", err
+	}
+	idx.sortForms()
+	return idx, string(fingerprint), nil
+}
+
+// currentCorpusIndex returns the stored index if it was built from the
+// corpus as it is now, and otherwise updates it, re-indexing only the texts
+// that changed. data may be empty when there is none.
+func currentCorpusIndex(data []byte, fingerprint string) (idx *CorpusIndex, updated bool, err error) {
+	// An index that cannot be read is built anew like one of another
+	// version or with other reference markers
+	old, stored, err := decodeCorpusIndex(data)
+	if err == nil && stored == fingerprint {
+		return old, false, nil
+	}
+	start := time.Now()
+	idx, tokenized, err := updateCorpusIndex(old)
+	if err != nil {
+		return nil, false, err
+	}
+	log.Printf("Re-indexed %d of %d texts in %s",
+		tokenized, len(idx.Paths), time.Since(start).Round(time.Millisecond))
+	return idx, true, nil
+}
+
+// storedCorpusIndex returns the index kept in the data directory, updated
+// and kept there again when the corpus changed since
+func storedCorpusIndex() (*CorpusIndex, error) {
+	fingerprint, err := corpusFingerprint()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(corpusIndexPath())
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	idx, updated, err := currentCorpusIndex(data, fingerprint)
+	if err != nil || !updated {
+		return idx, err
+	}
+	return idx, saveCorpusIndex(idx, fingerprint)
+}
+
+// saveCorpusIndex keeps the index in the data directory for the next start
+func saveCorpusIndex(idx *CorpusIndex, fingerprint string) error {
+	data, err := encodeCorpusIndex(idx, fingerprint)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(corpusIndexPath(), data)
+}
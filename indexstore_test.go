@@ -0,0 +1,39 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestDecodeCorpusIndex(t *testing.T) {
+	useConfig(t, Config{References: defaultReferences()})
+	idx := &CorpusIndex{
+		Paths:    []string{"a.htm"},
+		Postings: map[string][]Posting{"dhamma": {{Doc: 0, Count: 1}}},
+		Lengths:  []int{1},
+		Hashes:   []string{"00"},
+	}
+	data, err := encodeCorpusIndex(idx, "fingerprint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, fingerprint, err := decodeCorpusIndex(data)
+	if err != nil || fingerprint != "fingerprint" || !slices.Equal(decoded.Paths, idx.Paths) {
+		t.Fatalf("decodeCorpusIndex = %v, %q, %v", decoded, fingerprint, err)
+	}
+
+	for _, stale := range [][]byte{nil, []byte("4 fingerprint\n"), data[1:]} {
+		if _, _, err := decodeCorpusIndex(stale); !errors.Is(err, errStaleIndex) {
+			t.Errorf("decodeCorpusIndex(%.20q) = %v; want %v", stale, err, errStaleIndex)
+		}
+	}
+
+	// Other reference markers give other words and citations
+	config.References = append(slices.Clone(config.References), ReferenceConfig{
+		Patterns: []ReferencePattern{{Pattern: `\[\d+\]`}},
+	})
+	if _, _, err := decodeCorpusIndex(data); !errors.Is(err, errIndexReferences) {
+		t.Errorf("decodeCorpusIndex with other references = %v; want %v", err, errIndexReferences)
+	}
+}
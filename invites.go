@@ -0,0 +1,241 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Account is a user who signed up with an invitation or logged in with
+// single sign-on. Accounts are kept in the data directory, next to the
+// users of the config file.
+type Account struct {
+	Password string      `json:"password,omitempty"` // bcrypt hash
+	Roles    []RoleGrant `json:"roles"`
+	Invited  string      `json:"invited,omitempty"`  // by whom
+	Provider string      `json:"provider,omitempty"` // the OIDC issuer of a single sign-on user
+	Created  time.Time   `json:"created"`
+}
+
+var accounts = &jsonFile[map[string]Account]{name: "accounts.json"}
+
+// Invitation is a link an admin hands out so that a group, such as a
+// class, can sign up with the role it presets
+type Invitation struct {
+	Token   string    `json:"token"`
+	Role    string    `json:"role"`
+	Path    string    `json:"path"`  // corpus folder the role covers; the whole corpus if empty
+	Seats   int       `json:"seats"` // how many more may sign up with it
+	Expires time.Time `json:"expires"`
+	Creator string    `json:"creator"`
+}
+
+var invitations = &jsonFile[[]Invitation]{name: "invitations.json"}
+
+const (
+	// maxInviteDays bounds how long an invitation stays valid
+	maxInviteDays = 90
+
+	// minPasswordLength is the shortest password a signup may choose
+	minPasswordLength = 8
+)
+
+// accountName is what a user name chosen at signup may look like
+var accountName = regexp.MustCompile(`^[\pL\pN][\pL\pN._-]{1,31}$`)
+
+// knownUser reports whether user is in the config file or signed up
+func knownUser(user string) bool {
+	if _, ok := config.Auth.Users[user]; ok {
+		return true
+	}
+	found := false
+	accounts.Read(func(all *map[string]Account) {
+		_, found = (*all)[user]
+	})
+	return found
+}
+
+// checkLogin reports whether password is user's, for the users of the
+// config file and those who signed up
+func checkLogin(user, password string) bool {
+	if _, ok := config.Auth.Users[user]; ok {
+		return checkPassword(config.Auth.Users, user, password)
+	}
+	var hash string
+	accounts.Read(func(all *map[string]Account) {
+		hash = (*all)[user].Password
+	})
+	return hash != "" && bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// accountRoles returns the roles of a user who signed up, as invited, or
+// logged in with single sign-on, as the provider's claims last mapped them
+func accountRoles(user string) []RoleGrant {
+	var grants []RoleGrant
+	accounts.Read(func(all *map[string]Account) {
+		grants = slices.Clone((*all)[user].Roles)
+	})
+	return grants
+}
+
+// activeInvitations returns the invitations to folder that can still be
+// used
+func activeInvitations(folder string) []Invitation {
+	var active []Invitation
+	now := time.Now()
+	invitations.Read(func(all *[]Invitation) {
+		for _, inv := range *all {
+			if inv.Path == folder && inv.Seats > 0 && now.Before(inv.Expires) {
+				active = append(active, inv)
+			}
+		}
+	})
+	return active
+}
+
+// invite creates an invitation to folder from the invite form of the
+// admin area, returning its link
+func invite(r *http.Request, folder, user string) (string, error) {
+	role := r.FormValue("role")
+	if roleRanks[role] == 0 {
+		return "", errors.New("choose reader, editor or admin")
+	}
+	days, err := strconv.Atoi(r.FormValue("days"))
+	if err != nil || days < 1 || days > maxInviteDays {
+		return "", fmt.Errorf("invitations last 1 to %d days", maxInviteDays)
+	}
+	seats, err := strconv.Atoi(r.FormValue("seats"))
+	if err != nil || seats < 1 {
+		return "", errors.New("an invitation is for at least one person")
+	}
+	inv := Invitation{
+		Token:   randomToken(),
+		Role:    role,
+		Path:    folder,
+		Seats:   seats,
+		Expires: time.Now().Add(time.Duration(days) * 24 * time.Hour).UTC(),
+		Creator: user,
+	}
+	err = invitations.Update(func(all *[]Invitation) error {
+		// Used up and expired invitations go
+		now := time.Now()
+		*all = slices.DeleteFunc(*all, func(i Invitation) bool {
+			return i.Seats <= 0 || now.After(i.Expires)
+		})
+		*all = append(*all, inv)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return baseURL(r) + "/invite/" + inv.Token, nil
+}
+
+// revokeInvitation ends an invitation to folder before it expires
+func revokeInvitation(folder, token string) error {
+	return invitations.Update(func(all *[]Invitation) error {
+		i := slices.IndexFunc(*all, func(i Invitation) bool { return i.Token == token && i.Path == folder })
+		if i < 0 {
+			return errors.New("no such invitation")
+		}
+		*all = slices.Delete(*all, i, i+1)
+		return nil
+	})
+}
+
+// handleInvite shows the signup form of an invitation (GET) or creates the
+// account and logs it in (POST)
+func handleInvite(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimPrefix(r.URL.Path, "/invite/")
+	var inv Invitation
+	invitations.Read(func(all *[]Invitation) {
+		if i := slices.IndexFunc(*all, func(i Invitation) bool { return i.Token == token }); i >= 0 {
+			inv = (*all)[i]
+		}
+	})
+	if inv.Token == "" || inv.Seats <= 0 || time.Now().After(inv.Expires) {
+		httpError(w, r, "This invitation has expired or been used up", http.StatusNotFound)
+		return
+	}
+	data := PageData{
+		Title:  "Sign up",
+		Invite: &inv,
+	}
+
+	if r.Method == http.MethodPost {
+		user := strings.TrimSpace(r.FormValue("user"))
+		err := signUp(token, user, r.FormValue("password"), r.FormValue("confirm"))
+		if err == nil {
+			logf(r.Context(), "%s signed up with an invitation from %s", user, inv.Creator)
+			if err := startSession(w, r, user); err != nil {
+				logf(r.Context(), "Error signing session: %v", err)
+				httpError(w, r, "Cannot log in", http.StatusInternalServerError)
+				return
+			}
+			http.Redirect(w, r, sitePath("/"), http.StatusSeeOther)
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		data.Notice = err.Error()
+	}
+
+	err := templatesFor(r).ExecuteTemplate(w, "invite", data)
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// signUp creates an account with the role of the invitation, taking one of
+// its seats
+func signUp(token, user, password, confirm string) error {
+	if !accountName.MatchString(user) {
+		return errors.New("user names are 2 to 32 letters, digits, dots, dashes and underscores")
+	}
+	if len(password) < minPasswordLength {
+		return fmt.Errorf("passwords have at least %d characters", minPasswordLength)
+	}
+	if password != confirm {
+		return errors.New("the passwords do not match")
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	return invitations.Update(func(all *[]Invitation) error {
+		i := slices.IndexFunc(*all, func(i Invitation) bool { return i.Token == token })
+		if i < 0 || (*all)[i].Seats <= 0 || time.Now().After((*all)[i].Expires) {
+			return errors.New("this invitation has expired or been used up")
+		}
+		inv := &(*all)[i]
+		if _, ok := config.Auth.Users[user]; ok {
+			return fmt.Errorf("%s is taken", user)
+		}
+		err := accounts.Update(func(accts *map[string]Account) error {
+			if _, ok := (*accts)[user]; ok {
+				return fmt.Errorf("%s is taken", user)
+			}
+			if *accts == nil {
+				*accts = make(map[string]Account)
+			}
+			(*accts)[user] = Account{
+				Password: string(hash),
+				Roles:    []RoleGrant{{User: user, Role: inv.Role, Path: inv.Path}},
+				Invited:  inv.Creator,
+				Created:  time.Now().UTC(),
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		inv.Seats--
+		return nil
+	})
+}
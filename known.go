@@ -0,0 +1,143 @@
+package main
+
+import (
+	"html"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// maxKnownWords caps how many words a reader can mark as known
+const maxKnownWords = 50000
+
+// knownWordList reads the words of a pasted word list, separated by spaces,
+// commas or new lines, in Pali alphabetical order and each once
+func knownWordList(list string) []string {
+	seen := make(map[string]bool)
+	var words []string
+	for _, field := range strings.FieldsFunc(list, func(r rune) bool {
+		return unicode.IsSpace(r) || r == ',' || r == ';'
+	}) {
+		word := cleanWord(field)
+		if word == "" || !containsLetter(word) || seen[word] {
+			continue
+		}
+		seen[word] = true
+		words = append(words, word)
+	}
+	sort.Slice(words, func(i, j int) bool { return paliLess(words[i], words[j]) })
+	if len(words) > maxKnownWords {
+		words = words[:maxKnownWords]
+	}
+	return words
+}
+
+// frequencyRanks numbers the word forms of the corpus index by how often
+// they occur, the most frequent first, for the index they were counted in
+var frequencyRanks struct {
+	sync.Mutex
+	idx   *CorpusIndex
+	ranks map[string]int
+}
+
+// wordRanks returns the rank of every word form of the index by frequency,
+// from one
+func wordRanks(idx *CorpusIndex) map[string]int {
+	frequencyRanks.Lock()
+	defer frequencyRanks.Unlock()
+	if frequencyRanks.idx == idx {
+		return frequencyRanks.ranks
+	}
+	counts := make(map[string]int, len(idx.Postings))
+	forms := make([]string, 0, len(idx.Postings))
+	for form, postings := range idx.Postings {
+		for _, p := range postings {
+			counts[form] += p.Count
+		}
+		forms = append(forms, form)
+	}
+	sort.Slice(forms, func(i, j int) bool {
+		if counts[forms[i]] != counts[forms[j]] {
+			return counts[forms[i]] > counts[forms[j]]
+		}
+		return forms[i] < forms[j]
+	})
+	ranks := make(map[string]int, len(forms))
+	for i, form := range forms {
+		ranks[form] = i + 1
+	}
+	frequencyRanks.idx, frequencyRanks.ranks = idx, ranks
+	return ranks
+}
+
+// knownMarker returns what tells the words the reader knows from the others
+// in the processed HTML of a text, marking each link as known or unknown,
+// or nil when the reader has set no vocabulary level. Until the corpus
+// index is ready, only the words marked one by one count as known.
+func knownMarker(s UserSettings) func(content string) string {
+	if len(s.Known) == 0 && s.KnownTop == 0 {
+		return nil
+	}
+	known := make(map[string]bool, len(s.Known))
+	for _, word := range s.Known {
+		known[word] = true
+	}
+	var ranks map[string]int
+	if idx := corpusIndex.Load(); idx != nil && s.KnownTop > 0 {
+		ranks = wordRanks(idx)
+	}
+	return func(content string) string {
+		return wordLink.ReplaceAllStringFunc(content, func(link string) string {
+			word := cleanWord(html.UnescapeString(wordLink.FindStringSubmatch(link)[1]))
+			class := "unknown"
+			if rank, ok := ranks[word]; known[word] || ok && rank <= s.KnownTop {
+				class = "known"
+			}
+			return strings.Replace(link, `class="pali-word`, `class="pali-word `+class, 1)
+		})
+	}
+}
+
+// handleKnown marks a word as known (POST with known=1) or no longer known,
+// for the reader's dictionary chooser
+func handleKnown(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeAPIError(w, r, http.StatusMethodNotAllowed, "Method not allowed", map[string]string{"allow": http.MethodPost})
+		return
+	}
+
+	word := cleanWord(strings.TrimSpace(r.FormValue("word")))
+	if word == "" || !containsLetter(word) {
+		writeAPIError(w, r, http.StatusBadRequest, "Missing word", map[string]string{"field": "word"})
+		return
+	}
+	known := r.FormValue("known") == "1"
+
+	err := updateSettings(r, func(s *UserSettings) {
+		i, found := slices.BinarySearchFunc(s.Known, word, func(a, b string) int {
+			switch {
+			case paliLess(a, b):
+				return -1
+			case paliLess(b, a):
+				return 1
+			}
+			return 0
+		})
+		switch {
+		case known && !found && len(s.Known) < maxKnownWords:
+			s.Known = slices.Insert(s.Known, i, word)
+		case !known && found:
+			s.Known = slices.Delete(s.Known, i, i+1)
+		}
+	})
+	if err != nil {
+		logf(r.Context(), "Error saving known word: %v", err)
+		writeAPIError(w, r, http.StatusInternalServerError, "Cannot save known word", nil)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
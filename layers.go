@@ -0,0 +1,188 @@
+package main
+
+import (
+	"io/fs"
+	"net/http"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// The texts come in three layers: the root texts (mūla), their commentaries
+// (aṭṭhakathā) and the subcommentaries (ṭīkā) on those. A text links to the
+// texts of the other layers that go with it, found by their file names or
+// by the folders' sidecars.
+const (
+	layerRoot = iota
+	layerCommentary
+	layerSubcommentary
+)
+
+// layerNames name the layers in the links, in their order
+var layerNames = []string{"Root text", "Commentary", "Subcommentary"}
+
+// LayerLink is a text of another layer that goes with the one read
+type LayerLink struct {
+	Layer string // as layerNames has it
+	Path  string
+	Title string
+}
+
+// cstLayerName matches the file names of the Chaṭṭha Saṅgāyana edition,
+// where the layers of a book share its code: s0101m.mul0.htm,
+// s0101a.att0.htm and s0101t.tik0.htm
+var cstLayerName = regexp.MustCompile(`^([a-z]+\d+)[mat]\d*\.(mul|att|tik)(\d*)\.htm$`)
+
+// cstLayers are the layers by the extension the edition gives them
+var cstLayers = map[string]int{"mul": layerRoot, "att": layerCommentary, "tik": layerSubcommentary}
+
+// gretilCommentaries are the commentaries of the GRETIL corpus by the start
+// of their file names, with the root texts they comment on. GRETIL names
+// the volumes of a work alike, as paraji_u.htm, vin3s1ou.htm and
+// vin3s1pu.htm.
+var gretilCommentaries = []struct {
+	commentary string
+	roots      []string
+}{
+	// Samantapāsādikā, on the Vinaya
+	{"samp_1", []string{"paraji", "vin3s1"}},
+	{"samp_2", []string{"paraji", "vin3s1"}},
+	{"samp_3", []string{"paraji", "vin3s1"}},
+	{"samp_4", []string{"pacitt", "vin4s2"}},
+	{"samp_5", []string{"mahavg", "vin1ma"}},
+	{"samp_6", []string{"cullav", "vin2cu"}},
+	{"samp_7", []string{"pariva", "vin5pa"}},
+}
+
+// layerText is a text of the corpus in a layer
+type layerText struct {
+	name  string
+	layer int
+}
+
+// layerPair goes from a text to one of another layer that goes with it
+type layerPair struct {
+	from, to layerText
+}
+
+// layerLinks returns the texts of the other layers that go with the text
+// at the corpus name, the root texts first, that r may read
+func layerLinks(r *http.Request, name string) []LayerLink {
+	var links []LayerLink
+	for _, p := range layerPairs() {
+		if p.from.name != name || !canRead(r, p.to.name) || !textExists(p.to.name) {
+			continue
+		}
+		if slices.ContainsFunc(links, func(l LayerLink) bool { return l.Path == p.to.name }) {
+			continue
+		}
+		links = append(links, LayerLink{Layer: layerNames[p.to.layer], Path: p.to.name, Title: displayTitle(p.to.name)})
+	}
+	slices.SortStableFunc(links, func(a, b LayerLink) int {
+		return slices.Index(layerNames, a.Layer) - slices.Index(layerNames, b.Layer)
+	})
+	return links
+}
+
+// layerPairs finds the texts of the corpus that go together, both ways
+func layerPairs() []layerPair {
+	var pairs []layerPair
+	link := func(a, b layerText) {
+		if a.name != b.name {
+			pairs = append(pairs, layerPair{a, b}, layerPair{b, a})
+		}
+	}
+
+	var names []string
+	books := make(map[string][]layerText) // Chaṭṭha Saṅgāyana texts by folder, book and part
+	walkCorpus("", func(rel string, d fs.DirEntry) error {
+		if d.IsDir() {
+			for _, p := range sidecarLayerPairs(rel) {
+				link(p.from, p.to)
+			}
+			return nil
+		}
+		base := strings.ToLower(d.Name())
+		if !strings.HasSuffix(base, ".htm") {
+			return nil
+		}
+		names = append(names, rel)
+		if m := cstLayerName.FindStringSubmatch(base); m != nil {
+			book := path.Dir(rel) + "/" + m[1] + m[3]
+			books[book] = append(books[book], layerText{rel, cstLayers[m[2]]})
+		}
+		return nil
+	})
+
+	for _, texts := range books {
+		for i, a := range texts {
+			for _, b := range texts[i+1:] {
+				if a.layer != b.layer {
+					link(a, b)
+				}
+			}
+		}
+	}
+
+	for _, c := range gretilCommentaries {
+		for _, commentary := range names {
+			if !strings.HasPrefix(strings.ToLower(path.Base(commentary)), c.commentary) {
+				continue
+			}
+			for _, root := range names {
+				base := strings.ToLower(path.Base(root))
+				if !slices.ContainsFunc(c.roots, func(p string) bool { return strings.HasPrefix(base, p) }) {
+					continue
+				}
+				// Annotated commentaries go with the annotated root texts,
+				// and plain ones with the plain
+				if v, w := gretilVariant(root), gretilVariant(commentary); v != "u" && w != "u" && v != w {
+					continue
+				}
+				link(layerText{commentary, layerCommentary}, layerText{root, layerRoot})
+			}
+		}
+	}
+	return pairs
+}
+
+// gretilVariant returns how a GRETIL text is given: "ou" annotated, "pu"
+// plain, or "u" otherwise
+func gretilVariant(name string) string {
+	base := strings.TrimSuffix(strings.ToLower(path.Base(name)), ".htm")
+	for _, v := range []string{"ou", "pu"} {
+		if strings.HasSuffix(base, v) {
+			return v
+		}
+	}
+	return "u"
+}
+
+// sidecarLayerPairs returns the texts the sidecar of the folder at the
+// corpus name says go together. An entry naming a root text is a
+// commentary, or a subcommentary if it also names a commentary; any other
+// is a root text.
+func sidecarLayerPairs(dir string) []layerPair {
+	meta := folderMeta(dir)
+	if meta == nil {
+		return nil
+	}
+	var pairs []layerPair
+	for _, e := range meta.Entries {
+		layer := layerRoot
+		switch {
+		case e.Root != "" && e.Commentary != "":
+			layer = layerSubcommentary
+		case e.Root != "":
+			layer = layerCommentary
+		}
+		from := layerText{path.Join(dir, e.Name), layer}
+		for i, other := range []string{e.Root, e.Commentary, e.Subcommentary} {
+			if other != "" && i != layer {
+				pairs = append(pairs, layerPair{from, layerText{path.Join(dir, other), i}})
+			}
+		}
+	}
+	return pairs
+}
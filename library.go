@@ -0,0 +1,252 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Library is a collection of texts served under its name, as in
+// /read/tipitaka/1_tipit/... Without libraries the corpus is the GRETIL
+// texts in baseDir and paths have no library name. Dir may also be a zip or
+// tar archive, read without unpacking it.
+type Library struct {
+	Name  string `json:"name"`
+	Title string `json:"title"` // shown on the index, defaults to the name
+	Dir   string `json:"dir"`
+	Root  string `json:"root"` // folder inside Dir holding the texts, optional
+
+	Git *GitSource `json:"git"` // where sync gets Dir from, optional
+}
+
+// corpus holds the texts, addressed by corpus path
+var corpus fs.FS = os.DirFS(baseDir)
+
+// openCorpus sets up the corpus of the configured libraries
+func openCorpus(libraries []Library) (fs.FS, error) {
+	if len(libraries) == 0 {
+		return os.DirFS(baseDir), nil
+	}
+	l := &libraryFS{dirs: make(map[string]fs.FS)}
+	for _, lib := range libraries {
+		dir := os.DirFS(lib.Dir)
+		if isArchive(lib.Dir) {
+			var err error
+			if dir, err = openArchive(lib.Dir); err != nil {
+				return nil, fmt.Errorf("library %s: %w", lib.Name, err)
+			}
+		}
+		if root := strings.Trim(lib.Root, "/"); root != "" {
+			var err error
+			if dir, err = fs.Sub(dir, root); err != nil {
+				return nil, fmt.Errorf("library %s: %w", lib.Name, err)
+			}
+		}
+		l.libraries = append(l.libraries, lib)
+		l.dirs[lib.Name] = dir
+	}
+	return l, nil
+}
+
+// corpusName turns a path from a URL or the command line into the name of
+// an entry of the corpus, "." for the whole corpus. Paths cannot leave it.
+func corpusName(p string) (string, error) {
+	p = filepath.ToSlash(p)
+	if strings.Contains(p, "\x00") {
+		return "", errors.New("invalid path")
+	}
+	name := strings.TrimPrefix(path.Clean("/"+p), "/")
+	if name == "" {
+		name = "."
+	}
+	if !fs.ValidPath(name) {
+		return "", errors.New("invalid path")
+	}
+	return name, nil
+}
+
+// walkCorpus calls fn with the corpus path of every folder and file below
+// dir ("" for the whole corpus), as filepath.WalkDir does
+func walkCorpus(dir string, fn func(rel string, d fs.DirEntry) error) error {
+	root, err := corpusName(dir)
+	if err != nil {
+		return err
+	}
+	return fs.WalkDir(corpus, root, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if name == "." {
+			return nil
+		}
+		return fn(name, d)
+	})
+}
+
+// libraryFS puts the libraries side by side, each in a folder of its name
+type libraryFS struct {
+	libraries []Library
+	dirs      map[string]fs.FS
+}
+
+// split returns the library holding name and the name inside it
+func (l *libraryFS) split(op, name string) (fs.FS, string, error) {
+	if !fs.ValidPath(name) {
+		return nil, "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	lib, rest, _ := strings.Cut(name, "/")
+	dir, ok := l.dirs[lib]
+	if !ok {
+		return nil, "", &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	if rest == "" {
+		rest = "."
+	}
+	return dir, rest, nil
+}
+
+func (l *libraryFS) Open(name string) (fs.File, error) {
+	if name == "." {
+		entries, err := l.ReadDir(".")
+		if err != nil {
+			return nil, err
+		}
+		return &libraryRoot{entries: entries}, nil
+	}
+	dir, rest, err := l.split("open", name)
+	if err != nil {
+		return nil, err
+	}
+	return dir.Open(rest)
+}
+
+func (l *libraryFS) Stat(name string) (fs.FileInfo, error) {
+	if name == "." {
+		return rootInfo{}, nil
+	}
+	dir, rest, err := l.split("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := fs.Stat(dir, rest)
+	if err != nil || rest != "." {
+		return info, err
+	}
+	return renamedInfo{info, name}, nil
+}
+
+func (l *libraryFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if name != "." {
+		dir, rest, err := l.split("readdir", name)
+		if err != nil {
+			return nil, err
+		}
+		return fs.ReadDir(dir, rest)
+	}
+	var entries []fs.DirEntry
+	for _, lib := range l.libraries {
+		info, err := l.Stat(lib.Name)
+		if err != nil {
+			// A missing library is left out rather than failing the rest
+			continue
+		}
+		entries = append(entries, fs.FileInfoToDirEntry(info))
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+// libraryTitle returns the title of the library named name, if there is one
+func libraryTitle(name string) (string, bool) {
+	for _, lib := range config.Libraries {
+		if lib.Name == name {
+			if lib.Title == "" {
+				return lib.Name, true
+			}
+			return lib.Title, true
+		}
+	}
+	return "", false
+}
+
+// textPath returns the path of a text inside its library
+func textPath(p string) string {
+	if len(config.Libraries) == 0 {
+		return p
+	}
+	_, rest, _ := strings.Cut(p, "/")
+	return rest
+}
+
+// diskPath returns the file on disk holding the corpus entry rel, for the
+// tools that change texts
+func diskPath(rel string) (string, error) {
+	name, err := corpusName(rel)
+	if err != nil {
+		return "", err
+	}
+	if len(config.Libraries) == 0 {
+		return filepath.Join(baseDir, filepath.FromSlash(name)), nil
+	}
+	lib, rest, _ := strings.Cut(name, "/")
+	for _, l := range config.Libraries {
+		if l.Name != lib {
+			continue
+		}
+		if isArchive(l.Dir) {
+			return "", fmt.Errorf("%s: the texts of an archive cannot be changed", lib)
+		}
+		return filepath.Join(l.Dir, filepath.FromSlash(strings.Trim(l.Root, "/")), filepath.FromSlash(rest)), nil
+	}
+	return "", fmt.Errorf("%s: no such library", lib)
+}
+
+// renamedInfo is a library folder, named after the library
+type renamedInfo struct {
+	fs.FileInfo
+	name string
+}
+
+func (r renamedInfo) Name() string { return r.name }
+
+// rootInfo describes the folder holding the libraries
+type rootInfo struct{}
+
+func (rootInfo) Name() string       { return "." }
+func (rootInfo) Size() int64        { return 0 }
+func (rootInfo) Mode() fs.FileMode  { return fs.ModeDir | 0o555 }
+func (rootInfo) ModTime() time.Time { return time.Time{} }
+func (rootInfo) IsDir() bool        { return true }
+func (rootInfo) Sys() any           { return nil }
+
+// libraryRoot is the open folder holding the libraries
+type libraryRoot struct {
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (r *libraryRoot) Stat() (fs.FileInfo, error) { return rootInfo{}, nil }
+func (r *libraryRoot) Close() error               { return nil }
+
+func (r *libraryRoot) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: ".", Err: errors.New("is a directory")}
+}
+
+func (r *libraryRoot) ReadDir(n int) ([]fs.DirEntry, error) {
+	rest := r.entries[r.offset:]
+	if n > 0 && len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if n > 0 && n < len(rest) {
+		rest = rest[:n]
+	}
+	r.offset += len(rest)
+	return rest, nil
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ListenerConfig is one address the web server listens on. Addr is a TCP
+// address like ":8000" or "[::1]:8000", optionally prefixed with "tcp4:" or
+// "tcp6:" to pick the IP version, or "unix:/path" for a unix socket. With a
+// certificate the listener serves HTTPS.
+type ListenerConfig struct {
+	Addr     string `json:"addr"`
+	CertFile string `json:"certFile"`
+	KeyFile  string `json:"keyFile"`
+
+	// BasicAuth maps user names to passwords required on this listener only
+	BasicAuth map[string]string `json:"basicAuth"`
+
+	// tlsConfig serves HTTPS with certificates obtained at run time
+	tlsConfig *tls.Config
+}
+
+// secure reports whether the listener serves HTTPS
+func (l ListenerConfig) secure() bool {
+	return l.CertFile != "" || l.tlsConfig != nil
+}
+
+// splitListenAddr splits an address with an optional network prefix
+func splitListenAddr(addr string) (network, address string) {
+	for _, network := range []string{"unix", "tcp4", "tcp6"} {
+		if rest, ok := strings.CutPrefix(addr, network+":"); ok {
+			return network, rest
+		}
+	}
+	return "tcp", addr
+}
+
+// listen opens the listener's socket, replacing a stale unix socket file
+func (l ListenerConfig) listen() (net.Listener, error) {
+	network, address := splitListenAddr(l.Addr)
+	if network == "unix" {
+		os.Remove(address)
+	}
+	return net.Listen(network, address)
+}
+
+// handler wraps h in the listener's middleware
+func (l ListenerConfig) handler(h http.Handler) http.Handler {
+	if len(l.BasicAuth) > 0 {
+		h = basicAuth(l.BasicAuth, h)
+	}
+	return h
+}
+
+// URL describes where the listener can be reached, for the startup message
+func (l ListenerConfig) URL() string {
+	network, address := splitListenAddr(l.Addr)
+	if network == "unix" {
+		return "unix:" + address
+	}
+	scheme := "http"
+	if l.secure() {
+		scheme = "https"
+	}
+	if strings.HasPrefix(address, ":") {
+		address = "localhost" + address
+	}
+	return scheme + "://" + address
+}
+
+// withBasePath serves h under the base path. Requests are taken with or
+// without the prefix, so a proxy in front may strip it or pass it on.
+func withBasePath(base string, h http.Handler) http.Handler {
+	if base == "" {
+		return h
+	}
+	stripped := http.StripPrefix(base, h)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == base:
+			http.Redirect(w, r, base+"/", http.StatusMovedPermanently)
+		case strings.HasPrefix(r.URL.Path, base+"/"):
+			stripped.ServeHTTP(w, r)
+		default:
+			h.ServeHTTP(w, r)
+		}
+	})
+}
+
+// basicAuth requires one of the given user names and passwords
+func basicAuth(users map[string]string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, ok := r.BasicAuth(); ok && checkPassword(users, user, password) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="Pali Reader", charset="UTF-8"`)
+		httpError(w, r, "Unauthorized", http.StatusUnauthorized)
+	})
+}
+
+// listenAndServe serves h on every configured listener until one of them
+// fails or the process gets SIGINT or SIGTERM. On a signal the listeners stop
+// accepting connections and requests in flight are given until the shutdown
+// timeout to finish.
+func listenAndServe(listeners []ListenerConfig, timeouts TimeoutConfig, h http.Handler) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	seconds := func(n int) time.Duration { return time.Duration(n) * time.Second }
+	var servers []*http.Server
+	errs := make(chan error, len(listeners))
+	for _, l := range listeners {
+		ln, err := l.listen()
+		if err != nil {
+			return err
+		}
+		srv := &http.Server{
+			Handler:      withRequestID(l.handler(h)),
+			ReadTimeout:  seconds(timeouts.Read),
+			WriteTimeout: seconds(timeouts.Write),
+			IdleTimeout:  seconds(timeouts.Idle),
+			TLSConfig:    l.tlsConfig,
+		}
+		servers = append(servers, srv)
+		fmt.Printf("Pali Reader listening on %s\n", l.URL())
+		go func() {
+			var err error
+			if l.secure() {
+				err = srv.ServeTLS(ln, l.CertFile, l.KeyFile)
+			} else {
+				err = srv.Serve(ln)
+			}
+			if !errors.Is(err, http.ErrServerClosed) {
+				errs <- fmt.Errorf("%s: %w", l.Addr, err)
+			}
+		}()
+	}
+
+	var serveErr error
+	select {
+	case serveErr = <-errs:
+	case <-ctx.Done():
+		log.Println("Shutting down; waiting for requests in flight")
+	}
+	stop()
+	// Live reload streams never finish by themselves
+	corpusChanges.close()
+
+	shutdownCtx := context.Background()
+	if timeouts.Shutdown > 0 {
+		var cancel context.CancelFunc
+		shutdownCtx, cancel = context.WithTimeout(shutdownCtx, seconds(timeouts.Shutdown))
+		defer cancel()
+	}
+	for _, srv := range servers {
+		if err := srv.Shutdown(shutdownCtx); err != nil && serveErr == nil {
+			serveErr = fmt.Errorf("shutting down: %w", err)
+		}
+	}
+	return serveErr
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// liveReload is set by serve -dev: reader pages then reload themselves when
+// their text changes on disk, for proofreading in an editor alongside
+var liveReload bool
+
+// corpusChanges tells the open reader pages which corpus names the watcher
+// saw change
+var corpusChanges = &changeFeed{subscribers: make(map[chan string]struct{})}
+
+type changeFeed struct {
+	mu          sync.Mutex
+	subscribers map[chan string]struct{}
+	closed      bool
+}
+
+// subscribe returns a channel getting the changed names, closed when the
+// server shuts down
+func (f *changeFeed) subscribe() chan string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ch := make(chan string, 16)
+	if f.closed {
+		close(ch)
+		return ch
+	}
+	f.subscribers[ch] = struct{}{}
+	return ch
+}
+
+func (f *changeFeed) unsubscribe(ch chan string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.subscribers[ch]; ok {
+		delete(f.subscribers, ch)
+		close(ch)
+	}
+}
+
+// publish tells every subscriber about a changed name. A subscriber that
+// is behind misses it; it is about to reload anyway.
+func (f *changeFeed) publish(name string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for ch := range f.subscribers {
+		select {
+		case ch <- name:
+		default:
+		}
+	}
+}
+
+// close ends every subscription, so the streams do not hold up shutdown
+func (f *changeFeed) close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = true
+	for ch := range f.subscribers {
+		delete(f.subscribers, ch)
+		close(ch)
+	}
+}
+
+// reloadSettle is how long a text has to stay unchanged before its pages
+// reload, as editors save in several steps
+const reloadSettle = 200 * time.Millisecond
+
+// handleReload streams an event to the reader page of a text whenever the
+// text changes on disk
+func handleReload(w http.ResponseWriter, r *http.Request) {
+	name, err := corpusName(strings.TrimPrefix(r.URL.Path, "/reload/"))
+	if err != nil {
+		httpError(w, r, "Invalid path", http.StatusBadRequest)
+		return
+	}
+	if !canRead(r, name) {
+		httpError(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// The stream outlives the server's write timeout
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		httpError(w, r, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	changes := corpusChanges.subscribe()
+	defer corpusChanges.unsubscribe(changes)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	rc.Flush()
+
+	settle := time.NewTimer(reloadSettle)
+	settle.Stop()
+	heartbeat := time.NewTicker(30 * time.Second)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case changed, ok := <-changes:
+			if !ok {
+				return
+			}
+			// A folder above the text may have been moved or removed
+			if changed == name || changed == "." || strings.HasPrefix(name, changed+"/") {
+				settle.Reset(reloadSettle)
+			}
+		case <-settle.C:
+			fmt.Fprint(w, "event: reload\ndata: \n\n")
+			rc.Flush()
+		case <-heartbeat.C:
+			// Keeps proxies from closing the idle connection
+			fmt.Fprint(w, ": ping\n\n")
+			rc.Flush()
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Lookup records one word looked up, or saved to the vocabulary, while
+// reading
+type Lookup struct {
+	Word   string    `json:"word"`
+	Source string    `json:"source"` // text path, optionally with a #paragraph anchor
+	Time   time.Time `json:"time"`
+}
+
+var lookups = &userFile[[]Lookup]{name: "lookups.json"}
+
+// maxLookups caps the lookups kept for a reader; the oldest go first
+const maxLookups = 20000
+
+// Flashcard is one exported vocabulary card
+type Flashcard struct {
+	Word   string
+	Gloss  string
+	Source string
+	Count  int
+}
+
+// handleLookups records a lookup reported by the reader script
+func handleLookups(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeAPIError(w, r, http.StatusMethodNotAllowed, "Method not allowed", map[string]string{"allow": http.MethodPost})
+		return
+	}
+
+	word := cleanWord(strings.TrimSpace(r.FormValue("word")))
+	if word == "" || !containsLetter(word) {
+		writeAPIError(w, r, http.StatusBadRequest, "Missing word", map[string]string{"field": "word"})
+		return
+	}
+
+	lookup := Lookup{
+		Word:   word,
+		Source: sourcePath(r.FormValue("source")),
+		Time:   time.Now().UTC(),
+	}
+	err := lookups.of(r).Update(func(list *[]Lookup) error {
+		*list = append(*list, lookup)
+		*list = (*list)[max(len(*list)-maxLookups, 0):]
+		return nil
+	})
+	if err != nil {
+		logf(r.Context(), "Error recording lookup: %v", err)
+		writeAPIError(w, r, http.StatusInternalServerError, "Cannot record lookup", nil)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// flashcards turns recorded lookups or saved words into one card per word,
+// keeping the most recent source
+func flashcards(words *jsonFile[[]Lookup]) ([]Flashcard, error) {
+	var cards []Flashcard
+	err := words.Read(func(list *[]Lookup) {
+		byWord := make(map[string]int)
+		for _, l := range *list {
+			i, ok := byWord[l.Word]
+			if !ok {
+				i = len(cards)
+				byWord[l.Word] = i
+				cards = append(cards, Flashcard{Word: l.Word})
+			}
+			cards[i].Count++
+			if l.Source != "" {
+				cards[i].Source = l.Source
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if dictionary != nil {
+		for i := range cards {
+			cards[i].Gloss = strings.Join(dictionary.Lookup(cards[i].Word), "; ")
+		}
+	}
+	return cards, nil
+}
+
+// handleFlashcards exports the looked-up words (or the saved vocabulary with
+// ?from=vocab) as an Anki-importable TSV file or as CSV (?format=csv)
+func handleFlashcards(w http.ResponseWriter, r *http.Request) {
+	if err := takeQuota(w, r, "export"); err != nil {
+		httpError(w, r, err.Error(), http.StatusTooManyRequests)
+		return
+	}
+	words := lookups.of(r)
+	if r.URL.Query().Get("from") == "vocab" {
+		words = vocabulary.of(r)
+	}
+
+	cards, err := flashcards(words)
+	if err != nil {
+		refundQuota(r, "export")
+		httpError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	base := baseURL(r)
+	if r.URL.Query().Get("format") == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="pali-words.csv"`)
+		writeFlashcardsCSV(w, cards, base)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/tab-separated-values; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="pali-words.txt"`)
+	writeFlashcardsAnki(w, cards, base)
+}
+
+// writeFlashcardsCSV writes cards as CSV, linking sources below base
+func writeFlashcardsCSV(w io.Writer, cards []Flashcard, base string) {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"word", "gloss", "source", "link", "lookups"})
+	for _, c := range cards {
+		link := ""
+		if c.Source != "" {
+			link = base + "/read/" + c.Source
+		}
+		cw.Write([]string{c.Word, c.Gloss, citation(c.Source), link, fmt.Sprint(c.Count)})
+	}
+	cw.Flush()
+}
+
+// writeFlashcardsAnki writes cards as an Anki-importable TSV file, linking
+// sources below base
+func writeFlashcardsAnki(w io.Writer, cards []Flashcard, base string) {
+	// Anki reads these header lines to set up the import
+	fmt.Fprint(w, "#separator:tab\n#html:true\n#columns:Word\tGloss\tSource\tTags\n")
+	for _, c := range cards {
+		source := ""
+		if c.Source != "" {
+			source = fmt.Sprintf(`<a href="%s/read/%s">%s</a>`,
+				base, template.HTMLEscapeString(c.Source), template.HTMLEscapeString(citation(c.Source)))
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n",
+			tsvField(template.HTMLEscapeString(c.Word)),
+			tsvField(template.HTMLEscapeString(c.Gloss)),
+			tsvField(source),
+			"palireader")
+	}
+}
+
+// citation formats a lookup source like "dighan1u ¶12"
+func citation(source string) string {
+	if source == "" {
+		return ""
+	}
+	path, anchor, _ := strings.Cut(source, "#")
+	title := textTitle(path)
+	if strings.HasPrefix(anchor, "p") {
+		return title + " ¶" + strings.TrimPrefix(anchor, "p")
+	}
+	return title
+}
+
+// tsvField keeps a value on one line and out of neighbouring columns
+func tsvField(s string) string {
+	return strings.NewReplacer("\t", " ", "\r", " ", "\n", " ").Replace(s)
+}
+
+// baseURL returns the URL of the site the request was made to
+func baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + config.BasePath
+}
@@ -1,21 +1,30 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
 	"fmt"
+	"html"
 	"html/template"
+	"io"
+	"io/fs"
 	"log"
 	"net/http"
-	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 	"unicode"
+
+	"golang.org/x/text/unicode/norm"
 )
 
 const baseDir = "2_pali"
-const paliAnalysisURL = "https://dpdict.net/"
 
 // FileInfo represents a file or directory in the tree
 type FileInfo struct {
@@ -23,6 +32,25 @@ type FileInfo struct {
 	Path     string
 	IsDir    bool
 	Children []*FileInfo
+
+	// Title comes from the folder's sidecar or a text's <title> or first
+	// heading, the nikāya or book ID and the description from the sidecar
+	Title       string
+	ID          string
+	Description string
+
+	// Audio are the recordings beside a text, such as dn1.mp3 beside
+	// dn1.htm
+	Audio []string
+}
+
+// DisplayName is what the entry is shown as: its title, or else its
+// humanized name
+func (f *FileInfo) DisplayName() string {
+	if f.Title != "" {
+		return f.Title
+	}
+	return humanizePath(f.Name)
 }
 
 // PageData holds data for template rendering
@@ -32,131 +60,647 @@ type PageData struct {
 	Files       *FileInfo
 	CurrentPath string
 	Breadcrumbs []Breadcrumb
+
+	// Search pages
+	Query          string
+	Notice         string
+	ReverseResults []ReverseMatch
+	Occurrences    []Occurrence
+	SearchMode     string
+	SearchScope    string       // folder searched in, "" for the whole corpus
+	SearchScopes   []Breadcrumb // the folders it can be narrowed to or widened to
+	SearchResults  []SearchResult
+	Passages       []CitedPassage
+	VocabGroups    []VocabGroup
+	History        []HistoryDay
+	ReadingStats   *ReadingStats
+	Bookmarks      []Bookmark
+	Review         *ReviewPage
+
+	// Reader page
+	Editions []ExternalLink
+	Layers   []LayerLink // texts of the other layers: root text, commentary and subcommentary
+	Pager    *Pager      // nil for a text on one page
+	Print    *PrintOptions
+	Preview  *Preview // of a text, for links shared to it
+	Audio    []string // recordings to play along with the text
+	Timed    bool     // whether the recordings have timing, to follow them paragraph by paragraph
+
+	// Glossary tab
+	Glossary []GlossaryEntry
+	Sort     string
+
+	// Login page: where to go once logged in
+	Next string
+
+	// Signup page
+	Invite *Invitation
+
+	// Settings page
+	Settings *UserSettings
+
+	// API tokens page
+	Tokens *TokensPage
+
+	// Admin area
+	Admin *AdminPage
+
+	// Canon page
+	Canon *CanonPage
+
+	// Home page
+	MostRead  []PopularText
+	Recent    []RecentText
+	Favorites []Favorite
+
+	// Texts the reader starred, and the tags they gave texts, for the
+	// stars and tags of folders and texts
+	Starred map[string]bool
+	Tags    map[string][]string
+
+	// Tags pages
+	TagPage *TagPage
+
+	// Activity page
+	Events []CorpusEvent
+
+	// Dictionary pages
+	Glosses     []string
+	UpstreamURL string
 }
 
 // Breadcrumb for navigation
 type Breadcrumb struct {
-	Name string
-	Path string
+	Name  string
+	Path  string
+	Title string // of a text, as its entry in the tree
+
+	// Siblings are the entries of the folder holding this one, itself
+	// included, for jumping sideways
+	Siblings []*FileInfo
+}
+
+// DisplayName is what the crumb is shown as, like an entry of the tree
+func (b Breadcrumb) DisplayName() string {
+	if b.Title != "" {
+		return b.Title
+	}
+	return humanizePath(b.Name)
 }
 
 var templates *template.Template
 
+// staticSite is set while writing a static copy of the site, leaving out
+// everything that needs the server
+var staticSite bool
+
 func main() {
+	configPath := flag.String("config", defaultConfigFile, "path to the JSON config file")
+	themeDir := flag.String("theme-dir", "", "directory of templates and assets overriding the built-in ones")
+	flag.Usage = usage
+	flag.Parse()
+
+	// Only insist on the config file existing if one was asked for explicitly
+	configRequired := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "config" {
+			configRequired = true
+		}
+	})
+
 	var err error
-	templates, err = template.New("").Funcs(template.FuncMap{
-		"isLastIndex": func(index, length int) bool {
-			return index == length-1
-		},
-	}).Parse(templatesHTML)
+	config, err = loadConfig(*configPath, configRequired)
 	if err != nil {
-		log.Fatal("Error parsing templates:", err)
+		log.Fatal("Error loading config:", err)
+	}
+	if *themeDir != "" {
+		config.ThemeDir = *themeDir
+	}
+	if config.ThemeDir != "" {
+		if err := loadTheme(config.ThemeDir); err != nil {
+			log.Fatal("Error loading the theme: ", err)
+		}
+	}
+	if corpus, err = openCorpus(config.Libraries); err != nil {
+		log.Fatal("Error opening the corpus: ", err)
+	}
+
+	name, args := "serve", flag.Args()
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
+	}
+	cmd := findCommand(name)
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", name)
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err := cmd.run(args); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// runServe loads the dictionary and templates and runs the web server
+func runServe(args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	certFile := flags.String("cert", "", "TLS certificate file for the listeners without one")
+	keyFile := flags.String("key", "", "TLS key file to go with -cert")
+	domains := flags.String("autocert", "", "comma-separated domains to get Let's Encrypt certificates for")
+	flags.BoolVar(&liveReload, "dev", false, "reload reader pages when their text changes on disk")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: palireader serve [-cert file -key file] [-autocert domains] [-dev]")
+		fmt.Fprintln(flags.Output(), "\nRuns the web server on the listeners set in the config file.")
+		fmt.Fprintln(flags.Output(), "\nFlags:")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if (*certFile == "") != (*keyFile == "") {
+		return errors.New("-cert and -key go together")
+	}
+	if *domains != "" {
+		config.Autocert = &AutocertConfig{Domains: strings.Split(*domains, ",")}
+	}
+	listeners := config.listeners()
+	if *certFile != "" {
+		for i, l := range listeners {
+			if network, _ := splitListenAddr(l.Addr); network != "unix" && l.CertFile == "" {
+				listeners[i].CertFile, listeners[i].KeyFile = *certFile, *keyFile
+			}
+		}
+	}
+	if config.Autocert != nil {
+		listeners = useAutocert(config.Autocert, listeners)
+	}
+
+	if err := loadConfiguredDictionary(); err != nil {
+		return fmt.Errorf("loading dictionary: %w", err)
+	}
+
+	// Word links go through /dict/ whenever it has something to show
+	lookupEndpoint := config.DictProxy != nil || dictionary != nil
+	if lookupEndpoint {
+		dictProxyProvider.URL = sitePath(dictProxyProvider.URL)
+		config.Providers = append([]DictionaryProvider{dictProxyProvider}, config.Providers...)
+	}
+
+	if err := parseTemplates(); err != nil {
+		return fmt.Errorf("parsing templates: %w", err)
 	}
 
 	http.HandleFunc("/", handleIndex)
 	http.HandleFunc("/read/", handleRead)
-	http.HandleFunc("/static/style.css", handleCSS)
+	http.HandleFunc("/print/", handlePrint)
+	http.HandleFunc("/glossary/", handleGlossary)
+	http.HandleFunc("/audio/", handleAudio)
+	http.HandleFunc("/timing/", handleTiming)
+	http.HandleFunc("/cite/", handleCite)
+	http.HandleFunc("/random", handleRandom)
+	http.HandleFunc("/canon", handleCanon)
+	http.HandleFunc("/go", handleGo)
+	http.HandleFunc("/canon/", handleCanon)
+	http.HandleFunc("/feed.xml", handleFeed)
+	http.HandleFunc("/sitemap.xml", handleSitemap)
+	http.HandleFunc("/sitemap/", handleSitemap)
+	http.HandleFunc("/robots.txt", handleRobots)
+	http.HandleFunc("/manifest.webmanifest", handleManifest)
+	http.HandleFunc("/sw.js", handleServiceWorker)
+	http.HandleFunc("/offline/", handleOffline)
+	http.HandleFunc("/quick", handleQuick)
+	http.HandleFunc("/tree/", handleTree)
+	http.HandleFunc("/api/tree", handleTreeAPI)
+	http.HandleFunc("/language", handleLanguage)
+	http.HandleFunc("/activity", handleActivity)
+	http.HandleFunc("/activity.xml", handleActivityFeed)
+	http.HandleFunc("/static/", handleStatic)
+	http.HandleFunc("/static/custom.css", handleCustomCSS)
+	http.Handle("/settings", http.NewCrossOriginProtection().Handler(http.HandlerFunc(handleSettings)))
+	http.HandleFunc("/reverse", handleReverse)
+	http.HandleFunc("/occurrences", handleOccurrences)
+	http.HandleFunc("/search", handleSearch)
+	// A private site keeps nothing of what readers look up or save
+	if !config.Private {
+		http.HandleFunc("/lookups", handleLookups)
+		http.HandleFunc("/vocab", handleVocab)
+		http.HandleFunc("/known", handleKnown)
+		http.HandleFunc("/history", handleHistory)
+		http.HandleFunc("/reading", handleReading)
+		http.HandleFunc("/stats", handleReadingStats)
+		http.HandleFunc("/bookmarks", handleBookmarks)
+		http.HandleFunc("/favorites", handleFavorites)
+		http.HandleFunc("/tags", handleTags)
+		http.HandleFunc("/tags/", handleTags)
+		http.Handle("/annotations/", http.NewCrossOriginProtection().Handler(http.HandlerFunc(handleAnnotations)))
+		http.HandleFunc("/review", handleReview)
+		http.HandleFunc("/export/flashcards", handleFlashcards)
+		http.HandleFunc("/account/export", handleAccountExport)
+		http.Handle("/account/import", http.NewCrossOriginProtection().Handler(http.HandlerFunc(handleAccountImport)))
+	}
+	if config.Ask != nil {
+		http.HandleFunc("/ask", handleAsk)
+	}
+	if config.TTS != nil {
+		http.HandleFunc("/listen/", handleListen)
+	}
+	if config.Auth != nil {
+		http.HandleFunc("/login", handleLogin)
+		http.HandleFunc("/logout", handleLogout)
+		if config.Auth.OIDC != nil {
+			http.HandleFunc("/login/oidc", handleOIDCLogin)
+			http.HandleFunc("/login/oidc/callback", handleOIDCCallback)
+		}
+		http.Handle("/invite/", http.NewCrossOriginProtection().Handler(http.HandlerFunc(handleInvite)))
+		http.Handle("/tokens", http.NewCrossOriginProtection().Handler(http.HandlerFunc(handleTokens)))
+		if config.Auth.managed() {
+			// Forms posted from other sites must not change the corpus
+			// in an admin's name
+			http.Handle("/admin/", http.NewCrossOriginProtection().Handler(http.HandlerFunc(handleAdmin)))
+		}
+	}
+	if lookupEndpoint {
+		http.HandleFunc("/dict/", handleDict)
+	}
+
+	if config.Cache != nil {
+		var err error
+		if sharedCache, err = openCache(config.Cache); err != nil {
+			return err
+		}
+	}
+	if !watchCorpus() && liveReload {
+		log.Println("Live reload is off, the corpus is not being watched")
+		liveReload = false
+	}
+	if liveReload {
+		http.HandleFunc("/reload/", handleReload)
+	}
+	if config.Warmup != nil {
+		warmUp(config.Warmup)
+	}
+	startCorpusIndex()
+	reindexOnHangup()
+	saveViewsPeriodically()
+	saveRecentPeriodically()
+	// Splitting the corpus into daily readings takes a while; do it before
+	// the first feed request
+	go readingSchedule()
+	if config.Semantic != nil {
+		startSemanticSearch()
+	}
+	if config.Gemini != nil {
+		startGemini()
+	}
 
-	port := "8000"
-	fmt.Printf("Pali Reader starting on http://localhost:%s\n", port)
-	log.Fatal(http.ListenAndServe(":"+port, nil))
+	h := withSlugs(withAuth(withVisitors(http.DefaultServeMux)))
+	if config.RateLimit != nil {
+		h = withRateLimit(config.RateLimit, h)
+	}
+	h = withBasePath(config.BasePath, h)
+	if config.AccessLog != nil {
+		var err error
+		if h, err = withAccessLog(config.AccessLog, h); err != nil {
+			return err
+		}
+	}
+	err := listenAndServe(listeners, config.Timeouts, h)
+	saveViews()
+	saveRecent()
+	return err
 }
 
-func handleCSS(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "text/css")
-	w.Write([]byte(cssContent))
+// parseTemplates parses the page templates with their helper functions,
+// once for each language of the interface
+func parseTemplates() error {
+	if err := loadCatalogs(); err != nil {
+		return fmt.Errorf("loading translations: %w", err)
+	}
+	localized := make(map[string]*template.Template)
+	for _, c := range catalogs {
+		set, err := newTemplates(c)
+		if err != nil {
+			return err
+		}
+		localized[c.Tag] = set
+	}
+	templates, localizedTemplates = localized["en"], localized
+	return nil
+}
+
+// newTemplates parses the templates, with their messages in the language
+// of catalog c, and the theme's after them
+func newTemplates(c *Catalog) (*template.Template, error) {
+	set, err := template.New("").Funcs(template.FuncMap{
+		"t":     c.translate,
+		"tHTML": c.translateHTML,
+		"link":  link,
+		"code":  code,
+		"pali":  pali,
+		"lang": func() string {
+			return c.Tag
+		},
+		"languages": func() []*Catalog {
+			return catalogs
+		},
+		"isLastIndex": func(index, length int) bool {
+			return index == length-1
+		},
+		"lookupProviders": func() []DictionaryProvider {
+			return config.Providers
+		},
+		"lookupURL": func(word string) string {
+			return config.defaultProvider().LookupURL(word)
+		},
+		"dictionaryLoaded": func() bool {
+			return dictionary != nil
+		},
+		"join": strings.Join,
+		"semanticSearch": func() bool {
+			return config.Semantic != nil
+		},
+		"askEnabled": func() bool {
+			return config.Ask != nil
+		},
+		"listenEnabled": func() bool {
+			return config.TTS != nil && !staticSite
+		},
+		"citation":      citation,
+		"adjacentText":  adjacentText,
+		"transliterate": transliterate,
+		"collate":       collate,
+		"humanizePath":  humanizePath,
+		"slug":          slugPath,
+		"overlays": func() []OverlayConfig {
+			return config.Overlays
+		},
+		"truncateWords": truncateWords,
+		"treeFilterMin": func() int { return treeFilterMin },
+		"staticSite": func() bool {
+			return staticSite
+		},
+		// keepsNothing hides what would save readers' words and texts
+		"keepsNothing": func() bool {
+			return staticSite || config.Private
+		},
+		"liveReload": func() bool {
+			return liveReload && !staticSite
+		},
+		"starOf": func(path string, starred bool) map[string]any {
+			return map[string]any{"Path": path, "Starred": starred}
+		},
+		"tagged": func(path string, tags []string, links bool) map[string]any {
+			return map[string]any{"Path": path, "Tags": tags, "Links": links}
+		},
+		"logins": func() bool {
+			return config.Auth != nil
+		},
+		"oidcName": func() string {
+			if config.Auth == nil || config.Auth.OIDC == nil {
+				return ""
+			}
+			return config.Auth.OIDC.Name
+		},
+		"base": func() string {
+			return config.BasePath
+		},
+		"asset":           assetPath,
+		"themeStylesheet": themeStylesheet,
+		"patternTimeout":  patternTimeout.String,
+	}).Parse(templatesHTML)
+	if err != nil {
+		return nil, err
+	}
+	return set, parseThemeTemplates(set)
 }
 
 func handleIndex(w http.ResponseWriter, r *http.Request) {
-	files := buildFileTree(baseDir, "")
+	files := buildFileTree("")
 
 	data := PageData{
-		Title: "Pali Reader",
-		Files: files,
+		Title:     "Pali Reader",
+		Files:     files,
+		MostRead:  mostRead(r, mostReadCount),
+		Recent:    recentlyOpened(r),
+		Favorites: starredTexts(r),
 	}
+	hideProtected(r, &data)
 
-	err := templates.ExecuteTemplate(w, "index", data)
+	err := templatesFor(r).ExecuteTemplate(w, "index", data)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		httpError(w, r, err.Error(), http.StatusInternalServerError)
 	}
 }
 
 func handleRead(w http.ResponseWriter, r *http.Request) {
 	filePath := strings.TrimPrefix(r.URL.Path, "/read/")
 	if filePath == "" {
-		http.Redirect(w, r, "/", http.StatusFound)
+		http.Redirect(w, r, sitePath("/"), http.StatusFound)
 		return
 	}
 
-	fullPath := filepath.Join(baseDir, filePath)
-
-	// Security check - prevent directory traversal
-	absBase, _ := filepath.Abs(baseDir)
-	absPath, _ := filepath.Abs(fullPath)
-	if !strings.HasPrefix(absPath, absBase) {
-		http.Error(w, "Invalid path", http.StatusBadRequest)
+	name, err := corpusName(filePath)
+	if err != nil {
+		httpError(w, r, "Invalid path", http.StatusBadRequest)
 		return
 	}
 
-	info, err := os.Stat(fullPath)
+	info, err := fs.Stat(corpus, name)
 	if err != nil {
-		http.Error(w, "File not found", http.StatusNotFound)
+		httpError(w, r, "File not found", http.StatusNotFound)
 		return
 	}
 
 	if info.IsDir() {
 		// Show directory listing
-		files := buildFileTree(fullPath, filePath)
+		files := buildFileTree(filePath)
 		breadcrumbs := buildBreadcrumbs(filePath)
 
 		data := PageData{
-			Title:       filepath.Base(filePath),
+			Title:       files.DisplayName(),
 			Files:       files,
 			CurrentPath: filePath,
 			Breadcrumbs: breadcrumbs,
+			Starred:     starredSet(r),
+			Tags:        tagsOf(r),
 		}
+		hideProtected(r, &data)
 
-		err := templates.ExecuteTemplate(w, "directory", data)
+		err := templatesFor(r).ExecuteTemplate(w, "directory", data)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			httpError(w, r, err.Error(), http.StatusInternalServerError)
 		}
 		return
 	}
 
-	// Read and process file
-	content, err := os.ReadFile(fullPath)
-	if err != nil {
-		http.Error(w, "Cannot read file", http.StatusInternalServerError)
+	// Find the page asked for: where the pages start is kept while the
+	// corpus is watched, and so are the pages once processed
+	starts, generation, cached := corpusCache.pageStarts(name)
+	var body string
+	if !cached {
+		content, err := fs.ReadFile(corpus, name)
+		if err != nil {
+			httpError(w, r, "Cannot read file", http.StatusInternalServerError)
+			return
+		}
+		body = extractBody(string(content))
+		starts = textPages(paragraphBreak.Split(body, -1))
+		corpusCache.storePageStarts(name, starts, generation)
+	}
+	page, _ := strconv.Atoi(r.FormValue("page"))
+	from, to, pager := pageRange(starts, page)
+	if pager != nil {
+		page = pager.Page
+	} else {
+		page = 1
+	}
+	if page == 1 {
+		countView(name)
+	}
+	recordRecent(r, name, page)
+	s, _ := userSettings(r)
+	style := s.anusvara()
+	// Opened from a search result, the words searched for are marked, and
+	// with a vocabulary level set so are the words known
+	var marks []func(string) string
+	if highlight := highlighter(r.FormValue("hl")); highlight != nil {
+		marks = append(marks, highlight)
+	}
+	if known := knownMarker(s); known != nil {
+		marks = append(marks, known)
+	}
+	convert := func(content string) string {
+		for _, mark := range marks {
+			content = mark(content)
+		}
+		return style.convertHTML(content)
+	}
+
+	data := PageData{
+		Title:       displayTitle(filePath),
+		CurrentPath: filePath,
+		Breadcrumbs: buildBreadcrumbs(filePath),
+		Editions:    editionLinks(filePath),
+		Layers:      layerLinks(r, name),
+		Pager:       pager,
+		Audio:       textAudio(filePath),
+		Timed:       hasTiming(name),
+		Preview:     textPreview(r, filePath, name, info, page),
+		Starred:     starredSet(r),
+		Tags:        tagsOf(r),
+	}
+	hideProtected(r, &data)
+
+	// The page goes out as it is processed, so a long one starts showing at
+	// once and is never held whole for the response
+	if err := templatesFor(r).ExecuteTemplate(w, "reader-start", data); err != nil {
+		httpError(w, r, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	http.NewResponseController(w).Flush()
+	if processed, ok := renderedPage(name, info, page); ok {
+		io.WriteString(w, convert(processed))
+	} else {
+		if body == "" {
+			content, err := fs.ReadFile(corpus, name)
+			if err != nil {
+				logf(r.Context(), "Error reading %s: %v", name, err)
+				return
+			}
+			body = extractBody(string(content))
+		}
+		var processed strings.Builder
+		err := eachProcessed(name, body, from, to, func(chunk string) error {
+			processed.WriteString(chunk)
+			_, err := io.WriteString(w, convert(chunk))
+			return err
+		})
+		if err != nil {
+			// The reader went away
+			return
+		}
+		keepRenderedPage(name, info, page, processed.String(), generation)
+	}
+	if err := templatesFor(r).ExecuteTemplate(w, "reader-end", data); err != nil {
+		logf(r.Context(), "Error rendering %s: %v", name, err)
+	}
+}
 
-	processedContent := processHTMContent(string(content))
-	breadcrumbs := buildBreadcrumbs(filePath)
+// textTitle extracts a text's title from its filename
+func textTitle(filePath string) string {
+	return strings.TrimSuffix(filepath.Base(filePath), filepath.Ext(filePath))
+}
 
-	// Extract title from filename
-	title := strings.TrimSuffix(filepath.Base(filePath), filepath.Ext(filePath))
+// displayTitle returns the title a text is shown with: the one its document
+// gives, as in the tree, or else the one of its filename
+func displayTitle(filePath string) string {
+	if entry := treeEntry(filePath); entry != nil && entry.Title != "" {
+		return entry.Title
+	}
+	return textTitle(filePath)
+}
 
-	data := PageData{
-		Title:       title,
-		Content:     template.HTML(processedContent),
-		CurrentPath: filePath,
-		Breadcrumbs: breadcrumbs,
+// treeEntry returns the text at filePath as its folder lists it, or nil
+func treeEntry(filePath string) *FileInfo {
+	parent := filepath.Dir(filePath)
+	if parent == "." {
+		parent = ""
 	}
+	for _, child := range buildFileTree(parent).Children {
+		if child.Path == filePath {
+			return child
+		}
+	}
+	return nil
+}
 
-	err = templates.ExecuteTemplate(w, "reader", data)
+// titleScan is how much of the start of a text is searched for its title
+const titleScan = 32 << 10
+
+var (
+	titleTag   = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+	headingTag = regexp.MustCompile(`(?is)<h[1-6][^>]*>(.*?)</h[1-6]>`)
+)
+
+// documentTitle returns the title of the text at the corpus name, from its
+// <title> tag or else its first heading, so that a text such as
+// s0101m.mul0.htm shows by the name it gives itself; "" if it has neither
+func documentTitle(name string) string {
+	f, err := corpus.Open(name)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	head, err := io.ReadAll(io.LimitReader(f, titleScan))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return ""
 	}
+	for _, tag := range []*regexp.Regexp{titleTag, headingTag} {
+		if m := tag.FindSubmatch(head); m != nil {
+			text := html.UnescapeString(htmlTag.ReplaceAllString(string(m[1]), ""))
+			if title := strings.Join(strings.Fields(norm.NFC.String(text)), " "); title != "" {
+				return title
+			}
+		}
+	}
+	return ""
 }
 
-func buildFileTree(dirPath, relativePath string) *FileInfo {
+// buildFileTree lists the folder at the corpus path dir
+func buildFileTree(dir string) *FileInfo {
 	root := &FileInfo{
-		Name:  filepath.Base(dirPath),
-		Path:  relativePath,
+		Name:  path.Base(dir),
+		Path:  dir,
 		IsDir: true,
 	}
 
-	entries, err := os.ReadDir(dirPath)
+	name, err := corpusName(dir)
+	if err != nil {
+		return root
+	}
+	tree, generation, ok := corpusCache.tree(name)
+	if ok {
+		return tree
+	}
+	entries, err := fs.ReadDir(corpus, name)
 	if err != nil {
 		return root
 	}
@@ -165,7 +709,7 @@ func buildFileTree(dirPath, relativePath string) *FileInfo {
 	var dirs, files []*FileInfo
 
 	for _, entry := range entries {
-		childPath := filepath.Join(relativePath, entry.Name())
+		childPath := path.Join(dir, entry.Name())
 		child := &FileInfo{
 			Name:  entry.Name(),
 			Path:  childPath,
@@ -175,10 +719,13 @@ func buildFileTree(dirPath, relativePath string) *FileInfo {
 		if entry.IsDir() {
 			dirs = append(dirs, child)
 		} else if strings.HasSuffix(strings.ToLower(entry.Name()), ".htm") {
+			child.Title = documentTitle(path.Join(name, entry.Name()))
 			files = append(files, child)
 		}
 	}
 
+	attachAudio(files, entries, dir)
+
 	// Sort alphabetically
 	sort.Slice(dirs, func(i, j int) bool {
 		return dirs[i].Name < dirs[j].Name
@@ -187,9 +734,20 @@ func buildFileTree(dirPath, relativePath string) *FileInfo {
 		return files[i].Name < files[j].Name
 	})
 
-	// Directories first, then files
+	// Directories first, then files, unless the folder's sidecar orders
+	// them otherwise
 	root.Children = append(dirs, files...)
+	for _, child := range dirs {
+		if meta := folderMeta(path.Join(name, child.Name)); meta != nil {
+			child.Title, child.ID, child.Description = meta.Title, meta.ID, meta.Description
+		}
+	}
+	if meta := folderMeta(name); meta != nil {
+		root.Title, root.ID, root.Description = meta.Title, meta.ID, meta.Description
+		meta.apply(root.Children)
+	}
 
+	corpusCache.storeTree(name, root, generation)
 	return root
 }
 
@@ -211,18 +769,115 @@ func buildBreadcrumbs(path string) []Breadcrumb {
 		} else {
 			currentPath = filepath.Join(currentPath, part)
 		}
+		parent := filepath.Dir(currentPath)
+		if parent == "." {
+			parent = ""
+		}
+		siblings := buildFileTree(parent).Children
+		title := ""
+		for _, s := range siblings {
+			if s.Path == currentPath {
+				title = s.Title
+			}
+		}
 		breadcrumbs = append(breadcrumbs, Breadcrumb{
-			Name: part,
-			Path: currentPath,
+			Name:     part,
+			Path:     currentPath,
+			Title:    title,
+			Siblings: siblings,
 		})
 	}
 
 	return breadcrumbs
 }
 
-// processHTMContent processes the HTML content and makes Pali words clickable
-func processHTMContent(content string) string {
-	// Extract body content if present
+// processHTMContent processes the HTML content of the text at path and makes
+// Pali words clickable
+func processHTMContent(path, content string) string {
+	var result strings.Builder
+	eachProcessed(path, extractBody(content), 0, -1, func(chunk string) error {
+		result.WriteString(chunk)
+		return nil
+	})
+	return result.String()
+}
+
+// eachProcessed processes the paragraphs from up to, but not including, to
+// (-1 for the end) of the body of the text at path, and hands each to fn
+// with the break after it, so that a page can be sent as it is processed.
+// It stops at the first error fn returns.
+func eachProcessed(path, body string, from, to int, fn func(chunk string) error) error {
+	refs := referencePatterns(path)
+
+	// Anchor every paragraph (numbered as by paragraphs) so passages can be
+	// linked to, place the pages of other editions and make the words
+	// clickable
+	marks := overlayMarks()[path]
+	var b strings.Builder
+	last, paragraph := 0, 0
+	volume := "" // the PTS volume read, whose pages the markers are anchored as
+	breaks := paragraphBreak.FindAllStringIndex(body, -1)
+	for ; paragraph <= len(breaks) && (to < 0 || paragraph < to); paragraph++ {
+		end, next := len(body), len(body)
+		if paragraph < len(breaks) {
+			end, next = breaks[paragraph][0], breaks[paragraph][1]
+		}
+		if paragraph >= from {
+			b.Reset()
+			writeParagraph(&b, path, refs, marks[paragraph], paragraph, body[last:end], &volume)
+			b.WriteString(body[end:next])
+			if err := fn(b.String()); err != nil {
+				return err
+			}
+		} else {
+			for _, ref := range findReferences(refs, body[last:end]) {
+				if ref.volume != "" {
+					volume = ptsVolume(ref.volume)
+				}
+			}
+		}
+		last = next
+	}
+	return nil
+}
+
+// writeParagraph writes one processed paragraph of the text at path
+// preceded by its anchor. Given the PTS volume read so far, which it
+// updates, it anchors the PTS page markers too.
+func writeParagraph(result io.Writer, path string, refs []ReferencePattern, marks []overlayMark, index int, part string, volume *string) {
+	if strings.TrimSpace(part) != "" {
+		fmt.Fprintf(result, `<span id="p%d" class="anchor" data-segment="%s"></span>`, index, segmentID(path, index))
+	}
+	if len(marks) > 0 {
+		part = placeOverlays(part, marks)
+	}
+	io.WriteString(result, makeWordsClickable(part, refs, volume))
+}
+
+// segmentID identifies a paragraph for tools annotating the texts: the
+// first 16 hex digits of the SHA-256 of "path#pN". It stays the same across
+// rebuilds for as long as the text keeps its path and paragraph breaks.
+func segmentID(path string, paragraph int) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s#p%d", path, paragraph)))
+	return hex.EncodeToString(sum[:8])
+}
+
+// extractBody returns the content between the body tags, or the whole
+// content if there is no body tag, in NFC: some texts spell letters like ṃ
+// as m and a combining dot, which dictionaries and searches would not match
+func extractBody(content string) string {
+	return norm.NFC.String(rawBody(content))
+}
+
+// rawBody is extractBody leaving the characters as the file has them
+func rawBody(content string) string {
+	start, end := bodyBounds(content)
+	return content[start:end]
+}
+
+// bodyBounds returns where the content between the body tags starts and
+// ends in content
+func bodyBounds(content string) (int, int) {
 	bodyStart := strings.Index(strings.ToLower(content), "<body")
 	bodyEnd := strings.LastIndex(strings.ToLower(content), "</body>")
 
@@ -236,14 +891,10 @@ func processHTMContent(content string) string {
 		bodyStart = 0
 	}
 
-	if bodyEnd == -1 {
+	if bodyEnd == -1 || bodyEnd < bodyStart {
 		bodyEnd = len(content)
 	}
-
-	bodyContent := content[bodyStart:bodyEnd]
-
-	// Process the content to make words clickable
-	return makeWordsClickable(bodyContent)
+	return bodyStart, bodyEnd
 }
 
 // isPaliChar checks if a rune is a valid Pali character
@@ -264,28 +915,67 @@ func isWordChar(r rune) bool {
 	return isPaliChar(r) || r == '\'' || r == '\u2019'
 }
 
-// makeWordsClickable wraps each Pali word in an anchor tag
-func makeWordsClickable(content string) string {
+// cleanWord normalizes a word for dictionary lookup and indexing
+func cleanWord(word string) string {
+	return norm.NFC.String(strings.Trim(strings.ToLower(word), "''\""))
+}
+
+// Regex to match HTML tags
+var tagPattern = regexp.MustCompile(`<[^>]+>`)
+
+// makeWordsClickable wraps each Pali word in an anchor tag and styles the
+// reference markers. Given the PTS volume read so far, it follows the volume
+// markers and makes the page markers links to themselves, such as
+// #pts-d1-87, that citations of the page can go to.
+func makeWordsClickable(content string, refs []ReferencePattern, volume *string) string {
 	var result strings.Builder
+	lastEnd := 0
+	for _, ref := range findReferences(refs, content) {
+		result.WriteString(processMarkup(content[lastEnd:ref.start]))
+		// Keep the reference as-is (with styling), along with any line
+		// breaks inside it
+		marker := content[ref.start:ref.end]
+		if volume != nil && ref.volume != "" {
+			*volume = ptsVolume(ref.volume)
+		}
+		closing := `</span>`
+		if id := ptsAnchor(volume, ref.page); id != "" {
+			fmt.Fprintf(&result, `<a href="#%s" id="%s" class="%s" title="Link to this page">`, id, id, ref.class)
+			closing = `</a>`
+		} else {
+			fmt.Fprintf(&result, `<span class="%s">`, ref.class)
+		}
+		last := 0
+		for _, tag := range tagPattern.FindAllStringIndex(marker, -1) {
+			result.WriteString(template.HTMLEscapeString(marker[last:tag[0]]))
+			result.WriteString(marker[tag[0]:tag[1]])
+			last = tag[1]
+		}
+		result.WriteString(template.HTMLEscapeString(marker[last:]))
+		result.WriteString(closing)
+		lastEnd = ref.end
+	}
+	result.WriteString(processMarkup(content[lastEnd:]))
+	return result.String()
+}
 
-	// Regex to match HTML tags
-	tagPattern := regexp.MustCompile(`<[^>]+>`)
-	// Regex to match reference patterns like [PTS Page 001]
-	refPattern := regexp.MustCompile(`\[[^\]]+\]`)
+// processMarkup makes the words of the text between the tags of content
+// clickable, keeping the tags as they are
+func processMarkup(content string) string {
+	var result strings.Builder
 
 	// Split content into segments (tags and text)
 	lastEnd := 0
 	tagMatches := tagPattern.FindAllStringIndex(content, -1)
 
 	if len(tagMatches) == 0 {
-		return processTextSegment(content, refPattern)
+		return processWords(content)
 	}
 
 	for _, match := range tagMatches {
 		// Process text before this tag
 		if match[0] > lastEnd {
-			textSegment := content[lastEnd:match[0]]
-			result.WriteString(processTextSegment(textSegment, refPattern))
+			result.WriteString(processWords(content[lastEnd:match[0]]))
 		}
 		// Keep the tag as-is
 		result.WriteString(content[match[0]:match[1]])
@@ -294,40 +984,7 @@ func makeWordsClickable(content string) string {
 
 	// Process remaining text after last tag
 	if lastEnd < len(content) {
-		result.WriteString(processTextSegment(content[lastEnd:], refPattern))
-	}
-
-	return result.String()
-}
-
-// processTextSegment processes a text segment (not inside HTML tags)
-func processTextSegment(text string, refPattern *regexp.Regexp) string {
-	var result strings.Builder
-
-	// Find all reference patterns and process around them
-	refMatches := refPattern.FindAllStringIndex(text, -1)
-
-	if len(refMatches) == 0 {
-		return processWords(text)
-	}
-
-	lastEnd := 0
-	for _, match := range refMatches {
-		// Process text before this reference
-		if match[0] > lastEnd {
-			result.WriteString(processWords(text[lastEnd:match[0]]))
-		}
-		// Keep the reference as-is (with styling)
-		ref := text[match[0]:match[1]]
-		result.WriteString(`<span class="reference">`)
-		result.WriteString(template.HTMLEscapeString(ref))
-		result.WriteString(`</span>`)
-		lastEnd = match[1]
-	}
-
-	// Process remaining text
-	if lastEnd < len(text) {
-		result.WriteString(processWords(text[lastEnd:]))
+		result.WriteString(processWords(content[lastEnd:]))
 	}
 
 	return result.String()
@@ -350,15 +1007,14 @@ func processWords(text string) string {
 			word := string(runes[wordStart:i])
 
 			// Clean word for URL (remove quotes, normalize)
-			cleanWord := strings.ToLower(word)
-			cleanWord = strings.Trim(cleanWord, "''\"")
+			clean := cleanWord(word)
 
-			if len(cleanWord) > 0 && containsLetter(cleanWord) {
-				// Create clickable link
-				linkURL := fmt.Sprintf("%s?tab=dpd&q=%s",
-					paliAnalysisURL, url.QueryEscape(cleanWord))
+			if len(clean) > 0 && containsLetter(clean) {
+				// Create clickable link to the default provider; the
+				// chooser script offers the others on click
+				linkURL := config.defaultProvider().LookupURL(clean)
 				fmt.Fprintf(&result, `<a href="%s" class="pali-word" target="other">%s</a>`,
-					linkURL, template.HTMLEscapeString(word))
+					template.HTMLEscapeString(linkURL), template.HTMLEscapeString(word))
 			} else {
 				result.WriteString(template.HTMLEscapeString(word))
 			}
@@ -384,40 +1040,100 @@ func containsLetter(s string) bool {
 
 const templatesHTML = `
 {{define "base"}}
+{{template "header" .}}
+        {{template "content" .}}
+{{template "footer" .}}
+{{end}}
+
+{{define "header"}}
 <!DOCTYPE html>
-<html lang="en">
+<html lang="{{lang}}" data-base="{{base}}">
 <head>
     <meta charset="UTF-8">
     <meta name="viewport" content="width=device-width, initial-scale=1.0">
-    <title>{{.Title}} - Pali Reader</title>
-    <link rel="stylesheet" href="/static/style.css">
+    <title>{{t .Title}} - Pali Reader</title>
+    {{- with .Preview}}
+    <meta name="description" content="{{.Description}}">
+    <meta property="og:type" content="article">
+    <meta property="og:site_name" content="Pali Reader">
+    <meta property="og:title" content="{{$.Title}}">
+    <meta property="og:description" content="{{.Description}}">
+    <meta property="og:url" content="{{.URL}}">
+    <link rel="canonical" href="{{.URL}}">
+    {{- end}}
+    <link rel="stylesheet" href="{{base}}{{asset "style.css"}}">{{with themeStylesheet}}<link rel="stylesheet" href="{{base}}{{.}}">{{end}}
+    {{if not staticSite}}<link rel="stylesheet" href="{{base}}/static/custom.css"><link rel="manifest" href="{{base}}/manifest.webmanifest"><meta name="theme-color" content="#8B4513">{{end}}
+    {{if not staticSite}}<link rel="alternate" type="application/atom+xml" title="{{t "Daily reading"}}" href="{{base}}/feed.xml">{{end}}
+    {{if not staticSite}}<link rel="alternate" type="application/atom+xml" title="{{t "Corpus activity"}}" href="{{base}}/activity.xml">{{end}}
 </head>
 <body>
     <header>
         <div class="header-content">
-            <a href="/" class="logo">
+            <a href="{{base}}/" class="logo">{{block "logo" .}}
                 <span class="logo-icon">☸</span>
                 <span class="logo-text">Pali Reader</span>
-            </a>
+            {{end}}</a>
             <nav class="breadcrumbs">
-                <a href="/">Home</a>
+                <a href="{{base}}/">{{t "Home"}}</a>
                 {{range $i, $bc := .Breadcrumbs}}
                 <span class="separator">›</span>
                 {{if isLastIndex $i (len $.Breadcrumbs)}}
-                <span class="current">{{$bc.Name}}</span>
+                <span class="current">{{$bc.DisplayName}}</span>
                 {{else}}
-                <a href="/read/{{$bc.Path}}">{{$bc.Name}}</a>
+                <a href="{{base}}/read/{{slug $bc.Path}}">{{$bc.DisplayName}}</a>
                 {{end}}
+                {{if gt (len $bc.Siblings) 1}}
+                <details class="crumb-menu">
+                    <summary title="{{t "Next to %s" $bc.DisplayName}}">▾</summary>
+                    <ul>
+                        {{range $bc.Siblings}}
+                        <li><a href="{{base}}/read/{{slug .Path}}"{{if eq .Path $bc.Path}} class="current"{{end}}>{{if .IsDir}}📁{{else}}📜{{end}} {{.DisplayName}}</a></li>
+                        {{end}}
+                    </ul>
+                </details>
                 {{end}}
+                {{end}}
+            </nav>
+            {{if not staticSite}}
+            <form action="{{base}}/go" method="get" class="quick-jump" role="search">
+                <input type="search" name="q" placeholder="MN 10, SN 56.11, Vin I 1" aria-label="{{t "Go to a citation"}}" title="{{t "Go to a citation such as MN 10, SN 56.11, Dhp 183 or Vin I 1"}}">
+            </form>
+            <nav class="site-nav">
+                <a href="{{base}}/search{{if .CurrentPath}}?in={{.CurrentPath}}{{end}}">{{t "Search"}}</a>
+                <a href="{{base}}/canon">{{t "Canon"}}</a>
+                <a href="{{base}}/random{{if .CurrentPath}}?in={{.CurrentPath}}{{end}}" title="{{if .CurrentPath}}{{t "Open a random text from this folder"}}{{else}}{{t "Open a random text"}}{{end}}">{{t "Random"}}</a>
+                {{if dictionaryLoaded}}<a href="{{base}}/reverse">{{t "English → Pali"}}</a>{{end}}
+                {{if askEnabled}}<a href="{{base}}/ask">{{t "Ask"}}</a>{{end}}
+                {{if not keepsNothing}}
+                <a href="{{base}}/vocab">{{t "Vocabulary"}}</a>
+                <a href="{{base}}/review">{{t "Review"}}</a>
+                <a href="{{base}}/history">{{t "History"}}</a>
+                <a href="{{base}}/stats">{{t "Stats"}}</a>
+                <a href="{{base}}/bookmarks">{{t "Bookmarks"}}</a>
+                <a href="{{base}}/tags">{{t "Tags"}}</a>
+                {{end}}
+                <a href="{{base}}/settings">{{t "Settings"}}</a>
             </nav>
+            {{end}}
         </div>
     </header>
     <main>
-        {{template "content" .}}
+{{end}}
+
+{{define "footer"}}
     </main>
-    <footer>
-        <p>Click any Pali word to view its analysis on the Digital Pali Dictionary.</a></p>
-    </footer>
+    <footer>{{block "footer-note" .}}
+        <p>{{t "Click any Pali word to look it up in a dictionary."}}
+        {{if not keepsNothing}}{{tHTML "Export the words you looked up as %[1]s or %[2]s." (link (print base "/export/flashcards") (t "Anki cards")) (link (print base "/export/flashcards?format=csv") "CSV")}}{{end}}</p>
+    {{end}}{{if not staticSite}}<nav class="languages" aria-label="{{t "Language"}}">{{range languages}}<a href="{{base}}/language?lang={{.Tag}}" lang="{{.Tag}}"{{if eq .Tag lang}} aria-current="true"{{end}}>{{.Name}}</a>{{end}}</nav><aside class="tree-sidebar" data-tree="{{base}}/api/tree" data-current="{{.CurrentPath}}" aria-label="{{t "Contents"}}" hidden></aside>{{end}}</footer>
+    <div id="lookup-chooser" class="lookup-chooser"{{if not keepsNothing}} data-record{{end}} hidden>
+        <div class="lookup-word"></div>
+        {{range lookupProviders}}
+        <a href="#" data-lookup="{{.URL}}" target="other">{{.Name}}</a>
+        {{end}}
+        {{if not keepsNothing}}<button type="button" class="save-word">☆ {{t "Save word"}}</button>{{end}}{{if not keepsNothing}}<button type="button" class="known-word">{{t "Mark known"}}</button>{{end}}
+    </div>
+    <script src="{{base}}{{asset "reader.js"}}"></script>{{if not staticSite}}<script src="{{base}}{{asset "shortcuts.js"}}" defer></script>{{end}}
 </body>
 </html>
 {{end}}
@@ -430,370 +1146,849 @@ const templatesHTML = `
 {{template "base" .}}
 {{end}}
 
+{{define "star"}}<button type="button" class="star" data-star="{{.Path}}" aria-pressed="{{.Starred}}" title="{{t "Favorite: keep this text on the home page"}}">{{if .Starred}}★{{else}}☆{{end}}</button>{{end}}
+
+{{define "tag-button"}}<button type="button" class="tag-button" data-tag="{{.Path}}" data-tags="{{join .Tags ", "}}" title="{{t "Tags, such as for class or needs proofreading"}}">🏷</button>{{end}}
+
+{{define "tag-chips"}}<div class="tag-chips" data-tags-of="{{.Path}}"{{if .Links}} data-links{{end}}>{{range .Tags}}{{if $.Links}}<a class="tag-chip" href="{{base}}/tags/{{.}}">{{.}}</a>{{else}}<span class="tag-chip">{{.}}</span>{{end}}{{end}}</div>{{end}}
+
 {{define "reader"}}
-{{template "base" .}}
+{{template "reader-start" .}}
+            {{.Content}}
+{{template "reader-end" .}}
 {{end}}
 
-{{define "content"}}
+{{define "reader-start"}}
+{{template "header" .}}
 <div class="container">
-    {{if .Content}}
     <article class="reader-content">
         <h1>{{.Title}}</h1>
-        <div class="pali-text">
-            {{.Content}}
+        {{if .Editions}}
+        <p class="editions">{{t "Other editions:"}}
+            {{range $i, $e := .Editions}}{{if $i}} · {{end}}<a href="{{$e.URL}}" target="_blank" rel="noopener">{{$e.Name}}</a>{{end}}
+        </p>
+        {{end}}
+        {{if .Layers}}
+        <p class="layers">
+            {{range $i, $l := .Layers}}{{if $i}} · {{end}}{{$l.Layer}}: <a href="{{base}}/read/{{slug $l.Path}}">{{$l.Title}}</a>{{end}}
+        </p>
+        {{end}}
+        {{if not staticSite}}
+        <nav class="text-tabs"{{with adjacentText .CurrentPath -1}} data-prev="{{base}}/read/{{slug .}}"{{end}}{{with adjacentText .CurrentPath 1}} data-next="{{base}}/read/{{slug .}}"{{end}}>
+            <a href="{{base}}/read/{{slug .CurrentPath}}" class="active">{{t "Text"}}</a>
+            <a href="{{base}}/glossary/{{slug .CurrentPath}}">{{t "Glossary"}}</a>
+            <a href="{{base}}/print/{{slug .CurrentPath}}">{{t "Print"}}</a>
+            {{if not keepsNothing}}{{template "star" (starOf .CurrentPath (index .Starred .CurrentPath))}}{{template "tag-button" (tagged .CurrentPath (index .Tags .CurrentPath) true)}}{{end}}
+        </nav>
+        {{if not keepsNothing}}{{template "tag-chips" (tagged .CurrentPath (index .Tags .CurrentPath) true)}}{{end}}
+        {{end}}
+        {{- range .Audio}}<audio class="recording" controls preload="metadata" src="{{base}}/audio/{{slug .}}" aria-label="{{t "Recording"}}"{{if $.Timed}} data-timing="{{base}}/timing/{{slug $.CurrentPath}}"{{end}}></audio>{{end}}
+        {{- if and .Audio .Timed}}<script src="{{base}}{{asset "audiosync.js"}}" defer></script>{{end}}
+        <p class="page-toggles">{{t "Show:"}}
+            <label><input type="checkbox" data-toggle="reference" checked> {{t "References"}}</label>
+            {{range overlays}}<label><input type="checkbox" data-toggle="edition-{{.Name}}" checked> {{t "%s pages" (or .Title .Name)}}</label>{{end}}
+            {{- if listenEnabled}}<button type="button" class="listen" data-listen="{{base}}/listen/{{.CurrentPath}}" title="{{t "Read aloud from the paragraph at the top of the window"}}">▶ {{t "Listen"}}</button>{{end}}
+        </p>
+        {{template "pager" .}}
+        <div class="pali-text"{{if liveReload}} data-reload="{{base}}/reload/{{.CurrentPath}}"{{end}}{{if not staticSite}} data-cite="{{base}}/cite/{{.CurrentPath}}"{{end}}>
+{{end}}
+
+{{define "reader-end"}}
         </div>
+        {{template "pager" .}}
     </article>
-    {{else}}
-    <div class="file-browser">
-        <h1>{{if .CurrentPath}}{{.Title}}{{else}}Pali Texts Library{{end}}</h1>
-        <p class="intro">Browse the collection of Pali texts. Click on any folder to explore, or select a text to read.</p>
+</div>
+{{template "footer" .}}
+{{end}}
 
-        {{if .Files}}
-        <div class="file-grid">
-            {{range .Files.Children}}
-            <a href="/read/{{.Path}}" class="file-card {{if .IsDir}}folder{{else}}file{{end}}">
-                <div class="file-icon">
-                    {{if .IsDir}}📁{{else}}📜{{end}}
-                </div>
-                <div class="file-name">{{.Name}}</div>
-            </a>
+{{define "reverse"}}
+{{template "header" .}}
+<div class="container">
+    <div class="search-page">
+        <h1>{{t "English → Pali"}}</h1>
+        <p class="intro">{{t "Search the dictionary's English glosses to find Pali words."}}</p>
+        <form action="{{base}}/reverse" method="get" class="search-form">
+            <input type="search" name="q" value="{{.Query}}" placeholder="{{t "e.g. %s" "impermanence"}}" autofocus>
+            <button type="submit">{{t "Search"}}</button>
+        </form>
+        {{if .Query}}
+        {{if .ReverseResults}}
+        <ul class="result-list">
+            {{range .ReverseResults}}
+            <li>
+                <a href="{{lookupURL .Word}}" class="pali-word" target="other">{{.Word}}</a>
+                <span class="gloss">{{.Gloss}}</span>
+                <a href="{{base}}/occurrences?word={{.Word}}" class="result-action">{{t "occurrences"}}</a>
+            </li>
             {{end}}
-        </div>
+        </ul>
+        {{else}}
+        <p class="empty">{{t "No Pali words found for “%s”." .Query}}</p>
+        {{end}}
         {{end}}
     </div>
-    {{end}}
 </div>
+{{template "footer" .}}
 {{end}}
-`
-
-const cssContent = `
-/* CSS Variables for theming */
-:root {
-    --primary-color: #8B4513;
-    --primary-light: #D2691E;
-    --primary-dark: #5D2E0C;
-    --secondary-color: #F5DEB3;
-    --background-color: #FDF5E6;
-    --text-color: #333;
-    --text-light: #666;
-    --border-color: #DEB887;
-    --card-shadow: 0 2px 8px rgba(139, 69, 19, 0.15);
-    --link-color: #8B4513;
-    --link-hover: #D2691E;
-    --font-pali: 'Noto Sans', 'Noto Serif', 'Gentium Plus', 'Gentium', Georgia, serif;
-}
-
-/* Reset and base styles */
-* {
-    margin: 0;
-    padding: 0;
-    box-sizing: border-box;
-}
-
-html {
-    scroll-behavior: smooth;
-}
-
-body {
-    font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, Oxygen, Ubuntu, sans-serif;
-    background-color: var(--background-color);
-    color: var(--text-color);
-    line-height: 1.6;
-    min-height: 100vh;
-    display: flex;
-    flex-direction: column;
-}
-
-/* Header */
-header {
-    background: linear-gradient(135deg, var(--primary-color), var(--primary-dark));
-    color: white;
-    padding: 1rem 2rem;
-    position: sticky;
-    top: 0;
-    z-index: 100;
-    box-shadow: 0 2px 10px rgba(0,0,0,0.2);
-}
-
-.header-content {
-    max-width: 1400px;
-    margin: 0 auto;
-    display: flex;
-    align-items: center;
-    justify-content: space-between;
-    flex-wrap: wrap;
-    gap: 1rem;
-}
-
-.logo {
-    display: flex;
-    align-items: center;
-    gap: 0.5rem;
-    text-decoration: none;
-    color: white;
-}
-
-.logo-icon {
-    font-size: 2rem;
-}
-
-.logo-text {
-    font-size: 1.5rem;
-    font-weight: 600;
-    letter-spacing: 0.5px;
-}
-
-/* Breadcrumbs */
-.breadcrumbs {
-    display: flex;
-    align-items: center;
-    gap: 0.5rem;
-    flex-wrap: wrap;
-    font-size: 0.9rem;
-}
-
-.breadcrumbs a {
-    color: rgba(255,255,255,0.85);
-    text-decoration: none;
-    padding: 0.25rem 0.5rem;
-    border-radius: 4px;
-    transition: all 0.2s;
-}
-
-.breadcrumbs a:hover {
-    background: rgba(255,255,255,0.15);
-    color: white;
-}
-
-.breadcrumbs .separator {
-    color: rgba(255,255,255,0.5);
-}
-
-.breadcrumbs .current {
-    color: var(--secondary-color);
-    font-weight: 500;
-}
-
-/* Main content */
-main {
-    flex: 1;
-    padding: 2rem;
-}
 
-.container {
-    max-width: 1200px;
-    margin: 0 auto;
-}
-
-/* File browser */
-.file-browser h1 {
-    color: var(--primary-dark);
-    margin-bottom: 0.5rem;
-    font-size: 2rem;
-}
+{{define "dict"}}
+{{template "header" .}}
+<div class="container">
+    <article class="reader-content dict-page">
+        <h1 class="pali-heading">{{.Query}}</h1>
+        {{if .Glosses}}
+        <ul class="local-glosses">
+            {{range .Glosses}}<li>{{.}}</li>{{end}}
+        </ul>
+        {{end}}
+        {{if .Notice}}
+        <p class="empty">{{.Notice}}</p>
+        {{end}}
+        <div class="dict-entry">
+            {{.Content}}
+        </div>
+        <p class="dict-source">
+            {{if .UpstreamURL}}{{t "Source:"}} <a href="{{.UpstreamURL}}" target="_blank" rel="noopener">{{.UpstreamURL}}</a> · {{end}}
+            <a href="{{base}}/occurrences?word={{.Query}}">{{t "occurrences in the corpus"}}</a>
+        </p>
+    </article>
+</div>
+{{template "footer" .}}
+{{end}}
 
-.intro {
-    color: var(--text-light);
-    margin-bottom: 2rem;
-    font-size: 1.1rem;
-}
+{{define "search"}}
+{{template "header" .}}
+<div class="container">
+    <div class="search-page">
+        <h1>{{t "Search"}}</h1>
+        <form action="{{base}}/search" method="get" class="search-form">
+            <input type="search" name="q" value="{{.Query}}" placeholder="{{if eq .SearchMode "semantic"}}{{t "e.g. simile of the raft"}}{{else if eq .SearchMode "regex"}}{{t "e.g. %s" "\\pL+ssa\\b"}}{{else}}{{t "e.g. %s" "yathābhūtaṃ"}}{{end}}" autofocus>
+            <select name="mode">
+                <option value="lexical"{{if eq .SearchMode "lexical"}} selected{{end}}>{{t "Exact words"}}</option>
+                <option value="regex"{{if eq .SearchMode "regex"}} selected{{end}}>{{t "Pattern"}}</option>
+                {{if semanticSearch}}<option value="semantic"{{if eq .SearchMode "semantic"}} selected{{end}}>{{t "By meaning"}}</option>{{end}}
+            </select>
+            {{if .SearchScopes}}
+            <select name="in" aria-label="{{t "Search in"}}">
+                <option value="">{{t "Everywhere"}}</option>
+                {{range .SearchScopes}}<option value="{{.Path}}"{{if eq .Path $.SearchScope}} selected{{end}}>{{t "In %s" .DisplayName}}</option>{{end}}
+            </select>
+            {{end}}
+            <button type="submit">{{t "Search"}}</button>
+        </form>
+        {{if eq .SearchMode "regex"}}
+        <details class="search-help">
+            <summary>{{t "How to search by pattern"}}</summary>
+            <p>{{tHTML "The query is a regular expression in %[1]s, matched against the plain text of every paragraph, such as %[2]s for words ending in -ssa or %[3]s ignoring case. %[4]s matches any letter, diacritics and all, where %[5]s matches only a to z. Texts with the most matches come first. A search stops after %[6]s and shows what it found by then." (link "https://github.com/google/re2/wiki/Syntax" (t "RE2 syntax")) (code "\\pL+ssa\\b") (code "(?i)evaṃ me sutaṃ") (code "\\pL") (code "\\w") patternTimeout}}</p>
+        </details>
+        {{else if ne .SearchMode "semantic"}}
+        <details class="search-help">
+            <summary>{{t "How to search"}}</summary>
+            <dl>
+                <dt>{{tHTML "%[1]s or %[2]s" (code "dukkha samudaya") (code "dukkha AND samudaya")}}</dt>
+                <dd>{{t "Texts with both words, anywhere in them."}}</dd>
+                <dt><code>"yoniso manasikāra"</code></dt>
+                <dd>{{t "Texts with the words one right after the other."}}</dd>
+                <dt><code>sīla OR samādhi</code></dt>
+                <dd>{{t "Texts with either word or phrase; OR joins the two on either side of it, and the rest of the query must still be found."}}</dd>
+                <dt>{{tHTML "%[1]s or %[2]s" (code "nibbāna -nibbānassa") (code "nibbāna NOT nibbānassa")}}</dt>
+                <dd>{{t "Texts with the first word but not the second."}}</dd>
+            </dl>
+            <p>{{t "Words are matched as written, in any case; AND, OR and NOT work in capitals only. The texts with the rarer words, found more often, come first."}}</p>
+        </details>
+        {{end}}
+        {{if .Notice}}
+        <p class="empty">{{.Notice}}</p>
+        {{end}}
+        {{if .Query}}
+        {{if .SearchResults}}
+        <ul class="result-list">
+            {{range .SearchResults}}
+            <li>
+                <a href="{{base}}/read/{{slug .Path}}{{if eq $.SearchMode "lexical"}}?hl={{$.Query}}{{end}}{{if .Anchor}}#{{.Anchor}}{{end}}">{{.Path}}</a>
+                {{if .Marked}}<span class="snippet">{{.Marked}}</span>{{else if .Snippet}}<span class="snippet">{{.Snippet}}</span>{{end}}
+                <span class="count" title="{{if eq $.SearchMode "semantic"}}{{t "Similarity"}}{{else if eq $.SearchMode "regex"}}{{t "Matches"}}{{else}}{{t "Relevance"}}{{end}}">{{if eq $.SearchMode "regex"}}{{printf "%.0f" .Score}}{{else}}{{printf "%.2f" .Score}}{{end}}</span>
+            </li>
+            {{end}}
+        </ul>
+        {{else if not .Notice}}
+        <p class="empty">{{t "Nothing found for “%s”." .Query}}</p>
+        {{end}}
+        {{end}}
+    </div>
+</div>
+{{template "footer" .}}
+{{end}}
 
-.file-grid {
-    display: grid;
-    grid-template-columns: repeat(auto-fill, minmax(200px, 1fr));
-    gap: 1.5rem;
-}
+{{define "ask"}}
+{{template "header" .}}
+<div class="container">
+    <div class="search-page">
+        <h1>{{t "Ask the texts"}}</h1>
+        <p class="intro">{{t "Answers are drawn from passages retrieved from the corpus, with citations linking to each passage."}}</p>
+        <form action="{{base}}/ask" method="get" class="search-form">
+            <input type="search" name="q" value="{{.Query}}" placeholder="{{t "e.g. What is the simile of the raft?"}}" autofocus>
+            <button type="submit">{{t "Ask"}}</button>
+        </form>
+        {{if .Notice}}
+        <p class="empty">{{.Notice}}</p>
+        {{end}}
+        {{if .Content}}
+        <div class="answer">
+            {{.Content}}
+        </div>
+        {{end}}
+        {{if .Passages}}
+        <h2 class="sources-heading">{{t "Sources"}}</h2>
+        <ol class="result-list sources">
+            {{range .Passages}}
+            <li>
+                <a href="{{base}}/read/{{slug .Path}}#{{.Anchor}}" class="result-action">[{{.Number}}] {{citation (printf "%s#%s" .Path .Anchor)}}</a>
+                <span class="snippet">{{.Text}}</span>
+            </li>
+            {{end}}
+        </ol>
+        {{end}}
+    </div>
+</div>
+{{template "footer" .}}
+{{end}}
 
-.file-card {
-    background: white;
-    border: 1px solid var(--border-color);
-    border-radius: 12px;
-    padding: 1.5rem;
-    text-decoration: none;
-    color: var(--text-color);
-    transition: all 0.3s ease;
-    display: flex;
-    flex-direction: column;
-    align-items: center;
-    text-align: center;
-    box-shadow: var(--card-shadow);
-}
+{{define "vocab"}}
+{{template "header" .}}
+<div class="container">
+    <div class="search-page">
+        <h1>{{t "Vocabulary"}}</h1>
+        {{if .VocabGroups}}
+        <p class="intro">{{tHTML "Words you saved, by the text you saved them from. %[1]s here or export them as %[2]s or %[3]s." (link (print base "/review") (t "Review them")) (link (print base "/export/flashcards?from=vocab") (t "Anki cards")) (link (print base "/export/flashcards?from=vocab&format=csv") "CSV")}}</p>
+        {{range .VocabGroups}}
+        <h2 class="vocab-heading">{{if .Path}}<a href="{{base}}/read/{{slug .Path}}">{{.Title}}</a>{{else}}{{t .Title}}{{end}}</h2>
+        <ul class="result-list">
+            {{range .Words}}
+            <li>
+                <a href="{{lookupURL .Word}}" class="pali-word" target="other">{{.Word}}</a>
+                <span class="gloss">{{.Gloss}}</span>
+                <span class="count">{{.Count}}</span>
+            </li>
+            {{end}}
+        </ul>
+        {{end}}
+        {{else}}
+        <p class="empty">{{t "No saved words yet. Click a word while reading and choose “Save word”."}}</p>
+        {{end}}
+    </div>
+</div>
+{{template "footer" .}}
+{{end}}
 
-.file-card:hover {
-    transform: translateY(-4px);
-    box-shadow: 0 8px 24px rgba(139, 69, 19, 0.2);
-    border-color: var(--primary-light);
-}
+{{define "history"}}
+{{template "header" .}}
+<div class="container">
+    <div class="search-page">
+        <h1>{{t "Lookup history"}}</h1>
+        {{if .History}}
+        <p class="intro">{{tHTML "Every word you looked up while reading, the latest first. Export them as %[1]s with the time of each lookup, or once per word as %[2]s." (link (print base "/history?format=csv") "CSV") (link (print base "/export/flashcards") (t "Anki cards"))}}</p>
+        {{if .Notice}}
+        <p class="empty">{{.Notice}}</p>
+        {{end}}
+        {{range .History}}
+        <h2 class="vocab-heading">{{.Date}}</h2>
+        <ul class="result-list">
+            {{range .Lookups}}
+            <li>
+                <span class="time">{{.Time.Format "15:04"}}</span>
+                <a href="{{lookupURL .Word}}" class="pali-word" target="other">{{.Word}}</a>
+                <span class="gloss">{{.Gloss}}</span>
+                {{if .Source}}<a href="{{base}}/read/{{slug .Source}}" class="result-action">{{citation .Source}}</a>{{end}}
+            </li>
+            {{end}}
+        </ul>
+        {{end}}
+        {{else}}
+        <p class="empty">{{t "No lookups yet. Click a word while reading to look it up."}}</p>
+        {{end}}
+    </div>
+</div>
+{{template "footer" .}}
+{{end}}
 
-.file-card.folder:hover {
-    background: linear-gradient(135deg, #FFF8DC, white);
-}
+{{define "stats"}}
+{{template "header" .}}
+<div class="container">
+    <div class="search-page">
+        <h1>{{t "Reading statistics"}}</h1>
+        {{with .ReadingStats}}
+        <dl class="stats-summary">
+            <div><dt>{{t "Texts read"}}</dt><dd>{{.Texts}}</dd></div>
+            <div><dt>{{t "Words read"}}</dt><dd>{{.Words}}</dd></div>
+            <div><dt>{{t "Time reading"}}</dt><dd>{{.Time}}</dd></div>
+            <div><dt>{{t "Words looked up"}}</dt><dd>{{.UniqueLookups}}</dd></div>
+            <div><dt>{{t "Longest streak"}}</dt><dd>{{if eq .LongestStreak 1}}{{t "%d day" .LongestStreak}}{{else}}{{t "%d days" .LongestStreak}}{{end}}</dd></div>
+            <div><dt>{{t "Current streak"}}</dt><dd>{{if eq .CurrentStreak 1}}{{t "%d day" .CurrentStreak}}{{else}}{{t "%d days" .CurrentStreak}}{{end}}</dd></div>
+        </dl>
+        <p class="intro">{{tHTML "Time is counted while a text is open and you are reading it; a page's words count as read once it has been open half a minute on a day. Every lookup (%[1]d in all) is listed in the %[2]s. A streak is the days in a row you read or looked up a word." .Lookups (link (print base "/history") (t "history"))}}</p>
+        <h2 class="vocab-heading">{{t "The last 30 days"}}</h2>
+        <table class="glossary stats-days">
+            <thead><tr><th>{{t "Day"}}</th><th>{{t "Time"}}</th><th></th><th>{{t "Words"}}</th><th>{{t "Lookups"}}</th></tr></thead>
+            <tbody>
+            {{range .Days}}
+            <tr>
+                <td>{{.Date}}</td>
+                <td class="count">{{.Time}}</td>
+                <td class="stats-chart"><span class="stats-bar" style="width: {{.Percent}}%"></span></td>
+                <td class="count">{{.Words}}</td>
+                <td class="count">{{.Lookups}}</td>
+            </tr>
+            {{end}}
+            </tbody>
+        </table>
+        {{if .TopTexts}}
+        <h2 class="vocab-heading">{{t "Read longest"}}</h2>
+        <ul class="result-list">
+            {{range .TopTexts}}
+            <li>
+                <a href="{{base}}/read/{{slug .Path}}">{{.Title}}</a>
+                <span class="gloss">{{t "%d words" .Words}}</span>
+                <span class="count">{{.Time}}</span>
+            </li>
+            {{end}}
+        </ul>
+        {{end}}
+        {{end}}
+    </div>
+</div>
+{{template "footer" .}}
+{{end}}
 
-.file-card.file:hover {
-    background: linear-gradient(135deg, #F5F5DC, white);
-}
+{{define "canon"}}
+{{template "header" .}}
+<div class="container">
+    <div class="search-page">
+        {{if .Canon.Trail}}<p class="admin-path">{{range $i, $e := .Canon.Trail}}{{if $i}} › {{end}}<a href="{{base}}{{$e.URL}}">{{$e.Name}}</a>{{end}}</p>{{end}}
+        <h1>{{.Title}}</h1>
+        <form action="{{base}}/canon" method="get" class="search-form">
+            <input type="text" name="go" placeholder="{{t "Go to, such as MN 10 or Dhp"}}" aria-label="{{t "Citation"}}">
+            <button type="submit">{{t "Go"}}</button>
+        </form>
+        {{if .Notice}}
+        <p class="empty">{{.Notice}}</p>
+        {{end}}
+        {{template "canon-list" .Canon.Entries}}
+    </div>
+</div>
+{{template "footer" .}}
+{{end}}
 
-.file-icon {
-    font-size: 3rem;
-    margin-bottom: 0.75rem;
-}
+{{define "canon-list"}}
+<ul class="canon-list">
+    {{range .}}
+    <li>
+        {{if .URL}}<a href="{{base}}{{.URL}}">{{.Name}}</a>{{else}}<span class="missing" title="{{t "Not in the corpus"}}">{{.Name}}</span>{{end}}
+        {{if .ID}}<span class="canon-id">{{.ID}}</span>{{end}}
+        {{range .Volumes}}<a href="{{base}}{{.URL}}" class="canon-volume" title="{{t "Volume %s" .Name}}">{{.Name}}</a>{{end}}
+        {{if .Children}}{{template "canon-list" .Children}}{{end}}
+    </li>
+    {{end}}
+</ul>
+{{end}}
 
-.file-name {
-    font-weight: 500;
-    word-break: break-word;
-    font-size: 0.95rem;
-}
+{{define "bookmarks"}}
+{{template "header" .}}
+<div class="container">
+    <div class="search-page">
+        <h1>{{t "Bookmarks"}}</h1>
+        {{if .Bookmarks}}
+        <ul class="result-list">
+            {{range .Bookmarks}}
+            <li>
+                <a href="{{base}}/read/{{slug .Path}}{{if .Anchor}}#{{.Anchor}}{{end}}">{{if .Title}}{{.Title}}{{else}}{{humanizePath .Path}}{{end}}</a>
+                <span class="gloss">{{humanizePath .Path}}</span>
+            </li>
+            {{end}}
+        </ul>
+        {{else}}
+        <p class="empty">{{tHTML "No bookmarks yet. Import them from the Digital Pali Reader or your browser with %s." (code "palireader import -bookmarks")}}</p>
+        {{end}}
+    </div>
+</div>
+{{template "footer" .}}
+{{end}}
 
-/* Reader content */
-.reader-content {
-    background: white;
-    border-radius: 16px;
-    padding: 3rem;
-    box-shadow: var(--card-shadow);
-    border: 1px solid var(--border-color);
-}
+{{define "tags"}}
+{{template "header" .}}
+<div class="container">
+    <div class="search-page">
+        <h1>{{if .TagPage.Tag}}{{t "Tagged %s" .TagPage.Tag}}{{else}}{{t "Tags"}}{{end}}</h1>
+        {{if .TagPage.Counts}}
+        <p class="tag-chips">{{range .TagPage.Counts}}<a class="tag-chip{{if eq .Tag $.TagPage.Tag}} current{{end}}" href="{{base}}/tags/{{.Tag}}">{{.Tag}} <span class="views">{{.Count}}</span></a>{{end}}</p>
+        {{else}}
+        <p class="empty">{{t "No tags yet. Tag a text with the 🏷 button on its card or above it."}}</p>
+        {{end}}
+        {{if .TagPage.Texts}}
+        <ul class="result-list">
+            {{range .TagPage.Texts}}
+            <li>
+                <a href="{{base}}/read/{{slug .Path}}">{{.Title}}</a>
+                <span class="gloss">{{humanizePath .Path}}</span>
+                <span class="tag-chips">{{range .Tags}}<a class="tag-chip" href="{{base}}/tags/{{.}}">{{.}}</a>{{end}}</span>
+            </li>
+            {{end}}
+        </ul>
+        {{end}}
+    </div>
+</div>
+{{template "footer" .}}
+{{end}}
 
-.reader-content h1 {
-    color: var(--primary-dark);
-    margin-bottom: 2rem;
-    padding-bottom: 1rem;
-    border-bottom: 2px solid var(--secondary-color);
-    font-size: 2rem;
-}
+{{define "settings"}}
+{{template "header" .}}
+<div class="container">
+    <div class="search-page">
+        <h1>{{t "Settings"}}</h1>
+        {{if .Notice}}
+        <p class="empty">{{.Notice}}</p>
+        {{end}}
+        <form action="{{base}}/settings" method="post" class="settings-form">
+            <label for="css">{{t "Custom CSS"}}</label>
+            <p class="intro">{{tHTML "Added to every page for your own tweaks, like %s. Imports and scripting are removed." (code ".reader-content { font-family: Georgia; line-height: 2; }")}}</p>
+            <textarea id="css" name="css" rows="12" spellcheck="false">{{.Settings.CSS}}</textarea>
+            <label for="anusvara">{{t "Niggahīta"}}</label>
+            <p class="intro">{{t "Shows every text with the niggahīta written one way, whichever its transcription uses. Dictionary links keep the text's own spelling."}}</p>
+            <select id="anusvara" name="anusvara">
+                <option value="">{{t "As in the text"}}</option>
+                <option value="ṃ"{{if eq .Settings.Anusvara "ṃ"}} selected{{end}}>ṃ (PTS)</option>
+                <option value="ṁ"{{if eq .Settings.Anusvara "ṁ"}} selected{{end}}>ṁ (Chaṭṭha Saṅgāyana)</option>
+                <option value="ŋ"{{if eq .Settings.Anusvara "ŋ"}} selected{{end}}>{{t "ŋ (older Sinhalese and Thai editions)"}}</option>
+            </select>
+            <label><input type="checkbox" name="classNasals" value="1"{{if .Settings.ClassNasals}} checked{{end}}> {{t "Before a stop, write the nasal of its class (saṅgha for saṃgha)"}}</label>
+            <label for="known">{{t "Known words"}}</label>
+            <p class="intro">{{t "Texts show the words you know as plain text and the others as links, so what is left to learn stands out. Paste a word list, one word per line or separated by spaces or commas, or mark words one by one by clicking them while reading."}}</p>
+            <textarea id="known" name="known" rows="8" spellcheck="false">{{join .Settings.Known "\n"}}</textarea>
+            <label for="knownTop">{{t "Most frequent words known"}}</label>
+            <p class="intro">{{t "Also counts this many of the corpus's most frequent word forms as known, such as 500 for a beginner or 5000 for a seasoned reader. 0 counts none."}}</p>
+            <input type="number" id="knownTop" name="knownTop" min="0" step="100" value="{{.Settings.KnownTop}}">
+            <button type="submit">{{t "Save"}}</button>
+        </form>
+        {{if not keepsNothing}}
+        <h2>{{t "Your data"}}</h2>
+        <p class="intro">{{tHTML "Download your bookmarks, favorites, tags, saved words, lookups, review cards, reading log, settings, notes and highlights as a %[1]s or as %[2]s, and import the archive here or on another Pali Reader to carry on there. Importing adds what is not there yet and keeps the rest." (link (print base "/account/export") (t "ZIP archive")) (link (print base "/account/export?format=json") "JSON")}}</p>
+        <form action="{{base}}/account/import" method="post" enctype="multipart/form-data" class="settings-form">
+            <label for="archive">{{t "Archive"}}</label>
+            <input type="file" id="archive" name="archive" accept=".zip,.json,application/zip,application/json" required>
+            <button type="submit">{{t "Import"}}</button>
+        </form>
+        {{end}}
+        {{if logins}}
+        <h2>{{t "API tokens"}}</h2>
+        <p class="intro">{{tHTML "%s for a script or app to read and save your bookmarks, words and progress in your name, without your password." (link (print base "/tokens") (t "Make a token"))}}</p>
+        {{end}}
+    </div>
+</div>
+{{template "footer" .}}
+{{end}}
 
-.pali-text {
-    font-family: var(--font-pali);
-    font-size: 1.2rem;
-    line-height: 2;
-    color: var(--text-color);
-}
+{{define "print"}}
+<!DOCTYPE html>
+<html lang="pi">
+<head>
+    <meta charset="UTF-8">
+    <title>{{.Title}} - Pali Reader</title>
+    <link rel="stylesheet" href="{{base}}{{asset "print.css"}}">
+</head>
+<body>
+    {{with .Print}}
+    <form class="print-options" method="get">
+        <label><input type="checkbox" name="refs" value="on"{{if .References}} checked{{end}}> {{t "PTS pages"}}</label>
+        <label><input type="checkbox" name="breaks" value="on"{{if .Breaks}} checked{{end}}> {{t "A new page for each section"}}</label>
+        {{/* After the boxes, so that a ticked box is the value read first */}}<input type="hidden" name="refs" value="off"><input type="hidden" name="breaks" value="off">
+        <button type="submit">{{t "Apply"}}</button>
+        <a href="{{base}}/read/{{slug $.CurrentPath}}">{{t "Back to the text"}}</a>
+    </form>
+    {{end}}
+    <section class="title-page">
+        <h1>{{.Title}}</h1>
+        <p class="collection">{{humanizePath .CurrentPath}}</p>
+        {{if .Editions}}<p>{{t "Also in:"}} {{range $i, $e := .Editions}}{{if $i}} · {{end}}{{$e.Name}}{{end}}</p>{{end}}
+        <p class="colophon">{{t "From the GRETIL edition via Pali Reader, %s" (print base "/read/" (slug .CurrentPath))}}</p>
+    </section>
+    {{.Content}}
+</body>
+</html>
+{{end}}
 
-.pali-text br + br {
-    display: block;
-    content: "";
-    margin-top: 1em;
-}
+{{define "tokens"}}
+{{template "header" .}}
+<div class="container">
+    <div class="search-page">
+        <h1>{{t "API tokens"}}</h1>
+        <p class="intro">{{tHTML "Scripts and apps send a token as %s to call the reader as you, with only the scopes you give it." (code "Authorization: Bearer")}}</p>
+        {{if .Notice}}
+        <p class="empty">{{.Notice}}</p>
+        {{end}}
+        {{with .Tokens.New}}
+        <p class="intro">{{t "Copy the token now; it is not shown again."}}</p>
+        <input type="text" value="{{.}}" readonly onclick="this.select()">
+        {{end}}
+        {{if .Tokens.Tokens}}
+        <ul class="result-list admin-list">
+            {{range .Tokens.Tokens}}
+            <li>
+                <span>{{.Name}}</span>
+                <span class="gloss">{{join .Scopes ", "}} · {{t "made %s" (.Created.Format "2 Jan 2006")}}{{if not .Expires.IsZero}}, {{t "until %s" (.Expires.Format "2 Jan 2006")}}{{end}}{{if not .Used.IsZero}}, {{t "last used %s" (.Used.Format "2 Jan 2006")}}{{end}}</span>
+                <form method="post" class="admin-inline">
+                    <input type="hidden" name="action" value="revoke">
+                    <input type="hidden" name="id" value="{{.ID}}">
+                    <button type="submit">{{t "Revoke"}}</button>
+                </form>
+            </li>
+            {{end}}
+        </ul>
+        {{end}}
+        <form method="post" class="settings-form">
+            <label for="name">{{t "Name"}}</label>
+            <input type="text" id="name" name="name" placeholder="{{t "Phone"}}" maxlength="64" required>
+            {{range .Tokens.Scopes}}
+            <label for="scope-{{.Name}}">{{t .Label}}</label>
+            <select id="scope-{{.Name}}" name="scope-{{.Name}}">
+                <option value="">{{t "No access"}}</option>
+                <option value="read">{{t "Read"}}</option>
+                {{if ne .Name "texts"}}<option value="write">{{t "Read and change"}}</option>{{end}}
+            </select>
+            {{end}}
+            <label for="days">{{t "Expires"}}</label>
+            <select id="days" name="days">
+                <option value="30">{{t "In 30 days"}}</option>
+                <option value="90">{{t "In 90 days"}}</option>
+                <option value="365">{{t "In a year"}}</option>
+                <option value="0">{{t "Never"}}</option>
+            </select>
+            <button type="submit">{{t "Make token"}}</button>
+        </form>
+    </div>
+</div>
+{{template "footer" .}}
+{{end}}
 
-/* Pali word links */
-.pali-word {
-    color: var(--link-color);
-    text-decoration: none;
-    border-bottom: 1px dotted var(--border-color);
-    padding: 0 2px;
-    border-radius: 2px;
-    transition: all 0.2s ease;
-}
+{{define "login"}}
+{{template "header" .}}
+<div class="container">
+    <div class="search-page">
+        <h1>{{t "Log in"}}</h1>
+        {{if .Notice}}
+        <p class="empty">{{.Notice}}</p>
+        {{end}}
+        <form action="{{base}}/login" method="post" class="login-form">
+            <input type="hidden" name="next" value="{{.Next}}">
+            <input type="text" name="user" placeholder="{{t "User name"}}" autocomplete="username" autofocus required>
+            <input type="password" name="password" placeholder="{{t "Password"}}" autocomplete="current-password" required>
+            <button type="submit">{{t "Log in"}}</button>
+        </form>
+        {{with oidcName}}
+        <p class="intro"><a href="{{base}}/login/oidc?next={{$.Next}}">{{t "Log in with %s" .}}</a></p>
+        {{end}}
+    </div>
+</div>
+{{template "footer" .}}
+{{end}}
 
-.pali-word:hover {
-    background-color: var(--secondary-color);
-    color: var(--primary-dark);
-    border-bottom-color: var(--primary-color);
-}
+{{define "invite"}}
+{{template "header" .}}
+<div class="container">
+    <div class="search-page">
+        <h1>{{t "Sign up"}}</h1>
+        <p class="intro">{{if .Invite.Path}}{{t "You are invited by %[1]s to join as %[2]s of %[3]s." .Invite.Creator (t .Invite.Role) (humanizePath .Invite.Path)}}{{else}}{{t "You are invited by %[1]s to join as %[2]s." .Invite.Creator (t .Invite.Role)}}{{end}}</p>
+        {{if .Notice}}
+        <p class="empty">{{.Notice}}</p>
+        {{end}}
+        <form method="post" class="login-form">
+            <input type="text" name="user" placeholder="{{t "User name"}}" autocomplete="username" autofocus required>
+            <input type="password" name="password" placeholder="{{t "Password"}}" autocomplete="new-password" required>
+            <input type="password" name="confirm" placeholder="{{t "Password again"}}" autocomplete="new-password" required>
+            <button type="submit">{{t "Sign up"}}</button>
+        </form>
+    </div>
+</div>
+{{template "footer" .}}
+{{end}}
 
-/* Reference markers */
-.reference {
-    display: inline-block;
-    background: #E8E0D5;
-    color: var(--text-light);
-    font-size: 0.75rem;
-    padding: 0.15rem 0.4rem;
-    border-radius: 4px;
-    margin: 0 0.25rem;
-    font-family: monospace;
-    vertical-align: middle;
-}
+{{define "admin"}}
+{{template "header" .}}
+<div class="container">
+    <div class="search-page">
+        <h1>{{t "Manage texts"}}</h1>
+        <p class="admin-path"><a href="{{base}}/admin/">{{t "Corpus"}}</a>{{range .Admin.Crumbs}} › <a href="{{base}}/admin/{{.Path}}">{{humanizePath .Name}}</a>{{end}}</p>
+        {{if .Notice}}
+        <p class="empty">{{.Notice}}</p>
+        {{end}}
+        {{if .Admin.Entries}}
+        <ul class="result-list admin-list">
+            {{range .Admin.Entries}}
+            <li>
+                {{if .IsDir}}<a href="{{base}}/admin/{{.Path}}">📁 {{.Name}}</a>{{else}}<a href="{{base}}/read/{{slug .Path}}">📜 {{.Name}}</a>{{end}}
+                <span class="gloss">{{if not .IsDir}}{{t "%d bytes" .Size}}{{end}}</span>
+                <form method="post" class="admin-inline">
+                    <input type="hidden" name="action" value="rename">
+                    <input type="hidden" name="entry" value="{{.Name}}">
+                    <input type="text" name="to" value="{{.Name}}" aria-label="{{t "New name"}}" required>
+                    <button type="submit">{{t "Rename"}}</button>
+                </form>
+                <form method="post" class="admin-inline" data-confirm="{{t "Delete %s?" .Name}}">
+                    <input type="hidden" name="action" value="delete">
+                    <input type="hidden" name="entry" value="{{.Name}}">
+                    <button type="submit">{{t "Delete"}}</button>
+                </form>
+            </li>
+            {{end}}
+        </ul>
+        {{else}}
+        <p class="empty">{{t "This folder is empty."}}</p>
+        {{end}}
+        <form method="post" enctype="multipart/form-data" class="settings-form">
+            <label for="files">{{t "Upload texts"}}</label>
+            <p class="intro">{{tHTML "UTF-8 HTML files ending in %s. Texts with scripts or with end tags that close nothing are refused." (code ".htm")}}</p>
+            <input type="hidden" name="action" value="upload">
+            <input type="file" id="files" name="files" accept=".htm" multiple required>
+            <label><input type="checkbox" name="replace" value="1"> {{t "Replace texts of the same name"}}</label>
+            <button type="submit">{{t "Upload"}}</button>
+        </form>
+        <form method="post" class="settings-form">
+            <label for="folder">{{t "New folder"}}</label>
+            <input type="hidden" name="action" value="mkdir">
+            <input type="text" id="folder" name="name" required>
+            <button type="submit">{{t "Create"}}</button>
+        </form>
+        {{if .Admin.IsAdmin}}
+        <form method="post" class="settings-form">
+            <label for="note">{{t "Publish"}}</label>
+            <p class="intro">{{tHTML "Announces this folder as published on the %s page and feed, with an optional note." (link (print base "/activity") (t "activity"))}}</p>
+            <input type="hidden" name="action" value="publish">
+            <input type="text" id="note" name="note" placeholder="{{t "Proofread against the Chaṭṭha Saṅgāyana"}}">
+            <button type="submit">{{t "Publish"}}</button>
+        </form>
+        <form method="post" class="settings-form">
+            <label for="role">{{t "Invite"}}</label>
+            <p class="intro">{{t "Makes a signup link for a group, such as a class, whose accounts get this role over this folder."}}</p>
+            <input type="hidden" name="action" value="invite">
+            <select id="role" name="role">
+                <option value="reader">{{t "Reader"}}</option>
+                <option value="editor">{{t "Editor"}}</option>
+                <option value="admin">{{t "Admin"}}</option>
+            </select>
+            <label for="seats">{{t "People"}}</label>
+            <input type="number" id="seats" name="seats" value="30" min="1" required>
+            <label for="days">{{t "Valid for days"}}</label>
+            <input type="number" id="days" name="days" value="14" min="1" max="90" required>
+            <button type="submit">{{t "Make link"}}</button>
+        </form>
+        {{if .Admin.Invites}}
+        <ul class="result-list admin-list">
+            {{range .Admin.Invites}}
+            <li>
+                <a href="{{base}}/invite/{{.Token}}">{{t "%s invitation" (t .Role)}}</a>
+                <span class="gloss">{{t "%[1]d left until %[2]s, from %[3]s" .Seats (.Expires.Format "2 Jan 2006") .Creator}}</span>
+                <form method="post" class="admin-inline">
+                    <input type="hidden" name="action" value="revoke">
+                    <input type="hidden" name="token" value="{{.Token}}">
+                    <button type="submit">{{t "Revoke"}}</button>
+                </form>
+            </li>
+            {{end}}
+        </ul>
+        {{end}}
+        {{end}}
+        {{if .Admin.Jobs}}
+        <form method="post" class="settings-form">
+            <label>{{t "Re-index"}}</label>
+            <p class="intro">{{t "Reads the whole corpus afresh and rebuilds the word index, as after texts were changed outside the reader. Searches use the old index until the new one is ready."}}</p>
+            <input type="hidden" name="action" value="reindex">
+            <button type="submit">{{t "Re-index"}}</button>
+        </form>
+        {{end}}
+    </div>
+</div>
+{{template "footer" .}}
+{{end}}
 
-/* Horizontal rules */
-.pali-text hr {
-    border: none;
-    height: 1px;
-    background: linear-gradient(to right, transparent, var(--border-color), transparent);
-    margin: 2rem 0;
-}
+{{define "activity"}}
+{{template "header" .}}
+<div class="container">
+    <div class="search-page">
+        <h1>{{t "Activity"}}</h1>
+        <p class="intro">{{tHTML "The latest texts added, edited and deleted, and the collections published. Follow them in a feed reader with the %s." (link (print base "/activity.xml") (t "Atom feed"))}}</p>
+        {{if .Events}}
+        <ul class="result-list">
+            {{range .Events}}
+            <li>
+                <span class="count">{{t .Verb}}</span>
+                {{if eq .Kind "deleted"}}<span>{{humanizePath .Path}}</span>{{else}}<a href="{{base}}/read/{{slug .Path}}">{{humanizePath .Path}}</a>{{end}}
+                <span class="gloss">{{if .Note}}{{.Note}} · {{end}}{{if .User}}{{.User}}, {{end}}{{.Time.Local.Format "2 Jan 2006 15:04"}}</span>
+            </li>
+            {{end}}
+        </ul>
+        {{else}}
+        <p class="empty">{{t "Nothing has changed yet. Changes are noted while the server runs."}}</p>
+        {{end}}
+    </div>
+</div>
+{{template "footer" .}}
+{{end}}
 
-/* Tables in content */
-.pali-text table {
-    margin: 1.5rem 0;
-    border-collapse: collapse;
-    font-size: 0.9rem;
-}
+{{define "review"}}
+{{template "header" .}}
+<div class="container">
+    <div class="search-page">
+        <h1>{{t "Review"}}</h1>
+        {{with .Review}}
+        {{if .Card}}
+        <p class="intro">{{if eq .Due 1}}{{t "%d card due." .Due}}{{else}}{{t "%d cards due." .Due}}{{end}}</p>
+        <div class="review-card">
+            {{if eq .Card.Direction "gloss"}}
+            <p class="review-prompt">{{t "Which Pali word means…"}}</p>
+            <p class="review-front">{{join .Glosses "; "}}</p>
+            {{else}}
+            <p class="review-prompt">{{t "What does this mean?"}}</p>
+            <p class="review-front pali-text">{{.Card.Word}}</p>
+            {{end}}
+            {{if .ShowAnswer}}
+            <div class="review-back">
+                {{if eq .Card.Direction "gloss"}}
+                <a href="{{lookupURL .Card.Word}}" class="pali-word" target="other">{{.Card.Word}}</a>
+                {{else if .Glosses}}
+                <ul class="glosses">
+                    {{range .Glosses}}<li>{{.}}</li>{{end}}
+                </ul>
+                {{else}}
+                <a href="{{lookupURL .Card.Word}}" target="other">{{t "Look up “%s”" .Card.Word}}</a>
+                {{end}}
+            </div>
+            <form action="{{base}}/review" method="post" class="review-grades">
+                <input type="hidden" name="card" value="{{.Card.ID}}">
+                <button type="submit" name="grade" value="1">{{t "Again"}}</button>
+                <button type="submit" name="grade" value="3">{{t "Hard"}}</button>
+                <button type="submit" name="grade" value="4">{{t "Good"}}</button>
+                <button type="submit" name="grade" value="5">{{t "Easy"}}</button>
+            </form>
+            {{else}}
+            <a href="{{base}}/review?card={{.Card.ID}}&amp;answer" class="review-show">{{t "Show answer"}}</a>
+            {{end}}
+        </div>
+        {{else if $.Notice}}
+        <p class="empty">{{$.Notice}}</p>
+        {{else}}
+        <p class="empty">{{t "All caught up. The next card is due %s." (.Next.Local.Format "Mon 2 Jan 15:04")}}</p>
+        {{end}}
+        {{end}}
+    </div>
+</div>
+{{template "footer" .}}
+{{end}}
 
-.pali-text td {
-    padding: 0.5rem 1rem;
-    border: 1px solid var(--border-color);
-}
+{{define "glossary"}}
+{{template "header" .}}
+<div class="container">
+    <article class="reader-content">
+        <h1>{{.Title}}</h1>
+        <nav class="text-tabs">
+            <a href="{{base}}/read/{{slug .CurrentPath}}">{{t "Text"}}</a>
+            <a href="{{base}}/glossary/{{slug .CurrentPath}}" class="active">{{t "Glossary"}}</a>
+            <a href="{{base}}/print/{{slug .CurrentPath}}">{{t "Print"}}</a>
+        </nav>
+        <p class="glossary-options">
+            {{t "%d distinct words, sorted" (len .Glossary)}}
+            {{if eq .Sort "alpha"}}<a href="?sort=frequency">{{t "by frequency"}}</a> · <strong>{{t "alphabetically"}}</strong>{{else}}<strong>{{t "by frequency"}}</strong> · <a href="?sort=alpha">{{t "alphabetically"}}</a>{{end}}
+            · <a href="?sort={{.Sort}}&amp;format=csv">{{t "Download CSV"}}</a>
+        </p>
+        <table class="glossary">
+            <thead>
+                <tr><th>{{t "Word"}}</th><th>{{t "Gloss"}}</th><th>{{t "Count"}}</th></tr>
+            </thead>
+            <tbody>
+                {{range .Glossary}}
+                <tr>
+                    <td><a href="{{lookupURL .Word}}" class="pali-word" target="other">{{.Word}}</a></td>
+                    <td>{{.Gloss}}</td>
+                    <td class="count">{{.Count}}</td>
+                </tr>
+                {{end}}
+            </tbody>
+        </table>
+    </article>
+</div>
+{{template "footer" .}}
+{{end}}
 
-/* Footer */
-footer {
-    background: var(--primary-dark);
-    color: rgba(255,255,255,0.8);
-    text-align: center;
-    padding: 1.5rem 2rem;
-    margin-top: auto;
-}
+{{define "occurrences"}}
+{{template "header" .}}
+<div class="container">
+    <div class="search-page">
+        <h1>{{tHTML "Occurrences of %s" (pali .Query)}}</h1>
+        {{if .Notice}}
+        <p class="empty">{{.Notice}}</p>
+        {{else if .Occurrences}}
+        <p class="intro">{{t "Word forms beginning with “%s” and the texts they appear in." .Query}}</p>
+        <ul class="result-list">
+            {{range .Occurrences}}
+            <li>
+                <a href="{{base}}/read/{{slug .Path}}">{{.Path}}</a>
+                <span class="count">{{.Count}}</span>
+                <span class="forms">{{join .Forms ", "}}</span>
+            </li>
+            {{end}}
+        </ul>
+        {{else}}
+        <p class="empty">{{t "“%s” does not occur in the corpus." .Query}}</p>
+        {{end}}
+    </div>
+</div>
+{{template "footer" .}}
+{{end}}
 
-footer a {
-    color: var(--secondary-color);
-    text-decoration: none;
-}
+{{define "pager"}}
+{{with .Pager}}
+<nav class="pager" aria-label="{{t "Page %d of %d" .Page .Count}}" data-page="{{.Page}}" data-starts="{{.StartList}}">
+    {{if .Prev}}<a href="?page={{.Prev}}" rel="prev">‹ {{t "Previous"}}</a>{{end}}
+    {{$page := .Page}}{{range .Pages}}{{if eq . $page}}<span class="current">{{.}}</span>{{else}}<a href="?page={{.}}">{{.}}</a>{{end}} {{end}}
+    {{if .Next}}<a href="?page={{.Next}}" rel="next">{{t "Next"}} ›</a>{{end}}
+</nav>
+{{end}}
+{{end}}
 
-footer a:hover {
-    text-decoration: underline;
-}
+{{define "content"}}
+<div class="container">
+    <div class="file-browser">
+        <h1>{{if .CurrentPath}}{{.Title}}{{else}}{{t "Pali Texts Library"}}{{end}}</h1>
+        {{if and .Files .Files.Description}}
+        <p class="intro">{{.Files.Description}}</p>
+        {{else}}
+        <p class="intro">{{t "Browse the collection of Pali texts. Click on any folder to explore, or select a text to read."}}</p>
+        {{end}}{{if and .CurrentPath (not staticSite)}}<p class="offline"><button type="button" data-offline="{{base}}/offline/{{slug .CurrentPath}}" hidden>{{t "Save for offline reading"}}</button></p>{{end}}{{if and .Files (not staticSite) (gt (len .Files.Children) treeFilterMin)}}<p class="tree-filter"><input type="search" data-tree="{{base}}/tree/{{slug .CurrentPath}}" placeholder="{{t "Filter by title, e.g. mahasati"}}" aria-label="{{t "Filter this folder"}}" hidden></p>{{end}}{{if .Favorites}}<section class="recent favorites"><h2>{{t "Favorites"}}</h2><ol>{{range .Favorites}}<li><a href="{{base}}/read/{{slug .Path}}">★ {{.Title}}</a></li>{{end}}</ol></section>{{end}}{{if .Recent}}<section class="recent"><h2>{{t "Recent"}}</h2><ol>{{range .Recent}}<li><a href="{{base}}/read/{{slug .Path}}{{if .Page}}?page={{.Page}}{{end}}">{{.Title}}</a> <time datetime="{{.Time.Format "2006-01-02T15:04:05Z07:00"}}">{{.Time.Local.Format "2 Jan 15:04"}}</time></li>{{end}}</ol></section>{{end}}
 
-/* Responsive adjustments */
-@media (max-width: 768px) {
-    header {
-        padding: 1rem;
-    }
-
-    .header-content {
-        flex-direction: column;
-        align-items: flex-start;
-    }
-
-    main {
-        padding: 1rem;
-    }
-
-    .reader-content {
-        padding: 1.5rem;
-    }
-
-    .pali-text {
-        font-size: 1.1rem;
-        line-height: 1.8;
-    }
-
-    .file-grid {
-        grid-template-columns: repeat(auto-fill, minmax(150px, 1fr));
-        gap: 1rem;
-    }
-
-    .file-card {
-        padding: 1rem;
-    }
-
-    .file-icon {
-        font-size: 2.5rem;
-    }
-}
+        {{if .Files}}
+        <div class="file-grid">
+            {{range .Files.Children}}
+            {{if and (not keepsNothing) (not .IsDir)}}<div class="starrable">{{end}}<a href="{{base}}/read/{{slug .Path}}" class="file-card {{if .IsDir}}folder{{else}}file{{end}}">
+                <div class="file-icon">
+                    {{if .IsDir}}📁{{else}}📜{{end}}
+                </div>
+                <div class="file-name">{{.DisplayName}}</div>
+                {{if .ID}}<div class="file-id">{{.ID}}</div>{{end}}
+                {{if .Description}}<div class="file-description">{{.Description}}</div>{{end}}{{if and (not keepsNothing) (not .IsDir)}}{{template "tag-chips" (tagged .Path (index $.Tags .Path) false)}}{{end}}{{if .Audio}}<div class="file-audio">🎧 {{t "With a recording"}}</div>{{end}}
+            </a>{{if and (not keepsNothing) (not .IsDir)}}{{template "star" (starOf .Path (index $.Starred .Path))}}{{template "tag-button" (tagged .Path (index $.Tags .Path) false)}}</div>{{end}}
+            {{end}}
+        </div>
+        {{end}}
 
-/* Print styles */
-@media print {
-    header, footer {
-        display: none;
-    }
-
-    .reader-content {
-        box-shadow: none;
-        border: none;
-        padding: 0;
-    }
-
-    .pali-word {
-        color: black;
-        border-bottom: none;
-    }
-}
+        {{if .MostRead}}
+        <section class="most-read">
+            <h2>{{t "Most read"}}</h2>
+            <ol>
+                {{range .MostRead}}
+                <li><a href="{{base}}/read/{{slug .Path}}">{{.Title}}</a> <span class="views">{{.Views}}</span></li>
+                {{end}}
+            </ol>
+        </section>
+        {{end}}
+    </div>
+</div>
+{{end}}
 `
@@ -0,0 +1,492 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net"
+	"os"
+	"slices"
+	"strings"
+)
+
+// mcpProtocolVersion is the Model Context Protocol revision the tool server
+// speaks when the client does not ask for another one
+const mcpProtocolVersion = "2024-11-05"
+
+// rpcRequest is a JSON-RPC 2.0 request or, without an ID, a notification
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// JSON-RPC error codes
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+)
+
+// mcpTool describes one tool offered to clients
+type mcpTool struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	InputSchema map[string]any `json:"inputSchema"`
+
+	call func(ctx context.Context, args json.RawMessage) (any, error)
+}
+
+// mcpContent is a block of a tool result
+type mcpContent struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type mcpToolResult struct {
+	Content []mcpContent `json:"content"`
+	IsError bool         `json:"isError,omitempty"`
+}
+
+// objectSchema builds the JSON schema of a tool's arguments
+func objectSchema(properties map[string]any, required ...string) map[string]any {
+	schema := map[string]any{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+func stringProperty(description string) map[string]any {
+	return map[string]any{"type": "string", "description": description}
+}
+
+var mcpTools = []*mcpTool{
+	{
+		Name:        "search",
+		Description: "Search the Pali corpus. Lexical searches return the texts containing every word and \"quoted phrase\" of the query, either side of an OR, and none preceded by -; semantic searches return passages.",
+		InputSchema: objectSchema(map[string]any{
+			"query": stringProperty("Pali words and phrases to search for, or a description for semantic search"),
+			"mode":  map[string]any{"type": "string", "enum": []string{"lexical", "semantic"}},
+			"in":    stringProperty("Folder of the corpus to search in, such as 1_tipit/2_sut/2_majjh; the whole corpus if left out"),
+		}, "query"),
+		call: toolSearch,
+	},
+	{
+		Name:        "lookup",
+		Description: "Look up a Pali word in the local dictionary and get links to the configured online dictionaries.",
+		InputSchema: objectSchema(map[string]any{
+			"word": stringProperty("Pali word"),
+		}, "word"),
+		call: toolLookup,
+	},
+	{
+		Name:        "reverse_lookup",
+		Description: "Find Pali words whose English glosses match the query.",
+		InputSchema: objectSchema(map[string]any{
+			"query": stringProperty("English words"),
+		}, "query"),
+		call: toolReverseLookup,
+	},
+	{
+		Name:        "list_texts",
+		Description: "List the directories and texts in a corpus directory.",
+		InputSchema: objectSchema(map[string]any{
+			"path": stringProperty("Directory relative to the corpus root; empty for the root"),
+		}),
+		call: toolListTexts,
+	},
+	{
+		Name:        "read_text",
+		Description: "Read the plain text of a corpus text, or of one of its paragraphs.",
+		InputSchema: objectSchema(map[string]any{
+			"path":      stringProperty("Text path relative to the corpus root"),
+			"paragraph": map[string]any{"type": "integer", "description": "Paragraph number, as in the p<N> anchors of search results"},
+		}, "path"),
+		call: toolReadText,
+	},
+}
+
+// runMCP serves the corpus tools over the Model Context Protocol, on stdio
+// or on the socket given with -listen. Clients are not authenticated, so
+// they see what a reader who is not logged in sees, and sockets are only
+// opened where no one else can connect: on loopback addresses and unix
+// sockets.
+func runMCP(args []string) error {
+	flags := flag.NewFlagSet("mcp", flag.ExitOnError)
+	listen := flags.String("listen", "", "serve on a loopback TCP address (localhost:port) or unix socket (unix:/path) instead of stdio")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: palireader mcp [-listen address]")
+		fmt.Fprintln(flags.Output(), "\nExposes search, dictionary and text tools to editors and assistants as a")
+		fmt.Fprintln(flags.Output(), "JSON-RPC 2.0 / Model Context Protocol server, one message per line.")
+		fmt.Fprintln(flags.Output(), "\nFlags:")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if err := loadConfiguredDictionary(); err != nil {
+		return fmt.Errorf("loading dictionary: %w", err)
+	}
+	startCorpusIndex()
+	if config.Semantic != nil {
+		startSemanticSearch()
+	}
+
+	if *listen == "" {
+		return serveRPC(context.Background(), os.Stdin, os.Stdout)
+	}
+
+	if err := checkLocalAddr(*listen); err != nil {
+		return err
+	}
+	listener := ListenerConfig{Addr: *listen}
+	ln, err := listener.listen()
+	if err != nil {
+		return err
+	}
+	log.Printf("MCP server listening on %s", listener.Addr)
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			if err := serveRPC(context.Background(), conn, conn); err != nil {
+				log.Println("MCP connection:", err)
+			}
+		}()
+	}
+}
+
+// checkLocalAddr returns an error unless addr is a unix socket or a loopback
+// address, which only this machine can connect to
+func checkLocalAddr(addr string) error {
+	network, address := splitListenAddr(addr)
+	if network == "unix" {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); host == "localhost" || ip != nil && ip.IsLoopback() {
+		return nil
+	}
+	return fmt.Errorf("-listen %s would let anyone who can reach this machine read the corpus; use a loopback address such as localhost:8765 or a unix socket", addr)
+}
+
+// serveRPC answers newline-delimited JSON-RPC messages until r is exhausted
+func serveRPC(ctx context.Context, r io.Reader, w io.Writer) error {
+	enc := json.NewEncoder(w)
+	send := func(resp rpcResponse) {
+		if err := enc.Encode(resp); err != nil {
+			log.Println("Error writing MCP response:", err)
+		}
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		var req rpcRequest
+		if err := json.Unmarshal([]byte(line), &req); err != nil {
+			send(rpcResponse{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &rpcError{rpcParseError, err.Error()}})
+			continue
+		}
+		if req.JSONRPC != "2.0" || req.Method == "" {
+			if req.ID != nil {
+				send(rpcResponse{JSONRPC: "2.0", ID: req.ID, Error: &rpcError{rpcInvalidRequest, "not a JSON-RPC 2.0 request"}})
+			}
+			continue
+		}
+
+		result, rpcErr := handleRPC(ctx, req)
+		if req.ID == nil {
+			// Notifications get no reply
+			continue
+		}
+		resp := rpcResponse{JSONRPC: "2.0", ID: req.ID, Result: result, Error: rpcErr}
+		if rpcErr == nil && result == nil {
+			resp.Result = struct{}{}
+		}
+		send(resp)
+	}
+	return scanner.Err()
+}
+
+// handleRPC dispatches one request
+func handleRPC(ctx context.Context, req rpcRequest) (any, *rpcError) {
+	switch req.Method {
+	case "initialize":
+		var params struct {
+			ProtocolVersion string `json:"protocolVersion"`
+		}
+		json.Unmarshal(req.Params, &params)
+		version := params.ProtocolVersion
+		if version == "" {
+			version = mcpProtocolVersion
+		}
+		return map[string]any{
+			"protocolVersion": version,
+			"capabilities":    map[string]any{"tools": map[string]any{}},
+			"serverInfo":      map[string]string{"name": "palireader", "version": "1.0"},
+		}, nil
+
+	case "notifications/initialized", "notifications/cancelled", "ping":
+		return nil, nil
+
+	case "tools/list":
+		return map[string]any{"tools": mcpTools}, nil
+
+	case "tools/call":
+		var params struct {
+			Name      string          `json:"name"`
+			Arguments json.RawMessage `json:"arguments"`
+		}
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, &rpcError{rpcInvalidParams, err.Error()}
+		}
+		for _, tool := range mcpTools {
+			if tool.Name != params.Name {
+				continue
+			}
+			if params.Arguments == nil {
+				params.Arguments = json.RawMessage("{}")
+			}
+			value, err := tool.call(ctx, params.Arguments)
+			if err != nil {
+				// Tool failures are results the client can show, not protocol errors
+				return mcpToolResult{Content: []mcpContent{{"text", err.Error()}}, IsError: true}, nil
+			}
+			var text strings.Builder
+			enc := json.NewEncoder(&text)
+			enc.SetEscapeHTML(false)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(value); err != nil {
+				return nil, &rpcError{rpcInvalidParams, err.Error()}
+			}
+			return mcpToolResult{Content: []mcpContent{{"text", text.String()}}}, nil
+		}
+		return nil, &rpcError{rpcInvalidParams, fmt.Sprintf("unknown tool %q", params.Name)}
+	}
+	return nil, &rpcError{rpcMethodNotFound, fmt.Sprintf("method %q not found", req.Method)}
+}
+
+func toolSearch(ctx context.Context, args json.RawMessage) (any, error) {
+	var params struct {
+		Query string `json:"query"`
+		Mode  string `json:"mode"`
+		In    string `json:"in"`
+	}
+	if err := json.Unmarshal(args, &params); err != nil {
+		return nil, err
+	}
+	query := strings.TrimSpace(params.Query)
+	if query == "" {
+		return nil, errors.New("query is required")
+	}
+
+	scope := searchScope(params.In)
+	var results []SearchResult
+	if params.Mode == "semantic" {
+		if config.Semantic == nil {
+			return nil, errors.New("semantic search is not configured")
+		}
+		var err error
+		results, err = semanticSearch(ctx, query, scope)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		idx := corpusIndex.Load()
+		if idx == nil {
+			return nil, errors.New("the corpus is still being indexed; try again in a moment")
+		}
+		results = idx.Search(query, scope)
+	}
+	results = slices.DeleteFunc(results, func(r SearchResult) bool { return protectedPath(r.Path) })
+	if len(results) > maxSearchResults {
+		results = results[:maxSearchResults]
+	}
+	if params.Mode != "semantic" {
+		addSnippets(results, query)
+	}
+
+	type hit struct {
+		Path     string  `json:"path"`
+		Title    string  `json:"title"`
+		Citation string  `json:"citation"`
+		Score    float64 `json:"score"`
+		Snippet  string  `json:"snippet,omitempty"`
+	}
+	hits := make([]hit, 0, len(results))
+	for _, r := range results {
+		source := r.Path
+		if r.Anchor != "" {
+			source += "#" + r.Anchor
+		}
+		hits = append(hits, hit{
+			Path:     source,
+			Title:    textTitle(r.Path),
+			Citation: citation(source),
+			Score:    r.Score,
+			Snippet:  r.Snippet,
+		})
+	}
+	return hits, nil
+}
+
+func toolLookup(ctx context.Context, args json.RawMessage) (any, error) {
+	var params struct {
+		Word string `json:"word"`
+	}
+	if err := json.Unmarshal(args, &params); err != nil {
+		return nil, err
+	}
+	word := cleanWord(strings.TrimSpace(params.Word))
+	if word == "" {
+		return nil, errors.New("word is required")
+	}
+
+	var glosses []string
+	if dictionary != nil {
+		glosses = dictionary.Lookup(word)
+	}
+	links := make(map[string]string)
+	for _, p := range config.Providers {
+		links[p.Name] = p.LookupURL(word)
+	}
+	return map[string]any{
+		"word":    word,
+		"glosses": glosses,
+		"links":   links,
+	}, nil
+}
+
+func toolReverseLookup(ctx context.Context, args json.RawMessage) (any, error) {
+	var params struct {
+		Query string `json:"query"`
+	}
+	if err := json.Unmarshal(args, &params); err != nil {
+		return nil, err
+	}
+	if dictionary == nil {
+		return nil, errors.New("no local dictionary is loaded")
+	}
+
+	type match struct {
+		Word  string `json:"word"`
+		Gloss string `json:"gloss"`
+	}
+	var matches []match
+	for _, m := range dictionary.ReverseLookup(params.Query) {
+		matches = append(matches, match{m.Word, m.Gloss})
+		if len(matches) == maxSearchResults {
+			break
+		}
+	}
+	return matches, nil
+}
+
+func toolListTexts(ctx context.Context, args json.RawMessage) (any, error) {
+	var params struct {
+		Path string `json:"path"`
+	}
+	if err := json.Unmarshal(args, &params); err != nil {
+		return nil, err
+	}
+	name, err := corpusName(params.Path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := fs.Stat(corpus, name)
+	if err != nil || !info.IsDir() || protectedPath(name) {
+		return nil, fmt.Errorf("no directory %q in the corpus", params.Path)
+	}
+
+	type entry struct {
+		Path  string `json:"path"`
+		IsDir bool   `json:"isDir,omitempty"`
+	}
+	var entries []entry
+	for _, child := range buildFileTree(params.Path).Children {
+		if !protectedPath(child.Path) {
+			entries = append(entries, entry{child.Path, child.IsDir})
+		}
+	}
+	return entries, nil
+}
+
+func toolReadText(ctx context.Context, args json.RawMessage) (any, error) {
+	var params struct {
+		Path      string `json:"path"`
+		Paragraph *int   `json:"paragraph"`
+	}
+	if err := json.Unmarshal(args, &params); err != nil {
+		return nil, err
+	}
+	name, err := corpusName(params.Path)
+	if err != nil {
+		return nil, err
+	}
+	if protectedPath(name) {
+		return nil, fmt.Errorf("no text %q in the corpus", params.Path)
+	}
+	content, err := fs.ReadFile(corpus, name)
+	if err != nil {
+		return nil, fmt.Errorf("no text %q in the corpus", params.Path)
+	}
+
+	paras := paragraphs(params.Path, string(content))
+	if params.Paragraph != nil {
+		n := *params.Paragraph
+		if n < 0 || n >= len(paras) {
+			return nil, fmt.Errorf("%s has paragraphs 0 to %d", params.Path, len(paras)-1)
+		}
+		return map[string]any{
+			"path":      params.Path,
+			"citation":  citation(fmt.Sprintf("%s#p%d", params.Path, n)),
+			"paragraph": n,
+			"segment":   segmentID(params.Path, n),
+			"text":      paras[n],
+		}, nil
+	}
+
+	// Number the non-empty paragraphs so they can be cited
+	var b strings.Builder
+	for i, text := range paras {
+		if text != "" {
+			fmt.Fprintf(&b, "[p%d] %s\n\n", i, text)
+		}
+	}
+	return map[string]any{
+		"path":  params.Path,
+		"title": textTitle(params.Path),
+		"text":  strings.TrimSpace(b.String()),
+	}, nil
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// A folder of the corpus may describe itself and its entries in a sidecar
+// file, meta.json or meta.yaml, so that curators can name and order the
+// collections without renaming files:
+//
+//	title: Dīgha Nikāya
+//	id: DN
+//	description: The long discourses of the Buddha
+//	entries:
+//	  - name: dighan1u.htm
+//	    title: Sīlakkhandhavagga
+//	    id: DN 1–13
+//	  - name: dighan2u.htm
+//	    title: Mahāvagga
+//	    commentary: ../4_comm/sumangalavilasini2.htm
+//
+// The entries listed come first, in the order given; the rest follow as
+// before. meta.yaml is read in the subset of YAML above: scalars, and a list
+// of entries holding scalars.
+
+// metaFiles are the names a folder's sidecar may have, the first found
+// being read
+var metaFiles = []string{"meta.json", "meta.yaml"}
+
+// FolderMeta is what a folder's sidecar says of it
+type FolderMeta struct {
+	Title       string      `json:"title"`
+	ID          string      `json:"id"` // nikāya or book identifier, such as DN
+	Description string      `json:"description"`
+	Entries     []EntryMeta `json:"entries"`
+}
+
+// EntryMeta is what a sidecar says of one of its folder's texts or folders.
+// Root, Commentary and Subcommentary name the texts of the other layers
+// that go with a text, relative to the folder.
+type EntryMeta struct {
+	Name          string `json:"name"` // file or folder name
+	Title         string `json:"title"`
+	ID            string `json:"id"`
+	Description   string `json:"description"`
+	Root          string `json:"root"`
+	Commentary    string `json:"commentary"`
+	Subcommentary string `json:"subcommentary"`
+}
+
+// isMetaFile reports whether the corpus name is a folder's sidecar
+func isMetaFile(name string) bool {
+	return slices.Contains(metaFiles, path.Base(name))
+}
+
+// folderMeta reads the sidecar of the folder at the corpus name; a folder
+// without one, or with one that cannot be read, has none
+func folderMeta(name string) *FolderMeta {
+	for _, file := range metaFiles {
+		data, err := fs.ReadFile(corpus, path.Join(name, file))
+		if err != nil {
+			continue
+		}
+		meta := &FolderMeta{}
+		if path.Ext(file) == ".json" {
+			err = json.Unmarshal(data, meta)
+		} else {
+			err = parseMetaYAML(data, meta)
+		}
+		if err != nil {
+			log.Printf("Ignoring %s: %v", path.Join(name, file), err)
+			return nil
+		}
+		return meta
+	}
+	return nil
+}
+
+// entry returns what the sidecar says of the entry called name
+func (m *FolderMeta) entry(name string) (EntryMeta, bool) {
+	for _, e := range m.Entries {
+		if e.Name == name {
+			return e, true
+		}
+	}
+	return EntryMeta{}, false
+}
+
+// apply names the entries of a folder's listing and puts them in the
+// sidecar's order
+func (m *FolderMeta) apply(children []*FileInfo) {
+	for _, child := range children {
+		e, ok := m.entry(child.Name)
+		if !ok {
+			continue
+		}
+		if e.Title != "" {
+			child.Title = e.Title
+		}
+		if e.ID != "" {
+			child.ID = e.ID
+		}
+		if e.Description != "" {
+			child.Description = e.Description
+		}
+	}
+	rank := func(f *FileInfo) int {
+		i := slices.IndexFunc(m.Entries, func(e EntryMeta) bool { return e.Name == f.Name })
+		if i < 0 {
+			return len(m.Entries)
+		}
+		return i
+	}
+	slices.SortStableFunc(children, func(a, b *FileInfo) int {
+		return rank(a) - rank(b)
+	})
+}
+
+// parseMetaYAML reads a meta.yaml into meta
+func parseMetaYAML(data []byte, meta *FolderMeta) error {
+	var entry *EntryMeta
+	inEntries := false
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") || trimmed == "---" {
+			continue
+		}
+		indented := line[0] == ' ' || line[0] == '\t'
+		item := strings.HasPrefix(trimmed, "- ")
+		if item {
+			trimmed = strings.TrimSpace(trimmed[2:])
+		}
+		key, value, ok := strings.Cut(trimmed, ":")
+		if !ok {
+			return fmt.Errorf("line %d: expected key: value", n)
+		}
+		key = strings.TrimSpace(key)
+		value, err := yamlScalar(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("line %d: %v", n, err)
+		}
+
+		switch {
+		case !indented && !item:
+			inEntries, entry = false, nil
+			switch key {
+			case "title":
+				meta.Title = value
+			case "id":
+				meta.ID = value
+			case "description":
+				meta.Description = value
+			case "entries":
+				if value != "" {
+					return fmt.Errorf("line %d: entries is a list", n)
+				}
+				inEntries = true
+			default:
+				return fmt.Errorf("line %d: unknown key %s", n, key)
+			}
+		case !inEntries:
+			return fmt.Errorf("line %d: only entries holds a list", n)
+		default:
+			if item {
+				meta.Entries = append(meta.Entries, EntryMeta{})
+				entry = &meta.Entries[len(meta.Entries)-1]
+			}
+			if entry == nil {
+				return fmt.Errorf("line %d: an entry starts with -", n)
+			}
+			switch key {
+			case "name":
+				entry.Name = value
+			case "title":
+				entry.Title = value
+			case "id":
+				entry.ID = value
+			case "description":
+				entry.Description = value
+			case "root":
+				entry.Root = value
+			case "commentary":
+				entry.Commentary = value
+			case "subcommentary":
+				entry.Subcommentary = value
+			default:
+				return fmt.Errorf("line %d: unknown key %s", n, key)
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+// yamlScalar reads a plain, single-quoted or double-quoted YAML scalar
+func yamlScalar(s string) (string, error) {
+	switch {
+	case strings.HasPrefix(s, `"`):
+		return strconv.Unquote(s)
+	case strings.HasPrefix(s, "'"):
+		if len(s) < 2 || !strings.HasSuffix(s, "'") {
+			return "", fmt.Errorf("unterminated string %s", s)
+		}
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'"), nil
+	}
+	// A comment ends a plain scalar
+	if i := strings.Index(s, " #"); i >= 0 {
+		s = strings.TrimSpace(s[:i])
+	}
+	return s, nil
+}
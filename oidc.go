@@ -0,0 +1,355 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OIDCConfig lets readers log in with an OpenID Connect provider, such as
+// Google or a Keycloak realm, besides or instead of the users of the config
+// file
+type OIDCConfig struct {
+	Issuer       string      `json:"issuer"` // e.g. https://accounts.google.com
+	ClientID     string      `json:"clientID"`
+	ClientSecret string      `json:"clientSecret"`
+	Name         string      `json:"name"`       // on the login button, e.g. "Google"
+	Scopes       []string    `json:"scopes"`     // defaults to openid, email and profile
+	UserClaim    string      `json:"userClaim"`  // names the user; defaults to email
+	Domains      []string    `json:"domains"`    // email domains let in; any if empty
+	RoleClaims   []RoleClaim `json:"roleClaims"` // roles for the values of claims
+}
+
+// RoleClaim gives the users whose ID token has a value in a claim a role,
+// such as editor to the members of a Keycloak group
+type RoleClaim struct {
+	Claim string `json:"claim"` // dotted for nested claims, e.g. realm_access.roles
+	Value string `json:"value"`
+	Role  string `json:"role"`
+	Path  string `json:"path"` // corpus folder; the whole corpus if empty
+}
+
+const (
+	// oidcCookie holds the state of a login while the reader is at the
+	// provider
+	oidcCookie = "palireader_oidc"
+
+	// oidcLoginTime is how long the reader has to log in at the provider
+	oidcLoginTime = 10 * time.Minute
+)
+
+var oidcClient = &http.Client{Timeout: 15 * time.Second}
+
+// oidcEndpoints are the provider's, from its discovery document
+type oidcEndpoints struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+}
+
+// oidcDiscovery keeps the endpoints once they were fetched, trying again
+// after a failure
+var oidcDiscovery struct {
+	mu        sync.Mutex
+	endpoints *oidcEndpoints
+}
+
+// providerEndpoints returns the endpoints of the configured provider
+func providerEndpoints() (*oidcEndpoints, error) {
+	oidcDiscovery.mu.Lock()
+	defer oidcDiscovery.mu.Unlock()
+	if oidcDiscovery.endpoints != nil {
+		return oidcDiscovery.endpoints, nil
+	}
+	issuer := strings.TrimSuffix(config.Auth.OIDC.Issuer, "/")
+	resp, err := oidcClient.Get(issuer + "/.well-known/openid-configuration")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("discovery: %s", resp.Status)
+	}
+	var e oidcEndpoints
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&e); err != nil {
+		return nil, fmt.Errorf("discovery: %w", err)
+	}
+	if strings.TrimSuffix(e.Issuer, "/") != issuer || e.AuthorizationEndpoint == "" || e.TokenEndpoint == "" {
+		return nil, errors.New("discovery: the document is not for the configured issuer")
+	}
+	oidcDiscovery.endpoints = &e
+	return &e, nil
+}
+
+// oidcState is what a login remembers while the reader is at the provider
+type oidcState struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"` // PKCE
+	Next     string `json:"next"`
+	Expires  int64  `json:"expires"`
+}
+
+// randomToken returns a random URL-safe string
+func randomToken() string {
+	b := make([]byte, 24)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// oidcRedirect is where the provider sends the reader back to
+func oidcRedirect(r *http.Request) string {
+	return baseURL(r) + "/login/oidc/callback"
+}
+
+// handleOIDCLogin sends the reader to the provider to log in
+func handleOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	endpoints, err := providerEndpoints()
+	if err != nil {
+		logf(r.Context(), "OIDC: %v", err)
+		httpError(w, r, "Cannot reach the login provider", http.StatusBadGateway)
+		return
+	}
+	st := oidcState{
+		State:    randomToken(),
+		Nonce:    randomToken(),
+		Verifier: randomToken(),
+		Next:     safeNext(r.FormValue("next")),
+		Expires:  time.Now().Add(oidcLoginTime).Unix(),
+	}
+	data, _ := json.Marshal(st)
+	value, err := sign(base64.RawURLEncoding.EncodeToString(data))
+	if err != nil {
+		httpError(w, r, "Cannot log in", http.StatusInternalServerError)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcCookie,
+		Value:    value,
+		Path:     sitePath("/login/oidc"),
+		MaxAge:   int(oidcLoginTime / time.Second),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	c := config.Auth.OIDC
+	challenge := sha256.Sum256([]byte(st.Verifier))
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {c.ClientID},
+		"redirect_uri":          {oidcRedirect(r)},
+		"scope":                 {strings.Join(c.Scopes, " ")},
+		"state":                 {st.State},
+		"nonce":                 {st.Nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(endpoints.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	http.Redirect(w, r, endpoints.AuthorizationEndpoint+sep+q.Encode(), http.StatusFound)
+}
+
+// handleOIDCCallback finishes a login at the provider: it exchanges the
+// code for an ID token, checks it and logs its user in
+func handleOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	var st oidcState
+	if c, err := r.Cookie(oidcCookie); err == nil {
+		if payload, ok := verify(c.Value); ok {
+			if data, err := base64.RawURLEncoding.DecodeString(payload); err == nil {
+				json.Unmarshal(data, &st)
+			}
+		}
+	}
+	http.SetCookie(w, &http.Cookie{Name: oidcCookie, Path: sitePath("/login/oidc"), MaxAge: -1})
+	if st.State == "" || st.State != r.FormValue("state") || time.Now().Unix() > st.Expires {
+		httpError(w, r, "The login expired or was not started here; try again", http.StatusBadRequest)
+		return
+	}
+	if msg := r.FormValue("error"); msg != "" {
+		logf(r.Context(), "OIDC: provider refused the login: %s", msg)
+		httpError(w, r, "The login provider refused the login", http.StatusForbidden)
+		return
+	}
+
+	claims, err := exchangeCode(r, r.FormValue("code"), st)
+	if err != nil {
+		logf(r.Context(), "OIDC: %v", err)
+		httpError(w, r, "Cannot log in with the provider", http.StatusBadGateway)
+		return
+	}
+	user, err := ssoAccount(claims)
+	if err != nil {
+		logf(r.Context(), "OIDC: %v", err)
+		httpError(w, r, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err := startSession(w, r, user); err != nil {
+		logf(r.Context(), "Error signing session: %v", err)
+		httpError(w, r, "Cannot log in", http.StatusInternalServerError)
+		return
+	}
+	logf(r.Context(), "%s logged in with %s", user, config.Auth.OIDC.Issuer)
+	http.Redirect(w, r, sitePath(st.Next), http.StatusSeeOther)
+}
+
+// exchangeCode trades an authorization code for the claims of its ID
+// token. The token comes straight from the provider's token endpoint over
+// TLS, which vouches for it, so its issuer, audience, expiry and nonce are
+// checked but not its signature, as OpenID Connect Core 3.1.3.7 allows.
+func exchangeCode(r *http.Request, code string, st oidcState) (map[string]any, error) {
+	endpoints, err := providerEndpoints()
+	if err != nil {
+		return nil, err
+	}
+	c := config.Auth.OIDC
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {oidcRedirect(r)},
+		"code_verifier": {st.Verifier},
+	}
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodPost, endpoints.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(c.ClientID), url.QueryEscape(c.ClientSecret))
+	resp, err := oidcClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var token struct {
+		IDToken string `json:"id_token"`
+		Error   string `json:"error"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&token); err != nil {
+		return nil, fmt.Errorf("token endpoint: %s: %w", resp.Status, err)
+	}
+	if resp.StatusCode != http.StatusOK || token.IDToken == "" {
+		return nil, fmt.Errorf("token endpoint: %s %s", resp.Status, token.Error)
+	}
+
+	parts := strings.Split(token.IDToken, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("the ID token is not a JWT")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("ID token: %w", err)
+	}
+	var claims map[string]any
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("ID token: %w", err)
+	}
+
+	if iss, _ := claims["iss"].(string); strings.TrimSuffix(iss, "/") != strings.TrimSuffix(c.Issuer, "/") {
+		return nil, fmt.Errorf("ID token from %q, not the configured issuer", iss)
+	}
+	if !slices.Contains(claimValues(claims, "aud"), c.ClientID) {
+		return nil, errors.New("the ID token is for another client")
+	}
+	if exp, _ := claims["exp"].(float64); time.Now().Unix() > int64(exp) {
+		return nil, errors.New("the ID token has expired")
+	}
+	if nonce, _ := claims["nonce"].(string); nonce != st.Nonce {
+		return nil, errors.New("the ID token is not for this login")
+	}
+	return claims, nil
+}
+
+// claimValues returns the strings a claim holds, whether one or a list.
+// A dotted name reaches into nested claims.
+func claimValues(claims map[string]any, name string) []string {
+	var v any = claims
+	for part := range strings.SplitSeq(name, ".") {
+		m, ok := v.(map[string]any)
+		if !ok {
+			return nil
+		}
+		v = m[part]
+	}
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case bool:
+		return []string{strconv.FormatBool(v)}
+	case []any:
+		var values []string
+		for _, e := range v {
+			if s, ok := e.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// ssoAccount returns the user an ID token names, keeping their account
+// with the roles its claims map to
+func ssoAccount(claims map[string]any) (string, error) {
+	c := config.Auth.OIDC
+	names := claimValues(claims, c.UserClaim)
+	if len(names) == 0 || names[0] == "" {
+		return "", fmt.Errorf("the provider did not send %s", c.UserClaim)
+	}
+	user := names[0]
+	// An unverified address could be anyone's, so it names no one. Nor
+	// does one the provider does not say it verified.
+	if c.UserClaim == "email" || len(c.Domains) > 0 {
+		if verified := claimValues(claims, "email_verified"); len(verified) != 1 || verified[0] != "true" {
+			return "", errors.New("your email address is not verified")
+		}
+	}
+	if len(c.Domains) > 0 {
+		email := claimValues(claims, "email")
+		_, domain, _ := strings.Cut(strings.Join(email, ""), "@")
+		if !slices.Contains(c.Domains, strings.ToLower(domain)) {
+			return "", errors.New("your account is not one of those let in")
+		}
+	}
+	if _, ok := config.Auth.Users[user]; ok {
+		return "", fmt.Errorf("%s is a local user; log in with the password", user)
+	}
+
+	var grants []RoleGrant
+	for _, rc := range c.RoleClaims {
+		if slices.Contains(claimValues(claims, rc.Claim), rc.Value) {
+			grants = append(grants, RoleGrant{User: user, Role: rc.Role, Path: rc.Path})
+		}
+	}
+	err := accounts.Update(func(all *map[string]Account) error {
+		a, ok := (*all)[user]
+		if ok && a.Provider == "" {
+			return fmt.Errorf("%s signed up with a password; log in with it", user)
+		}
+		if *all == nil {
+			*all = make(map[string]Account)
+		}
+		if !ok {
+			a.Created = time.Now().UTC()
+		}
+		// The provider decides the roles afresh at every login
+		a.Provider = c.Issuer
+		a.Roles = grants
+		(*all)[user] = a
+		return nil
+	})
+	return user, err
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestClaimValues(t *testing.T) {
+	claims := map[string]any{
+		"email":          "ann@example.org",
+		"email_verified": true,
+		"groups":         []any{"editors", 7, "readers"},
+		"realm_access":   map[string]any{"roles": []any{"admin"}},
+	}
+	tests := []struct {
+		name   string
+		values []string
+	}{
+		{"email", []string{"ann@example.org"}},
+		{"email_verified", []string{"true"}},
+		{"groups", []string{"editors", "readers"}},
+		{"realm_access.roles", []string{"admin"}},
+		{"realm_access.groups", nil},
+		{"email.domain", nil},
+		{"name", nil},
+	}
+	for _, tt := range tests {
+		if values := claimValues(claims, tt.name); !slices.Equal(values, tt.values) {
+			t.Errorf("claimValues(%q) = %q; want %q", tt.name, values, tt.values)
+		}
+	}
+}
+
+func TestSSOAccount(t *testing.T) {
+	useConfig(t, Config{DataDir: t.TempDir(), Auth: &AuthConfig{
+		Users: map[string]string{"bob@example.org": "b"},
+		OIDC: &OIDCConfig{
+			Issuer:     "https://id.example.org",
+			UserClaim:  "email",
+			Domains:    []string{"example.org"},
+			RoleClaims: []RoleClaim{{Claim: "groups", Value: "editors", Role: roleEditor, Path: "gretil"}},
+		},
+	}})
+	saved := accounts
+	accounts = &jsonFile[map[string]Account]{name: "accounts.json"}
+	t.Cleanup(func() { accounts = saved })
+
+	user, err := ssoAccount(map[string]any{
+		"email":          "ann@example.org",
+		"email_verified": true,
+		"groups":         []any{"editors"},
+	})
+	if err != nil || user != "ann@example.org" {
+		t.Fatalf("ssoAccount of a verified member = %q, %v", user, err)
+	}
+	var a Account
+	accounts.Read(func(all *map[string]Account) { a = (*all)[user] })
+	if want := []RoleGrant{{User: user, Role: roleEditor, Path: "gretil"}}; !slices.Equal(a.Roles, want) {
+		t.Errorf("roles from the claims = %v; want %v", a.Roles, want)
+	}
+	if a.Provider != "https://id.example.org" {
+		t.Errorf("provider = %q", a.Provider)
+	}
+
+	// The provider decides the roles afresh at every login
+	if _, err := ssoAccount(map[string]any{"email": user, "email_verified": true}); err != nil {
+		t.Fatal(err)
+	}
+	accounts.Read(func(all *map[string]Account) { a = (*all)[user] })
+	if len(a.Roles) != 0 {
+		t.Errorf("roles kept after leaving the group: %v", a.Roles)
+	}
+
+	for _, claims := range []map[string]any{
+		{},
+		{"email": ""},
+		{"email": "eve@example.com", "email_verified": true},
+		{"email": "eve@example.org.example.com", "email_verified": true},
+		{"email": "eve@example.org", "email_verified": false},
+		{"email": "eve@example.org", "email_verified": "false"},
+		{"email": "eve@example.org"},
+		// Local users log in with their passwords
+		{"email": "bob@example.org", "email_verified": true},
+	} {
+		if user, err := ssoAccount(claims); err == nil {
+			t.Errorf("ssoAccount(%v) = %q; want an error", claims, user)
+		}
+	}
+
+	// An unverified address names no one, even where any domain is let in
+	config.Auth.OIDC.Domains = nil
+	for _, verified := range []any{false, nil} {
+		claims := map[string]any{"email": "ann@example.org"}
+		if verified != nil {
+			claims["email_verified"] = verified
+		}
+		if user, err := ssoAccount(claims); err == nil {
+			t.Errorf("ssoAccount of an address with email_verified %v = %q; want an error", verified, user)
+		}
+	}
+	if user, err := ssoAccount(map[string]any{"email": "ann@example.org", "email_verified": "true"}); err != nil || user != "ann@example.org" {
+		t.Errorf("ssoAccount with email_verified \"true\" = %q, %v", user, err)
+	}
+	config.Auth.OIDC.UserClaim = "sub"
+	if user, err := ssoAccount(map[string]any{"sub": "4711", "email_verified": false}); err != nil || user != "4711" {
+		t.Errorf("ssoAccount by subject = %q, %v; want 4711", user, err)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// anusvaraSigns are the ways traditions write the niggahīta: ṃ (PTS and
+// GRETIL), ṁ (the Chaṭṭha Saṅgāyana romanization) and ŋ (older Sinhalese
+// and Thai romanizations)
+var anusvaraSigns = []string{"ṃ", "ṁ", "ŋ"}
+
+// anusvaraPattern matches a niggahīta in any of its spellings, lower case
+// in the first group and upper case in the second, with the stop following
+// it in the third
+var anusvaraPattern = regexp.MustCompile(`(?:([ṃṁŋ]|m\x{0323}|m\x{0307})|([ṂṀŊ]|M\x{0323}|M\x{0307}))` +
+	`(kh|gh|ch|jh|ṭh|ḍh|th|dh|ph|bh|[kgcjṭḍtdpb])?`)
+
+// classNasal returns the nasal of the class of a stop
+func classNasal(stop string) string {
+	switch []rune(stop)[0] {
+	case 'k', 'g':
+		return "ṅ"
+	case 'c', 'j':
+		return "ñ"
+	case 'ṭ', 'ḍ':
+		return "ṇ"
+	case 't', 'd':
+		return "n"
+	}
+	return "m"
+}
+
+// anusvaraStyle is how a reader wants the niggahīta written
+type anusvaraStyle struct {
+	sign  string // one of anusvaraSigns, "" to keep the text's own
+	class bool   // before a stop, write the nasal of its class instead
+}
+
+func (s anusvaraStyle) isZero() bool {
+	return s.sign == "" && !s.class
+}
+
+// validAnusvaraSign reports whether sign is one the texts can be written with
+func validAnusvaraSign(sign string) bool {
+	for _, s := range anusvaraSigns {
+		if sign == s {
+			return true
+		}
+	}
+	return sign == ""
+}
+
+// expand writes the niggahīta matched by m in the style
+func (s anusvaraStyle) expand(content string, m []int) []byte {
+	original := content[m[0]:m[1]]
+	upper := m[4] >= 0
+	stop := ""
+	if m[6] >= 0 {
+		stop = content[m[6]:m[7]]
+	}
+
+	var nasal string
+	switch {
+	case s.class && stop != "":
+		nasal = classNasal(stop)
+	case s.sign != "":
+		nasal = s.sign
+	default:
+		return []byte(original)
+	}
+	if upper {
+		nasal = strings.ToUpper(nasal)
+	}
+	return []byte(nasal + stop)
+}
+
+// convert writes every niggahīta of text in the style
+func (s anusvaraStyle) convert(text string) string {
+	if s.isZero() {
+		return text
+	}
+	converted, _, _ := replaceText(anusvaraPattern, text, s.expand)
+	return converted
+}
+
+// convertHTML writes every niggahīta of the text of an HTML fragment in the
+// style, leaving the tags, and so the dictionary links, as they are
+func (s anusvaraStyle) convertHTML(content string) string {
+	if s.isZero() {
+		return content
+	}
+	var b strings.Builder
+	last := 0
+	for _, tag := range tagPattern.FindAllStringIndex(content, -1) {
+		b.WriteString(s.convert(content[last:tag[0]]))
+		b.WriteString(content[tag[0]:tag[1]])
+		last = tag[1]
+	}
+	b.WriteString(s.convert(content[last:]))
+	return b.String()
+}
+
+// runAnusvara implements the anusvara command: it rewrites the niggahīta of
+// the texts in one convention
+func runAnusvara(args []string) error {
+	flags := flag.NewFlagSet("anusvara", flag.ExitOnError)
+	var opts rewriteOptions
+	opts.addFlags(flags)
+	class := flags.Bool("class", false, "before a stop, write the nasal of its class (saṅgha for saṃgha)")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: palireader anusvara [-class] [-in folder] [-n] [-y] [-commit message] [ṃ|ṁ|ŋ]")
+		fmt.Fprintln(flags.Output(), "\nWrites every niggahīta of the texts with the given sign, and with -class")
+		fmt.Fprintln(flags.Output(), "as the nasal of the stop following it inside a word. The changed lines of")
+		fmt.Fprintln(flags.Output(), "each text are shown first and nothing is written until the changes are")
+		fmt.Fprintln(flags.Output(), "confirmed. The reader can show texts in another convention without")
+		fmt.Fprintln(flags.Output(), "changing them; see /settings.")
+		fmt.Fprintln(flags.Output(), "\nFlags:")
+		flags.PrintDefaults()
+	}
+	positional := parseInterspersed(flags, args)
+	style := anusvaraStyle{class: *class}
+	switch {
+	case len(positional) == 1 && positional[0] != "":
+		style.sign = positional[0]
+	case len(positional) == 0 && *class:
+	default:
+		flags.Usage()
+		os.Exit(2)
+	}
+	if !validAnusvaraSign(style.sign) {
+		return fmt.Errorf("%q is not one of %s", style.sign, strings.Join(anusvaraSigns, " "))
+	}
+
+	return rewriteTexts(opts, func(content string) (string, []replaceHunk, int) {
+		count := 0
+		converted, hunks, _ := replaceText(anusvaraPattern, content, func(content string, m []int) []byte {
+			out := style.expand(content, m)
+			if string(out) != content[m[0]:m[1]] {
+				count++
+			}
+			return out
+		})
+		return converted, hunks, count
+	})
+}
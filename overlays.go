@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"html/template"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// OverlayConfig is a file placing the pages of another printed edition, such
+// as the Myanmar Chaṭṭha Saṅgāyana or the Thai Syāmaraṭṭha edition, in the
+// texts. Each line of File is
+//
+//	path <tab> paragraph <tab> page [<tab> first words of the page]
+//
+// where paragraph is the text's paragraph anchor (p12, or 12) and the page
+// starts before the first words, or at the paragraph's start without them.
+// Lines starting with # are comments.
+type OverlayConfig struct {
+	Name  string `json:"name"`  // short name shown with the page, like "M"
+	Title string `json:"title"` // like "Myanmar edition", defaults to the name
+	File  string `json:"file"`
+}
+
+// overlayMark is a page of an edition starting in a paragraph
+type overlayMark struct {
+	edition *OverlayConfig
+	page    string
+	words   string
+}
+
+// overlays holds the edition pages of every text as read from the files
+// in the state stamp tells
+var overlays struct {
+	sync.Mutex
+	stamp string
+	marks map[string]map[int][]overlayMark
+}
+
+// overlayStamp tells apart the states of the overlay files by their sizes
+// and times
+func overlayStamp() string {
+	var b strings.Builder
+	for _, o := range config.Overlays {
+		if info, err := os.Stat(o.File); err == nil {
+			fmt.Fprintf(&b, "%d:%d", info.Size(), info.ModTime().UnixNano())
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// overlayMarks returns the edition pages of every text, by path and
+// paragraph. The files are read again when they change, and the pages
+// processed with the old ones are forgotten.
+func overlayMarks() map[string]map[int][]overlayMark {
+	stamp := overlayStamp()
+	overlays.Lock()
+	defer overlays.Unlock()
+	if overlays.marks != nil && overlays.stamp == stamp {
+		return overlays.marks
+	}
+	if overlays.marks != nil {
+		corpusCache.invalidate(".")
+	}
+	marks := make(map[string]map[int][]overlayMark)
+	for i := range config.Overlays {
+		o := &config.Overlays[i]
+		if err := readOverlay(o, marks); err != nil {
+			log.Printf("Error reading the %s pages: %v", o.Name, err)
+		}
+	}
+	overlays.stamp, overlays.marks = stamp, marks
+	return marks
+}
+
+func readOverlay(o *OverlayConfig, marks map[string]map[int][]overlayMark) error {
+	f, err := os.Open(o.File)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(text) == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Split(text, "\t")
+		if len(fields) < 3 {
+			return fmt.Errorf("%s:%d: need path, paragraph and page separated by tabs", o.File, line)
+		}
+		path, err := corpusName(strings.TrimSpace(fields[0]))
+		if err != nil {
+			return fmt.Errorf("%s:%d: %w", o.File, line, err)
+		}
+		paragraph, err := strconv.Atoi(strings.TrimPrefix(strings.TrimSpace(fields[1]), "p"))
+		if err != nil || paragraph < 0 {
+			return fmt.Errorf("%s:%d: %q is not a paragraph", o.File, line, fields[1])
+		}
+		mark := overlayMark{edition: o, page: strings.TrimSpace(fields[2])}
+		if len(fields) > 3 {
+			mark.words = norm.NFC.String(strings.TrimSpace(fields[3]))
+		}
+		if marks[path] == nil {
+			marks[path] = make(map[int][]overlayMark)
+		}
+		marks[path][paragraph] = append(marks[path][paragraph], mark)
+	}
+	return scanner.Err()
+}
+
+// overlayGap is what may come between the words of an overlay mark in the
+// text: spaces, tags and reference markers
+const overlayGap = `(?:\s|<[^>]*>|\[[^\]]*\])+`
+
+// placeOverlays puts the badges of the edition pages starting in a
+// paragraph of raw text into it. The badges are empty tags showing their
+// page through CSS, so that the page numbers do not become words.
+func placeOverlays(part string, marks []overlayMark) string {
+	type insert struct {
+		at    int
+		badge string
+	}
+	var inserts []insert
+	for _, m := range marks {
+		at := 0
+		if words := strings.Fields(m.words); len(words) > 0 {
+			for i, w := range words {
+				words[i] = regexp.QuoteMeta(w)
+			}
+			re, err := regexp.Compile(strings.Join(words, overlayGap))
+			if err == nil {
+				for _, loc := range re.FindAllStringIndex(part, -1) {
+					if !insideTag(part, loc[0]) {
+						at = loc[0]
+						break
+					}
+				}
+			}
+		}
+		title := m.edition.Title
+		if title == "" {
+			title = m.edition.Name
+		}
+		inserts = append(inserts, insert{at, fmt.Sprintf(`<span class="edition-page edition-%s" data-page="%s" title="%s, page %s"></span>`,
+			m.edition.Name, template.HTMLEscapeString(m.edition.Name+" "+m.page),
+			template.HTMLEscapeString(title), template.HTMLEscapeString(m.page))})
+	}
+
+	// From the end, so the earlier places stay put; badges at the same
+	// place keep the order of the files
+	sort.SliceStable(inserts, func(i, j int) bool { return inserts[i].at < inserts[j].at })
+	for i := len(inserts) - 1; i >= 0; i-- {
+		in := inserts[i]
+		part = part[:in.at] + in.badge + part[in.at:]
+	}
+	return part
+}
+
+// insideTag reports whether byte i of s is inside a tag
+func insideTag(s string, i int) bool {
+	open := strings.LastIndexByte(s[:i], '<')
+	return open >= 0 && strings.LastIndexByte(s[:i], '>') < open
+}
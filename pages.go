@@ -0,0 +1,153 @@
+package main
+
+import (
+	"html"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// readerPageSize is about how much of a text's HTML one page of the reader
+// holds: longer texts, such as the Visuddhimagga, are split into numbered
+// pages
+const readerPageSize = 100_000
+
+// maxHeading is the most characters a paragraph taken for a heading has
+const maxHeading = 80
+
+// htmlTag matches a tag, for reading a paragraph's text
+var htmlTag = regexp.MustCompile(`<[^>]*>`)
+
+// Pager places a page of a long text among the others
+type Pager struct {
+	Page   int   // from 1
+	Starts []int // the first paragraph of each page
+}
+
+// Pages lists the page numbers, for the links between them
+func (p *Pager) Pages() []int {
+	pages := make([]int, len(p.Starts))
+	for i := range pages {
+		pages[i] = i + 1
+	}
+	return pages
+}
+
+// Count is how many pages the text has
+func (p *Pager) Count() int {
+	return len(p.Starts)
+}
+
+// Prev is the number of the page before, or 0 on the first
+func (p *Pager) Prev() int {
+	return p.Page - 1
+}
+
+// Next is the number of the page after, or 0 on the last
+func (p *Pager) Next() int {
+	if p.Page >= len(p.Starts) {
+		return 0
+	}
+	return p.Page + 1
+}
+
+// StartList lists the first paragraphs for the reader script, which sends
+// links to a paragraph on another page there
+func (p *Pager) StartList() string {
+	list := make([]string, len(p.Starts))
+	for i, s := range p.Starts {
+		list[i] = strconv.Itoa(s)
+	}
+	return strings.Join(list, ",")
+}
+
+// paragraphPage returns the reader page of a text holding a paragraph, or
+// 0 for a text read on one page
+func paragraphPage(name string, paragraph int) (int, error) {
+	starts, err := textPageStarts(name)
+	if err != nil {
+		return 0, err
+	}
+	if len(starts) == 1 {
+		return 0, nil
+	}
+	// The number of pages starting at or before the paragraph
+	return sort.SearchInts(starts, paragraph+1), nil
+}
+
+// textPageStarts returns the first paragraph of each reader page of the
+// text at name
+func textPageStarts(name string) ([]int, error) {
+	starts, generation, cached := corpusCache.pageStarts(name)
+	if !cached {
+		content, err := fs.ReadFile(corpus, name)
+		if err != nil {
+			return nil, err
+		}
+		starts = textPages(paragraphBreak.Split(extractBody(string(content)), -1))
+		corpusCache.storePageStarts(name, starts, generation)
+	}
+	return starts, nil
+}
+
+// textPages splits a text, given as its paragraphs, into pages of about
+// readerPageSize and returns the first paragraph of each. Once a page is
+// full it ends before the next section heading, or at any paragraph if none
+// comes before twice the size. The same text always splits the same way,
+// so page URLs stay put.
+func textPages(parts []string) []int {
+	total := 0
+	for _, part := range parts {
+		total += len(part)
+	}
+	starts := []int{0}
+	if total <= readerPageSize*3/2 {
+		return starts
+	}
+	size, done := 0, 0
+	for i, part := range parts {
+		// The last page is not left with a few paragraphs
+		if size >= readerPageSize && total-done > readerPageSize/4 &&
+			(size >= 2*readerPageSize || opensSection(part)) {
+			starts = append(starts, i)
+			size = 0
+		}
+		size += len(part)
+		done += len(part)
+	}
+	return starts
+}
+
+// opensSection reports whether a paragraph is a section heading, such as
+// "1. Avijjāvaggo" or "Sāmaññaphalasuttaṃ": a short line that does not end
+// like a sentence, as "Sāmaññaphalasuttaṃ niṭṭhitaṃ." does
+func opensSection(part string) bool {
+	if len(part) > 4000 {
+		return false
+	}
+	text := strings.TrimSpace(html.UnescapeString(htmlTag.ReplaceAllString(part, "")))
+	if text == "" || utf8.RuneCountInString(text) > maxHeading {
+		return false
+	}
+	last, _ := utf8.DecodeLastRuneInString(text)
+	return unicode.IsLetter(last) || unicode.IsDigit(last) || last == ']'
+}
+
+// pageRange returns the paragraphs of page n, from 1, of a text whose pages
+// start at starts, and its pager, nil for a text on one page. to is -1 on
+// the last page; n is clamped to the pages there are.
+func pageRange(starts []int, n int) (from, to int, pager *Pager) {
+	if len(starts) == 1 {
+		return 0, -1, nil
+	}
+	n = max(1, min(n, len(starts)))
+	to = -1
+	if n < len(starts) {
+		to = starts[n]
+	}
+	return starts[n-1], to, &Pager{Page: n, Starts: starts}
+}
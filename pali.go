@@ -0,0 +1,242 @@
+package main
+
+import (
+	"path"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Pali letters in dictionary order. Aspirated consonants are letters of
+// their own and sort after the unaspirated one.
+var (
+	paliVowels     = []string{"a", "ā", "i", "ī", "u", "ū", "e", "o"}
+	paliConsonants = []string{
+		"k", "kh", "g", "gh", "ṅ",
+		"c", "ch", "j", "jh", "ñ",
+		"ṭ", "ṭh", "ḍ", "ḍh", "ṇ",
+		"t", "th", "d", "dh", "n",
+		"p", "ph", "b", "bh", "m",
+		"y", "r", "l", "v", "s", "h", "ḷ",
+	}
+)
+
+// paliLetterRank gives each letter its position in the alphabet, with the
+// niggahita between the vowels and the consonants
+var paliLetterRank = func() map[string]int {
+	rank := make(map[string]int)
+	for _, v := range paliVowels {
+		rank[v] = len(rank) + 1
+	}
+	rank["ṃ"] = len(rank) + 1
+	for _, c := range paliConsonants {
+		rank[c] = len(rank) + 1
+	}
+	return rank
+}()
+
+// niggahitaForms are the other spellings of ṃ found in Pali texts
+var niggahitaForms = strings.NewReplacer("ṁ", "ṃ", "ŋ", "ṃ", "Ṁ", "ṃ", "Ŋ", "ṃ")
+
+// paliLetters splits lowercased text into Pali letters, reading an aspirate
+// as one letter. Anything else is passed through a rune at a time.
+func paliLetters(s string) []string {
+	s = niggahitaForms.Replace(strings.ToLower(s))
+	var letters []string
+	for s != "" {
+		_, size := utf8.DecodeRuneInString(s)
+		if len(s) > size && s[size] == 'h' {
+			if _, ok := paliLetterRank[s[:size+1]]; ok {
+				size++
+			}
+		}
+		letters = append(letters, s[:size])
+		s = s[size:]
+	}
+	return letters
+}
+
+// paliLess reports whether a sorts before b in Pali dictionary order.
+// Characters outside the alphabet sort after it by code point.
+func paliLess(a, b string) bool {
+	la, lb := paliLetters(a), paliLetters(b)
+	for i := 0; i < len(la) && i < len(lb); i++ {
+		ra, rb := paliRank(la[i]), paliRank(lb[i])
+		if ra != rb {
+			return ra < rb
+		}
+	}
+	if len(la) != len(lb) {
+		return len(la) < len(lb)
+	}
+	return a < b
+}
+
+func paliRank(letter string) int {
+	if rank, ok := paliLetterRank[letter]; ok {
+		return rank
+	}
+	r, _ := utf8.DecodeRuneInString(letter)
+	return len(paliLetterRank) + 1 + int(r)
+}
+
+// collate returns a copy of words sorted in Pali dictionary order
+func collate(words []string) []string {
+	sorted := append([]string(nil), words...)
+	sort.SliceStable(sorted, func(i, j int) bool { return paliLess(sorted[i], sorted[j]) })
+	return sorted
+}
+
+// brahmicScript holds an Indic script's letters in the order of paliVowels
+// and paliConsonants. Vowel signs follow a consonant; the first, for the
+// inherent a, is empty.
+type brahmicScript struct {
+	vowels     []string
+	signs      []string
+	consonants []string
+	niggahita  string
+	virama     string
+}
+
+// scripts are the targets of transliterate besides "ascii"
+var scripts = map[string]*brahmicScript{
+	"deva": {
+		vowels:     strings.Split("अ आ इ ई उ ऊ ए ओ", " "),
+		signs:      strings.Split(" ा ि ी ु ू े ो", " "),
+		consonants: strings.Split("क ख ग घ ङ च छ ज झ ञ ट ठ ड ढ ण त थ द ध न प फ ब भ म य र ल व स ह ळ", " "),
+		niggahita:  "ं",
+		virama:     "्",
+	},
+	"sinh": {
+		vowels:     strings.Split("අ ආ ඉ ඊ උ ඌ එ ඔ", " "),
+		signs:      strings.Split(" ා ි ී ු ූ ෙ ො", " "),
+		consonants: strings.Split("ක ඛ ග ඝ ඞ ච ඡ ජ ඣ ඤ ට ඨ ඩ ඪ ණ ත ථ ද ධ න ප ඵ බ භ ම ය ර ල ව ස හ ළ", " "),
+		niggahita:  "ං",
+		virama:     "්",
+	},
+}
+
+// asciiPali drops the diacritics of romanized Pali
+var asciiPali = strings.NewReplacer(
+	"ā", "a", "ī", "i", "ū", "u", "ṃ", "m", "ṁ", "m", "ŋ", "m",
+	"ṅ", "n", "ñ", "n", "ṭ", "t", "ḍ", "d", "ṇ", "n", "ḷ", "l",
+	"Ā", "A", "Ī", "I", "Ū", "U", "Ṃ", "M", "Ṁ", "M",
+	"Ṅ", "N", "Ñ", "N", "Ṭ", "T", "Ḍ", "D", "Ṇ", "N", "Ḷ", "L",
+)
+
+// transliterate writes romanized Pali in another script: "deva"
+// (Devanagari), "sinh" (Sinhala) or "ascii" (without diacritics). Unknown
+// scripts leave the text as it is.
+func transliterate(s, script string) string {
+	if script == "ascii" {
+		return asciiPali.Replace(s)
+	}
+	target := scripts[script]
+	if target == nil {
+		return s
+	}
+
+	index := func(list []string, letter string) int {
+		for i, l := range list {
+			if l == letter {
+				return i
+			}
+		}
+		return -1
+	}
+
+	var b strings.Builder
+	letters := paliLetters(s)
+	for i := 0; i < len(letters); i++ {
+		letter := letters[i]
+		if c := index(paliConsonants, letter); c >= 0 {
+			b.WriteString(target.consonants[c])
+			if i+1 < len(letters) {
+				if v := index(paliVowels, letters[i+1]); v >= 0 {
+					b.WriteString(target.signs[v])
+					i++
+					continue
+				}
+			}
+			b.WriteString(target.virama)
+		} else if v := index(paliVowels, letter); v >= 0 {
+			b.WriteString(target.vowels[v])
+		} else if letter == "ṃ" {
+			b.WriteString(target.niggahita)
+		} else {
+			b.WriteString(letter)
+		}
+	}
+	return b.String()
+}
+
+// collectionNames are the titles of the corpus folders, by name without
+// their ordering prefix
+var collectionNames = map[string]string{
+	"tipit":  "Tipiṭaka",
+	"vin":    "Vinaya Piṭaka",
+	"sut":    "Sutta Piṭaka",
+	"digh":   "Dīgha Nikāya",
+	"majjh":  "Majjhima Nikāya",
+	"samyu":  "Saṃyutta Nikāya",
+	"angu":   "Aṅguttara Nikāya",
+	"khudd":  "Khuddaka Nikāya",
+	"abh":    "Abhidhamma Piṭaka",
+	"parcan": "Paracanonical texts",
+	"chron":  "Chronicles",
+	"comm":   "Commentaries",
+	"phil":   "Philology",
+	"gramm":  "Grammar",
+	"lex":    "Lexicography",
+	"rhet":   "Rhetoric",
+}
+
+// humanizePath names a corpus path for readers, like "Sutta Piṭaka › Dīgha
+// Nikāya › dighan1u" for "2_sut/1_digh/dighan1u.htm": folders lose their
+// ordering prefix and get their collection's title, texts their extension,
+// and a library its title.
+func humanizePath(p string) string {
+	var names []string
+	for i, segment := range strings.Split(strings.Trim(p, "/"), "/") {
+		if segment == "" {
+			continue
+		}
+		if title, ok := libraryTitle(segment); ok && i == 0 {
+			names = append(names, title)
+			continue
+		}
+		if path.Ext(segment) != "" {
+			names = append(names, textTitle(segment))
+			continue
+		}
+		name := segment
+		if prefix, rest, ok := strings.Cut(segment, "_"); ok && prefix != "" && strings.Trim(prefix, "0123456789") == "" {
+			name = rest
+		}
+		if title, ok := collectionNames[name]; ok {
+			name = title
+		} else {
+			r, size := utf8.DecodeRuneInString(name)
+			name = string(unicode.ToUpper(r)) + name[size:]
+		}
+		names = append(names, name)
+	}
+	return strings.Join(names, " › ")
+}
+
+// truncateWords shortens s to at most n runes, cutting at a word boundary
+// where there is one and adding an ellipsis if cut
+func truncateWords(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	cut := string(runes[:n])
+	if i := strings.LastIndexFunc(cut, unicode.IsSpace); i > 0 {
+		cut = cut[:i]
+	}
+	return strings.TrimRightFunc(cut, func(r rune) bool {
+		return unicode.IsSpace(r) || unicode.IsPunct(r)
+	}) + "…"
+}
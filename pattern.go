@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Pattern searches run a regular expression over the plain text of every
+// text, so they are kept within bounds: the pattern's length, how long one
+// may run and how many run at once. Go's regular expressions take time in
+// proportion to the text, whatever the pattern.
+const (
+	maxPatternLength = 256
+	patternTimeout   = 10 * time.Second
+	maxPatternHits   = 1000 // counted in one text
+)
+
+// patternSearches holds a slot for each pattern search running
+var patternSearches = make(chan struct{}, 2)
+
+// errPatternBusy is returned when as many pattern searches as allowed are
+// running already
+var errPatternBusy = errors.New("too many pattern searches are running; try again in a moment")
+
+// patternSearch finds the texts whose plain text matches the regular
+// expression, those with the most matches first, each with the paragraph
+// of its first match. Only texts for which keep returns true are read.
+// When the search runs out of time, the texts found so far are returned
+// along with complete false.
+func patternSearch(ctx context.Context, pattern string, keep func(path string) bool) (results []SearchResult, complete bool, err error) {
+	if len(pattern) > maxPatternLength {
+		return nil, false, fmt.Errorf("the pattern is longer than %d characters", maxPatternLength)
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid pattern: %w", err)
+	}
+	if re.MatchString("") {
+		return nil, false, errors.New("the pattern matches where there is nothing, so it would match everywhere")
+	}
+	select {
+	case patternSearches <- struct{}{}:
+		defer func() { <-patternSearches }()
+	default:
+		return nil, false, errPatternBusy
+	}
+	ctx, cancel := context.WithTimeout(ctx, patternTimeout)
+	defer cancel()
+
+	var paths []string
+	err = walkCorpus("", func(rel string, d fs.DirEntry) error {
+		if !d.IsDir() && strings.HasSuffix(strings.ToLower(d.Name()), ".htm") && keep(rel) {
+			paths = append(paths, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	// The texts are shared out to a worker per processor
+	work := make(chan string)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for range runtime.GOMAXPROCS(0) {
+		wg.Go(func() {
+			for path := range work {
+				if r, ok := matchText(re, path); ok {
+					mu.Lock()
+					results = append(results, r)
+					mu.Unlock()
+				}
+			}
+		})
+	}
+	complete = true
+feed:
+	for _, path := range paths {
+		select {
+		case work <- path:
+		case <-ctx.Done():
+			complete = false
+			break feed
+		}
+	}
+	close(work)
+	wg.Wait()
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Path < results[j].Path
+	})
+	return results, complete, nil
+}
+
+// matchText counts the matches of re in the paragraphs of the text at
+// path, up to maxPatternHits, and shows the first with its surroundings
+func matchText(re *regexp.Regexp, path string) (SearchResult, bool) {
+	content, err := fs.ReadFile(corpus, path)
+	if err != nil {
+		return SearchResult{}, false
+	}
+	result := SearchResult{Path: path}
+	hits := 0
+	for i, text := range paragraphs(path, string(content)) {
+		matches := re.FindAllStringIndex(text, maxPatternHits-hits)
+		if len(matches) == 0 {
+			continue
+		}
+		if hits == 0 {
+			result.Anchor = fmt.Sprintf("p%d", i)
+			result.Snippet, result.Marked = markMatches(text, matches)
+		}
+		if hits += len(matches); hits >= maxPatternHits {
+			break
+		}
+	}
+	result.Score = float64(hits)
+	return result, hits > 0
+}
+
+// patternContext is how many characters of a paragraph a pattern snippet
+// shows on either side of the first match
+const patternContext = 80
+
+// markMatches cuts the paragraph down to the surroundings of its first
+// match, as plain text and with the matches in it in bold
+func markMatches(text string, matches [][]int) (string, template.HTML) {
+	from := max(0, matches[0][0]-patternContext)
+	to := min(len(text), matches[0][1]+patternContext)
+	// Cut at spaces rather than inside words or characters
+	if i := strings.IndexByte(text[from:matches[0][0]], ' '); from > 0 && i >= 0 {
+		from += i + 1
+	}
+	if i := strings.LastIndexByte(text[matches[0][1]:to], ' '); to < len(text) && i >= 0 {
+		to = matches[0][1] + i
+	}
+	for from > 0 && !utf8.RuneStart(text[from]) {
+		from++
+	}
+	for to < len(text) && !utf8.RuneStart(text[to]) {
+		to--
+	}
+
+	var marked strings.Builder
+	if from > 0 {
+		marked.WriteString("… ")
+	}
+	at := from
+	for _, m := range matches {
+		if m[0] < at || m[1] > to {
+			continue
+		}
+		marked.WriteString(template.HTMLEscapeString(text[at:m[0]]))
+		marked.WriteString("<b>" + template.HTMLEscapeString(text[m[0]:m[1]]) + "</b>")
+		at = m[1]
+	}
+	marked.WriteString(template.HTMLEscapeString(text[at:to]))
+	plain := text[from:to]
+	if from > 0 {
+		plain = "… " + plain
+	}
+	if to < len(text) {
+		plain += " …"
+		marked.WriteString(" …")
+	}
+	return plain, template.HTML(marked.String())
+}
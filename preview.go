@@ -0,0 +1,89 @@
+package main
+
+import (
+	"io/fs"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Preview is what chat apps and social media show of a link to a text:
+// its opening lines, and the URL it is found at
+type Preview struct {
+	Description string
+	URL         string
+}
+
+const (
+	// previewChars is about how much of a text a preview quotes
+	previewChars = 200
+	// previewHeader is how many paragraphs into a text its header may run
+	previewHeader = 40
+)
+
+// textPreviews keeps the opening lines of the texts, while they are unchanged
+var textPreviews = struct {
+	sync.Mutex
+	texts map[string]textOpening // by corpus name
+}{texts: make(map[string]textOpening)}
+
+type textOpening struct {
+	modified time.Time
+	lines    string
+}
+
+// textPreview returns the preview of a page of the text at filePath, read
+// from the corpus as name, whose file info is given. A description the
+// folder's metadata gives the text comes before its opening lines.
+func textPreview(r *http.Request, filePath, name string, info fs.FileInfo, page int) *Preview {
+	p := &Preview{URL: baseURL(r) + "/read/" + slugPath(filePath)}
+	if page > 1 {
+		p.URL += "?page=" + strconv.Itoa(page)
+	}
+	if entry := treeEntry(filePath); entry != nil && entry.Description != "" {
+		p.Description = entry.Description
+		return p
+	}
+
+	textPreviews.Lock()
+	defer textPreviews.Unlock()
+	cached, ok := textPreviews.texts[name]
+	if !ok || !cached.modified.Equal(info.ModTime()) {
+		content, err := fs.ReadFile(corpus, name)
+		if err != nil {
+			return p
+		}
+		cached = textOpening{modified: info.ModTime(), lines: openingLines(name, string(content))}
+		textPreviews.texts[name] = cached
+	}
+	p.Description = cached.lines
+	return p
+}
+
+// openingLines returns the first lines of a text, past the notes of the
+// GRETIL header, which ends with a link to GRETIL's site
+func openingLines(name, content string) string {
+	paras := paragraphs(name, content)
+	start := 0
+	for i, p := range paras[:min(len(paras), previewHeader)] {
+		if strings.Contains(strings.ToLower(p), "gretil") {
+			start = i + 1
+		}
+	}
+	var b strings.Builder
+	for _, p := range paras[start:] {
+		if !containsLetter(p) {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(p)
+		if b.Len() >= previewChars {
+			break
+		}
+	}
+	return truncateRunes(b.String(), previewChars)
+}
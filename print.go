@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// PrintOptions are what a printed text may leave out: the PTS pages, in
+// the margin and the running header (?refs=off), and the page break before
+// each section (?breaks=off)
+type PrintOptions struct {
+	References bool
+	Breaks     bool
+}
+
+// handlePrint shows a text laid out for printing: a title page, the PTS
+// page in the running header, variant readings as footnotes and a page break
+// before each sutta or vagga
+func handlePrint(w http.ResponseWriter, r *http.Request) {
+	filePath := strings.TrimPrefix(r.URL.Path, "/print/")
+	name, err := corpusName(filePath)
+	if err != nil {
+		httpError(w, r, "Invalid path", http.StatusBadRequest)
+		return
+	}
+	info, err := fs.Stat(corpus, name)
+	if err != nil || info.IsDir() {
+		httpError(w, r, "File not found", http.StatusNotFound)
+		return
+	}
+	content, err := fs.ReadFile(corpus, name)
+	if err != nil {
+		httpError(w, r, "Cannot read file", http.StatusInternalServerError)
+		return
+	}
+
+	opts := PrintOptions{
+		References: r.FormValue("refs") != "off",
+		Breaks:     r.FormValue("breaks") != "off",
+	}
+	body := printContent(filePath, extractBody(string(content)), opts)
+	if s, err := userSettings(r); err == nil {
+		body = s.anusvara().convertHTML(body)
+	}
+	data := PageData{
+		Title:       displayTitle(filePath),
+		Content:     template.HTML(body),
+		CurrentPath: filePath,
+		Editions:    editionLinks(filePath),
+		Print:       &opts,
+	}
+	err = templatesFor(r).ExecuteTemplate(w, "print", data)
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+var (
+	// variantLine is a line of the variant readings following a paragraph,
+	// like "2. Mama ca. Machasaṃ."
+	variantLine = regexp.MustCompile(`^(\d+)\.\s+\S`)
+
+	// variantMarker is a note number stuck to the word it is about, as in
+	// "sajitā1"
+	variantMarker = regexp.MustCompile(`(\pL)(\d{1,2})\b`)
+
+	// lineBreak splits a paragraph into its lines
+	lineBreak = regexp.MustCompile(`(?i)<br\s*/?>`)
+)
+
+// sectionEndings are the last words of the headings a printed text breaks
+// the page before
+var sectionEndings = []string{"suttaṃ", "sutta", "vaggo", "vagga", "nipāto", "saṃyuttaṃ", "kaṇḍaṃ", "paṇṇāsakaṃ"}
+
+// printParagraph is a paragraph of a text as plain lines, its reference
+// markers taken out
+type printParagraph struct {
+	lines []string
+	pts   []string // PTS pages starting in the paragraph
+}
+
+// printContent lays out the body of the text at path for print
+func printContent(path, body string, opts PrintOptions) string {
+	refs := referencePatterns(path)
+	var paragraphs []printParagraph
+	volume := ""
+	for _, part := range paragraphBreak.Split(body, -1) {
+		var p printParagraph
+		for _, line := range lineBreak.Split(part, -1) {
+			var kept strings.Builder
+			last := 0
+			for _, ref := range findReferences(refs, line) {
+				kept.WriteString(line[last:ref.start])
+				last = ref.end
+				switch marker := line[ref.start:ref.end]; {
+				case ref.volume != "":
+					volume = ptsVolumeName(ref.volume)
+				case ref.page != "":
+					p.pts = append(p.pts, strings.TrimSpace(volume+" "+strings.TrimLeft(ref.page, "0")))
+				case marker == "[PTS.]" || marker == "[PTS]":
+					// Sigla of the variant readings stay
+					kept.WriteString(marker)
+				}
+			}
+			kept.WriteString(line[last:])
+			line = strings.Join(strings.Fields(html.UnescapeString(tagPattern.ReplaceAllString(kept.String(), ""))), " ")
+			if line != "" {
+				p.lines = append(p.lines, line)
+			}
+		}
+		if len(p.lines) > 0 || len(p.pts) > 0 {
+			paragraphs = append(paragraphs, p)
+		}
+	}
+
+	var b strings.Builder
+	sections := 0
+	for i := 0; i < len(paragraphs); i++ {
+		p := paragraphs[i]
+		for _, page := range p.pts {
+			if opts.References {
+				fmt.Fprintf(&b, `<span class="pts">%s</span>`, template.HTMLEscapeString(page))
+			}
+		}
+		if len(p.lines) == 0 {
+			continue
+		}
+
+		if isSectionHeading(p.lines) {
+			class := "section"
+			if sections == 0 {
+				class = "section first"
+			} else if !opts.Breaks {
+				class = "section run-on"
+			}
+			sections++
+			fmt.Fprintf(&b, "<h2 class=\"%s\">%s</h2>\n", class, template.HTMLEscapeString(p.lines[0]))
+			continue
+		}
+
+		// Variant readings follow the paragraph they are about, which marks
+		// the words they are about with their numbers
+		text := template.HTMLEscapeString(strings.Join(p.lines, " "))
+		var notes []string
+		if i+1 < len(paragraphs) && isVariantList(paragraphs[i+1].lines) {
+			numbers := make(map[string]bool)
+			for _, line := range paragraphs[i+1].lines {
+				numbers[variantLine.FindStringSubmatch(line)[1]] = true
+			}
+			marked := variantMarker.ReplaceAllStringFunc(text, func(m string) string {
+				sub := variantMarker.FindStringSubmatch(m)
+				if !numbers[sub[2]] {
+					return m
+				}
+				return fmt.Sprintf(`%s<sup class="note-ref"><a href="#v%d-%s">%s</a></sup>`, sub[1], i, sub[2], sub[2])
+			})
+			if marked != text {
+				text, notes = marked, paragraphs[i+1].lines
+			}
+		}
+		fmt.Fprintf(&b, "<p>%s</p>\n", text)
+
+		if len(notes) > 0 {
+			b.WriteString(`<ol class="variants">`)
+			for _, line := range notes {
+				number := variantLine.FindStringSubmatch(line)[1]
+				n, _ := strconv.Atoi(number)
+				reading := strings.TrimSpace(strings.TrimPrefix(line, number+"."))
+				fmt.Fprintf(&b, `<li id="v%d-%s" value="%d">%s</li>`, i, number, n, template.HTMLEscapeString(reading))
+			}
+			b.WriteString("</ol>\n")
+			i++
+		}
+	}
+	return b.String()
+}
+
+// ptsVolumeName writes a volume of the PTS references, like "D - 1", the
+// way it is cited: "D I"
+func ptsVolumeName(ref string) string {
+	fields := strings.Fields(strings.ReplaceAll(ref, "-", " "))
+	if len(fields) == 0 {
+		return ""
+	}
+	if n, err := strconv.Atoi(fields[len(fields)-1]); err == nil && n > 0 && n <= 10 {
+		fields[len(fields)-1] = []string{"I", "II", "III", "IV", "V", "VI", "VII", "VIII", "IX", "X"}[n-1]
+	}
+	return strings.Join(fields, " ")
+}
+
+// isSectionHeading reports whether a paragraph is the title of a sutta,
+// vagga or other section: one short line ending in such a word
+func isSectionHeading(lines []string) bool {
+	if len(lines) != 1 {
+		return false
+	}
+	words := strings.Fields(strings.TrimRight(lines[0], ".: "))
+	if len(words) == 0 || len(words) > 6 {
+		return false
+	}
+	last := strings.ToLower(words[len(words)-1])
+	for _, ending := range sectionEndings {
+		if strings.HasSuffix(last, ending) {
+			return true
+		}
+	}
+	return false
+}
+
+// isVariantList reports whether a paragraph holds only numbered variant
+// readings
+func isVariantList(lines []string) bool {
+	if len(lines) == 0 {
+		return false
+	}
+	for _, line := range lines {
+		if !variantLine.MatchString(line) {
+			return false
+		}
+	}
+	return true
+}
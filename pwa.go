@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io/fs"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// serviceWorkerContent keeps the pages read, and those saved, for reading
+// offline. handleServiceWorker sets base, shell and version before it.
+//
+//go:embed static/sw.js
+var serviceWorkerContent string
+
+// maxOfflineTexts caps how many texts of a folder can be saved for offline
+// reading at once
+const maxOfflineTexts = 1000
+
+// wheelIcon draws the app icon, a Dhamma wheel of eight spokes, size pixels
+// square
+func wheelIcon(size int) []byte {
+	background := color.RGBA{0x8B, 0x45, 0x13, 0xFF} // --primary-color
+	wheel := color.RGBA{0xFD, 0xF5, 0xE6, 0xFF}      // --background-color
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	c := float64(size) / 2
+	for y := range size {
+		for x := range size {
+			dx, dy := float64(x)+0.5-c, float64(y)+0.5-c
+			r := math.Hypot(dx, dy) / c
+			// Distance from the nearest spoke, in radii
+			angle := math.Mod(math.Atan2(dy, dx)+2*math.Pi, math.Pi/4)
+			spoke := math.Min(angle, math.Pi/4-angle) * r
+			inside := r > 0.58 && r < 0.7 || // rim
+				r < 0.14 || // hub
+				r < 0.6 && spoke < 0.035 || // spokes
+				r < 0.78 && r > 0.68 && spoke < 0.05 // knobs on the rim
+			img.Set(x, y, background)
+			if inside {
+				img.Set(x, y, wheel)
+			}
+		}
+	}
+	var b bytes.Buffer
+	png.Encode(&b, img)
+	return b.Bytes()
+}
+
+// handleManifest serves the web app manifest, so that the reader can be
+// installed on a phone or desktop
+func handleManifest(w http.ResponseWriter, r *http.Request) {
+	base := sitePath("")
+	manifest := map[string]any{
+		"name":             "Pali Reader",
+		"short_name":       "Pali Reader",
+		"description":      "Pali texts from GRETIL, interlinked with the Digital Pali Dictionary",
+		"start_url":        base + "/",
+		"scope":            base + "/",
+		"display":          "standalone",
+		"background_color": "#FDF5E6",
+		"theme_color":      "#8B4513",
+		"icons": []map[string]string{
+			{"src": base + assetPath("icon-192.png"), "sizes": "192x192", "type": "image/png"},
+			{"src": base + assetPath("icon-512.png"), "sizes": "512x512", "type": "image/png", "purpose": "any maskable"},
+		},
+	}
+	w.Header().Set("Content-Type", "application/manifest+json")
+	json.NewEncoder(w).Encode(manifest)
+}
+
+// handleServiceWorker serves the service worker, with the assets of this
+// version of the reader to keep. A new version changes it, which has
+// browsers install it afresh.
+func handleServiceWorker(w http.ResponseWriter, r *http.Request) {
+	base := sitePath("")
+	shell := []string{base + "/"}
+	for _, name := range []string{"style.css", "reader.js", "icon-192.png"} {
+		shell = append(shell, base+assetPath(name))
+	}
+	list, _ := json.Marshal(shell)
+	sum := sha256.Sum256(list)
+	w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	fmt.Fprintf(w, "var base = %q;\nvar shell = %s;\nvar version = %q;\n%s",
+		base, list, hex.EncodeToString(sum[:6]), serviceWorkerContent)
+}
+
+// OfflineList is what to fetch to read the texts of a folder offline
+type OfflineList struct {
+	URLs      []string `json:"urls"` // every page of every text
+	Texts     int      `json:"texts"`
+	Truncated bool     `json:"truncated"` // whether the folder has more than maxOfflineTexts
+}
+
+// handleOffline lists the pages of the texts below a folder, for the reader
+// script to save them for reading offline
+func handleOffline(w http.ResponseWriter, r *http.Request) {
+	filePath := strings.TrimPrefix(r.URL.Path, "/offline/")
+	if _, err := corpusName(filePath); err != nil {
+		writeAPIError(w, r, http.StatusBadRequest, "Invalid path", nil)
+		return
+	}
+	list := OfflineList{URLs: []string{}}
+	err := walkCorpus(filePath, func(rel string, d fs.DirEntry) error {
+		if !canRead(r, rel) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			list.URLs = append(list.URLs, sitePath("/read/"+slugPath(rel)))
+			return nil
+		}
+		if !strings.HasSuffix(strings.ToLower(d.Name()), ".htm") {
+			return nil
+		}
+		if list.Texts == maxOfflineTexts {
+			list.Truncated = true
+			return fs.SkipAll
+		}
+		starts, err := textPageStarts(rel)
+		if err != nil {
+			return err
+		}
+		url := sitePath("/read/" + slugPath(rel))
+		list.URLs = append(list.URLs, url)
+		for page := 2; page <= len(starts); page++ {
+			list.URLs = append(list.URLs, url+"?page="+strconv.Itoa(page))
+		}
+		list.Texts++
+		return nil
+	})
+	if err != nil {
+		writeAPIError(w, r, http.StatusNotFound, "Folder not found", nil)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// QuotaConfig caps how many of the heaviest operations one user may run in
+// a while, so that nobody monopolizes a shared server. Logged-in users are
+// counted by name and the others by IP address. A nil quota sets no cap.
+type QuotaConfig struct {
+	Ask      *Quota `json:"ask"`      // questions to the language model
+	Export   *Quota `json:"export"`   // flashcard and glossary downloads
+	Analysis *Quota `json:"analysis"` // corpus-wide searches and occurrence lists
+	Speech   *Quota `json:"speech"`   // paragraphs synthesized for the Listen button
+}
+
+// Quota allows Limit operations in every window of Hours
+type Quota struct {
+	Limit int `json:"limit"`
+	Hours int `json:"hours"` // 24 if unset
+}
+
+// What the operations of each quota are called in messages
+var quotaNouns = map[string]string{
+	"ask":      "questions",
+	"export":   "exports",
+	"analysis": "corpus-wide searches",
+	"speech":   "paragraphs read aloud",
+}
+
+// quotaWindow counts what one user ran in the window ending at Reset
+type quotaWindow struct {
+	Used  int       `json:"used"`
+	Reset time.Time `json:"reset"`
+}
+
+// quotaUse holds the windows by kind and user. It is kept in the state
+// store, so that a restart does not hand out new quotas and replicas
+// sharing the store count together.
+var quotaUse = &jsonFile[map[string]quotaWindow]{name: "quotas.json"}
+
+// quotaFor returns the quota of a kind of operation, or nil
+func quotaFor(kind string) *Quota {
+	q := config.Quotas
+	if q == nil {
+		return nil
+	}
+	switch kind {
+	case "ask":
+		return q.Ask
+	case "export":
+		return q.Export
+	case "analysis":
+		return q.Analysis
+	case "speech":
+		return q.Speech
+	}
+	return nil
+}
+
+// quotaError is the feedback for a user who has used up a quota
+type quotaError struct {
+	kind  string
+	quota *Quota
+	reset time.Time
+}
+
+func (e *quotaError) Error() string {
+	return fmt.Sprintf("You have used up your %d %s per %d hours; more are allowed from %s UTC.",
+		e.quota.Limit, quotaNouns[e.kind], e.quota.Hours, e.reset.UTC().Format("Jan 2 15:04"))
+}
+
+// quotaKey names the window of kind for the user of the request
+func quotaKey(r *http.Request, kind string) string {
+	user := currentUser(r)
+	if user == "" {
+		user = "ip:" + clientIP(r, config.RateLimit.proxyHops())
+	}
+	return kind + "\x00" + user
+}
+
+// takeQuota counts one operation of kind against the user of the request
+// and reports what is left in the RateLimit headers. Once the quota is used
+// up it returns a *quotaError and sets Retry-After; the caller answers with
+// 429 Too Many Requests. An operation that then fails is given back with
+// refundQuota.
+func takeQuota(w http.ResponseWriter, r *http.Request, kind string) error {
+	q := quotaFor(kind)
+	if q == nil {
+		return nil
+	}
+	key := quotaKey(r, kind)
+	now := time.Now()
+
+	var qw quotaWindow
+	var exhausted bool
+	err := quotaUse.Update(func(windows *map[string]quotaWindow) error {
+		if *windows == nil {
+			*windows = make(map[string]quotaWindow)
+		}
+		// Windows that are over are dropped, so the store does not grow
+		// with every address that ever called
+		for k, old := range *windows {
+			if !now.Before(old.Reset) {
+				delete(*windows, k)
+			}
+		}
+		qw = (*windows)[key]
+		if qw.Reset.IsZero() {
+			qw.Reset = now.Add(time.Duration(q.Hours) * time.Hour)
+		}
+		if exhausted = qw.Used >= q.Limit; !exhausted {
+			qw.Used++
+		}
+		(*windows)[key] = qw
+		return nil
+	})
+	if err != nil {
+		// A store that cannot be reached does not stop anyone reading
+		logf(r.Context(), "Error counting %s quota for %q: %v", kind, key[len(kind)+1:], err)
+		return nil
+	}
+
+	seconds := strconv.Itoa(int(math.Ceil(qw.Reset.Sub(now).Seconds())))
+	h := w.Header()
+	h.Set("RateLimit-Limit", strconv.Itoa(q.Limit))
+	h.Set("RateLimit-Reset", seconds)
+	if exhausted {
+		h.Set("RateLimit-Remaining", "0")
+		h.Set("Retry-After", seconds)
+		return &quotaError{kind: kind, quota: q, reset: qw.Reset}
+	}
+	h.Set("RateLimit-Remaining", strconv.Itoa(q.Limit-qw.Used))
+	return nil
+}
+
+// refundQuota gives back the operation of kind that takeQuota counted for
+// the request, when it failed and the user got nothing for it
+func refundQuota(r *http.Request, kind string) {
+	if quotaFor(kind) == nil {
+		return
+	}
+	key := quotaKey(r, kind)
+	err := quotaUse.Update(func(windows *map[string]quotaWindow) error {
+		if qw, ok := (*windows)[key]; ok && qw.Used > 0 && time.Now().Before(qw.Reset) {
+			qw.Used--
+			(*windows)[key] = qw
+		}
+		return nil
+	})
+	if err != nil {
+		logf(r.Context(), "Error refunding %s quota for %q: %v", kind, key[len(kind)+1:], err)
+	}
+}
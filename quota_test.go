@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTakeQuota(t *testing.T) {
+	useConfig(t, Config{DataDir: t.TempDir(), Quotas: &QuotaConfig{Ask: &Quota{Limit: 2, Hours: 1}}})
+	saved := quotaUse
+	quotaUse = &jsonFile[map[string]quotaWindow]{name: "quotas.json"}
+	t.Cleanup(func() { quotaUse = saved })
+
+	take := func(kind, remote string) (*httptest.ResponseRecorder, error) {
+		r := httptest.NewRequest(http.MethodGet, "/ask", nil)
+		r.RemoteAddr = remote
+		w := httptest.NewRecorder()
+		return w, takeQuota(w, r, kind)
+	}
+	for i, remaining := range []string{"1", "0"} {
+		w, err := take("ask", "192.0.2.1:1234")
+		if err != nil {
+			t.Fatalf("question %d of 2: %v", i+1, err)
+		}
+		if got := w.Header().Get("RateLimit-Remaining"); got != remaining {
+			t.Errorf("RateLimit-Remaining after question %d = %q; want %q", i+1, got, remaining)
+		}
+	}
+
+	w, err := take("ask", "192.0.2.1:5678")
+	var qe *quotaError
+	if !errors.As(err, &qe) {
+		t.Fatalf("third question = %v; want a quota error", err)
+	}
+	if retry := w.Header().Get("Retry-After"); retry != "3600" {
+		t.Errorf("Retry-After = %q; want 3600", retry)
+	}
+	if want := "You have used up your 2 questions per 1 hours"; !strings.HasPrefix(err.Error(), want) {
+		t.Errorf("error = %q; want it to start %q", err, want)
+	}
+
+	// A question that failed is given back
+	r := httptest.NewRequest(http.MethodGet, "/ask", nil)
+	r.RemoteAddr = "192.0.2.1:1234"
+	refundQuota(r, "ask")
+	if w, err := take("ask", "192.0.2.1:1234"); err != nil || w.Header().Get("RateLimit-Remaining") != "0" {
+		t.Errorf("question after a refund = %v, RateLimit-Remaining %q; want 0 left", err, w.Header().Get("RateLimit-Remaining"))
+	}
+
+	// What was used outlasts a restart
+	quotaUse = &jsonFile[map[string]quotaWindow]{name: "quotas.json"}
+	if _, err := take("ask", "192.0.2.1:1234"); !errors.As(err, &qe) {
+		t.Errorf("question after a restart = %v; want a quota error", err)
+	}
+
+	if _, err := take("ask", "192.0.2.2:1234"); err != nil {
+		t.Errorf("another address's question: %v", err)
+	}
+	if w, err := take("export", "192.0.2.1:1234"); err != nil || w.Header().Get("RateLimit-Limit") != "" {
+		t.Errorf("an export with no quota = %v, RateLimit-Limit %q", err, w.Header().Get("RateLimit-Limit"))
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"io/fs"
+	"math/rand/v2"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// handleRandom redirects to a random text, optionally one below the folder
+// given with ?in=. Given a text, it picks from the text's folder.
+func handleRandom(w http.ResponseWriter, r *http.Request) {
+	dir := strings.Trim(r.URL.Query().Get("in"), "/")
+	name, err := corpusName(dir)
+	if err != nil {
+		httpError(w, r, "Invalid path", http.StatusBadRequest)
+		return
+	}
+	if info, err := fs.Stat(corpus, name); err == nil && !info.IsDir() {
+		name = path.Dir(name)
+	}
+	if name == "." {
+		name = ""
+	}
+
+	var texts []string
+	err = walkCorpus(name, func(rel string, d fs.DirEntry) error {
+		if !d.IsDir() && strings.HasSuffix(strings.ToLower(d.Name()), ".htm") && canRead(r, rel) {
+			texts = append(texts, rel)
+		}
+		return nil
+	})
+	if err != nil || len(texts) == 0 {
+		httpError(w, r, "No texts found", http.StatusNotFound)
+		return
+	}
+
+	// Never cache, so every visit picks again
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, sitePath("/read/")+slugPath(texts[rand.IntN(len(texts))]), http.StatusFound)
+}
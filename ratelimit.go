@@ -0,0 +1,145 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimitConfig limits how fast one client may call the expensive
+// endpoints, with a token bucket per IP address: Burst requests at once,
+// then PerMinute a minute. TrustProxy takes the client's address from
+// X-Forwarded-For, for servers behind a reverse proxy; ProxyHops says how
+// many proxies there are when more than one adds to the header.
+type RateLimitConfig struct {
+	PerMinute  int      `json:"perMinute"`
+	Burst      int      `json:"burst"`
+	Paths      []string `json:"paths"` // a trailing / covers everything below
+	TrustProxy bool     `json:"trustProxy"`
+	ProxyHops  int      `json:"proxyHops"` // 1 if unset with trustProxy
+}
+
+// proxyHops returns how many of the X-Forwarded-For entries, counting from
+// the right, our own proxies added, or 0 to go by the connection's address
+func (c *RateLimitConfig) proxyHops() int {
+	if c == nil || !c.TrustProxy {
+		return 0
+	}
+	return max(c.ProxyHops, 1)
+}
+
+// defaultRateLimitPaths are the endpoints that read or search whole texts,
+// or call out to other servers
+var defaultRateLimitPaths = []string{
+	"/read/", "/print/", "/glossary/", "/listen/", "/search", "/reverse", "/occurrences",
+	"/ask", "/export/", "/dict/", "/login",
+}
+
+// tokenBucket holds the requests a client may still make
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter keeps a token bucket per client
+type rateLimiter struct {
+	perSecond float64
+	burst     float64
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+// allow takes a token from the client's bucket. If there is none it
+// returns how long until there is.
+func (l *rateLimiter) allow(client string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.buckets[client]
+	if b == nil {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[client] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.perSecond)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / l.perSecond * float64(time.Second))
+}
+
+// prune forgets the clients whose buckets have filled up again
+func (l *rateLimiter) prune(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	full := time.Duration(l.burst / l.perSecond * float64(time.Second))
+	for client, b := range l.buckets {
+		if now.Sub(b.last) > full {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+// clientIP returns the address a request is rate limited by. Behind hops
+// proxies it is the entry of X-Forwarded-For the farthest of them added:
+// the client can write anything to the left of it, so as not to get a new
+// bucket with every request.
+func clientIP(r *http.Request, hops int) string {
+	if hops > 0 {
+		var forwarded []string
+		for _, v := range r.Header.Values("X-Forwarded-For") {
+			for e := range strings.SplitSeq(v, ",") {
+				forwarded = append(forwarded, strings.TrimSpace(e))
+			}
+		}
+		if len(forwarded) >= hops && forwarded[len(forwarded)-hops] != "" {
+			return forwarded[len(forwarded)-hops]
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// withRateLimit turns clients away with 429 Too Many Requests once they
+// call the limited paths faster than the config allows
+func withRateLimit(c *RateLimitConfig, h http.Handler) http.Handler {
+	l := &rateLimiter{
+		perSecond: float64(c.PerMinute) / 60,
+		burst:     float64(c.Burst),
+		buckets:   make(map[string]*tokenBucket),
+	}
+	go func() {
+		for now := range time.Tick(time.Minute) {
+			l.prune(now)
+		}
+	}()
+
+	limited := func(p string) bool {
+		for _, prefix := range c.Paths {
+			if p == prefix || (strings.HasSuffix(prefix, "/") && strings.HasPrefix(p, prefix)) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if limited(r.URL.Path) {
+			if ok, wait := l.allow(clientIP(r, c.proxyHops()), time.Now()); !ok {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				httpError(w, r, "Too many requests, slow down", http.StatusTooManyRequests)
+				return
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := &rateLimiter{perSecond: 1, burst: 2, buckets: make(map[string]*tokenBucket)}
+	now := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+
+	for i := range 2 {
+		if ok, _ := l.allow("a", now); !ok {
+			t.Fatalf("request %d of a burst of 2 refused", i+1)
+		}
+	}
+	if ok, wait := l.allow("a", now); ok || wait != time.Second {
+		t.Errorf("third request at once = %v, wait %v; want refused for 1s", ok, wait)
+	}
+	if ok, _ := l.allow("b", now); !ok {
+		t.Error("another client's first request refused")
+	}
+	if ok, _ := l.allow("a", now.Add(time.Second)); !ok {
+		t.Error("request after the bucket refilled refused")
+	}
+
+	// Buckets are never fuller than the burst
+	if ok, _ := l.allow("b", now.Add(time.Hour)); !ok {
+		t.Fatal("request after an hour refused")
+	}
+	if ok, _ := l.allow("b", now.Add(time.Hour)); !ok {
+		t.Error("second request after an hour refused")
+	}
+	if ok, _ := l.allow("b", now.Add(time.Hour)); ok {
+		t.Error("third request after an hour allowed beyond the burst")
+	}
+
+	l.prune(now.Add(time.Hour))
+	if _, ok := l.buckets["a"]; ok {
+		t.Error("a full bucket was not pruned")
+	}
+	if _, ok := l.buckets["b"]; !ok {
+		t.Error("a bucket in use was pruned")
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		remote, forwarded string
+		hops              int
+		ip                string
+	}{
+		{"192.0.2.1:1234", "", 0, "192.0.2.1"},
+		{"[2001:db8::1]:1234", "", 0, "2001:db8::1"},
+		{"192.0.2.1:1234", "198.51.100.7", 0, "192.0.2.1"},
+		{"192.0.2.1:1234", "198.51.100.7", 1, "198.51.100.7"},
+		{"192.0.2.1:1234", "", 1, "192.0.2.1"},
+		// The client writes what comes before the entry of our proxy
+		{"192.0.2.1:1234", "203.0.113.9, 198.51.100.7", 1, "198.51.100.7"},
+		{"192.0.2.1:1234", "203.0.113.9, 198.51.100.7, 192.0.2.50", 2, "198.51.100.7"},
+		{"192.0.2.1:1234", "198.51.100.7", 2, "192.0.2.1"},
+		{"192.0.2.1:1234", "198.51.100.7,", 1, "192.0.2.1"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/search", nil)
+		r.RemoteAddr = tt.remote
+		if tt.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", tt.forwarded)
+		}
+		if ip := clientIP(r, tt.hops); ip != tt.ip {
+			t.Errorf("clientIP(%s, X-Forwarded-For %q, %d hops) = %q; want %q",
+				tt.remote, tt.forwarded, tt.hops, ip, tt.ip)
+		}
+	}
+
+	// Headers repeated by the proxies count as one list
+	r := httptest.NewRequest(http.MethodGet, "/search", nil)
+	r.Header.Add("X-Forwarded-For", "203.0.113.9")
+	r.Header.Add("X-Forwarded-For", "198.51.100.7")
+	if ip := clientIP(r, 1); ip != "198.51.100.7" {
+		t.Errorf("clientIP with two headers = %q; want 198.51.100.7", ip)
+	}
+}
+
+func TestWithRateLimit(t *testing.T) {
+	c := &RateLimitConfig{PerMinute: 1, Burst: 1, Paths: []string{"/search", "/read/"}}
+	handler := withRateLimit(c, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	if w := get("/search"); w.Code != http.StatusOK {
+		t.Fatalf("first search = %d", w.Code)
+	}
+	w := get("/read/x.htm")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("second limited request = %d; want %d", w.Code, http.StatusTooManyRequests)
+	}
+	if retry := w.Header().Get("Retry-After"); retry != "60" {
+		t.Errorf("Retry-After = %q; want 60", retry)
+	}
+	for _, path := range []string{"/", "/searches", "/read"} {
+		if w := get(path); w.Code != http.StatusOK {
+			t.Errorf("GET %s, which is not limited, = %d", path, w.Code)
+		}
+	}
+}
+
+func TestRateLimitBehindProxy(t *testing.T) {
+	c := &RateLimitConfig{PerMinute: 1, Burst: 1, Paths: []string{"/search"}, TrustProxy: true}
+	handler := withRateLimit(c, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	// A made-up X-Forwarded-For does not get a client a new bucket
+	for i, spoofed := range []string{"", "203.0.113.1", "203.0.113.2, 203.0.113.3"} {
+		forwarded := "198.51.100.7"
+		if spoofed != "" {
+			forwarded = spoofed + ", " + forwarded
+		}
+		r := httptest.NewRequest(http.MethodGet, "/search", nil)
+		r.Header.Set("X-Forwarded-For", forwarded)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if want := http.StatusTooManyRequests; i > 0 && w.Code != want {
+			t.Errorf("search with X-Forwarded-For %q = %d; want %d", forwarded, w.Code, want)
+		}
+	}
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ReadingDay records what was read on one day: for each text, the pages
+// read, by number
+type ReadingDay struct {
+	Texts map[string]map[int]*PageReading `json:"texts"`
+}
+
+// PageReading is the time spent on one page of a text and how many words
+// it has
+type PageReading struct {
+	Seconds int `json:"seconds"`
+	Words   int `json:"words"`
+}
+
+// readingLog holds what was read by day, like 2006-01-02 in the server's
+// time zone
+var readingLog = &userFile[map[string]*ReadingDay]{name: "reading.json"}
+
+const (
+	// maxReadingReport caps the seconds one report of the reader script
+	// adds, which it sends every minute
+	maxReadingReport = 5 * 60
+	// minPageSeconds is how long a page must be open on a day for its words
+	// to count as read
+	minPageSeconds = 30
+	// statsDays is how many days the stats page charts
+	statsDays = 30
+)
+
+// dayKey names the day t falls on in the server's time zone
+func dayKey(t time.Time) string {
+	return t.Local().Format(time.DateOnly)
+}
+
+// handleReading records time spent reading a page, reported by the reader
+// script while the text is open and the reader active
+func handleReading(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeAPIError(w, r, http.StatusMethodNotAllowed, "Method not allowed", map[string]string{"allow": http.MethodPost})
+		return
+	}
+
+	name, _, _ := strings.Cut(sourcePath(r.FormValue("source")), "#")
+	if !textExists(name) || !canRead(r, name) {
+		writeAPIError(w, r, http.StatusBadRequest, "Unknown text", map[string]string{"field": "source"})
+		return
+	}
+	seconds, err := strconv.Atoi(r.FormValue("seconds"))
+	if err != nil || seconds <= 0 {
+		writeAPIError(w, r, http.StatusBadRequest, "Missing seconds", map[string]string{"field": "seconds"})
+		return
+	}
+	page, _ := strconv.Atoi(r.FormValue("page"))
+	words, _ := strconv.Atoi(r.FormValue("words"))
+
+	day := dayKey(time.Now())
+	err = readingLog.of(r).Update(func(days *map[string]*ReadingDay) error {
+		if *days == nil {
+			*days = make(map[string]*ReadingDay)
+		}
+		d := (*days)[day]
+		if d == nil {
+			d = &ReadingDay{Texts: make(map[string]map[int]*PageReading)}
+			(*days)[day] = d
+		}
+		if d.Texts[name] == nil {
+			d.Texts[name] = make(map[int]*PageReading)
+		}
+		p := d.Texts[name][max(page, 1)]
+		if p == nil {
+			p = &PageReading{}
+			d.Texts[name][max(page, 1)] = p
+		}
+		p.Seconds += min(seconds, maxReadingReport)
+		p.Words = max(p.Words, min(words, 1<<20))
+		return nil
+	})
+	if err != nil {
+		logf(r.Context(), "Error recording reading: %v", err)
+		writeAPIError(w, r, http.StatusInternalServerError, "Cannot record reading", nil)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ReadingStats sums up the reading log and the lookups
+type ReadingStats struct {
+	Texts         int // texts read
+	Words         int // words of the pages read, counted again on every day read
+	Time          string
+	Lookups       int
+	UniqueLookups int // distinct words looked up
+	LongestStreak int // most days in a row with reading or lookups
+	CurrentStreak int // days in a row up to today, or yesterday
+	Days          []StatsDay
+	TopTexts      []StatsText
+}
+
+// StatsDay is one day of the chart of the stats page
+type StatsDay struct {
+	Date    string
+	Time    string
+	Words   int
+	Lookups int
+	Percent int // of the longest time read in a day of the chart
+}
+
+// StatsText is one of the texts read longest
+type StatsText struct {
+	Path  string
+	Title string
+	Time  string
+	Words int
+}
+
+// topStatsTexts is how many of the texts read longest the stats page lists
+const topStatsTexts = 10
+
+// readingStats sums up what was read and looked up, with the last days up
+// to today, the latest first
+func readingStats(r *http.Request, today time.Time) (*ReadingStats, error) {
+	stats := &ReadingStats{}
+	seconds := make(map[string]int) // by day
+	words := make(map[string]int)
+	textSeconds := make(map[string]int)
+	textWords := make(map[string]int)
+	err := readingLog.of(r).Read(func(days *map[string]*ReadingDay) {
+		for day, d := range *days {
+			for name, pages := range d.Texts {
+				if !canRead(r, name) {
+					continue
+				}
+				for _, p := range pages {
+					seconds[day] += p.Seconds
+					textSeconds[name] += p.Seconds
+					if p.Seconds >= minPageSeconds {
+						words[day] += p.Words
+						textWords[name] += p.Words
+					}
+				}
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	lookupsByDay := make(map[string]int)
+	looked := make(map[string]bool)
+	err = lookups.of(r).Read(func(list *[]Lookup) {
+		for _, l := range *list {
+			lookupsByDay[dayKey(l.Time)]++
+			looked[l.Word] = true
+		}
+		stats.Lookups = len(*list)
+	})
+	if err != nil {
+		return nil, err
+	}
+	stats.UniqueLookups = len(looked)
+
+	total := 0
+	for name, s := range textSeconds {
+		total += s
+		stats.Words += textWords[name]
+	}
+	stats.Texts = len(textSeconds)
+	stats.Time = readingTime(total)
+
+	// Streaks of days with any reading or lookups
+	active := make(map[string]bool)
+	for day, s := range seconds {
+		active[day] = s > 0
+	}
+	for day := range lookupsByDay {
+		active[day] = true
+	}
+	stats.LongestStreak, stats.CurrentStreak = streaks(active, today)
+
+	longest := 0
+	for i := range statsDays {
+		longest = max(longest, seconds[dayKey(today.AddDate(0, 0, -i))])
+	}
+	for i := range statsDays {
+		day := dayKey(today.AddDate(0, 0, -i))
+		d := StatsDay{
+			Date:    today.AddDate(0, 0, -i).Local().Format("Mon 2 Jan"),
+			Time:    readingTime(seconds[day]),
+			Words:   words[day],
+			Lookups: lookupsByDay[day],
+		}
+		if longest > 0 {
+			d.Percent = seconds[day] * 100 / longest
+		}
+		stats.Days = append(stats.Days, d)
+	}
+
+	names := make([]string, 0, len(textSeconds))
+	for name := range textSeconds {
+		names = append(names, name)
+	}
+	for _, name := range mostCounted(names, textSeconds, topStatsTexts) {
+		stats.TopTexts = append(stats.TopTexts, StatsText{
+			Path:  name,
+			Title: displayTitle(name),
+			Time:  readingTime(textSeconds[name]),
+			Words: textWords[name],
+		})
+	}
+	return stats, nil
+}
+
+// streaks returns the most days in a row that were active, and how many
+// are in a row up to today, or up to yesterday while today is not yet
+func streaks(active map[string]bool, today time.Time) (longest, current int) {
+	var days []string
+	for day, ok := range active {
+		if ok {
+			days = append(days, day)
+		}
+	}
+	sort.Strings(days)
+	run := 0
+	var last time.Time
+	for _, day := range days {
+		t, err := time.ParseInLocation(time.DateOnly, day, time.Local)
+		if err != nil {
+			continue
+		}
+		if run > 0 && dayKey(last.AddDate(0, 0, 1)) == day {
+			run++
+		} else {
+			run = 1
+		}
+		last = t
+		longest = max(longest, run)
+	}
+
+	from := today
+	if !active[dayKey(from)] {
+		from = from.AddDate(0, 0, -1)
+	}
+	for active[dayKey(from.AddDate(0, 0, -current))] {
+		current++
+	}
+	return longest, current
+}
+
+// readingTime formats a number of seconds read, like "1 h 05 min"
+func readingTime(seconds int) string {
+	minutes := seconds / 60
+	switch {
+	case seconds == 0:
+		return "–"
+	case minutes < 1:
+		return "< 1 min"
+	case minutes < 60:
+		return fmt.Sprintf("%d min", minutes)
+	}
+	return fmt.Sprintf("%d h %02d min", minutes/60, minutes%60)
+}
+
+// handleReadingStats shows what was read and looked up
+func handleReadingStats(w http.ResponseWriter, r *http.Request) {
+	stats, err := readingStats(r, time.Now())
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	data := PageData{
+		Title:        "Reading statistics",
+		ReadingStats: stats,
+	}
+	err = templatesFor(r).ExecuteTemplate(w, "stats", data)
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusInternalServerError)
+	}
+}
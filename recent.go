@@ -0,0 +1,193 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RecentText is a text opened lately, with the page it was left on
+type RecentText struct {
+	Path  string    `json:"path"`
+	Page  int       `json:"page,omitempty"` // 0 for the first
+	Time  time.Time `json:"time"`
+	Title string    `json:"-"`
+}
+
+// recentTexts holds the texts each logged-in reader opened last, the latest
+// first. Visitors' are only kept in memory, while the server runs.
+var recentTexts = &userFile[[]RecentText]{name: "recent.json"}
+
+const (
+	// maxRecentTexts is how many texts are kept and shown as recent
+	maxRecentTexts = 8
+	// maxRecentReaders caps how many readers' recent texts are kept in
+	// memory; those of the reader who opened a text longest ago go first
+	maxRecentReaders = 10000
+	// recentFlushInterval is how often the recent texts of logged-in
+	// readers are saved
+	recentFlushInterval = time.Minute
+)
+
+// openedTexts are the recent texts of the readers who opened texts since
+// the server started, by dataOwner, so that opening a text writes no file
+var openedTexts = struct {
+	sync.Mutex
+	lists map[string]*openedList
+}{lists: make(map[string]*openedList)}
+
+type openedList struct {
+	texts []RecentText
+	dirty bool // changed since saved
+}
+
+// savesRecent reports whether the recent texts of owner are saved: those of
+// users, not those of visitors
+func savesRecent(owner string) bool {
+	return !strings.HasPrefix(owner, visitorPrefix)
+}
+
+// recordRecent puts the text at the front of the reader's recent texts,
+// unless nothing is kept of what readers do
+func recordRecent(r *http.Request, name string, page int) {
+	if config.Private || staticSite {
+		return
+	}
+	owner := dataOwner(r)
+	if owner == "" {
+		return
+	}
+	if page == 1 {
+		page = 0
+	}
+
+	openedTexts.Lock()
+	defer openedTexts.Unlock()
+	list := openedTexts.lists[owner]
+	if list == nil {
+		list = &openedList{}
+		if savesRecent(owner) {
+			err := recentTexts.forUser(owner).Read(func(saved *[]RecentText) {
+				list.texts = slices.Clone(*saved)
+			})
+			if err != nil {
+				logf(r.Context(), "Error reading the recent texts: %v", err)
+			}
+		}
+		if len(openedTexts.lists) >= maxRecentReaders {
+			forgetOldestRecent()
+		}
+		openedTexts.lists[owner] = list
+	}
+	list.texts = slices.DeleteFunc(list.texts, func(t RecentText) bool { return t.Path == name })
+	list.texts = slices.Insert(list.texts, 0, RecentText{Path: name, Page: page, Time: time.Now().UTC()})
+	list.texts = list.texts[:min(len(list.texts), maxRecentTexts)]
+	list.dirty = savesRecent(owner)
+}
+
+// forgetOldestRecent drops from memory the recent texts of the reader who
+// opened a text longest ago, saving them first if need be. It is called
+// with openedTexts locked.
+func forgetOldestRecent() {
+	var oldest string
+	var when time.Time
+	for owner, list := range openedTexts.lists {
+		if len(list.texts) > 0 && (oldest == "" || list.texts[0].Time.Before(when)) {
+			oldest, when = owner, list.texts[0].Time
+		}
+	}
+	if list := openedTexts.lists[oldest]; list != nil && list.dirty {
+		saveRecentOf(oldest, list.texts)
+	}
+	delete(openedTexts.lists, oldest)
+}
+
+// saveRecentPeriodically saves the recent texts of logged-in readers every
+// so often
+func saveRecentPeriodically() {
+	if config.Private {
+		return
+	}
+	go func() {
+		for range time.Tick(recentFlushInterval) {
+			saveRecent()
+		}
+	}()
+}
+
+// saveRecent saves the recent texts of the logged-in readers who opened a
+// text since the last time
+func saveRecent() {
+	changed := make(map[string][]RecentText)
+	openedTexts.Lock()
+	for owner, list := range openedTexts.lists {
+		if list.dirty {
+			changed[owner] = slices.Clone(list.texts)
+			list.dirty = false
+		}
+	}
+	openedTexts.Unlock()
+
+	for owner, texts := range changed {
+		if !saveRecentOf(owner, texts) {
+			// Keep them for the next time
+			openedTexts.Lock()
+			if list := openedTexts.lists[owner]; list != nil {
+				list.dirty = true
+			}
+			openedTexts.Unlock()
+		}
+	}
+}
+
+// saveRecentOf saves the recent texts of owner, reporting whether it could
+func saveRecentOf(owner string, texts []RecentText) bool {
+	err := recentTexts.forUser(owner).Update(func(saved *[]RecentText) error {
+		*saved = texts
+		return nil
+	})
+	if err != nil {
+		log.Printf("Cannot save the recent texts of %s: %v", owner, err)
+	}
+	return err == nil
+}
+
+// recentlyOpened returns the texts the reader opened last that are still
+// in the corpus and theirs to read
+func recentlyOpened(r *http.Request) []RecentText {
+	if config.Private || staticSite {
+		return nil
+	}
+	owner := dataOwner(r)
+	if owner == "" {
+		return nil
+	}
+
+	openedTexts.Lock()
+	var all []RecentText
+	list := openedTexts.lists[owner]
+	if list != nil {
+		all = slices.Clone(list.texts)
+	}
+	openedTexts.Unlock()
+	if list == nil && savesRecent(owner) {
+		err := recentTexts.forUser(owner).Read(func(saved *[]RecentText) {
+			all = slices.Clone(*saved)
+		})
+		if err != nil {
+			logf(r.Context(), "Error reading the recent texts: %v", err)
+		}
+	}
+
+	var shown []RecentText
+	for _, t := range all {
+		if canRead(r, t.Path) && textExists(t.Path) {
+			t.Title = displayTitle(t.Path)
+			shown = append(shown, t)
+		}
+	}
+	return shown
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ReferenceConfig lists the reference markers used by the texts below a
+// corpus folder, such as GRETIL's [PTS Page 001] or the {…} and <note>
+// markers of other corpora. Root "" applies to the whole corpus; the
+// deepest root holding a text wins.
+type ReferenceConfig struct {
+	Root     string             `json:"root"`
+	Patterns []ReferencePattern `json:"patterns"`
+}
+
+// ReferencePattern matches one kind of reference marker. Markers are kept
+// out of the words of a text and shown with the CSS class Class. A pattern
+// with a named group page (and volume) marks PTS pages, which the print
+// layout puts in its running header.
+type ReferencePattern struct {
+	Pattern string `json:"pattern"`
+	Class   string `json:"class"` // defaults to "reference"
+
+	re *regexp.Regexp
+}
+
+// defaultReferences are the markers of the GRETIL texts
+func defaultReferences() []ReferenceConfig {
+	patterns := []ReferencePattern{
+		{Pattern: `\[PTS Vol (?P<volume>[^\]]+)\]`},
+		{Pattern: `\[PTS Page 0*(?P<page>\d+)\]`},
+		{Pattern: `\[[^\]]+\]`},
+	}
+	for i := range patterns {
+		patterns[i].compile()
+	}
+	return []ReferenceConfig{{Patterns: patterns}}
+}
+
+var referenceClass = regexp.MustCompile(`^[A-Za-z][\w-]*$`)
+
+// compile checks the pattern and fills in its defaults
+func (p *ReferencePattern) compile() error {
+	if p.Class == "" {
+		p.Class = "reference"
+	}
+	if !referenceClass.MatchString(p.Class) {
+		return fmt.Errorf("reference class %q is not a CSS class name", p.Class)
+	}
+	re, err := regexp.Compile(p.Pattern)
+	if err != nil {
+		return fmt.Errorf("reference pattern: %w", err)
+	}
+	p.re = re
+	return nil
+}
+
+// referencePatterns returns the reference patterns of the text at path
+func referencePatterns(path string) []ReferencePattern {
+	path = strings.Trim(filepath.ToSlash(path), "/")
+	var best *ReferenceConfig
+	for i, c := range config.References {
+		root := strings.Trim(c.Root, "/")
+		if root != "" && path != root && !strings.HasPrefix(path, root+"/") {
+			continue
+		}
+		if best == nil || len(root) > len(strings.Trim(best.Root, "/")) {
+			best = &config.References[i]
+		}
+	}
+	if best == nil {
+		return nil
+	}
+	return best.Patterns
+}
+
+// reference is a reference marker found in a text
+type reference struct {
+	start, end int
+	class      string
+	page       string // PTS page, for page references
+	volume     string // PTS volume, for volume references
+}
+
+// findReferences returns the reference markers of text in order. Markers
+// starting inside a tag are left alone; of overlapping markers the first,
+// or the one of the earlier pattern, wins.
+func findReferences(patterns []ReferencePattern, text string) []reference {
+	var refs []reference
+	for _, p := range patterns {
+		pageGroup, volumeGroup := p.re.SubexpIndex("page"), p.re.SubexpIndex("volume")
+		for _, m := range p.re.FindAllStringSubmatchIndex(text, -1) {
+			if m[0] == m[1] {
+				continue
+			}
+			ref := reference{start: m[0], end: m[1], class: p.Class}
+			if pageGroup >= 0 && m[2*pageGroup] >= 0 {
+				ref.page = text[m[2*pageGroup]:m[2*pageGroup+1]]
+			}
+			if volumeGroup >= 0 && m[2*volumeGroup] >= 0 {
+				ref.volume = text[m[2*volumeGroup]:m[2*volumeGroup+1]]
+			}
+			refs = append(refs, ref)
+		}
+	}
+	if len(refs) == 0 {
+		return nil
+	}
+	sort.SliceStable(refs, func(i, j int) bool { return refs[i].start < refs[j].start })
+
+	tags := tagPattern.FindAllStringIndex(text, -1)
+	inTag := func(i int) bool {
+		k := sort.Search(len(tags), func(k int) bool { return tags[k][1] > i })
+		return k < len(tags) && tags[k][0] < i
+	}
+
+	kept := refs[:0]
+	end := 0
+	for _, ref := range refs {
+		if ref.start < end || inTag(ref.start) {
+			continue
+		}
+		kept = append(kept, ref)
+		end = ref.end
+	}
+	return kept
+}
+
+// stripReferences replaces the reference markers of text with spaces
+func stripReferences(patterns []ReferencePattern, text string) string {
+	refs := findReferences(patterns, text)
+	if len(refs) == 0 {
+		return text
+	}
+	var b strings.Builder
+	last := 0
+	for _, ref := range refs {
+		b.WriteString(text[last:ref.start])
+		b.WriteByte(' ')
+		last = ref.end
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
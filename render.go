@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// runRender writes the pages of the static site as snapshots, or with
+// -golden compares them against snapshots written before, so that a change
+// to the tokenizer, the links or the templates shows up page by page
+func runRender(args []string) error {
+	flags := flag.NewFlagSet("render", flag.ExitOnError)
+	golden := flags.Bool("golden", false, "compare the pages against the snapshots instead of writing them")
+	dir := flags.String("dir", filepath.Join("testdata", "golden"), "directory of the snapshots")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: palireader render [-golden] [-dir dir] [folder|text ...]")
+		fmt.Fprintln(flags.Output(), "\nRenders the pages of the folders and texts given, or of the whole corpus")
+		fmt.Fprintln(flags.Output(), "with the index, as build does, and writes them to the snapshot directory.")
+		fmt.Fprintln(flags.Output(), "With -golden nothing is written: every page that differs from its")
+		fmt.Fprintln(flags.Output(), "snapshot, or has none, is reported, and so is every snapshot no longer")
+		fmt.Fprintln(flags.Output(), "rendered. Snapshot a corpus before upgrading and check it after.")
+		fmt.Fprintln(flags.Output(), "\nFlags:")
+		flags.PrintDefaults()
+	}
+	roots := parseInterspersed(flags, args)
+
+	snapshot := func(path string) string {
+		return filepath.Join(*dir, filepath.FromSlash(path))
+	}
+
+	if !*golden {
+		texts, err := renderSite(roots, func(path string, data []byte) error {
+			path = snapshot(path)
+			if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+				return err
+			}
+			return os.WriteFile(path, unversioned(data), 0o644)
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Wrote the pages of %d texts to %s\n", texts, *dir)
+		return nil
+	}
+
+	pages, texts, err := checkGolden(os.Stdout, roots, *dir)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("The %d pages of %d texts match the snapshots in %s\n", pages, texts, *dir)
+	return nil
+}
+
+// unversioned puts back the plain names of the stylesheet and scripts in a
+// page, as their versioned names change with every change to them, which is
+// not a change to the pages
+func unversioned(data []byte) []byte {
+	for plain, a := range staticAssets {
+		data = bytes.ReplaceAll(data, []byte(a.Name), []byte(plain))
+	}
+	return data
+}
+
+// checkGolden renders the pages of roots and compares them against the
+// snapshots in dir, reporting to w every page that differs from its
+// snapshot or has none, and every snapshot no longer rendered. It returns
+// how many pages and texts it rendered, and an error if any page differs.
+func checkGolden(w io.Writer, roots []string, dir string) (pages, texts int, err error) {
+	rendered := make(map[string]bool)
+	differ := 0
+	texts, err = renderSite(roots, func(path string, data []byte) error {
+		rendered[path] = true
+		pages++
+		want, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(path)))
+		if errors.Is(err, fs.ErrNotExist) {
+			differ++
+			fmt.Fprintf(w, "%s: no snapshot\n", path)
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if line, got, wanted, ok := firstDifference(unversioned(data), want); !ok {
+			differ++
+			fmt.Fprintf(w, "%s:%d:\n  snapshot: %s\n  rendered: %s\n", path, line, wanted, got)
+		}
+		return nil
+	})
+	if err != nil {
+		return pages, texts, err
+	}
+
+	// Snapshots of pages below what was rendered that are gone
+	var stale []string
+	roots = slices.DeleteFunc(slices.Clone(roots), func(root string) bool { return strings.Trim(root, "/") == "" })
+	err = filepath.WalkDir(dir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, name)
+		if err != nil {
+			return err
+		}
+		path := filepath.ToSlash(rel)
+		if rendered[path] || !belowRoots(path, roots) {
+			return nil
+		}
+		stale = append(stale, path)
+		return nil
+	})
+	if err != nil {
+		return pages, texts, err
+	}
+	for _, path := range stale {
+		fmt.Fprintf(w, "%s: no longer rendered\n", path)
+	}
+
+	if differ > 0 || len(stale) > 0 {
+		return pages, texts, fmt.Errorf("%d of %d pages differ from the snapshots in %s, and %d snapshots are stale",
+			differ, pages, dir, len(stale))
+	}
+	return pages, texts, nil
+}
+
+// belowRoots reports whether the page at the site path was rendered for one
+// of the folders or texts given to render, or for the whole corpus if none
+// were
+func belowRoots(path string, roots []string) bool {
+	if len(roots) == 0 {
+		return true
+	}
+	for _, root := range roots {
+		root = "read/" + strings.Trim(filepath.ToSlash(root), "/")
+		if path == root || strings.HasPrefix(path, root+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// firstDifference compares two pages line by line and returns the first
+// line that differs, numbered from one, as it is in each. ok is true if the
+// pages are the same.
+func firstDifference(got, want []byte) (line int, gotLine, wantLine string, ok bool) {
+	if bytes.Equal(got, want) {
+		return 0, "", "", true
+	}
+	gotLines := strings.Split(string(got), "\n")
+	wantLines := strings.Split(string(want), "\n")
+	for i := 0; ; i++ {
+		g, w := "(end of page)", "(end of page)"
+		if i < len(gotLines) {
+			g = strings.TrimSpace(gotLines[i])
+		}
+		if i < len(wantLines) {
+			w = strings.TrimSpace(wantLines[i])
+		}
+		if i >= len(gotLines) || i >= len(wantLines) || gotLines[i] != wantLines[i] {
+			return i + 1, truncateLine(g), truncateLine(w), false
+		}
+	}
+}
+
+// truncateLine shortens a line of a page to show in a report
+func truncateLine(s string) string {
+	const limit = 160
+	if r := []rune(s); len(r) > limit {
+		return string(r[:limit]) + "…"
+	}
+	return s
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// openTestCorpus loads the config of the fixtures in testdata, as make
+// golden does, and opens their corpus
+var openTestCorpus = sync.OnceValue(func() error {
+	var err error
+	config, err = loadConfig(filepath.Join("testdata", "palireader.json"), true)
+	if err != nil {
+		return err
+	}
+	corpus, err = openCorpus(config.Libraries)
+	return err
+})
+
+func TestGolden(t *testing.T) {
+	if err := openTestCorpus(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { staticSite = false })
+
+	var report strings.Builder
+	pages, texts, err := checkGolden(&report, nil, filepath.Join("testdata", "golden"))
+	if err != nil {
+		t.Fatalf("%v\n%s\nIf the change was meant, write the snapshots anew with\n"+
+			"go run . -config testdata/palireader.json render", err, report.String())
+	}
+	if pages == 0 || texts == 0 {
+		t.Fatalf("rendered %d pages of %d texts", pages, texts)
+	}
+}
+
+func TestFirstDifference(t *testing.T) {
+	tests := []struct {
+		got, want string
+		line      int
+		ok        bool
+	}{
+		{"a\nb\n", "a\nb\n", 0, true},
+		{"a\nb\n", "a\nc\n", 2, false},
+		{"a\n", "a\nb\n", 2, false},
+		{"x", "", 1, false},
+	}
+	for _, tt := range tests {
+		line, _, _, ok := firstDifference([]byte(tt.got), []byte(tt.want))
+		if line != tt.line || ok != tt.ok {
+			t.Errorf("firstDifference(%q, %q) = line %d, %v; want line %d, %v", tt.got, tt.want, line, ok, tt.line, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// replaceHunk is a run of lines changed by a replacement
+type replaceHunk struct {
+	line     int // of the first line, counting from 1
+	old, new string
+}
+
+// replaceText replaces the matches of re in content with what expand makes
+// of each, returning the new content, the changed lines and the number of
+// matches
+func replaceText(re *regexp.Regexp, content string, expand func(content string, m []int) []byte) (string, []replaceHunk, int) {
+	matches := re.FindAllStringSubmatchIndex(content, -1)
+	if len(matches) == 0 {
+		return content, nil, 0
+	}
+
+	var (
+		result strings.Builder
+		hunks  []replaceHunk
+		last   int
+	)
+	// A hunk covers whole lines, from start to end; the lines of
+	// neighbouring matches join one hunk
+	start, end := -1, -1
+	var hunk strings.Builder
+	flush := func() {
+		if start < 0 {
+			return
+		}
+		hunk.WriteString(content[last:end])
+		result.WriteString(content[last:end])
+		hunks = append(hunks, replaceHunk{
+			line: strings.Count(content[:start], "\n") + 1,
+			old:  content[start:end],
+			new:  hunk.String(),
+		})
+		hunk.Reset()
+		last = end
+	}
+	for _, m := range matches {
+		lineStart := strings.LastIndexByte(content[:m[0]], '\n') + 1
+		if start < 0 || lineStart > end {
+			flush()
+			result.WriteString(content[last:lineStart])
+			start, last = lineStart, lineStart
+		}
+		end = len(content)
+		if i := strings.IndexByte(content[m[1]:], '\n'); i >= 0 {
+			end = m[1] + i
+		}
+		hunk.WriteString(content[last:m[0]])
+		result.WriteString(content[last:m[0]])
+		replaced := expand(content, m)
+		hunk.Write(replaced)
+		result.Write(replaced)
+		last = m[1]
+	}
+	flush()
+	result.WriteString(content[last:])
+	return result.String(), hunks, len(matches)
+}
+
+// runReplace implements the replace command: it finds a pattern in the texts
+// below a folder and, once the changes are shown and confirmed, replaces it
+func runReplace(args []string) error {
+	flags := flag.NewFlagSet("replace", flag.ExitOnError)
+	var opts rewriteOptions
+	opts.addFlags(flags)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: palireader replace [-in folder] [-n] [-y] [-commit message] pattern replacement")
+		fmt.Fprintln(flags.Output(), "\nReplaces every match of a regular expression in the texts of the corpus,")
+		fmt.Fprintln(flags.Output(), "for fixing systematic transcription errors. The replacement may refer to")
+		fmt.Fprintln(flags.Output(), "groups of the pattern as $1 or ${name}. The changed lines of each text are")
+		fmt.Fprintln(flags.Output(), "shown first and nothing is written until the changes are confirmed.")
+		fmt.Fprintln(flags.Output(), "\nFlags:")
+		flags.PrintDefaults()
+	}
+	positional := parseInterspersed(flags, args)
+	if len(positional) != 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	re, err := regexp.Compile(positional[0])
+	if err != nil {
+		return err
+	}
+	replacement := positional[1]
+
+	return rewriteTexts(opts, func(content string) (string, []replaceHunk, int) {
+		return replaceText(re, content, func(content string, m []int) []byte {
+			return re.ExpandString(nil, replacement, content, m)
+		})
+	})
+}
+
+// rewriteOptions are the flags of the commands that change texts
+type rewriteOptions struct {
+	in     string
+	dryRun bool
+	yes    bool
+	commit string
+}
+
+func (o *rewriteOptions) addFlags(flags *flag.FlagSet) {
+	flags.StringVar(&o.in, "in", "", "only change the texts below this corpus folder")
+	flags.BoolVar(&o.dryRun, "n", false, "only show the changes, writing nothing")
+	flags.BoolVar(&o.yes, "y", false, "apply the changes without asking")
+	flags.StringVar(&o.commit, "commit", "", "commit the changed texts to git with this message")
+}
+
+// rewriteTexts runs rewrite over the texts the options cover, shows the
+// changed lines and, once confirmed, writes the changed texts
+func rewriteTexts(opts rewriteOptions, rewrite func(content string) (string, []replaceHunk, int)) error {
+	type change struct {
+		rel     string
+		content string
+	}
+	var changes []change
+	count := 0
+	out := bufio.NewWriter(os.Stdout)
+	err := walkCorpus(opts.in, func(rel string, d fs.DirEntry) error {
+		if d.IsDir() || !strings.HasSuffix(strings.ToLower(d.Name()), ".htm") {
+			return nil
+		}
+		content, err := fs.ReadFile(corpus, rel)
+		if err != nil {
+			return err
+		}
+		changed, hunks, n := rewrite(string(content))
+		if changed == string(content) {
+			return nil
+		}
+		changes = append(changes, change{rel, changed})
+		count += n
+		fmt.Fprintf(out, "--- %s\n+++ %s\n", rel, rel)
+		for _, h := range hunks {
+			if h.old == h.new {
+				continue
+			}
+			fmt.Fprintf(out, "@@ line %d @@\n", h.line)
+			for _, line := range strings.Split(h.old, "\n") {
+				fmt.Fprintf(out, "-%s\n", line)
+			}
+			for _, line := range strings.Split(h.new, "\n") {
+				fmt.Fprintf(out, "+%s\n", line)
+			}
+		}
+		return nil
+	})
+	out.Flush()
+	if err != nil {
+		return err
+	}
+	if len(changes) == 0 {
+		fmt.Println("No text matches")
+		return nil
+	}
+	fmt.Printf("\n%d replacements in %d texts\n", count, len(changes))
+	if opts.dryRun {
+		return nil
+	}
+	if !opts.yes {
+		fmt.Print("Apply? [y/N] ")
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if a := strings.ToLower(strings.TrimSpace(answer)); a != "y" && a != "yes" {
+			fmt.Println("Nothing changed")
+			return nil
+		}
+	}
+
+	// Find every file first, so that nothing is written if one cannot be
+	files := make([]string, len(changes))
+	for i, c := range changes {
+		if files[i], err = diskPath(c.rel); err != nil {
+			return err
+		}
+	}
+	var written []string
+	for i, c := range changes {
+		file := files[i]
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(file, []byte(c.content), info.Mode().Perm()); err != nil {
+			return err
+		}
+		written = append(written, file)
+	}
+	fmt.Printf("Changed %d texts\n", len(written))
+
+	if opts.commit != "" {
+		if err := gitCommit(written, opts.commit); err != nil {
+			return fmt.Errorf("commit: %w", err)
+		}
+	}
+	return nil
+}
+
+// gitCommit commits the given files, each to the git repository holding it,
+// leaving anything else staged alone
+func gitCommit(files []string, message string) error {
+	repos := make(map[string][]string)
+	var order []string
+	for _, file := range files {
+		abs, err := filepath.Abs(file)
+		if err != nil {
+			return err
+		}
+		top, err := exec.Command("git", "-C", filepath.Dir(abs), "rev-parse", "--show-toplevel").Output()
+		if err != nil {
+			return fmt.Errorf("%s is not in a git repository", file)
+		}
+		repo := strings.TrimSpace(string(top))
+		if _, ok := repos[repo]; !ok {
+			order = append(order, repo)
+		}
+		repos[repo] = append(repos[repo], abs)
+	}
+
+	for _, repo := range order {
+		for _, step := range [][]string{
+			append([]string{"add", "--"}, repos[repo]...),
+			append([]string{"commit", "-q", "-m", message, "--"}, repos[repo]...),
+		} {
+			cmd := exec.Command("git", append([]string{"-C", repo}, step...)...)
+			cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+			if err := cmd.Run(); err != nil {
+				var exit *exec.ExitError
+				if errors.As(err, &exit) {
+					return fmt.Errorf("git %s failed in %s", step[0], repo)
+				}
+				return err
+			}
+		}
+		fmt.Printf("Committed %d texts in %s\n", len(repos[repo]), repo)
+	}
+	return nil
+}
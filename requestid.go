@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+type requestIDKey struct{}
+
+// validRequestID accepts request IDs set by a proxy in front of the server
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// withRequestID gives every request an ID, taken from an X-Request-ID header
+// or made up, and echoes it in the response so failures can be reported
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID.MatchString(id) {
+			id = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+func newRequestID() string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// requestID returns the ID of the request ctx belongs to, if any
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// logf logs a message tagged with the ID of the request ctx belongs to
+func logf(ctx context.Context, format string, args ...any) {
+	if id := requestID(ctx); id != "" {
+		format = "[" + id + "] " + format
+	}
+	log.Printf(format, args...)
+}
+
+// httpError replies with an error message carrying the request ID, as a JSON
+// envelope for clients asking for it. Server errors are logged under the
+// same ID.
+func httpError(w http.ResponseWriter, r *http.Request, message string, code int) {
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		writeAPIError(w, r, code, message, nil)
+		return
+	}
+
+	id := requestID(r.Context())
+	if code >= 500 {
+		logf(r.Context(), "%s %s: %d %s", r.Method, r.URL.Path, code, message)
+	}
+
+	if id != "" {
+		message = fmt.Sprintf("%s\n\nError ref %s", message, id)
+	}
+	http.Error(w, message, code)
+}
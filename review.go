@@ -0,0 +1,210 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Review directions: show the Pali word and recall its meaning, or show the
+// gloss and recall the Pali word
+const (
+	reviewPali  = "pali"
+	reviewGloss = "gloss"
+)
+
+// relearnDelay is how soon a forgotten card comes back
+const relearnDelay = 10 * time.Minute
+
+// ReviewCard is the SM-2 schedule of one saved word in one direction
+type ReviewCard struct {
+	Word        string    `json:"word"`
+	Direction   string    `json:"direction"`
+	Ease        float64   `json:"ease"`
+	Interval    int       `json:"interval"` // days
+	Repetitions int       `json:"repetitions"`
+	Due         time.Time `json:"due"`
+	Reviewed    time.Time `json:"reviewed,omitzero"`
+}
+
+// ID identifies the card in forms and in the review store
+func (c *ReviewCard) ID() string {
+	return c.Direction + ":" + c.Word
+}
+
+// reviews holds the review schedule of the saved vocabulary by card ID
+var reviews = &userFile[map[string]*ReviewCard]{name: "review.json"}
+
+// ReviewPage is the state of the /review flashcard page
+type ReviewPage struct {
+	Card       *ReviewCard
+	Glosses    []string
+	ShowAnswer bool
+	Due        int
+	Next       time.Time
+}
+
+// schedule applies an SM-2 grade from 0 (blackout) to 5 (perfect recall)
+func (c *ReviewCard) schedule(grade int, now time.Time) {
+	if grade >= 3 {
+		switch c.Repetitions {
+		case 0:
+			c.Interval = 1
+		case 1:
+			c.Interval = 6
+		default:
+			c.Interval = int(math.Round(float64(c.Interval) * c.Ease))
+		}
+		c.Repetitions++
+		c.Due = now.AddDate(0, 0, c.Interval)
+	} else {
+		c.Repetitions = 0
+		c.Interval = 1
+		c.Due = now.Add(relearnDelay)
+	}
+
+	q := float64(5 - grade)
+	c.Ease = max(1.3, c.Ease+0.1-q*(0.08+q*0.02))
+	c.Reviewed = now
+}
+
+// handleReview shows the next due card (GET) or records a grade (POST)
+func handleReview(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		gradeCard(w, r)
+		return
+	}
+
+	cards, err := syncReviewCards(r)
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	page := &ReviewPage{}
+	var due []*ReviewCard
+	for _, c := range cards {
+		if !c.Due.After(now) {
+			due = append(due, c)
+		} else if page.Next.IsZero() || c.Due.Before(page.Next) {
+			page.Next = c.Due
+		}
+	}
+	page.Due = len(due)
+
+	// Answer the card that was shown, otherwise the most overdue one
+	if id := r.URL.Query().Get("card"); id != "" {
+		for _, c := range cards {
+			if c.ID() == id {
+				page.Card = c
+				page.ShowAnswer = r.URL.Query().Has("answer")
+			}
+		}
+	}
+	if page.Card == nil && len(due) > 0 {
+		sort.Slice(due, func(i, j int) bool { return due[i].Due.Before(due[j].Due) })
+		page.Card = due[0]
+	}
+	if page.Card != nil && dictionary != nil {
+		page.Glosses = dictionary.Lookup(page.Card.Word)
+	}
+
+	data := PageData{
+		Title:  "Review",
+		Review: page,
+	}
+	if len(cards) == 0 {
+		data.Notice = "Save words while reading to review them here."
+	}
+	err = templatesFor(r).ExecuteTemplate(w, "review", data)
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func gradeCard(w http.ResponseWriter, r *http.Request) {
+	id := r.FormValue("card")
+	grade, err := strconv.Atoi(r.FormValue("grade"))
+	if err != nil || grade < 0 || grade > 5 {
+		httpError(w, r, "Grade must be between 0 and 5", http.StatusBadRequest)
+		return
+	}
+
+	found := false
+	err = reviews.of(r).Update(func(cards *map[string]*ReviewCard) error {
+		if c := (*cards)[id]; c != nil {
+			c.schedule(grade, time.Now().UTC())
+			found = true
+		}
+		return nil
+	})
+	if err != nil {
+		logf(r.Context(), "Error saving review: %v", err)
+		httpError(w, r, "Cannot save review", http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		httpError(w, r, "Unknown card", http.StatusNotFound)
+		return
+	}
+	http.Redirect(w, r, sitePath("/review"), http.StatusSeeOther)
+}
+
+// syncReviewCards adds cards for the words the reader newly saved and
+// returns all their cards.
+// Gloss → Pali cards need a gloss, so they only exist for words the local
+// dictionary knows.
+func syncReviewCards(r *http.Request) ([]*ReviewCard, error) {
+	var words []string
+	err := vocabulary.of(r).Read(func(list *[]Lookup) {
+		seen := make(map[string]bool)
+		for _, l := range *list {
+			if !seen[l.Word] {
+				seen[l.Word] = true
+				words = append(words, l.Word)
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var cards []*ReviewCard
+	err = reviews.of(r).Update(func(stored *map[string]*ReviewCard) error {
+		if *stored == nil {
+			*stored = make(map[string]*ReviewCard)
+		}
+		now := time.Now().UTC()
+		for _, word := range words {
+			directions := []string{reviewPali}
+			if dictionary != nil && len(dictionary.Lookup(word)) > 0 {
+				directions = append(directions, reviewGloss)
+			}
+			for _, direction := range directions {
+				c := &ReviewCard{Word: word, Direction: direction, Ease: 2.5, Due: now}
+				if _, ok := (*stored)[c.ID()]; !ok {
+					(*stored)[c.ID()] = c
+				}
+			}
+		}
+		cards = cards[:0]
+		for _, c := range *stored {
+			// Copy so the caller never shares the stored cards
+			copied := *c
+			cards = append(cards, &copied)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(cards, func(i, j int) bool {
+		return strings.Compare(cards[i].ID(), cards[j].ID()) < 0
+	})
+	return cards, nil
+}
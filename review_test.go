@@ -0,0 +1,41 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestSchedule(t *testing.T) {
+	now := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	tests := []struct {
+		grades      []int
+		interval    int
+		repetitions int
+		ease        float64
+		due         time.Duration
+	}{
+		{[]int{4}, 1, 1, 2.5, 24 * time.Hour},
+		{[]int{5}, 1, 1, 2.6, 24 * time.Hour},
+		{[]int{3}, 1, 1, 2.36, 24 * time.Hour},
+		{[]int{4, 4}, 6, 2, 2.5, 6 * 24 * time.Hour},
+		{[]int{4, 4, 4}, 15, 3, 2.5, 15 * 24 * time.Hour},
+		{[]int{5, 5, 5}, 16, 3, 2.8, 16 * 24 * time.Hour},
+		// A lapse starts the card over, due again in a few minutes
+		{[]int{4, 4, 1}, 1, 0, 1.96, relearnDelay},
+		{[]int{4, 4, 4, 2, 4}, 1, 1, 2.18, 24 * time.Hour},
+		// Ease never drops below 1.3
+		{[]int{0, 0, 0}, 1, 0, 1.3, relearnDelay},
+	}
+	for _, tt := range tests {
+		c := &ReviewCard{Word: "dhamma", Direction: "word", Ease: 2.5, Due: now}
+		for _, grade := range tt.grades {
+			c.schedule(grade, now)
+		}
+		if c.Interval != tt.interval || c.Repetitions != tt.repetitions || math.Abs(c.Ease-tt.ease) > 1e-9 ||
+			!c.Due.Equal(now.Add(tt.due)) || !c.Reviewed.Equal(now) {
+			t.Errorf("grades %v: interval %d, repetitions %d, ease %.2f, due %v; want %d, %d, %.2f, %v",
+				tt.grades, c.Interval, c.Repetitions, c.Ease, c.Due.Sub(now), tt.interval, tt.repetitions, tt.ease, tt.due)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// Roles, from the one that may do least to the one that may do most. Each
+// may do what the ones before it may.
+const (
+	roleReader = "reader" // reads the protected texts
+	roleEditor = "editor" // uploads, renames and deletes texts, and moderates their annotations
+	roleAdmin  = "admin"  // announces publications and runs jobs such as re-indexing
+)
+
+var roleRanks = map[string]int{roleReader: 1, roleEditor: 2, roleAdmin: 3}
+
+// RoleGrant gives a user a role over the corpus, or over one folder of it,
+// so that each team of a shared installation manages its own library
+type RoleGrant struct {
+	User string `json:"user"`
+	Role string `json:"role"` // reader, editor or admin
+	Path string `json:"path"` // corpus folder; the whole corpus if empty
+}
+
+// grants returns the roles given to user, in the config file or by the
+// invitation they signed up with. The admins listed on their own are admins
+// of the whole corpus, and users with no role at all read the whole corpus,
+// as before there were roles.
+func (a *AuthConfig) grants(user string) []RoleGrant {
+	var grants []RoleGrant
+	for _, admin := range a.Admins {
+		if admin == user {
+			grants = append(grants, RoleGrant{User: user, Role: roleAdmin})
+		}
+	}
+	for _, g := range a.Roles {
+		if g.User == user {
+			grants = append(grants, g)
+		}
+	}
+	grants = append(grants, accountRoles(user)...)
+	if len(grants) == 0 {
+		grants = append(grants, RoleGrant{User: user, Role: roleReader})
+	}
+	return grants
+}
+
+// managed reports whether any user may manage texts, so that the admin
+// area is served
+func (a *AuthConfig) managed() bool {
+	for _, g := range a.Roles {
+		if roleRanks[g.Role] >= roleRanks[roleEditor] {
+			return true
+		}
+	}
+	if a.OIDC != nil {
+		for _, rc := range a.OIDC.RoleClaims {
+			if roleRanks[rc.Role] >= roleRanks[roleEditor] {
+				return true
+			}
+		}
+	}
+	return len(a.Admins) > 0
+}
+
+// hasRole reports whether user holds role, or one that may do more, over
+// the corpus path
+func hasRole(user, role, p string) bool {
+	if config.Auth == nil || user == "" {
+		return false
+	}
+	if !knownUser(user) {
+		return false
+	}
+	p = strings.Trim(filepath.ToSlash(p), "/")
+	if p == "." {
+		p = ""
+	}
+	for _, g := range config.Auth.grants(user) {
+		root := strings.Trim(g.Path, "/")
+		covers := root == "" || p == root || strings.HasPrefix(p, root+"/")
+		if covers && roleRanks[g.Role] >= roleRanks[role] {
+			return true
+		}
+	}
+	return false
+}
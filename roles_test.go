@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHasRole(t *testing.T) {
+	useConfig(t, Config{DataDir: t.TempDir(), Auth: &AuthConfig{
+		Users:  map[string]string{"ann": "a", "bob": "b", "cat": "c", "dan": "d"},
+		Paths:  []string{"gretil"},
+		Admins: []string{"ann"},
+		Roles: []RoleGrant{
+			{User: "bob", Role: roleEditor, Path: "/gretil/1_tipit/"},
+			{User: "cat", Role: roleReader, Path: "gretil/9_phil"},
+		},
+	}})
+	tests := []struct {
+		user, role, path string
+		ok               bool
+	}{
+		{"ann", roleAdmin, "", true},
+		{"ann", roleEditor, "gretil/1_tipit/khuddaku.htm", true},
+		{"bob", roleEditor, "gretil/1_tipit/khuddaku.htm", true},
+		{"bob", roleReader, "gretil/1_tipit", true},
+		{"bob", roleEditor, "gretil/1_tipitaka", false},
+		{"bob", roleEditor, "gretil", false},
+		{"bob", roleAdmin, "gretil/1_tipit", false},
+		{"cat", roleReader, "gretil/9_phil/gramm/saddab_u.htm", true},
+		{"cat", roleReader, "gretil/1_tipit", false},
+		{"cat", roleEditor, "gretil/9_phil", false},
+		// Users with no role read everything, as before there were roles
+		{"dan", roleReader, "gretil/1_tipit", true},
+		{"dan", roleEditor, "gretil/1_tipit", false},
+		{"eve", roleReader, "", false},
+		{"", roleReader, "", false},
+	}
+	for _, tt := range tests {
+		if ok := hasRole(tt.user, tt.role, tt.path); ok != tt.ok {
+			t.Errorf("hasRole(%q, %s, %q) = %v; want %v", tt.user, tt.role, tt.path, ok, tt.ok)
+		}
+	}
+
+	// Readers of other folders are turned away from protected texts
+	handler := withAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for path, code := range map[string]int{
+		"/read/gretil/9_phil/gramm/saddab_u.htm":          http.StatusOK,
+		"/read/gretil/1_tipit/2_sut/5_khudd/khuddaku.htm": http.StatusForbidden,
+	} {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r.SetBasicAuth("cat", "c")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != code {
+			t.Errorf("GET %s as cat = %d; want %d", path, w.Code, code)
+		}
+	}
+}
@@ -0,0 +1,440 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"html/template"
+	"io/fs"
+	"math"
+	"net/http"
+	"path"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// maxSearchResults caps how many results a search page shows
+const maxSearchResults = 50
+
+// SearchResult is one hit on the search page: a whole text for lexical
+// searches, a passage for semantic ones
+type SearchResult struct {
+	Path    string
+	Anchor  string
+	Score   float64
+	Snippet string
+	Marked  template.HTML // the snippet with the words searched for in bold
+}
+
+// The BM25 parameters: how soon a word occurring again in a text stops
+// adding much to its score, and how far a long text is held to account for
+// its length
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// Search finds the texts matching the query, ranked by BM25: a word or
+// phrase counts for more the rarer it is in the corpus and the more often
+// it occurs in a text, for the text's length. See parseQuery for what a
+// query can say. With a scope, only the texts in that folder are found.
+func (idx *CorpusIndex) Search(query, scope string) []SearchResult {
+	q := parseQuery(query)
+	if len(q.clauses) == 0 {
+		return nil
+	}
+
+	texts := float64(len(idx.Paths))
+	average := idx.averageLength()
+	var scores map[int]float64
+	for _, clause := range q.clauses {
+		clauseScores := make(map[int]float64)
+		for _, words := range clause {
+			// How rare a word is goes by the whole corpus
+			counts := idx.matches(words)
+			found := float64(len(counts))
+			idf := math.Log(1 + (texts-found+0.5)/(found+0.5))
+			for doc, n := range counts {
+				if !inScope(idx.Paths[doc], scope) {
+					continue
+				}
+				count := float64(n)
+				length := 1 - bm25B + bm25B*idx.length(doc, average)/average
+				clauseScores[doc] += idf * count * (bm25K1 + 1) / (count + bm25K1*length)
+			}
+		}
+		if scores == nil {
+			scores = clauseScores
+			continue
+		}
+		for doc, score := range scores {
+			if clauseScore, ok := clauseScores[doc]; ok {
+				scores[doc] = score + clauseScore
+			} else {
+				delete(scores, doc)
+			}
+		}
+	}
+	for _, words := range q.excluded {
+		for doc := range idx.matches(words) {
+			delete(scores, doc)
+		}
+	}
+
+	results := make([]SearchResult, 0, len(scores))
+	for doc, score := range scores {
+		results = append(results, SearchResult{Path: idx.Paths[doc], Score: score})
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Path < results[j].Path
+	})
+	return results
+}
+
+// matches returns how often the words occur one after the other in each
+// text having them so
+func (idx *CorpusIndex) matches(words []string) map[int]int {
+	counts := make(map[int]int)
+	if len(words) == 1 {
+		for _, p := range idx.Postings[words[0]] {
+			counts[p.Doc] = p.Count
+		}
+		return counts
+	}
+
+	// The texts having every word, then those having them in order
+	byDoc := make(map[int][]Posting)
+	for i, word := range words {
+		for _, p := range idx.Postings[word] {
+			if len(byDoc[p.Doc]) == i {
+				byDoc[p.Doc] = append(byDoc[p.Doc], p)
+			}
+		}
+	}
+	for doc, postings := range byDoc {
+		if len(postings) < len(words) {
+			continue
+		}
+		starts := idx.positions(postings[0])
+		for i, p := range postings[1:] {
+			next := idx.positions(p)
+			starts = slices.DeleteFunc(starts, func(start int) bool {
+				_, found := slices.BinarySearch(next, start+i+1)
+				return !found
+			})
+		}
+		if len(starts) > 0 {
+			counts[doc] = len(starts)
+		}
+	}
+	return counts
+}
+
+// inScope reports whether the corpus path is in the folder scope, or
+// anywhere for an empty scope
+func inScope(path, scope string) bool {
+	return scope == "" || strings.HasPrefix(path, scope+"/")
+}
+
+// searchScope turns the in parameter of a search into the folder to search
+// in: the folder itself, or the folder holding a text, or "" for the whole
+// corpus
+func searchScope(in string) string {
+	name, err := corpusName(strings.Trim(in, "/"))
+	if err != nil || name == "." {
+		return ""
+	}
+	info, err := fs.Stat(corpus, name)
+	if err != nil {
+		return ""
+	}
+	if !info.IsDir() {
+		if name = path.Dir(name); name == "." {
+			return ""
+		}
+	}
+	return name
+}
+
+// searchQuery is a parsed query. A text matches when it has, for every
+// clause, one of the clause's words or phrases, and none of the excluded
+// ones. A word is a phrase of one word.
+type searchQuery struct {
+	clauses  [][][]string
+	excluded [][]string
+}
+
+// parseQuery reads a query. Words and "quoted phrases" must all occur in a
+// text, as with AND between them; OR between two of them lets either do,
+// and a leading - or NOT leaves out the texts having the word or phrase
+// after it. AND, OR and NOT are operators only in capitals.
+func parseQuery(query string) searchQuery {
+	var q searchQuery
+	or, not := false, false
+	rest := strings.TrimSpace(query)
+	for rest != "" {
+		var item string
+		phrase := false
+		if strings.HasPrefix(rest, "-") && len(rest) > 1 {
+			not = true
+			rest = rest[1:]
+		}
+		if r, size := utf8.DecodeRuneInString(rest); r == '"' || r == '“' || r == '”' {
+			rest = rest[size:]
+			end := strings.IndexFunc(rest, func(r rune) bool { return r == '"' || r == '“' || r == '”' })
+			if end < 0 {
+				end = len(rest)
+			}
+			item, rest, phrase = rest[:end], rest[end:], true
+			if _, size := utf8.DecodeRuneInString(rest); size > 0 {
+				rest = rest[size:]
+			}
+		} else {
+			end := strings.IndexFunc(rest, unicode.IsSpace)
+			if end < 0 {
+				end = len(rest)
+			}
+			item, rest = rest[:end], rest[end:]
+		}
+		rest = strings.TrimSpace(rest)
+
+		if !phrase {
+			switch item {
+			case "AND":
+				continue
+			case "OR":
+				or = len(q.clauses) > 0 && !not
+				continue
+			case "NOT":
+				not = true
+				continue
+			}
+		}
+		var words []string
+		forEachWord(item, func(word string) {
+			words = append(words, word)
+		})
+		switch {
+		case len(words) == 0:
+		case not:
+			q.excluded = append(q.excluded, words)
+		case or:
+			last := len(q.clauses) - 1
+			q.clauses[last] = append(q.clauses[last], words)
+		default:
+			q.clauses = append(q.clauses, [][]string{words})
+		}
+		or, not = false, false
+	}
+	return q
+}
+
+// queryTerms returns the words a query searches for, each once, leaving
+// out those of the texts it excludes
+func queryTerms(query string) []string {
+	var terms []string
+	for _, clause := range parseQuery(query).clauses {
+		for _, words := range clause {
+			for _, word := range words {
+				if !slices.Contains(terms, word) {
+					terms = append(terms, word)
+				}
+			}
+		}
+	}
+	return terms
+}
+
+// length returns how many words the text has, or the average for an index
+// stored before lengths were kept
+func (idx *CorpusIndex) length(doc int, average float64) float64 {
+	if doc < len(idx.Lengths) {
+		return float64(idx.Lengths[doc])
+	}
+	return average
+}
+
+// averageLength returns how many words a text has on average
+func (idx *CorpusIndex) averageLength() float64 {
+	if len(idx.Lengths) == 0 {
+		return 1
+	}
+	total := 0
+	for _, n := range idx.Lengths {
+		total += n
+	}
+	return max(float64(total)/float64(len(idx.Lengths)), 1)
+}
+
+// wordLink matches a clickable word of a processed text, capturing the word.
+// Marking words adds classes after pali-word.
+var wordLink = regexp.MustCompile(`<a href="[^"]*" class="pali-word[^"]*" target="other">([^<]*)</a>`)
+
+// highlighter returns what marks the words searched for in the processed
+// HTML of a text as hits, for a reader opened from a search result, or nil
+// for a query with no words
+func highlighter(query string) func(content string) string {
+	terms := queryTerms(query)
+	if len(terms) == 0 {
+		return nil
+	}
+	return func(content string) string {
+		return wordLink.ReplaceAllStringFunc(content, func(link string) string {
+			word := wordLink.FindStringSubmatch(link)[1]
+			if !slices.Contains(terms, cleanWord(html.UnescapeString(word))) {
+				return link
+			}
+			return strings.Replace(link, `class="pali-word`, `class="pali-word hit`, 1)
+		})
+	}
+}
+
+// snippetWords is how many words of a text a snippet shows
+const snippetWords = 30
+
+// addSnippets shows with each result the paragraph of its text holding the
+// most of the words searched for, around the first of them, and links to
+// it
+func addSnippets(results []SearchResult, query string) {
+	terms := queryTerms(query)
+	for i := range results {
+		content, err := fs.ReadFile(corpus, results[i].Path)
+		if err != nil {
+			continue
+		}
+		paragraph, words, matches := -1, []string(nil), []bool(nil)
+		best, bestCount := 0, 0
+		for j, text := range paragraphs(results[i].Path, string(content)) {
+			fields := strings.Fields(text)
+			matched := make([]bool, len(fields))
+			found := make(map[string]bool)
+			count := 0
+			for k, field := range fields {
+				forEachWord(field, func(word string) {
+					if slices.Contains(terms, word) {
+						matched[k] = true
+						found[word] = true
+					}
+				})
+				if matched[k] {
+					count++
+				}
+			}
+			if len(found) > best || len(found) == best && count > bestCount {
+				paragraph, words, matches = j, fields, matched
+				best, bestCount = len(found), count
+			}
+		}
+		if paragraph < 0 {
+			continue
+		}
+
+		first := slices.Index(matches, true)
+		from := max(0, min(first-snippetWords/3, len(words)-snippetWords))
+		to := min(len(words), from+snippetWords)
+		var plain, marked strings.Builder
+		if from > 0 {
+			plain.WriteString("… ")
+			marked.WriteString("… ")
+		}
+		for k := from; k < to; k++ {
+			if k > from {
+				plain.WriteByte(' ')
+				marked.WriteByte(' ')
+			}
+			plain.WriteString(words[k])
+			if matches[k] {
+				marked.WriteString("<b>" + template.HTMLEscapeString(words[k]) + "</b>")
+			} else {
+				marked.WriteString(template.HTMLEscapeString(words[k]))
+			}
+		}
+		if to < len(words) {
+			plain.WriteString(" …")
+			marked.WriteString(" …")
+		}
+		results[i].Anchor = fmt.Sprintf("p%d", paragraph)
+		results[i].Snippet = plain.String()
+		results[i].Marked = template.HTML(marked.String())
+	}
+}
+
+func handleSearch(w http.ResponseWriter, r *http.Request) {
+	query := norm.NFC.String(strings.TrimSpace(r.URL.Query().Get("q")))
+	mode := r.URL.Query().Get("mode")
+	if mode != "regex" && (mode != "semantic" || config.Semantic == nil) {
+		mode = "lexical"
+	}
+
+	scope := searchScope(r.URL.Query().Get("in"))
+	if !canRead(r, scope) {
+		scope = ""
+	}
+
+	data := PageData{
+		Title:        "Search",
+		Query:        query,
+		SearchMode:   mode,
+		SearchScope:  scope,
+		SearchScopes: buildBreadcrumbs(scope),
+	}
+
+	var quotaErr error
+	if query != "" {
+		quotaErr = takeQuota(w, r, "analysis")
+	}
+	if quotaErr != nil {
+		w.WriteHeader(http.StatusTooManyRequests)
+		data.Notice = quotaErr.Error()
+	} else if query != "" {
+		switch mode {
+		case "semantic":
+			results, err := semanticSearch(r.Context(), query, scope)
+			if err != nil {
+				refundQuota(r, "analysis")
+				data.Notice = "Semantic search is unavailable: " + err.Error() + "."
+			}
+			data.SearchResults = results
+		case "regex":
+			results, complete, err := patternSearch(r.Context(), query, func(path string) bool {
+				return inScope(path, scope) && canRead(r, path)
+			})
+			if err != nil {
+				refundQuota(r, "analysis")
+				data.Notice = "The pattern cannot be searched for: " + err.Error() + "."
+			} else if !complete {
+				data.Notice = fmt.Sprintf("The search was stopped after %s; these are the matches in the texts read by then.", patternTimeout)
+			}
+			data.SearchResults = results
+		default:
+			if idx := corpusIndex.Load(); idx != nil {
+				data.SearchResults = idx.Search(query, scope)
+			} else {
+				refundQuota(r, "analysis")
+				data.Notice = "The corpus is still being indexed. Please try again in a moment."
+			}
+		}
+		data.SearchResults = slices.DeleteFunc(data.SearchResults, func(res SearchResult) bool {
+			return !canRead(r, res.Path)
+		})
+		if len(data.SearchResults) > maxSearchResults {
+			data.SearchResults = data.SearchResults[:maxSearchResults]
+		}
+		if mode == "lexical" {
+			addSnippets(data.SearchResults, query)
+		}
+	}
+
+	err := templatesFor(r).ExecuteTemplate(w, "search", data)
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusInternalServerError)
+	}
+}
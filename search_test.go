@@ -0,0 +1,108 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSearchBM25(t *testing.T) {
+	idx := &CorpusIndex{
+		Paths:   []string{"sutta/long.htm", "sutta/short.htm", "vinaya/rare.htm", "vinaya/other.htm"},
+		Lengths: []int{400, 100, 100, 100},
+		Postings: map[string][]Posting{
+			"dhamma":  {{Doc: 0, Count: 3}, {Doc: 1, Count: 3}, {Doc: 2, Count: 1}, {Doc: 3, Count: 9}},
+			"nibbāna": {{Doc: 2, Count: 1}},
+			"sutta":   {{Doc: 0, Count: 1}, {Doc: 1, Count: 1}, {Doc: 2, Count: 1}},
+		},
+	}
+	paths := func(results []SearchResult) []string {
+		var paths []string
+		for _, r := range results {
+			paths = append(paths, r.Path)
+		}
+		return paths
+	}
+	score := func(query, path string) float64 {
+		for _, r := range idx.Search(query, "") {
+			if r.Path == path {
+				return r.Score
+			}
+		}
+		t.Fatalf("%s not found for %q", path, query)
+		return 0
+	}
+
+	if results := idx.Search("", ""); results != nil {
+		t.Errorf("Search of nothing = %v", results)
+	}
+	// Every word must occur
+	if got := paths(idx.Search("dhamma sutta", "")); len(got) != 3 || got[0] == "vinaya/other.htm" {
+		t.Errorf("Search(dhamma sutta) = %q; want long, short and rare", got)
+	}
+	// A shorter text with as many occurrences ranks higher
+	if long, short := score("dhamma", "sutta/long.htm"), score("dhamma", "sutta/short.htm"); long >= short {
+		t.Errorf("long text scores %v, short text %v", long, short)
+	}
+	// A rare word weighs more than a common one
+	if got := paths(idx.Search("sutta nibbāna", "")); len(got) != 1 || got[0] != "vinaya/rare.htm" {
+		t.Errorf("Search(sutta nibbāna) = %q; want vinaya/rare.htm", got)
+	}
+	if rare, common := score("nibbāna", "vinaya/rare.htm"), score("dhamma", "vinaya/rare.htm"); rare <= common {
+		t.Errorf("rare word scores %v, common word %v", rare, common)
+	}
+	// Occurrences add less and less
+	if nine, three := score("dhamma", "vinaya/other.htm"), score("dhamma", "sutta/short.htm"); nine <= three || nine >= 3*three {
+		t.Errorf("9 occurrences score %v, 3 occurrences %v", nine, three)
+	}
+
+	// Either of the words of OR, and none excluded
+	if got := paths(idx.Search("nibbāna OR sutta", "")); len(got) != 3 || got[0] != "vinaya/rare.htm" {
+		t.Errorf("Search(nibbāna OR sutta) = %q; want vinaya/rare.htm first of 3", got)
+	}
+	if got := paths(idx.Search("dhamma -sutta", "")); len(got) != 1 || got[0] != "vinaya/other.htm" {
+		t.Errorf("Search(dhamma -sutta) = %q; want vinaya/other.htm", got)
+	}
+
+	// Within a folder, the words weigh as much as in the whole corpus
+	if got := paths(idx.Search("dhamma", "sutta")); len(got) != 2 || got[0] != "sutta/short.htm" {
+		t.Errorf("Search(dhamma) in sutta = %q; want sutta/short.htm and sutta/long.htm", got)
+	}
+	if got := idx.Search("nibbāna", "vinaya"); len(got) != 1 || got[0].Score != score("nibbāna", "vinaya/rare.htm") {
+		t.Errorf("Search(nibbāna) in vinaya = %v", got)
+	}
+	if got := idx.Search("dhamma", "vin"); len(got) != 0 {
+		t.Errorf("Search(dhamma) in vin = %v; want none", got)
+	}
+}
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		query    string
+		clauses  [][][]string
+		excluded [][]string
+	}{
+		{"", nil, nil},
+		{"dukkha samudaya", [][][]string{{{"dukkha"}}, {{"samudaya"}}}, nil},
+		{"dukkha AND samudaya", [][][]string{{{"dukkha"}}, {{"samudaya"}}}, nil},
+		{"Dukkha", [][][]string{{{"dukkha"}}}, nil},
+		{`"yoniso manasikāra"`, [][][]string{{{"yoniso", "manasikāra"}}}, nil},
+		{"“evaṃ me” sutaṃ", [][][]string{{{"evaṃ", "me"}}, {{"sutaṃ"}}}, nil},
+		{`"yoniso manasikāra`, [][][]string{{{"yoniso", "manasikāra"}}}, nil},
+		{"sīla OR samādhi", [][][]string{{{"sīla"}, {"samādhi"}}}, nil},
+		{"sīla OR samādhi paññā", [][][]string{{{"sīla"}, {"samādhi"}}, {{"paññā"}}}, nil},
+		{"OR sīla", [][][]string{{{"sīla"}}}, nil},
+		{"sīla or samādhi", [][][]string{{{"sīla"}}, {{"or"}}, {{"samādhi"}}}, nil},
+		{"nibbāna -nibbānassa", [][][]string{{{"nibbāna"}}}, [][]string{{"nibbānassa"}}},
+		{"nibbāna NOT nibbānassa", [][][]string{{{"nibbāna"}}}, [][]string{{"nibbānassa"}}},
+		{`nibbāna -"parinibbāna sutta"`, [][][]string{{{"nibbāna"}}}, [][]string{{"parinibbāna", "sutta"}}},
+		{"nibbāna NOT OR sīla", [][][]string{{{"nibbāna"}}}, [][]string{{"sīla"}}},
+		{"183", nil, nil},
+	}
+	for _, tt := range tests {
+		q := parseQuery(tt.query)
+		if !reflect.DeepEqual(q.clauses, tt.clauses) || !reflect.DeepEqual(q.excluded, tt.excluded) {
+			t.Errorf("parseQuery(%q) = %q, excluding %q; want %q, excluding %q",
+				tt.query, q.clauses, q.excluded, tt.clauses, tt.excluded)
+		}
+	}
+}
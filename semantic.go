@@ -0,0 +1,438 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// minPassageChars is how long a passage must grow before it is embedded on
+// its own; shorter paragraphs are merged with the ones that follow
+const minPassageChars = 400
+
+// maxSnippetChars caps the passage text kept for display
+const maxSnippetChars = 300
+
+// SemanticConfig selects the embedding provider used for semantic search.
+// Provider "http" posts to an OpenAI-compatible embeddings endpoint;
+// provider "command" runs a local program (for example a wrapper around an
+// ONNX model) that reads {"texts": [...]} on stdin and writes
+// {"embeddings": [[...], ...]} to stdout.
+type SemanticConfig struct {
+	Provider  string   `json:"provider"`
+	URL       string   `json:"url"`
+	Model     string   `json:"model"`
+	APIKeyEnv string   `json:"apiKeyEnv"`
+	Command   []string `json:"command"`
+	BatchSize int      `json:"batchSize"`
+}
+
+// Embedder turns texts into vectors
+type Embedder interface {
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+}
+
+// newEmbedder returns the embedder described by the config
+func newEmbedder(c *SemanticConfig) (Embedder, error) {
+	switch c.Provider {
+	case "http":
+		if c.URL == "" {
+			return nil, errors.New("semantic search: the http provider needs a url")
+		}
+		return &httpEmbedder{config: c, client: &http.Client{Timeout: 2 * time.Minute}}, nil
+	case "command":
+		if len(c.Command) == 0 {
+			return nil, errors.New("semantic search: the command provider needs a command")
+		}
+		return &commandEmbedder{command: c.Command}, nil
+	}
+	return nil, fmt.Errorf("semantic search: unknown provider %q", c.Provider)
+}
+
+// semanticEmbedder is the embedder of the config, made once for every
+// query and indexing pass, which share its connections
+var semanticEmbedder = sync.OnceValues(func() (Embedder, error) {
+	return newEmbedder(config.Semantic)
+})
+
+// httpEmbedder calls an OpenAI-compatible /embeddings endpoint
+type httpEmbedder struct {
+	config *SemanticConfig
+	client *http.Client
+}
+
+func (e *httpEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	body, err := json.Marshal(map[string]any{"model": e.config.Model, "input": texts})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if e.config.APIKeyEnv != "" {
+		req.Header.Set("Authorization", "Bearer "+os.Getenv(e.config.APIKeyEnv))
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("embedding request failed: %s", resp.Status)
+	}
+
+	var result struct {
+		Data []struct {
+			Index     int       `json:"index"`
+			Embedding []float32 `json:"embedding"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	if len(result.Data) != len(texts) {
+		return nil, fmt.Errorf("embedding request returned %d vectors for %d texts", len(result.Data), len(texts))
+	}
+
+	vectors := make([][]float32, len(texts))
+	for _, d := range result.Data {
+		if d.Index < 0 || d.Index >= len(vectors) {
+			return nil, fmt.Errorf("embedding request returned index %d out of range", d.Index)
+		}
+		vectors[d.Index] = d.Embedding
+	}
+	return vectors, nil
+}
+
+// commandEmbedder runs a local program once per batch
+type commandEmbedder struct {
+	command []string
+}
+
+func (e *commandEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	input, err := json.Marshal(map[string]any{"texts": texts})
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.CommandContext(ctx, e.command[0], e.command[1:]...)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stderr = os.Stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", e.command[0], err)
+	}
+
+	var result struct {
+		Embeddings [][]float32 `json:"embeddings"`
+	}
+	if err := json.Unmarshal(output, &result); err != nil {
+		return nil, fmt.Errorf("%s: %w", e.command[0], err)
+	}
+	if len(result.Embeddings) != len(texts) {
+		return nil, fmt.Errorf("%s returned %d vectors for %d texts", e.command[0], len(result.Embeddings), len(texts))
+	}
+	return result.Embeddings, nil
+}
+
+// Passage is an embedded stretch of text, identified by the index of its
+// first paragraph
+type Passage struct {
+	Path      string
+	Paragraph int
+	Snippet   string
+	Vector    []float32 // normalized to unit length
+}
+
+// EmbeddingStore holds the passage vectors of the corpus
+type EmbeddingStore struct {
+	Model    string
+	Hashes   map[string]string // text path -> content hash when embedded
+	Passages []Passage
+}
+
+// embeddings is nil until the store has been loaded
+var embeddings atomic.Pointer[EmbeddingStore]
+
+func embeddingStorePath() string {
+	return filepath.Join(config.DataDir, "embeddings.gob")
+}
+
+func loadEmbeddingStore(path string) (*EmbeddingStore, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var store EmbeddingStore
+	if err := gob.NewDecoder(f).Decode(&store); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &store, nil
+}
+
+func saveEmbeddingStore(path string, store *EmbeddingStore) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(store); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, buf.Bytes())
+}
+
+// startSemanticSearch makes the embedder and loads the embedding store in
+// the background
+func startSemanticSearch() {
+	if _, err := semanticEmbedder(); err != nil {
+		log.Println(err)
+		return
+	}
+	go func() {
+		store, err := loadEmbeddingStore(embeddingStorePath())
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				log.Println("Semantic search has no embeddings yet; run 'palireader embed' to build them")
+			} else {
+				log.Println("Error loading embeddings:", err)
+			}
+			return
+		}
+		embeddings.Store(store)
+		log.Printf("Loaded %d passage embeddings", len(store.Passages))
+	}()
+}
+
+// semanticSearch ranks passages by cosine similarity to the query, taking
+// only those in the folder scope unless it is empty
+func semanticSearch(ctx context.Context, query, scope string) ([]SearchResult, error) {
+	store := embeddings.Load()
+	if store == nil {
+		return nil, errors.New("no passage embeddings are loaded yet")
+	}
+
+	embedder, err := semanticEmbedder()
+	if err != nil {
+		return nil, err
+	}
+	vectors, err := embedder.Embed(ctx, []string{query})
+	if err != nil {
+		logf(ctx, "Error embedding query: %v", err)
+		return nil, errors.New("the embedding provider could not be reached")
+	}
+	q := normalize(vectors[0])
+
+	results := make([]SearchResult, 0, len(store.Passages))
+	for _, p := range store.Passages {
+		if len(p.Vector) != len(q) || !inScope(p.Path, scope) {
+			continue
+		}
+		results = append(results, SearchResult{
+			Path:    p.Path,
+			Anchor:  fmt.Sprintf("p%d", p.Paragraph),
+			Score:   float64(dot(q, p.Vector)),
+			Snippet: p.Snippet,
+		})
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Score > results[j].Score
+	})
+	if len(results) > maxSearchResults {
+		results = results[:maxSearchResults]
+	}
+	return results, nil
+}
+
+func dot(a, b []float32) float32 {
+	var sum float32
+	for i := range a {
+		sum += a[i] * b[i]
+	}
+	return sum
+}
+
+func normalize(v []float32) []float32 {
+	var sum float64
+	for _, x := range v {
+		sum += float64(x) * float64(x)
+	}
+	norm := float32(math.Sqrt(sum))
+	if norm == 0 {
+		return v
+	}
+	out := make([]float32, len(v))
+	for i, x := range v {
+		out[i] = x / norm
+	}
+	return out
+}
+
+// runEmbed implements the embed command: it embeds the passages of every
+// text that changed since the last run and saves the store
+func runEmbed(args []string) error {
+	flags := flag.NewFlagSet("embed", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: palireader embed")
+		fmt.Fprintln(flags.Output(), "\nBuilds or updates the passage embeddings used by semantic search.")
+	}
+	flags.Parse(args)
+
+	if config.Semantic == nil {
+		return errors.New(`semantic search is not configured; add a "semantic" section to the config file`)
+	}
+	embedder, err := semanticEmbedder()
+	if err != nil {
+		return err
+	}
+
+	storePath := embeddingStorePath()
+	store, err := loadEmbeddingStore(storePath)
+	if errors.Is(err, fs.ErrNotExist) || (err == nil && store.Model != config.Semantic.Model) {
+		store, err = &EmbeddingStore{Model: config.Semantic.Model}, nil
+	}
+	if err != nil {
+		return err
+	}
+	if store.Hashes == nil {
+		store.Hashes = make(map[string]string)
+	}
+
+	// Keep passages of unchanged texts, re-embed the rest
+	current := make(map[string]string)
+	err = walkCorpus("", func(rel string, d fs.DirEntry) error {
+		if d.IsDir() || !strings.HasSuffix(strings.ToLower(d.Name()), ".htm") {
+			return nil
+		}
+		content, err := fs.ReadFile(corpus, rel)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(content)
+		current[rel] = hex.EncodeToString(sum[:])
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	kept := store.Passages[:0]
+	for _, p := range store.Passages {
+		if hash, ok := current[p.Path]; ok && hash == store.Hashes[p.Path] {
+			kept = append(kept, p)
+		}
+	}
+	store.Passages = kept
+
+	paths := make([]string, 0, len(current))
+	for path, hash := range current {
+		if store.Hashes[path] != hash {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	for path := range store.Hashes {
+		if _, ok := current[path]; !ok {
+			delete(store.Hashes, path)
+		}
+	}
+
+	batchSize := config.Semantic.BatchSize
+	if batchSize <= 0 {
+		batchSize = 32
+	}
+
+	ctx := context.Background()
+	for i, path := range paths {
+		content, err := fs.ReadFile(corpus, path)
+		if err != nil {
+			return err
+		}
+		passages := passagesOf(path, string(content))
+		fmt.Printf("[%d/%d] %s: %d passages\n", i+1, len(paths), path, len(passages))
+
+		for start := 0; start < len(passages); start += batchSize {
+			end := min(start+batchSize, len(passages))
+			texts := make([]string, end-start)
+			for j := range texts {
+				texts[j] = passages[start+j].Snippet
+			}
+			vectors, err := embedder.Embed(ctx, texts)
+			if err != nil {
+				return err
+			}
+			for j, v := range vectors {
+				passages[start+j].Vector = normalize(v)
+			}
+		}
+
+		for j := range passages {
+			passages[j].Snippet = truncateWords(passages[j].Snippet, maxSnippetChars)
+		}
+		store.Passages = append(store.Passages, passages...)
+		store.Hashes[path] = current[path]
+
+		// Save after every text so an interrupted run keeps its progress
+		if err := saveEmbeddingStore(storePath, store); err != nil {
+			return err
+		}
+	}
+
+	if err := saveEmbeddingStore(storePath, store); err != nil {
+		return err
+	}
+	fmt.Printf("%d passages embedded in %s\n", len(store.Passages), storePath)
+	return nil
+}
+
+// passagesOf groups the paragraphs of a text into passages of at least
+// minPassageChars. The full passage text is left in Snippet for embedding.
+func passagesOf(path, content string) []Passage {
+	var passages []Passage
+	var current strings.Builder
+	first := -1
+
+	flush := func() {
+		if current.Len() > 0 {
+			passages = append(passages, Passage{Path: path, Paragraph: first, Snippet: current.String()})
+		}
+		current.Reset()
+		first = -1
+	}
+
+	for i, text := range paragraphs(path, content) {
+		if text == "" {
+			continue
+		}
+		if first < 0 {
+			first = i
+		} else {
+			current.WriteByte(' ')
+		}
+		current.WriteString(text)
+		if current.Len() >= minPassageChars {
+			flush()
+		}
+	}
+	flush()
+	return passages
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// UserSettings are a reader's own preferences, kept by user or, for readers
+// not logged in, by visitor, as dataOwner names them
+type UserSettings struct {
+	CSS string `json:"css,omitempty"` // custom CSS added to every page
+
+	// Anusvara is the sign texts show the niggahīta with, "" for the
+	// text's own; ClassNasals shows it as the nasal of a following stop
+	Anusvara    string `json:"anusvara,omitempty"`
+	ClassNasals bool   `json:"classNasals,omitempty"`
+
+	// Known are the words the reader marked as known, and the KnownTop
+	// most frequent words of the corpus count as known too. Texts show
+	// known words as plain text and the others as links.
+	Known    []string `json:"known,omitempty"`
+	KnownTop int      `json:"knownTop,omitempty"`
+}
+
+// anusvara returns how the reader wants the niggahīta written
+func (s UserSettings) anusvara() anusvaraStyle {
+	return anusvaraStyle{sign: s.Anusvara, class: s.ClassNasals}
+}
+
+var settings = &jsonFile[map[string]*UserSettings]{name: "settings.json"}
+
+// maxCustomCSS caps the size of a custom CSS snippet
+const maxCustomCSS = 8 << 10
+
+// unsafeCSS matches what a custom snippet may not use: imports, markup that
+// could end the style sheet, the old ways of running script from CSS,
+// image sets, which load the images they name without url(), and escapes,
+// which could spell any of these
+var unsafeCSS = regexp.MustCompile(`(?i)@import[^;]*;?|<|expression\s*\(|javascript:|vbscript:|-moz-binding|behavior\s*:|image-set\s*\(|\\`)
+
+// cssURL matches the start of a url() and what it loads
+var cssURL = regexp.MustCompile(`(?i)url\(\s*(['"]?)\s*([^'")\s]*)`)
+
+// sanitizeCSS makes a custom snippet safe to serve. It may load images and
+// fonts from this site or data: URLs, not from other sites, which would
+// learn which pages the reader opens.
+func sanitizeCSS(css string) string {
+	css = strings.ReplaceAll(css, "\r\n", "\n")
+	if len(css) > maxCustomCSS {
+		cut := maxCustomCSS
+		for cut > 0 && !utf8.RuneStart(css[cut]) {
+			cut--
+		}
+		css = css[:cut]
+	}
+	// Repeat until nothing is left to drop, as dropping can join fragments
+	// into a new match
+	for unsafeCSS.MatchString(css) {
+		css = unsafeCSS.ReplaceAllString(css, "")
+	}
+	css = cssURL.ReplaceAllStringFunc(css, func(match string) string {
+		m := cssURL.FindStringSubmatch(match)
+		target := strings.ToLower(m[2])
+		if strings.HasPrefix(target, "data:") || !strings.Contains(target, ":") && !strings.HasPrefix(target, "//") {
+			return match
+		}
+		return "url(" + m[1] + "about:invalid"
+	})
+	return strings.TrimSpace(css)
+}
+
+// empty reports whether the settings are all the defaults
+func (s UserSettings) empty() bool {
+	return s.CSS == "" && s.Anusvara == "" && !s.ClassNasals && len(s.Known) == 0 && s.KnownTop == 0
+}
+
+var errNoOwner = errors.New("no user or visitor to save the settings of")
+
+// readSettings calls fn with the settings of whoever made the request, the
+// defaults for a browser that has saved none
+func readSettings(r *http.Request, fn func(s *UserSettings)) error {
+	owner := dataOwner(r)
+	if owner == "" {
+		fn(&UserSettings{})
+		return nil
+	}
+	return settings.Read(func(all *map[string]*UserSettings) {
+		s := (*all)[owner]
+		if s == nil {
+			s = &UserSettings{}
+		}
+		fn(s)
+	})
+}
+
+// updateSettings changes the settings of whoever made the request with fn
+func updateSettings(r *http.Request, fn func(s *UserSettings)) error {
+	owner := dataOwner(r)
+	if owner == "" {
+		return errNoOwner
+	}
+	return settings.Update(func(all *map[string]*UserSettings) error {
+		if *all == nil {
+			*all = make(map[string]*UserSettings)
+		}
+		s := (*all)[owner]
+		if s == nil {
+			s = &UserSettings{}
+			(*all)[owner] = s
+		}
+		fn(s)
+		if s.empty() {
+			delete(*all, owner)
+		}
+		return nil
+	})
+}
+
+// userSettings returns the settings of whoever made the request
+func userSettings(r *http.Request) (UserSettings, error) {
+	var s UserSettings
+	err := readSettings(r, func(found *UserSettings) { s = *found })
+	return s, err
+}
+
+// handleSettings shows the settings form (GET) or saves it (POST)
+func handleSettings(w http.ResponseWriter, r *http.Request) {
+	data := PageData{Title: "Settings"}
+
+	if r.Method == http.MethodPost {
+		// Room for the CSS and a list of known words, escaped
+		r.Body = http.MaxBytesReader(w, r.Body, 4*maxCustomCSS+maxKnownWords*64)
+		css := sanitizeCSS(r.FormValue("css"))
+		anusvara := r.FormValue("anusvara")
+		if !validAnusvaraSign(anusvara) {
+			httpError(w, r, "Unknown niggahīta sign", http.StatusBadRequest)
+			return
+		}
+		knownTop, _ := strconv.Atoi(r.FormValue("knownTop"))
+		known := knownWordList(r.FormValue("known"))
+		err := updateSettings(r, func(s *UserSettings) {
+			s.CSS = css
+			s.Anusvara, s.ClassNasals = anusvara, r.FormValue("classNasals") != ""
+			s.Known, s.KnownTop = known, max(knownTop, 0)
+		})
+		switch {
+		case errors.Is(err, errNoOwner):
+			// A private site gives readers who are not logged in no visitor
+			// cookie to keep their settings by
+			data.Notice = "Settings are only saved for logged-in readers on this site."
+		case err != nil:
+			logf(r.Context(), "Error saving settings: %v", err)
+			httpError(w, r, "Cannot save settings", http.StatusInternalServerError)
+			return
+		default:
+			data.Notice = "Settings saved."
+		}
+	}
+	showSettings(w, r, data)
+}
+
+// showSettings renders the settings form with the reader's settings
+func showSettings(w http.ResponseWriter, r *http.Request, data PageData) {
+	s, err := userSettings(r)
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	data.Settings = &s
+	err = templatesFor(r).ExecuteTemplate(w, "settings", data)
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// handleCustomCSS serves the custom CSS of whoever made the request
+func handleCustomCSS(w http.ResponseWriter, r *http.Request) {
+	s, err := userSettings(r)
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/css; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	// Differs by user, so must not be cached across logins
+	w.Header().Set("Cache-Control", "private, no-cache")
+	w.Write([]byte(s.CSS))
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSanitizeCSS(t *testing.T) {
+	tests := []struct {
+		css, want string
+	}{
+		{".reader-content { line-height: 2; }", ".reader-content { line-height: 2; }"},
+		{"a {}\r\nb {}\r\n", "a {}\nb {}"},
+		{"@import url(https://example.com/x.css);\n.a { color: red }", ".a { color: red }"},
+		{"@IMPORT 'x.css'; .a {}", ".a {}"},
+		{"</style><script>alert(1)</script>", "/style>script>alert(1)/script>"},
+		{"a { width: expression(alert(1)) }", "a { width: alert(1)) }"},
+		{"a { width: expr<ession(1) }", "a { width: 1) }"},
+		{`a { width: exp\ression(1) }`, "a { width: 1) }"},
+		{"a { background: url(javascript:alert(1)) }", "a { background: url(alert(1)) }"},
+		{"a { behavior: url(x.htc) }", "a {  url(x.htc) }"},
+		{"a { -moz-binding: url(x.xml) }", "a { : url(x.xml) }"},
+		{"a { background: image-set('x.png' 1x) }", "a { background: 'x.png' 1x) }"},
+		// Images and fonts load from this site or data: URLs only
+		{"a { background: url(/static/bg.png) }", "a { background: url(/static/bg.png) }"},
+		{"a { background: url(data:image/png;base64,AA==) }", "a { background: url(data:image/png;base64,AA==) }"},
+		{"a { background: url(https://example.com/p.png) }", "a { background: url(about:invalid) }"},
+		{`a { background: url( "//example.com/p.png" ) }`, `a { background: url("about:invalid" ) }`},
+	}
+	for _, tt := range tests {
+		if got := sanitizeCSS(tt.css); got != tt.want {
+			t.Errorf("sanitizeCSS(%q) = %q; want %q", tt.css, got, tt.want)
+		}
+	}
+
+	long := sanitizeCSS("x" + strings.Repeat("ā", maxCustomCSS))
+	if len(long) > maxCustomCSS || !utf8.ValidString(long) {
+		t.Errorf("a long snippet is cut to %d bytes, valid UTF-8 %v", len(long), utf8.ValidString(long))
+	}
+}
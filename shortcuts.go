@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// QuickResult is a folder or text whose title matches a quick search
+type QuickResult struct {
+	Title  string `json:"title"`
+	Path   string `json:"path"`
+	ID     string `json:"id,omitempty"` // the nikāya or book ID of the sidecar
+	URL    string `json:"url"`
+	Folder bool   `json:"folder,omitempty"`
+}
+
+const (
+	// maxQuickResults is how many titles a quick search returns
+	maxQuickResults = 20
+	// maxTreeResults is how many a folder's filter box shows
+	maxTreeResults = 200
+	// treeFilterMin is how many entries a folder has before its page gets
+	// a filter box
+	treeFilterMin = 24
+	// titleIndexAge is how long the titles are kept without a watcher to
+	// forget them when the corpus changes
+	titleIndexAge = time.Minute
+)
+
+// titleEntry is a folder or text as a quick search looks for it
+type titleEntry struct {
+	path, title, id string
+	folded          string // the title, path and slug, folded like slugs
+	folder          bool
+}
+
+// titleIndex keeps the titles of the corpus while the watcher sees no
+// change, or for titleIndexAge without one
+var titleIndex = struct {
+	sync.Mutex
+	generation uint64
+	built      time.Time
+	entries    []titleEntry
+}{}
+
+// foldTitle reduces a title or query to lower-case ASCII letters and digits
+// without spaces, so that "Mahāsatipaṭṭhāna" matches "mahasati"
+func foldTitle(s string) string {
+	return strings.ReplaceAll(slugify(s, true), "-", "")
+}
+
+// corpusTitles lists every folder and text of the corpus with its title
+func corpusTitles() ([]titleEntry, error) {
+	generation, enabled := corpusCache.state()
+	titleIndex.Lock()
+	defer titleIndex.Unlock()
+	fresh := enabled || time.Since(titleIndex.built) < titleIndexAge
+	if titleIndex.entries != nil && titleIndex.generation == generation && fresh {
+		return titleIndex.entries, nil
+	}
+
+	var entries []titleEntry
+	add := func(dir string) {
+		for _, child := range buildFileTree(dir).Children {
+			if !child.IsDir && !strings.HasSuffix(strings.ToLower(child.Name), ".htm") {
+				continue
+			}
+			entries = append(entries, titleEntry{
+				path:   child.Path,
+				title:  child.DisplayName(),
+				id:     child.ID,
+				folded: foldTitle(child.DisplayName()) + " " + foldTitle(child.Path) + " " + foldTitle(slugPath(child.Path)),
+				folder: child.IsDir,
+			})
+		}
+	}
+	add("")
+	err := walkCorpus("", func(rel string, d fs.DirEntry) error {
+		if d.IsDir() {
+			add(rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	titleIndex.generation, titleIndex.built, titleIndex.entries = generation, time.Now(), entries
+	return entries, nil
+}
+
+// quickSearch returns the folders and texts the reader may open whose title
+// or path holds every word of the query, those whose title starts with the
+// first word first, then the shorter titles
+func quickSearch(r *http.Request, query string) ([]QuickResult, error) {
+	return titleSearch(r, query, "", maxQuickResults)
+}
+
+// titleSearch is quickSearch within the folder dir, the whole corpus if
+// "", returning up to limit results
+func titleSearch(r *http.Request, query, dir string, limit int) ([]QuickResult, error) {
+	var words []string
+	for _, w := range strings.Fields(query) {
+		if f := foldTitle(w); f != "" {
+			words = append(words, f)
+		}
+	}
+	if len(words) == 0 {
+		return []QuickResult{}, nil
+	}
+	entries, err := corpusTitles()
+	if err != nil {
+		return nil, err
+	}
+
+	var found []titleEntry
+	for _, e := range entries {
+		matches := true
+		for _, w := range words {
+			if !strings.Contains(e.folded, w) {
+				matches = false
+				break
+			}
+		}
+		inside := dir == "" || strings.HasPrefix(e.path, dir+"/")
+		if matches && inside && canRead(r, e.path) {
+			found = append(found, e)
+		}
+	}
+	sort.SliceStable(found, func(i, j int) bool {
+		pi := strings.HasPrefix(found[i].folded, words[0])
+		pj := strings.HasPrefix(found[j].folded, words[0])
+		if pi != pj {
+			return pi
+		}
+		return len(found[i].title) < len(found[j].title)
+	})
+
+	results := []QuickResult{}
+	for _, e := range found[:min(len(found), limit)] {
+		results = append(results, QuickResult{
+			Title:  e.title,
+			Path:   e.path,
+			ID:     e.id,
+			URL:    sitePath("/read/" + slugPath(e.path)),
+			Folder: e.folder,
+		})
+	}
+	return results, nil
+}
+
+// handleQuick finds folders and texts by their titles for the command
+// palette: /quick?q=mahasati
+func handleQuick(w http.ResponseWriter, r *http.Request) {
+	results, err := quickSearch(r, r.URL.Query().Get("q"))
+	if err != nil {
+		logf(r.Context(), "Error listing titles: %v", err)
+		writeAPIError(w, r, http.StatusInternalServerError, "Cannot list the titles", nil)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
+
+// handleTree filters a folder's listing for the filter box of its page:
+// /tree/digha-nikaya?q=mahasati lists the folders and texts below it whose
+// titles or names match, diacritics or not
+func handleTree(w http.ResponseWriter, r *http.Request) {
+	dir, err := corpusName(strings.TrimPrefix(r.URL.Path, "/tree/"))
+	if err != nil {
+		writeAPIError(w, r, http.StatusBadRequest, "Invalid path", nil)
+		return
+	}
+	if dir == "." {
+		dir = ""
+	}
+	if dir != "" && !canRead(r, dir) {
+		writeAPIError(w, r, http.StatusNotFound, "Folder not found", nil)
+		return
+	}
+	results, err := titleSearch(r, r.URL.Query().Get("q"), dir, maxTreeResults)
+	if err != nil {
+		logf(r.Context(), "Error listing titles: %v", err)
+		writeAPIError(w, r, http.StatusInternalServerError, "Cannot list the titles", nil)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
+
+// adjacentText returns the text offset places from the one at filePath in
+// its folder, skipping folders, or "" where there is none
+func adjacentText(filePath string, offset int) string {
+	parent := path.Dir(filePath)
+	if parent == "." {
+		parent = ""
+	}
+	var texts []string
+	at := -1
+	for _, child := range buildFileTree(parent).Children {
+		if child.IsDir || !strings.HasSuffix(strings.ToLower(child.Name), ".htm") {
+			continue
+		}
+		if child.Path == filePath {
+			at = len(texts)
+		}
+		texts = append(texts, child.Path)
+	}
+	if at < 0 || at+offset < 0 || at+offset >= len(texts) {
+		return ""
+	}
+	return texts[at+offset]
+}
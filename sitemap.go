@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxSitemapURLs is how many URLs one sitemap may list, by the protocol.
+// A larger corpus gets a sitemap index, with a sitemap for each folder that
+// fits.
+const maxSitemapURLs = 50000
+
+// sitemapPage is a folder or text of the corpus a sitemap lists
+type sitemapPage struct {
+	path     string // in the corpus, "" for the home page
+	modified time.Time
+}
+
+// sitemapPages keeps the pages of the corpus while the watcher sees no
+// change; without a watcher they are listed afresh every time
+var sitemapPages = struct {
+	sync.Mutex
+	generation uint64
+	pages      []sitemapPage
+}{}
+
+// publicPages lists the home page and every folder and text of the corpus
+// but the protected ones, in the order of the corpus
+func publicPages() ([]sitemapPage, error) {
+	generation, enabled := corpusCache.state()
+	sitemapPages.Lock()
+	defer sitemapPages.Unlock()
+	if enabled && sitemapPages.pages != nil && sitemapPages.generation == generation {
+		return sitemapPages.pages, nil
+	}
+
+	pages := []sitemapPage{{}}
+	err := walkCorpus("", func(rel string, d fs.DirEntry) error {
+		if protectedPath(rel) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !d.IsDir() && !strings.HasSuffix(strings.ToLower(d.Name()), ".htm") {
+			return nil
+		}
+		page := sitemapPage{path: rel}
+		if info, err := d.Info(); err == nil && !d.IsDir() {
+			page.modified = info.ModTime()
+		}
+		pages = append(pages, page)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sitemapPages.generation, sitemapPages.pages = generation, pages
+	return pages, nil
+}
+
+// sitemapFolders splits the pages below folder into groups of at most
+// maxSitemapURLs, one for each folder, going down into the folders with
+// more. Each group lists the pages of its folder not in another.
+func sitemapFolders(folder string, pages []sitemapPage) map[string][]sitemapPage {
+	groups := make(map[string][]sitemapPage)
+	if len(pages) <= maxSitemapURLs {
+		groups[folder] = pages
+		return groups
+	}
+	below := make(map[string][]sitemapPage)
+	var order []string
+	for _, p := range pages {
+		rest := strings.TrimPrefix(p.path, folder+"/")
+		if folder == "" {
+			rest = p.path
+		}
+		child, _, nested := strings.Cut(rest, "/")
+		if !nested || p.path == "" {
+			// The folder itself and its texts
+			groups[folder] = append(groups[folder], p)
+			continue
+		}
+		child = path.Join(folder, child)
+		if below[child] == nil {
+			order = append(order, child)
+		}
+		below[child] = append(below[child], p)
+	}
+	for _, child := range order {
+		for name, group := range sitemapFolders(child, below[child]) {
+			groups[name] = group
+		}
+	}
+	// A folder of more texts than one sitemap holds is cut short
+	if len(groups[folder]) > maxSitemapURLs {
+		groups[folder] = groups[folder][:maxSitemapURLs]
+	}
+	return groups
+}
+
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 urlset"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapIndex struct {
+	XMLName  xml.Name     `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 sitemapindex"`
+	Sitemaps []sitemapURL `xml:"sitemap"`
+}
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+// sitemapName is the name of the sitemap of a folder's pages in an index
+func sitemapName(folder string) string {
+	if folder == "" {
+		return "/sitemap/index.xml"
+	}
+	return "/sitemap/" + slugPath(folder) + ".xml"
+}
+
+// handleSitemap lists the public folders and texts for search engines, in
+// one sitemap (/sitemap.xml) or, for a large corpus, in one for each folder
+// (/sitemap/<folder>.xml) that /sitemap.xml lists
+func handleSitemap(w http.ResponseWriter, r *http.Request) {
+	pages, err := publicPages()
+	if err != nil {
+		logf(r.Context(), "Error listing the corpus for the sitemap: %v", err)
+		httpError(w, r, "Cannot list the corpus", http.StatusInternalServerError)
+		return
+	}
+	groups := sitemapFolders("", pages)
+	base := baseURL(r)
+
+	var doc any
+	if r.URL.Path == "/sitemap.xml" && len(groups) > 1 {
+		index := sitemapIndex{}
+		for folder := range groups {
+			index.Sitemaps = append(index.Sitemaps, sitemapURL{Loc: base + sitemapName(folder)})
+		}
+		slices.SortFunc(index.Sitemaps, func(a, b sitemapURL) int { return strings.Compare(a.Loc, b.Loc) })
+		doc = index
+	} else {
+		var group []sitemapPage
+		var found bool
+		if r.URL.Path == "/sitemap.xml" {
+			group, found = groups[""]
+		} else {
+			for folder, g := range groups {
+				if sitemapName(folder) == r.URL.Path && len(groups) > 1 {
+					group, found = g, true
+				}
+			}
+		}
+		if !found {
+			httpError(w, r, "No such sitemap", http.StatusNotFound)
+			return
+		}
+		set := sitemapURLSet{}
+		for _, p := range group {
+			u := sitemapURL{Loc: base + "/"}
+			if p.path != "" {
+				u.Loc = base + "/read/" + slugPath(p.path)
+			}
+			if !p.modified.IsZero() {
+				u.LastMod = p.modified.UTC().Format(time.DateOnly)
+			}
+			set.URLs = append(set.URLs, u)
+		}
+		doc = set
+	}
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	fmt.Fprint(w, xml.Header)
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		logf(r.Context(), "Error writing sitemap: %v", err)
+	}
+	fmt.Fprintln(w)
+}
+
+// handleRobots points search engines to the sitemap
+func handleRobots(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintf(w, "User-agent: *\nAllow: /\n\nSitemap: %s/sitemap.xml\n", baseURL(r))
+}
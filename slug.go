@@ -0,0 +1,159 @@
+package main
+
+import (
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Texts and folders are linked to by slugs, their names without
+// diacritics, in lower case and with dashes for anything else, so that
+// /read/digha-nikaya/1-brahmajalasutta can be typed where the real path
+// would be percent-encoded. The real paths keep working.
+
+// slugTable maps the entries of a folder to their slugs and back
+type slugTable struct {
+	slugs map[string]string // by name
+	names map[string]string // by slug
+}
+
+// slugify returns the slug of a folder or text name: a text loses its
+// .htm extension. A name with no letters or digits in the Latin alphabet,
+// such as one in Devanāgarī, is its own slug.
+func slugify(name string, isDir bool) string {
+	if !isDir && strings.HasSuffix(strings.ToLower(name), ".htm") {
+		name = name[:len(name)-len(".htm")]
+	}
+	var b strings.Builder
+	dash := false
+	for _, r := range norm.NFD.String(name) {
+		switch {
+		case unicode.Is(unicode.Mn, r):
+			continue
+		case r <= unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			dash = false
+			b.WriteRune(unicode.ToLower(r))
+		default:
+			dash = true
+		}
+	}
+	if b.Len() == 0 {
+		return name
+	}
+	return b.String()
+}
+
+// folderSlugs returns the slugs of the folders and texts in the corpus
+// folder dir. An entry whose name is already a slug keeps it; the others
+// take theirs in the listing's order, numbered from -2 when it is taken.
+func folderSlugs(dir string) *slugTable {
+	name, err := corpusName(dir)
+	if err != nil {
+		name = "."
+	}
+	table, generation, ok := corpusCache.slugs(name)
+	if ok {
+		return table
+	}
+	table = &slugTable{slugs: make(map[string]string), names: make(map[string]string)}
+	children := buildFileTree(dir).Children
+	var rest []*FileInfo
+	for _, child := range children {
+		slug := slugify(child.Name, child.IsDir)
+		if slug == child.Name || (!child.IsDir && slug+path.Ext(child.Name) == child.Name) {
+			table.slugs[child.Name] = slug
+			table.names[slug] = child.Name
+		} else {
+			rest = append(rest, child)
+		}
+	}
+	for _, child := range rest {
+		slug := slugify(child.Name, child.IsDir)
+		for n := 2; table.names[slug] != ""; n++ {
+			slug = slugify(child.Name, child.IsDir) + "-" + strconv.Itoa(n)
+		}
+		table.slugs[child.Name] = slug
+		table.names[slug] = child.Name
+	}
+	corpusCache.storeSlugs(name, table, generation)
+	return table
+}
+
+// slugPath returns the slug path of a corpus path, for links. A static
+// site keeps the real paths, as its pages are files named after
+// them.
+func slugPath(p string) string {
+	if staticSite {
+		return p
+	}
+	segments := strings.Split(strings.Trim(p, "/"), "/")
+	dir := ""
+	for i, segment := range segments {
+		slug, ok := folderSlugs(dir).slugs[segment]
+		if !ok {
+			break
+		}
+		dir = path.Join(dir, segment)
+		segments[i] = slug
+	}
+	return strings.Join(segments, "/")
+}
+
+// resolveSlugs returns the corpus path a slug path links to. A real path,
+// or one that leads nowhere, is returned as it is, without slashes around.
+func resolveSlugs(p string) string {
+	p = strings.Trim(p, "/")
+	segments := strings.Split(p, "/")
+	dir := ""
+	for i, segment := range segments {
+		table := folderSlugs(dir)
+		if name, ok := table.names[segment]; ok {
+			segments[i] = name
+		} else if _, ok := table.slugs[segment]; !ok {
+			return p
+		}
+		dir = path.Join(dir, segments[i])
+	}
+	return strings.Join(segments, "/")
+}
+
+// withSlugs turns the slug paths of requests for texts and folders into
+// their corpus paths, before the logins protecting them are checked
+func withSlugs(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, prefix := range []string{"/read/", "/print/", "/glossary/", "/listen/", "/audio/", "/timing/", "/cite/", "/offline/", "/reload/", "/tree/"} {
+			rest, ok := strings.CutPrefix(r.URL.Path, prefix)
+			if !ok || rest == "" {
+				continue
+			}
+			if real := resolveSlugs(rest); real != strings.Trim(rest, "/") {
+				r = r.Clone(r.Context())
+				r.URL.Path = prefix + real
+				r.URL.RawPath = ""
+			}
+			break
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// sourcePath returns the corpus path, with its anchor, of a source the
+// reader sends along with a word, such as /read/digha-nikaya/1-brahmajalasutta#p3
+func sourcePath(source string) string {
+	p, anchor, found := strings.Cut(strings.TrimPrefix(source, "/read/"), "#")
+	if p == "" {
+		return ""
+	}
+	p = resolveSlugs(p)
+	if found {
+		p += "#" + anchor
+	}
+	return p
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"io/fs"
+	"testing"
+	"testing/fstest"
+)
+
+// useCorpus serves fsys as the corpus until the test ends
+func useCorpus(t *testing.T, fsys fs.FS) {
+	saved := corpus
+	corpus = fsys
+	corpusCache.invalidate(".")
+	t.Cleanup(func() {
+		corpus = saved
+		corpusCache.invalidate(".")
+	})
+}
+
+func TestSlugify(t *testing.T) {
+	tests := []struct {
+		name  string
+		isDir bool
+		slug  string
+	}{
+		{"khuddaku.htm", false, "khuddaku"},
+		{"KHUDDAKU.HTM", false, "khuddaku"},
+		{"9_phil", true, "9-phil"},
+		{"notes.htm", true, "notes-htm"},
+		{"Saṃyutta Nikāya", true, "samyutta-nikaya"},
+		{"SN 56.11", true, "sn-56-11"},
+		{"_draft_", true, "draft"},
+		{"धम्मपद", true, "धम्मपद"},
+	}
+	for _, tt := range tests {
+		if slug := slugify(tt.name, tt.isDir); slug != tt.slug {
+			t.Errorf("slugify(%q, %v) = %q; want %q", tt.name, tt.isDir, slug, tt.slug)
+		}
+	}
+}
+
+func TestResolveSlugs(t *testing.T) {
+	text := &fstest.MapFile{Data: []byte("<html><body><p>evaṃ me sutaṃ</body></html>")}
+	useCorpus(t, fstest.MapFS{
+		"Dīgha Nikāya/1 Brahmajālasutta.htm": text,
+		"digha-nikaya/notes.htm":             text,
+		"9_phil/gramm/saddab_u.htm":          text,
+	})
+	tests := []struct {
+		slugs string
+		path  string
+	}{
+		{"9-phil/gramm/saddab-u", "9_phil/gramm/saddab_u.htm"},
+		{"/9-phil/gramm/", "9_phil/gramm"},
+		// A name that is a slug already keeps it; the one that would
+		// take it too is numbered
+		{"digha-nikaya/notes", "digha-nikaya/notes.htm"},
+		{"digha-nikaya-2/1-brahmajalasutta", "Dīgha Nikāya/1 Brahmajālasutta.htm"},
+		// Real paths, and paths leading nowhere, stay as they are
+		{"9_phil/gramm/saddab_u.htm", "9_phil/gramm/saddab_u.htm"},
+		{"9-phil/nowhere", "9-phil/nowhere"},
+	}
+	for _, tt := range tests {
+		if path := resolveSlugs(tt.slugs); path != tt.path {
+			t.Errorf("resolveSlugs(%q) = %q; want %q", tt.slugs, path, tt.path)
+		}
+	}
+
+	// Links made with slugs lead back to the texts
+	for _, name := range []string{"9_phil/gramm/saddab_u.htm", "Dīgha Nikāya/1 Brahmajālasutta.htm"} {
+		if path := resolveSlugs(slugPath(name)); path != name {
+			t.Errorf("resolveSlugs(slugPath(%q)) = %q", name, path)
+		}
+	}
+}
@@ -0,0 +1,484 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"maps"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// sqliteState keeps the values in one SQLite database file: easier to back
+// up, copy and inspect than a directory of data files, and safe for the
+// server and commands such as import to change at the same time
+type sqliteState struct {
+	db *sql.DB
+}
+
+// sqliteTable holds one kind of data file of every reader, such as their
+// bookmarks, a row for each entry of a list or of an object by key
+type sqliteTable struct {
+	name  string
+	keyed bool // the file is an object by key rather than a list
+}
+
+// column names what tells the entries of an owner apart
+func (t sqliteTable) column() string {
+	if t.keyed {
+		return "key"
+	}
+	return "position"
+}
+
+// sqliteTables are the tables of the data files, by file name. Anything
+// else, such as the session key, is kept whole in the state table. A file
+// given a table here needs a migration creating it and importing what the
+// state table held of the file before.
+var sqliteTables = map[string]sqliteTable{
+	"accounts.json":    {"users", true},
+	"annotations.json": {"notes", false},
+	"bookmarks.json":   {"bookmarks", false},
+	"events.json":      {"events", false},
+	"favorites.json":   {"favorites", false},
+	"invitations.json": {"invitations", false},
+	"lookups.json":     {"history", false},
+	"reading.json":     {"reading", true},
+	"recent.json":      {"recent", false},
+	"review.json":      {"review", true},
+	"settings.json":    {"settings", true},
+	"tags.json":        {"tags", true},
+	"tokens.json":      {"tokens", false},
+	"views.json":       {"views", true},
+	"vocab.json":       {"vocab", false},
+}
+
+// sqliteMigration takes a database one version further, inside the
+// transaction that records the new version. dataDir is the data directory
+// of the server, where the data files kept before the database are.
+type sqliteMigration func(tx *sql.Tx, dataDir string) error
+
+// sqliteMigrations bring a database up to date, each in turn. The
+// database's user_version counts those it has had, so a migration is
+// never changed once released, only followed by another.
+var sqliteMigrations = []sqliteMigration{
+	sqlMigration(`CREATE TABLE state (
+		name    TEXT PRIMARY KEY,
+		value   BLOB NOT NULL,
+		updated TEXT NOT NULL
+	)`),
+	// A row for each entry of the data files. owner is "" for the files
+	// of the whole server, such as accounts.json, and otherwise the user
+	// or "visitor:" and the visitor's ID. Columns taken from the entries
+	// make them easy to query.
+	sqlMigration(`
+	CREATE TABLE users (owner TEXT NOT NULL, key TEXT NOT NULL, value TEXT NOT NULL,
+		PRIMARY KEY (owner, key));
+	CREATE TABLE notes (owner TEXT NOT NULL, position INTEGER NOT NULL, value TEXT NOT NULL,
+		path TEXT AS (json_extract(value, '$.path')),
+		PRIMARY KEY (owner, position));
+	CREATE TABLE bookmarks (owner TEXT NOT NULL, position INTEGER NOT NULL, value TEXT NOT NULL,
+		path TEXT AS (json_extract(value, '$.path')),
+		PRIMARY KEY (owner, position));
+	CREATE TABLE events (owner TEXT NOT NULL, position INTEGER NOT NULL, value TEXT NOT NULL,
+		PRIMARY KEY (owner, position));
+	CREATE TABLE favorites (owner TEXT NOT NULL, position INTEGER NOT NULL, value TEXT NOT NULL,
+		path TEXT AS (json_extract(value, '$.path')),
+		PRIMARY KEY (owner, position));
+	CREATE TABLE invitations (owner TEXT NOT NULL, position INTEGER NOT NULL, value TEXT NOT NULL,
+		PRIMARY KEY (owner, position));
+	CREATE TABLE history (owner TEXT NOT NULL, position INTEGER NOT NULL, value TEXT NOT NULL,
+		word TEXT AS (json_extract(value, '$.word')),
+		PRIMARY KEY (owner, position));
+	CREATE TABLE reading (owner TEXT NOT NULL, key TEXT NOT NULL, value TEXT NOT NULL,
+		PRIMARY KEY (owner, key));
+	CREATE TABLE recent (owner TEXT NOT NULL, position INTEGER NOT NULL, value TEXT NOT NULL,
+		path TEXT AS (json_extract(value, '$.path')),
+		PRIMARY KEY (owner, position));
+	CREATE TABLE review (owner TEXT NOT NULL, key TEXT NOT NULL, value TEXT NOT NULL,
+		PRIMARY KEY (owner, key));
+	CREATE TABLE settings (owner TEXT NOT NULL, key TEXT NOT NULL, value TEXT NOT NULL,
+		PRIMARY KEY (owner, key));
+	CREATE TABLE tags (owner TEXT NOT NULL, key TEXT NOT NULL, value TEXT NOT NULL,
+		PRIMARY KEY (owner, key));
+	CREATE TABLE tokens (owner TEXT NOT NULL, position INTEGER NOT NULL, value TEXT NOT NULL,
+		PRIMARY KEY (owner, position));
+	CREATE TABLE views (owner TEXT NOT NULL, key TEXT NOT NULL, value TEXT NOT NULL,
+		PRIMARY KEY (owner, key));
+	CREATE TABLE vocab (owner TEXT NOT NULL, position INTEGER NOT NULL, value TEXT NOT NULL,
+		word TEXT AS (json_extract(value, '$.word')),
+		PRIMARY KEY (owner, position));
+	`),
+	importDataFiles,
+}
+
+// sqlMigration is a migration made of SQL statements
+func sqlMigration(statements string) sqliteMigration {
+	return func(tx *sql.Tx, dataDir string) error {
+		_, err := tx.Exec(statements)
+		return err
+	}
+}
+
+// openSQLiteState opens the database at path, creating it if need be, and
+// migrates it to the current schema, importing the data files of dataDir
+// the first time
+func openSQLiteState(path, dataDir string) (*sqliteState, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	// Transactions take the write lock at once, so that an update never
+	// fails halfway for another writer, which waits its turn instead
+	dsn := "file:" + path + "?_pragma=busy_timeout(10000)&_pragma=journal_mode(WAL)&_txlock=immediate"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := migrateSQLite(db, dataDir); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &sqliteState{db}, nil
+}
+
+// migrateSQLite applies the migrations the database has not had yet
+func migrateSQLite(db *sql.DB, dataDir string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var version int
+	if err := tx.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+	if version > len(sqliteMigrations) {
+		return fmt.Errorf("the database is at version %d, made by a newer palireader than this one (%d)", version, len(sqliteMigrations))
+	}
+	for i := version; i < len(sqliteMigrations); i++ {
+		if err := sqliteMigrations[i](tx, dataDir); err != nil {
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+	}
+	if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", len(sqliteMigrations))); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// importDataFiles moves what users saved into the tables: the values the
+// state table held whole, and the data files of the data directory that it
+// did not, which are left where they are. The session key comes along, so
+// that logins hold. The tables are those of the files when it was
+// released, which is what databases it runs on hold, however sqliteTables
+// grows.
+func importDataFiles(tx *sql.Tx, dataDir string) error {
+	tables := map[string]sqliteTable{
+		"accounts.json":    {"users", true},
+		"annotations.json": {"notes", false},
+		"bookmarks.json":   {"bookmarks", false},
+		"events.json":      {"events", false},
+		"favorites.json":   {"favorites", false},
+		"invitations.json": {"invitations", false},
+		"lookups.json":     {"history", false},
+		"reading.json":     {"reading", true},
+		"recent.json":      {"recent", false},
+		"review.json":      {"review", true},
+		"settings.json":    {"settings", true},
+		"tags.json":        {"tags", true},
+		"tokens.json":      {"tokens", false},
+		"views.json":       {"views", true},
+		"vocab.json":       {"vocab", false},
+	}
+
+	rows, err := tx.Query("SELECT name, value FROM state")
+	if err != nil {
+		return err
+	}
+	held := make(map[string][]byte)
+	for rows.Next() {
+		var name string
+		var value []byte
+		if err := rows.Scan(&name, &value); err != nil {
+			rows.Close()
+			return err
+		}
+		held[name] = value
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	var names []string
+	for file := range tables {
+		names = append(names, file)
+		for _, dir := range []string{"users", "visitors"} {
+			matches, err := filepath.Glob(filepath.Join(dataDir, dir, "*", file))
+			if err != nil {
+				return err
+			}
+			for _, m := range matches {
+				rel, _ := filepath.Rel(dataDir, m)
+				names = append(names, filepath.ToSlash(rel))
+			}
+		}
+	}
+	for name := range held {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	names = slices.Compact(names)
+
+	imported := 0
+	for _, name := range names {
+		table, owner, ok := dataFileLocation(tables, name)
+		if !ok {
+			continue
+		}
+		value, inState := held[name]
+		if !inState {
+			value, err = os.ReadFile(filepath.Join(dataDir, filepath.FromSlash(name)))
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			imported++
+		}
+		if err := saveRows(tx, table, owner, nil, value); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if _, err := tx.Exec("DELETE FROM state WHERE name = ?", name); err != nil {
+			return err
+		}
+	}
+
+	if _, ok := held["session.key"]; !ok {
+		key, err := os.ReadFile(filepath.Join(dataDir, "session.key"))
+		if err == nil {
+			_, err = tx.Exec("INSERT INTO state (name, value, updated) VALUES ('session.key', ?, ?)",
+				key, time.Now().UTC().Format(time.RFC3339))
+		}
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	if imported > 0 {
+		log.Printf("Imported %d data files from %s into the database; they can be removed", imported, dataDir)
+	}
+	return nil
+}
+
+// sqliteLocation returns the table and owner of the entries of a data file
+// named as in the data directory, such as users/ann/vocab.json, and false
+// for the names kept in the state table
+func sqliteLocation(name string) (sqliteTable, string, bool) {
+	return dataFileLocation(sqliteTables, name)
+}
+
+// dataFileLocation returns the table of tables and owner of the entries of
+// a data file, as sqliteLocation does
+func dataFileLocation(tables map[string]sqliteTable, name string) (sqliteTable, string, bool) {
+	dir, file := path.Split(name)
+	table, ok := tables[file]
+	if !ok {
+		return table, "", false
+	}
+	kind, owner, _ := strings.Cut(strings.TrimSuffix(dir, "/"), "/")
+	switch {
+	case dir == "":
+		return table, "", true
+	case kind == "visitors" && visitorIDPattern.MatchString(owner):
+		return table, visitorPrefix + owner, true
+	case kind == "users" && owner != "" && !strings.Contains(owner, "/"):
+		user, err := url.PathUnescape(owner)
+		return table, user, err == nil
+	}
+	return table, "", false
+}
+
+// querier is a database or a transaction
+type querier interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+// loadRows returns the entries an owner has in a table, by key or position
+func loadRows(q querier, table sqliteTable, owner string) (map[string]string, error) {
+	rows, err := q.Query(fmt.Sprintf("SELECT %s, value FROM %s WHERE owner = ?", table.column(), table.name), owner)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	entries := make(map[string]string)
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, err
+		}
+		entries[key] = value
+	}
+	return entries, rows.Err()
+}
+
+// joinRows puts the entries back together into the JSON of the data file,
+// nil if there are none
+func joinRows(table sqliteTable, entries map[string]string) []byte {
+	if len(entries) == 0 {
+		return nil
+	}
+	var b strings.Builder
+	if table.keyed {
+		keys := slices.Sorted(maps.Keys(entries))
+		b.WriteByte('{')
+		for i, k := range keys {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			name, _ := json.Marshal(k)
+			b.Write(name)
+			b.WriteByte(':')
+			b.WriteString(entries[k])
+		}
+		b.WriteByte('}')
+		return []byte(b.String())
+	}
+	b.WriteByte('[')
+	for i := range len(entries) {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(entries[strconv.Itoa(i)])
+	}
+	b.WriteByte(']')
+	return []byte(b.String())
+}
+
+// saveRows makes the owner's rows of the table those of value, the JSON of
+// the data file, writing only the entries that changed from old
+func saveRows(tx *sql.Tx, table sqliteTable, owner string, old map[string]string, value []byte) error {
+	entries := make(map[string]string)
+	if len(strings.TrimSpace(string(value))) > 0 {
+		var raw map[string]json.RawMessage
+		if table.keyed {
+			if err := json.Unmarshal(value, &raw); err != nil {
+				return err
+			}
+		} else {
+			var list []json.RawMessage
+			if err := json.Unmarshal(value, &list); err != nil {
+				return err
+			}
+			raw = make(map[string]json.RawMessage, len(list))
+			for i, e := range list {
+				raw[strconv.Itoa(i)] = e
+			}
+		}
+		for k, e := range raw {
+			var b bytes.Buffer
+			if err := json.Compact(&b, e); err != nil {
+				return err
+			}
+			entries[k] = b.String()
+		}
+	}
+
+	for k := range old {
+		if _, ok := entries[k]; !ok {
+			_, err := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE owner = ? AND %s = ?", table.name, table.column()), owner, k)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for k, e := range entries {
+		if o, ok := old[k]; ok && o == e {
+			continue
+		}
+		_, err := tx.Exec(fmt.Sprintf(`INSERT INTO %[1]s (owner, %[2]s, value) VALUES (?, ?, ?)
+			ON CONFLICT (owner, %[2]s) DO UPDATE SET value = excluded.value`, table.name, table.column()),
+			owner, k, e)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *sqliteState) Load(name string) ([]byte, bool, error) {
+	if table, owner, ok := sqliteLocation(name); ok {
+		entries, err := loadRows(s.db, table, owner)
+		if err != nil {
+			return nil, false, err
+		}
+		// The data files were imported with the tables, so an owner
+		// without rows has nothing saved
+		return joinRows(table, entries), true, nil
+	}
+
+	var value []byte
+	err := s.db.QueryRow("SELECT value FROM state WHERE name = ?", name).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, false, nil
+	}
+	return value, err == nil, err
+}
+
+// Update holds the write lock from reading the value to saving the new
+// one, so fn is only ever called once
+func (s *sqliteState) Update(name string, fn func(old []byte, ok bool) ([]byte, error)) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if table, owner, ok := sqliteLocation(name); ok {
+		old, err := loadRows(tx, table, owner)
+		if err != nil {
+			return err
+		}
+		value, err := fn(joinRows(table, old), true)
+		if err != nil {
+			return err
+		}
+		if err := saveRows(tx, table, owner, old, value); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		return tx.Commit()
+	}
+
+	var old []byte
+	err = tx.QueryRow("SELECT value FROM state WHERE name = ?", name).Scan(&old)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	value, err := fn(old, err == nil)
+	if err != nil {
+		return err
+	}
+	if value == nil {
+		value = []byte{} // no value yet, as when a data file is missing
+	}
+	_, err = tx.Exec(`INSERT INTO state (name, value, updated) VALUES (?, ?, ?)
+		ON CONFLICT (name) DO UPDATE SET value = excluded.value, updated = excluded.updated`,
+		name, value, time.Now().UTC().Format(time.RFC3339))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
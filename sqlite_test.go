@@ -0,0 +1,151 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSQLiteState(t *testing.T) {
+	dataDir := t.TempDir()
+	path := filepath.Join(t.TempDir(), "state", "palireader.db")
+	s, err := openSQLiteState(path, dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := s.Load("session.key"); ok || err != nil {
+		t.Fatalf("Load of nothing = %v, %v", ok, err)
+	}
+
+	add := func(old []byte, ok bool) ([]byte, error) {
+		return append(old, 'x'), nil
+	}
+	for range 2 {
+		if err := s.Update("session.key", add); err != nil {
+			t.Fatal(err)
+		}
+	}
+	failed := errors.New("failed")
+	if err := s.Update("session.key", func([]byte, bool) ([]byte, error) { return nil, failed }); err != failed {
+		t.Errorf("Update = %v; want the error of fn", err)
+	}
+	if err := s.Update("index.gob", func([]byte, bool) ([]byte, error) { return nil, nil }); err != nil {
+		t.Fatal(err)
+	}
+	s.db.Close()
+
+	// Opening the database again migrates nothing and finds the values
+	s, err = openSQLiteState(path, dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value, ok, err := s.Load("session.key"); string(value) != "xx" || !ok || err != nil {
+		t.Errorf("Load = %q, %v, %v; want xx", value, ok, err)
+	}
+	if value, ok, err := s.Load("index.gob"); len(value) != 0 || !ok || err != nil {
+		t.Errorf("Load of an empty value = %q, %v, %v", value, ok, err)
+	}
+	var version int
+	if err := s.db.QueryRow("PRAGMA user_version").Scan(&version); err != nil || version != len(sqliteMigrations) {
+		t.Errorf("user_version = %d, %v; want %d", version, err, len(sqliteMigrations))
+	}
+
+	// A database made by a newer palireader is left alone
+	if _, err := s.db.Exec(fmt.Sprintf("PRAGMA user_version = %d", len(sqliteMigrations)+1)); err != nil {
+		t.Fatal(err)
+	}
+	s.db.Close()
+	if _, err := openSQLiteState(path, dataDir); err == nil {
+		t.Error("a database of a newer version was opened")
+	}
+}
+
+func TestSQLiteTables(t *testing.T) {
+	dataDir := t.TempDir()
+	write := func(name, content string) {
+		path := filepath.Join(dataDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("bookmarks.json", `[{"path": "a.htm"}, {"path": "b.htm"}]`)
+	write("users/ann/vocab.json", `[{"word": "dhamma"}]`)
+	write("visitors/0123456789abcdef0123456789abcdef/settings.json", `{"theme": "dark"}`)
+	write("session.key", "cafe")
+
+	// A database of the first version, holding a data file whole in the
+	// state table
+	path := filepath.Join(t.TempDir(), "palireader.db")
+	db, err := sql.Open("sqlite", "file:"+path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sqliteMigrations[0](tx, dataDir); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`INSERT INTO state (name, value, updated) VALUES ('users/bob/tags.json', '{"a.htm": ["sila"]}', '');
+		PRAGMA user_version = 1`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	s, err := openSQLiteState(path, dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.db.Close()
+	for name, want := range map[string]string{
+		"bookmarks.json":       `[{"path":"a.htm"},{"path":"b.htm"}]`,
+		"users/ann/vocab.json": `[{"word":"dhamma"}]`,
+		"users/bob/tags.json":  `{"a.htm":["sila"]}`,
+		"visitors/0123456789abcdef0123456789abcdef/settings.json": `{"theme":"dark"}`,
+		"session.key":          "cafe",
+		"users/cat/vocab.json": "",
+	} {
+		if value, _, err := s.Load(name); string(value) != want || err != nil {
+			t.Errorf("Load(%s) = %s, %v; want %s", name, value, err, want)
+		}
+	}
+	var word string
+	if err := s.db.QueryRow("SELECT word FROM vocab WHERE owner = 'ann'").Scan(&word); err != nil || word != "dhamma" {
+		t.Errorf("the word column = %q, %v", word, err)
+	}
+	var held int
+	if err := s.db.QueryRow("SELECT count(*) FROM state WHERE name LIKE '%.json'").Scan(&held); err != nil || held != 0 {
+		t.Errorf("%d data files left in the state table, %v", held, err)
+	}
+
+	// Entries are updated one by one
+	err = s.Update("users/ann/vocab.json", func(old []byte, ok bool) ([]byte, error) {
+		return []byte(`[{"word": "dhamma"}, {"word": "sīla"}]`), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value, _, _ := s.Load("users/ann/vocab.json"); string(value) != `[{"word":"dhamma"},{"word":"sīla"}]` {
+		t.Errorf("vocab after the update = %s", value)
+	}
+	if err := s.Update("bookmarks.json", func([]byte, bool) ([]byte, error) { return []byte("[]"), nil }); err != nil {
+		t.Fatal(err)
+	}
+	if value, _, _ := s.Load("bookmarks.json"); value != nil {
+		t.Errorf("bookmarks after deleting them all = %s", value)
+	}
+	if err := s.Update("users/ann/settings.json", func([]byte, bool) ([]byte, error) { return []byte("[1]"), nil }); err == nil {
+		t.Error("a list was saved as settings")
+	}
+}
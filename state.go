@@ -0,0 +1,174 @@
+package main
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// StateConfig keeps what users save, the key signing their sessions and the
+// corpus index in a store: a SQLite database, the default, or Redis, which
+// every replica of the server shares, so that several replicas behind a load
+// balancer serve one library alike. The files backend keeps them in data
+// files instead.
+type StateConfig struct {
+	Backend string `json:"backend"` // sqlite, redis or files
+	Path    string `json:"path"`    // of the SQLite database, palireader.db in DataDir by default
+	Redis   string `json:"redis"`   // like redis://:password@host:6379/0, or host:port
+}
+
+// StateStore keeps named values, such as bookmarks.json, where every replica
+// reads and changes the same ones
+type StateStore interface {
+	// Load returns the value of name, and false if there is none
+	Load(name string) ([]byte, bool, error)
+	// Update replaces the value of name with what fn makes of it. fn is
+	// called again with the new value when another replica changed it
+	// meanwhile.
+	Update(name string, fn func(old []byte, ok bool) ([]byte, error)) error
+}
+
+// sharedState opens the store of the "state" config section on first use;
+// it is nil with the files backend, and users' data stays in the data files
+var sharedState = sync.OnceValues(func() (StateStore, error) {
+	if config.State == nil {
+		return nil, nil
+	}
+	if config.State.Backend == "sqlite" {
+		return openSQLiteState(cmp.Or(config.State.Path, filepath.Join(config.DataDir, "palireader.db")), config.DataDir)
+	}
+	c, err := newRedisCache(config.State.Redis)
+	if err != nil {
+		return nil, err
+	}
+	return &redisState{c}, nil
+})
+
+// redisState keeps the values in Redis, under palireader:state: and their
+// names
+type redisState struct {
+	*redisCache
+}
+
+func (s *redisState) Load(name string) ([]byte, bool, error) {
+	reply, err := s.command("GET", "palireader:state:"+name)
+	if err != nil {
+		return nil, false, err
+	}
+	value, ok := reply.(string)
+	return []byte(value), ok, nil
+}
+
+func (s *redisState) Update(name string, fn func(old []byte, ok bool) ([]byte, error)) error {
+	conn, err := s.conn()
+	if err != nil {
+		return err
+	}
+	err = updateRedis(conn, "palireader:state:"+name, fn)
+	s.release(conn, err)
+	return err
+}
+
+// updateRedis changes the value at key inside a transaction, which Redis
+// drops when another client has set the key since it was read, and tries
+// again until one goes through
+func updateRedis(conn *redisConn, key string, fn func(old []byte, ok bool) ([]byte, error)) error {
+	for {
+		if _, err := conn.do("WATCH", key); err != nil {
+			return err
+		}
+		reply, err := conn.do("GET", key)
+		if err != nil {
+			return err
+		}
+		old, ok := reply.(string)
+		value, err := fn([]byte(old), ok)
+		if err != nil {
+			return err
+		}
+		if _, err := conn.do("MULTI"); err != nil {
+			return err
+		}
+		if _, err := conn.do("SET", key, string(value)); err != nil {
+			return err
+		}
+		reply, err = conn.do("EXEC")
+		if err != nil {
+			return err
+		}
+		if reply != nil {
+			return nil
+		}
+	}
+}
+
+// corpusFingerprint tells apart the states of the corpus by the names,
+// sizes and times of its texts. Replicas reading the same copy of the
+// corpus, as on a shared volume, see the same fingerprint.
+func corpusFingerprint() (string, error) {
+	h := sha256.New()
+	err := walkCorpus("", func(rel string, d fs.DirEntry) error {
+		if d.IsDir() || !strings.HasSuffix(strings.ToLower(d.Name()), ".htm") {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(h, "%s\x00%d\x00%d\n", rel, info.Size(), info.ModTime().UnixNano())
+		return nil
+	})
+	return hex.EncodeToString(h.Sum(nil)), err
+}
+
+// sharedCorpusIndex returns the index of the corpus from the shared state
+// store, where the first replica to build it for the corpus as it is now
+// leaves it for the others
+func sharedCorpusIndex(store StateStore) (*CorpusIndex, error) {
+	fingerprint, err := corpusFingerprint()
+	if err != nil {
+		return nil, err
+	}
+	data, _, err := store.Load("corpus-index")
+	if err != nil {
+		return nil, err
+	}
+	idx, updated, err := currentCorpusIndex(data, fingerprint)
+	if err != nil || !updated {
+		return idx, err
+	}
+	encoded, err := encodeCorpusIndex(idx, fingerprint)
+	if err != nil {
+		return nil, err
+	}
+	err = store.Update("corpus-index", func([]byte, bool) ([]byte, error) {
+		return encoded, nil
+	})
+	return idx, err
+}
+
+// sharedSessionKey returns the key signing session cookies from the shared
+// state store, made by the first replica that needed it
+func sharedSessionKey(store StateStore, newKey func() []byte) ([]byte, error) {
+	key, ok, err := store.Load("session.key")
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		err = store.Update("session.key", func(old []byte, ok bool) ([]byte, error) {
+			if key = old; !ok {
+				key = []byte(hex.EncodeToString(newKey()))
+			}
+			return key, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return hex.DecodeString(strings.TrimSpace(string(key)))
+}
@@ -0,0 +1,50 @@
+// Follows a recording of the text with its timing: marks the paragraph
+// being spoken, and lets each timed paragraph be played from its start
+(function () {
+    var audio = document.querySelector('audio.recording[data-timing]');
+    if (!audio) {
+        return;
+    }
+    fetch(audio.dataset.timing).then(function (resp) {
+        return resp.ok ? resp.json() : [];
+    }).then(function (cues) {
+        // Paragraphs on other pages of a long text have no anchor here
+        cues.forEach(function (cue) {
+            cue.element = document.getElementById(cue.anchor);
+            if (!cue.element) {
+                return;
+            }
+            var seek = document.createElement('button');
+            seek.type = 'button';
+            seek.className = 'seek';
+            seek.title = 'Play from here';
+            seek.textContent = '▶';
+            seek.addEventListener('click', function () {
+                audio.currentTime = cue.start;
+                audio.play();
+            });
+            cue.element.appendChild(seek);
+        });
+
+        var current = null;
+        audio.addEventListener('timeupdate', function () {
+            var cue = null;
+            for (var i = 0; i < cues.length && cues[i].start <= audio.currentTime; i++) {
+                cue = cues[i];
+            }
+            if (cue === current) {
+                return;
+            }
+            if (current && current.element) {
+                current.element.classList.remove('reading');
+            }
+            current = cue;
+            if (cue && cue.element) {
+                cue.element.classList.add('reading');
+                if (!audio.paused) {
+                    cue.element.scrollIntoView({behavior: 'smooth', block: 'center'});
+                }
+            }
+        });
+    });
+})();
@@ -0,0 +1,607 @@
+// Record every dictionary lookup so it can be exported as a flashcard
+function cleanWord(text) {
+    return text.normalize('NFC').toLowerCase().replace(/^['"’]+|['"’]+$/g, '');
+}
+
+// base is the path the site is served under, like /pali
+var base = document.documentElement.dataset.base || '';
+
+// lookupSource names the text and the paragraph the word appears in
+function lookupSource(word) {
+    var source = location.pathname.slice(base.length);
+    var anchors = document.querySelectorAll('.pali-text .anchor');
+    for (var i = anchors.length - 1; i >= 0; i--) {
+        if (anchors[i].compareDocumentPosition(word) & Node.DOCUMENT_POSITION_FOLLOWING) {
+            return source + '#' + anchors[i].id;
+        }
+    }
+    return source.indexOf('/read/') === 0 ? source : '';
+}
+
+function recordLookup(word) {
+    var data = new URLSearchParams({word: cleanWord(word.textContent), source: lookupSource(word)});
+    navigator.sendBeacon(base + '/lookups', data);
+}
+
+// Breadcrumb menus: only one is open at a time, and clicking elsewhere
+// closes it
+document.addEventListener('click', function (e) {
+    document.querySelectorAll('.crumb-menu[open]').forEach(function (menu) {
+        if (!menu.contains(e.target)) {
+            menu.open = false;
+        }
+    });
+});
+
+// Dictionary chooser: clicking a Pali word offers every configured provider
+// and saving the word to the vocabulary list
+(function () {
+    var chooser = document.getElementById('lookup-chooser');
+    if (!chooser) {
+        return;
+    }
+    var label = chooser.querySelector('.lookup-word');
+    var links = chooser.querySelectorAll('a[data-lookup]');
+    var save = chooser.querySelector('.save-word');
+    var knownButton = chooser.querySelector('.known-word');
+    var current = null;
+
+    document.addEventListener('click', function (e) {
+        var word = e.target.closest('.pali-word');
+        if (!word) {
+            if (!chooser.contains(e.target)) {
+                chooser.hidden = true;
+            }
+            return;
+        }
+        e.preventDefault();
+        current = word;
+        var clean = cleanWord(word.textContent);
+        label.textContent = clean;
+        links.forEach(function (link) {
+            link.href = link.dataset.lookup.split('{word}').join(encodeURIComponent(clean));
+        });
+        if (save) {
+            save.textContent = '☆ Save word';
+            save.disabled = false;
+        }
+        if (knownButton) {
+            knownButton.textContent = word.classList.contains('known') ? 'Mark not known' : 'Mark known';
+            knownButton.disabled = false;
+        }
+        var rect = word.getBoundingClientRect();
+        chooser.style.left = (window.scrollX + rect.left) + 'px';
+        chooser.style.top = (window.scrollY + rect.bottom + 4) + 'px';
+        chooser.hidden = false;
+    });
+
+    chooser.addEventListener('click', function (e) {
+        if (e.target.closest('a')) {
+            // Static copies of the site and private sites record no lookups
+            if (current && chooser.hasAttribute('data-record')) {
+                recordLookup(current);
+            }
+            chooser.hidden = true;
+        }
+    });
+
+    if (save) {
+        save.addEventListener('click', function () {
+            if (!current) {
+                return;
+            }
+            var data = new URLSearchParams({word: cleanWord(current.textContent), source: lookupSource(current)});
+            fetch(base + '/vocab', {method: 'POST', body: data}).then(function (resp) {
+                save.textContent = resp.ok ? '★ Saved' : 'Could not save';
+                save.disabled = resp.ok;
+            });
+        });
+    }
+
+    // Marking a word known, or no longer known, restyles every link to it
+    // on the page; the first word marked on a page sets the others apart
+    // as unknown
+    if (knownButton) {
+        knownButton.addEventListener('click', function () {
+            if (!current) {
+                return;
+            }
+            var clean = cleanWord(current.textContent);
+            var known = !current.classList.contains('known');
+            var data = new URLSearchParams({word: clean, known: known ? '1' : '0'});
+            fetch(base + '/known', {method: 'POST', body: data}).then(function (resp) {
+                if (!resp.ok) {
+                    knownButton.textContent = 'Could not save';
+                    knownButton.disabled = true;
+                    return;
+                }
+                document.querySelectorAll('.pali-word').forEach(function (link) {
+                    var isKnown = cleanWord(link.textContent) === clean ? known : link.classList.contains('known');
+                    link.classList.toggle('known', isKnown);
+                    link.classList.toggle('unknown', !isKnown);
+                });
+                chooser.hidden = true;
+            });
+        });
+    }
+
+    document.addEventListener('keydown', function (e) {
+        if (e.key === 'Escape') {
+            chooser.hidden = true;
+        }
+    });
+})();
+
+// Reading time: while a text is open in a visible tab and the reader has
+// done something in the last two minutes, the time is reported every minute
+// and when the tab is left, for the stats page
+(function () {
+    var text = document.querySelector('.pali-text');
+    var chooser = document.getElementById('lookup-chooser');
+    if (!text || !chooser || !chooser.hasAttribute('data-record')) {
+        return;
+    }
+    var words = text.querySelectorAll('.pali-word').length;
+    var page = new URLSearchParams(location.search).get('page') || '1';
+    var seconds = 0;
+    var lastActive = Date.now();
+    ['scroll', 'keydown', 'pointermove', 'pointerdown'].forEach(function (type) {
+        window.addEventListener(type, function () {
+            lastActive = Date.now();
+        }, {passive: true});
+    });
+    var report = function () {
+        if (seconds === 0) {
+            return;
+        }
+        var data = new URLSearchParams({source: location.pathname.slice(base.length), page: page, seconds: seconds, words: words});
+        navigator.sendBeacon(base + '/reading', data);
+        seconds = 0;
+    };
+    setInterval(function () {
+        if (document.visibilityState === 'visible' && Date.now() - lastActive < 120000) {
+            seconds += 15;
+            if (seconds >= 60) {
+                report();
+            }
+        }
+    }, 15000);
+    document.addEventListener('visibilitychange', function () {
+        if (document.visibilityState === 'hidden') {
+            report();
+        }
+    });
+})();
+
+// Listen: reads the text aloud from the paragraph at the top of the window,
+// one paragraph after the other, marking the one being read
+document.querySelectorAll('button[data-listen]').forEach(function (button) {
+    var audio = new Audio();
+    var anchors = Array.prototype.slice.call(document.querySelectorAll('.pali-text .anchor'));
+    var at = -1;
+    var mark = function (i) {
+        anchors.forEach(function (a, j) {
+            a.classList.toggle('reading', j === i);
+        });
+    };
+    var stop = function () {
+        audio.pause();
+        at = -1;
+        mark(-1);
+        button.textContent = '▶ Listen';
+    };
+    var play = function (i) {
+        if (i >= anchors.length) {
+            stop();
+            return;
+        }
+        if (at >= 0) {
+            anchors[i].scrollIntoView({behavior: 'smooth', block: 'start'});
+        }
+        at = i;
+        mark(i);
+        var n = anchors[i].id.slice(1);
+        fetch(button.dataset.listen + '?p=' + n).then(function (resp) {
+            if (at !== i) {
+                return null;
+            }
+            if (resp.status === 204) {
+                play(i + 1);
+                return null;
+            }
+            if (!resp.ok) {
+                stop();
+                button.textContent = 'Could not read aloud';
+                return null;
+            }
+            return resp.blob();
+        }).then(function (blob) {
+            if (!blob || at !== i) {
+                return;
+            }
+            URL.revokeObjectURL(audio.src);
+            audio.src = URL.createObjectURL(blob);
+            audio.play();
+        });
+    };
+    audio.addEventListener('ended', function () {
+        if (at >= 0) {
+            play(at + 1);
+        }
+    });
+    button.addEventListener('click', function () {
+        if (at >= 0) {
+            stop();
+            return;
+        }
+        var first = anchors.findIndex(function (a) {
+            return a.getBoundingClientRect().top >= 0;
+        });
+        button.textContent = '■ Stop';
+        play(Math.max(first, 0));
+    });
+});
+
+// Offline reading: the service worker keeps the pages read, and a folder's
+// texts can be saved at once, one page after the other, to read them where
+// there is no connection
+if ('serviceWorker' in navigator && document.querySelector('link[rel="manifest"]')) {
+    navigator.serviceWorker.register(base + '/sw.js', {scope: base + '/'});
+    document.querySelectorAll('button[data-offline]').forEach(function (button) {
+        button.hidden = false;
+        button.addEventListener('click', function () {
+            button.disabled = true;
+            fetch(button.dataset.offline).then(function (resp) {
+                return resp.ok ? resp.json() : Promise.reject(resp.status);
+            }).then(function (list) {
+                return caches.open('pages').then(function (cache) {
+                    var done = 0;
+                    return list.urls.reduce(function (saved, url) {
+                        return saved.then(function () {
+                            return cache.add(url);
+                        }).then(function () {
+                            done++;
+                            button.textContent = 'Saved ' + done + ' of ' + list.urls.length + ' pages';
+                        });
+                    }, Promise.resolve()).then(function () {
+                        return list;
+                    });
+                });
+            }).then(function (list) {
+                button.textContent = '✓ ' + list.texts + (list.texts === 1 ? ' text' : ' texts') + ' saved for offline reading' +
+                    (list.truncated ? ' (the first of the folder)' : '');
+            }, function () {
+                button.textContent = 'Could not save for offline reading';
+                button.disabled = false;
+            });
+        });
+    });
+}
+
+// Folder filter: on folders with many entries, the box above the grid
+// lists the folders and texts below whose titles match what is typed, found
+// by the server diacritics or not, and brings back the grid once cleared
+document.querySelectorAll('input[data-tree]').forEach(function (input) {
+    var grid = document.querySelector('.file-grid');
+    if (!grid) {
+        return;
+    }
+    var cards = Array.from(grid.children);
+    var asked = '';
+    var timer = null;
+    var card = function (r) {
+        var a = document.createElement('a');
+        a.href = r.url;
+        a.className = 'file-card ' + (r.folder ? 'folder' : 'file');
+        var icon = document.createElement('div');
+        icon.className = 'file-icon';
+        icon.textContent = r.folder ? '📁' : '📜';
+        var name = document.createElement('div');
+        name.className = 'file-name';
+        name.textContent = r.title;
+        a.append(icon, name);
+        if (r.id) {
+            var id = document.createElement('div');
+            id.className = 'file-id';
+            id.textContent = r.id;
+            a.appendChild(id);
+        }
+        return a;
+    };
+    var update = function () {
+        var q = input.value.trim();
+        asked = q;
+        if (!q) {
+            grid.replaceChildren.apply(grid, cards);
+            return;
+        }
+        fetch(input.dataset.tree + '?q=' + encodeURIComponent(q)).then(function (resp) {
+            return resp.ok ? resp.json() : [];
+        }).then(function (results) {
+            if (asked !== q) {
+                return;
+            }
+            grid.replaceChildren.apply(grid, results.map(card));
+            if (!results.length) {
+                var none = document.createElement('p');
+                none.className = 'empty';
+                none.textContent = 'Nothing here matches ' + q + '.';
+                grid.appendChild(none);
+            }
+        });
+    };
+    input.hidden = false;
+    input.addEventListener('input', function () {
+        clearTimeout(timer);
+        timer = setTimeout(update, 150);
+    });
+});
+
+// Contents sidebar: the folders of the corpus, each one's entries fetched
+// from /api/tree when it is first opened, those leading to the page shown
+// listed with it. On texts it stays docked beside the reader, so that the
+// texts next to this one are a click away, unless it was closed; whether
+// it is open and which folders were opened are remembered.
+document.querySelectorAll('aside[data-tree]').forEach(function (aside) {
+    var current = aside.dataset.current;
+    var reader = document.querySelector('.reader-content') !== null;
+    var opened = JSON.parse(localStorage.getItem('tree-opened') || '[]');
+    var remember = function (path, open) {
+        opened = opened.filter(function (p) {
+            return p !== path;
+        });
+        if (open) {
+            opened.push(path);
+        }
+        localStorage.setItem('tree-opened', JSON.stringify(opened.slice(-100)));
+    };
+    var toggle = document.createElement('button');
+    toggle.type = 'button';
+    toggle.className = 'tree-toggle';
+    toggle.textContent = '☰ ' + aside.getAttribute('aria-label');
+    var panel = document.createElement('div');
+    panel.className = 'tree-panel';
+    aside.classList.toggle('docked', reader);
+    aside.append(panel, toggle);
+    aside.hidden = false;
+    var load = function (path) {
+        var url = aside.dataset.tree + '?path=' + encodeURIComponent(path);
+        if (path === '' && current) {
+            url += '&open=' + encodeURIComponent(current);
+        }
+        return fetch(url).then(function (resp) {
+            return resp.ok ? resp.json() : Promise.reject(resp.status);
+        });
+    };
+    var list = function (node) {
+        var ul = document.createElement('ul');
+        node.children.forEach(function (child) {
+            var li = document.createElement('li');
+            var link = document.createElement('a');
+            link.href = child.url;
+            link.textContent = child.title;
+            if (child.path === current) {
+                link.setAttribute('aria-current', 'page');
+            }
+            ul.appendChild(li);
+            if (!child.folder) {
+                li.appendChild(link);
+                return;
+            }
+            var details = document.createElement('details');
+            var summary = document.createElement('summary');
+            summary.appendChild(link);
+            details.appendChild(summary);
+            if (child.children) {
+                details.appendChild(list(child));
+                details.dataset.loaded = 'true';
+                details.open = true;
+            }
+            details.addEventListener('toggle', function () {
+                var onTheWay = current === child.path || current.indexOf(child.path + '/') === 0;
+                if (!onTheWay) {
+                    remember(child.path, details.open);
+                }
+                if (!details.open || details.dataset.loaded) {
+                    return;
+                }
+                details.dataset.loaded = 'true';
+                load(child.path).then(function (folder) {
+                    details.appendChild(list(folder));
+                }, function () {
+                    delete details.dataset.loaded;
+                });
+            });
+            if (!child.children && opened.indexOf(child.path) >= 0) {
+                details.open = true;
+            }
+            li.appendChild(details);
+        });
+        return ul;
+    };
+    var show = function (open) {
+        panel.hidden = !open;
+        toggle.setAttribute('aria-expanded', String(open));
+        if (open && !panel.firstChild) {
+            load('').then(function (root) {
+                panel.appendChild(list(root));
+                var here = panel.querySelector('[aria-current]');
+                if (here) {
+                    here.scrollIntoView({block: 'center'});
+                }
+            });
+        }
+    };
+    var saved = localStorage.getItem('tree-sidebar');
+    show(saved ? saved === 'open' : reader);
+    toggle.addEventListener('click', function () {
+        show(panel.hidden);
+        localStorage.setItem('tree-sidebar', panel.hidden ? 'closed' : 'open');
+    });
+});
+
+// Favorites: the star of a text, beside its card or above it, keeps it on
+// the home page, or no longer
+document.querySelectorAll('button[data-star]').forEach(function (button) {
+    button.addEventListener('click', function () {
+        var starred = button.getAttribute('aria-pressed') !== 'true';
+        var data = new URLSearchParams({path: button.dataset.star, starred: starred ? '1' : '0'});
+        button.disabled = true;
+        fetch(base + '/favorites', {method: 'POST', body: data}).then(function (resp) {
+            button.disabled = false;
+            if (resp.ok) {
+                button.setAttribute('aria-pressed', String(starred));
+                button.textContent = starred ? '★' : '☆';
+            }
+        });
+    });
+});
+
+// Tags: the tag button of a text asks for its tags, separated by commas,
+// and shows them as saved on the chips of the text wherever they are
+document.querySelectorAll('button[data-tag]').forEach(function (button) {
+    button.addEventListener('click', function () {
+        var typed = prompt('Tags, separated by commas', button.dataset.tags);
+        if (typed === null) {
+            return;
+        }
+        var data = new URLSearchParams({path: button.dataset.tag, tags: typed});
+        fetch(base + '/tags', {method: 'POST', body: data}).then(function (resp) {
+            return resp.ok ? resp.json() : Promise.reject(resp.status);
+        }).then(function (tags) {
+            button.dataset.tags = tags.join(', ');
+            document.querySelectorAll('[data-tags-of]').forEach(function (chips) {
+                if (chips.dataset.tagsOf !== button.dataset.tag) {
+                    return;
+                }
+                chips.replaceChildren.apply(chips, tags.map(function (tag) {
+                    var chip = document.createElement(chips.hasAttribute('data-links') ? 'a' : 'span');
+                    chip.className = 'tag-chip';
+                    chip.textContent = tag;
+                    if (chip.tagName === 'A') {
+                        chip.href = base + '/tags/' + encodeURIComponent(tag);
+                    }
+                    return chip;
+                }));
+            });
+        }, function () {
+            button.title = 'Could not save the tags';
+        });
+    });
+});
+
+// Copy citation: a button before each paragraph copies how to cite it,
+// with its title, section, references and a link to it
+document.querySelectorAll('.pali-text[data-cite]').forEach(function (text) {
+    text.querySelectorAll('.anchor').forEach(function (anchor) {
+        var button = document.createElement('button');
+        button.type = 'button';
+        button.className = 'cite';
+        button.title = 'Copy citation';
+        button.textContent = '❝';
+        button.addEventListener('click', function () {
+            fetch(text.dataset.cite + '?format=text&p=' + anchor.id.slice(1)).then(function (resp) {
+                return resp.ok ? resp.text() : Promise.reject(resp.status);
+            }).then(function (citation) {
+                return navigator.clipboard.writeText(citation.trim());
+            }).then(function () {
+                button.textContent = '✓';
+                button.title = 'Citation copied';
+            }, function () {
+                button.textContent = '✗';
+                button.title = 'Could not copy the citation';
+            }).then(function () {
+                setTimeout(function () {
+                    button.textContent = '❝';
+                    button.title = 'Copy citation';
+                }, 2000);
+            });
+        });
+        anchor.appendChild(button);
+    });
+});
+
+// Page toggles: show or hide the reference markers and the pages of each
+// other edition, remembering the choice
+document.querySelectorAll('.page-toggles input[data-toggle]').forEach(function (box) {
+    var key = 'show-' + box.dataset.toggle;
+    var apply = function () {
+        document.querySelectorAll('.pali-text .' + box.dataset.toggle).forEach(function (el) {
+            el.classList.toggle('toggled-off', !box.checked);
+        });
+    };
+    box.checked = localStorage.getItem(key) !== 'no';
+    apply();
+    box.addEventListener('change', function () {
+        localStorage.setItem(key, box.checked ? 'yes' : 'no');
+        apply();
+    });
+});
+
+// Ask before the admin forms that cannot be undone
+document.querySelectorAll('form[data-confirm]').forEach(function (form) {
+    form.addEventListener('submit', function (e) {
+        if (!confirm(form.dataset.confirm)) e.preventDefault();
+    });
+});
+
+// Long texts are read in pages: a link to a paragraph on another page goes
+// to that page
+(function () {
+    var pager = document.querySelector('.pager');
+    var m = /^#p(\d+)$/.exec(location.hash);
+    if (!pager || !m || document.getElementById('p' + m[1])) return;
+    var starts = pager.dataset.starts.split(',').map(Number);
+    var page = 1;
+    while (page < starts.length && starts[page] <= Number(m[1])) page++;
+    if (String(page) !== pager.dataset.page) {
+        var params = new URLSearchParams(location.search);
+        params.set('page', page);
+        location.replace('?' + params + location.hash);
+    }
+})();
+
+// Opened from a search result: bring the first word searched for at or
+// after the paragraph linked to into view, and step through the others
+// with n and N
+(function () {
+    var hits = Array.prototype.slice.call(document.querySelectorAll('.pali-text .hit'));
+    if (!hits.length) return;
+    var target = location.hash ? document.getElementById(location.hash.slice(1)) : null;
+    var current = 0;
+    if (target) {
+        for (; current < hits.length - 1; current++) {
+            if (target.compareDocumentPosition(hits[current]) & Node.DOCUMENT_POSITION_FOLLOWING) break;
+        }
+    }
+    var show = function () {
+        hits.forEach(function (hit) { hit.classList.remove('current-hit'); });
+        hits[current].classList.add('current-hit');
+        hits[current].scrollIntoView({block: 'center'});
+    };
+    show();
+    document.addEventListener('keydown', function (e) {
+        if (e.target.closest('input, textarea, select') || e.ctrlKey || e.metaKey || e.altKey) return;
+        if (e.key === 'n' || e.key === 'N') {
+            current = (current + (e.key === 'n' ? 1 : hits.length - 1)) % hits.length;
+            show();
+        }
+    });
+})();
+
+// Live reload (serve -dev): reload when the text changes on disk, keeping
+// the place on the page
+(function () {
+    var text = document.querySelector('.pali-text[data-reload]');
+    if (!text || !window.EventSource) return;
+    var key = 'reload-scroll:' + location.pathname + location.search;
+    var saved = sessionStorage.getItem(key);
+    if (saved !== null) {
+        sessionStorage.removeItem(key);
+        window.scrollTo(0, parseFloat(saved));
+    }
+    new EventSource(text.dataset.reload).addEventListener('reload', function () {
+        sessionStorage.setItem(key, String(window.scrollY));
+        location.reload();
+    });
+})();
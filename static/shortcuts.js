@@ -0,0 +1,189 @@
+// Keyboard shortcuts, shown with ?, and the command palette (Ctrl-K).
+// Shortcuts are single keys, so they are left alone while typing in a field.
+(function () {
+    var base = document.documentElement.dataset.base || '';
+    var tabs = document.querySelector('.text-tabs');
+
+    // g and then a letter opens a nikāya or piṭaka on the canon page
+    var divisions = {d: 'dn', m: 'mn', s: 'sn', a: 'an', k: 'kn', v: 'vin', b: 'abhidh'};
+
+    var shortcuts = [
+        ['j', 'Next text in the folder'],
+        ['k', 'Previous text in the folder'],
+        ['/', 'Search'],
+        ['g d, g m, g s, g a, g k', 'Dīgha, Majjhima, Saṃyutta, Aṅguttara, Khuddaka Nikāya'],
+        ['g v, g b', 'Vinaya, Abhidhamma Piṭaka'],
+        ['Ctrl-K', 'Command palette: open a text, folder or page by its title'],
+        ['?', 'These shortcuts']
+    ];
+
+    var help = null;
+    var toggleHelp = function () {
+        if (!help) {
+            help = document.createElement('div');
+            help.className = 'shortcut-help';
+            help.setAttribute('role', 'dialog');
+            help.setAttribute('aria-label', 'Keyboard shortcuts');
+            var list = document.createElement('dl');
+            shortcuts.forEach(function (s) {
+                var key = document.createElement('dt');
+                key.textContent = s[0];
+                var what = document.createElement('dd');
+                what.textContent = s[1];
+                list.append(key, what);
+            });
+            help.appendChild(list);
+            help.addEventListener('click', function () {
+                help.hidden = true;
+            });
+            document.body.appendChild(help);
+            return;
+        }
+        help.hidden = !help.hidden;
+    };
+
+    // The palette lists the pages of the site menu, what to do with the
+    // query, and the folders and texts whose titles match it
+    var palette = null, input, list, items = [], selected = 0, asked = '';
+    var commands = Array.prototype.map.call(document.querySelectorAll('.site-nav a'), function (a) {
+        return {title: a.textContent.trim(), url: a.href};
+    });
+    var show = function () {
+        list.replaceChildren();
+        items.forEach(function (item, i) {
+            var li = document.createElement('li');
+            li.setAttribute('role', 'option');
+            li.className = i === selected ? 'selected' : '';
+            li.textContent = item.title;
+            if (item.hint) {
+                var hint = document.createElement('span');
+                hint.className = 'hint';
+                hint.textContent = item.hint;
+                li.appendChild(hint);
+            }
+            li.addEventListener('mousedown', function (e) {
+                e.preventDefault();
+                location.href = item.url;
+            });
+            list.appendChild(li);
+        });
+    };
+    var update = function () {
+        var q = input.value.trim();
+        var lower = q.toLowerCase();
+        items = commands.filter(function (c) {
+            return c.title.toLowerCase().indexOf(lower) >= 0;
+        });
+        if (q) {
+            items.push({title: 'Go to ' + q, hint: 'citation', url: base + '/go?q=' + encodeURIComponent(q)});
+            items.push({title: 'Search the texts for ' + q, hint: 'search', url: base + '/search?q=' + encodeURIComponent(q)});
+        }
+        selected = 0;
+        show();
+        asked = q;
+        if (!q) {
+            return;
+        }
+        fetch(base + '/quick?q=' + encodeURIComponent(q)).then(function (resp) {
+            return resp.ok ? resp.json() : [];
+        }).then(function (results) {
+            if (asked !== q) {
+                return;
+            }
+            items = results.map(function (r) {
+                return {title: (r.folder ? '📁 ' : '📜 ') + r.title, hint: r.path, url: r.url};
+            }).concat(items);
+            show();
+        });
+    };
+    var timer = null;
+    var openPalette = function () {
+        if (!palette) {
+            palette = document.createElement('div');
+            palette.className = 'palette';
+            palette.setAttribute('role', 'dialog');
+            palette.setAttribute('aria-label', 'Command palette');
+            input = document.createElement('input');
+            input.type = 'search';
+            input.placeholder = 'Open a text, folder or page…';
+            input.setAttribute('aria-label', 'Open a text, folder or page');
+            list = document.createElement('ul');
+            list.setAttribute('role', 'listbox');
+            palette.append(input, list);
+            document.body.appendChild(palette);
+            input.addEventListener('input', function () {
+                clearTimeout(timer);
+                timer = setTimeout(update, 150);
+            });
+            input.addEventListener('keydown', function (e) {
+                if (e.key === 'ArrowDown' || e.key === 'ArrowUp') {
+                    e.preventDefault();
+                    selected = (selected + (e.key === 'ArrowDown' ? 1 : items.length - 1)) % Math.max(items.length, 1);
+                    show();
+                } else if (e.key === 'Enter' && items[selected]) {
+                    e.preventDefault();
+                    location.href = items[selected].url;
+                } else if (e.key === 'Escape') {
+                    palette.hidden = true;
+                }
+            });
+            input.addEventListener('blur', function () {
+                palette.hidden = true;
+            });
+        }
+        palette.hidden = false;
+        input.value = '';
+        update();
+        input.focus();
+    };
+
+    var pending = false; // g was pressed
+    document.addEventListener('keydown', function (e) {
+        if (e.key === 'k' && (e.ctrlKey || e.metaKey) && !e.altKey) {
+            e.preventDefault();
+            openPalette();
+            return;
+        }
+        if (e.target.closest('input, textarea, select, [contenteditable]') || e.ctrlKey || e.metaKey || e.altKey) {
+            return;
+        }
+        if (pending) {
+            pending = false;
+            if (divisions[e.key]) {
+                location.href = base + '/canon/' + divisions[e.key];
+            }
+            return;
+        }
+        switch (e.key) {
+        case 'j':
+        case 'k':
+            var to = tabs && tabs.dataset[e.key === 'j' ? 'next' : 'prev'];
+            if (to) {
+                location.href = to;
+            }
+            break;
+        case '/':
+            var field = document.querySelector('.search-form input[name="q"]') || document.querySelector('.quick-jump input');
+            if (field) {
+                e.preventDefault();
+                field.focus();
+                field.select();
+            }
+            break;
+        case 'g':
+            pending = true;
+            setTimeout(function () {
+                pending = false;
+            }, 1500);
+            break;
+        case '?':
+            toggleHelp();
+            break;
+        case 'Escape':
+            if (help) {
+                help.hidden = true;
+            }
+            break;
+        }
+    });
+})();
@@ -0,0 +1,64 @@
+// The assets of this version, and the pages read or saved for offline
+// reading
+var SHELL = 'shell-' + version;
+var PAGES = 'pages';
+
+self.addEventListener('install', function (event) {
+    event.waitUntil(caches.open(SHELL).then(function (cache) {
+        return cache.addAll(shell);
+    }).then(function () {
+        return self.skipWaiting();
+    }));
+});
+
+// The assets of earlier versions are dropped
+self.addEventListener('activate', function (event) {
+    event.waitUntil(caches.keys().then(function (keys) {
+        return Promise.all(keys.filter(function (key) {
+            return key.indexOf('shell-') === 0 && key !== SHELL;
+        }).map(function (key) {
+            return caches.delete(key);
+        }));
+    }).then(function () {
+        return self.clients.claim();
+    }));
+});
+
+function offline() {
+    return new Response('<!DOCTYPE html><meta charset="UTF-8"><meta name="viewport" content="width=device-width, initial-scale=1.0">' +
+        '<title>Offline - Pali Reader</title><p>You are offline, and this page was not saved for reading offline.</p>' +
+        '<p><a href="' + base + '/">Home</a></p>', {status: 503, headers: {'Content-Type': 'text/html; charset=utf-8'}});
+}
+
+self.addEventListener('fetch', function (event) {
+    var request = event.request;
+    var url = new URL(request.url);
+    if (request.method !== 'GET' || url.origin !== location.origin) {
+        return;
+    }
+    // Versioned assets never change; the home page, first in the shell, does
+    if (shell.indexOf(url.pathname) > 0) {
+        event.respondWith(caches.match(request).then(function (hit) {
+            return hit || fetch(request);
+        }));
+        return;
+    }
+    // Pages come from the server while there is a connection, and are kept
+    // for when there is none
+    if (url.pathname === base + '/' || url.pathname.indexOf(base + '/read/') === 0 ||
+        url.pathname === base + '/static/custom.css') {
+        event.respondWith(fetch(request).then(function (response) {
+            if (response.ok) {
+                var copy = response.clone();
+                caches.open(PAGES).then(function (cache) {
+                    cache.put(request, copy);
+                });
+            }
+            return response;
+        }).catch(function () {
+            return caches.match(request, {ignoreVary: true}).then(function (hit) {
+                return hit || (request.mode === 'navigate' ? offline() : Response.error());
+            });
+        }));
+    }
+});
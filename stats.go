@@ -0,0 +1,140 @@
+package main
+
+import (
+	"io/fs"
+	"log"
+	"maps"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StatsConfig counts how often each text is opened, for the "Most read"
+// texts of the home page and the warm-up. Only the counts are kept: not who
+// read, from where or when.
+type StatsConfig struct {
+	Off bool `json:"off"` // counts nothing, for deployments that must not
+}
+
+// views holds how many times each text was opened, by corpus name
+var views = &jsonFile[map[string]int]{name: "views.json"}
+
+// viewsFlushInterval is how often the views counted are added to views.json
+const viewsFlushInterval = time.Minute
+
+// pendingViews are the views counted since they were last saved, kept in
+// memory so that reading a text does not write a file
+var pendingViews = struct {
+	sync.Mutex
+	counts map[string]int
+}{counts: make(map[string]int)}
+
+// countView counts a text being opened, unless stats are off or a static
+// copy of the site is being written
+func countView(name string) {
+	if config.Stats.Off || staticSite {
+		return
+	}
+	pendingViews.Lock()
+	pendingViews.counts[name]++
+	pendingViews.Unlock()
+}
+
+// saveViewsPeriodically adds the views counted to views.json every so often
+func saveViewsPeriodically() {
+	if config.Stats.Off {
+		return
+	}
+	go func() {
+		for range time.Tick(viewsFlushInterval) {
+			saveViews()
+		}
+	}()
+}
+
+// saveViews adds the views counted since the last time to views.json
+func saveViews() {
+	pendingViews.Lock()
+	counts := pendingViews.counts
+	pendingViews.counts = make(map[string]int)
+	pendingViews.Unlock()
+	if len(counts) == 0 {
+		return
+	}
+
+	err := views.Update(func(saved *map[string]int) error {
+		if *saved == nil {
+			*saved = make(map[string]int)
+		}
+		for name, n := range counts {
+			(*saved)[name] += n
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Cannot save the views: %v", err)
+		// Keep them for the next time
+		pendingViews.Lock()
+		for name, n := range counts {
+			pendingViews.counts[name] += n
+		}
+		pendingViews.Unlock()
+	}
+}
+
+// viewCounts returns how many times each text was opened, those not saved
+// yet included
+func viewCounts() (map[string]int, error) {
+	counts := make(map[string]int)
+	err := views.Read(func(saved *map[string]int) {
+		maps.Copy(counts, *saved)
+	})
+	pendingViews.Lock()
+	for name, n := range pendingViews.counts {
+		counts[name] += n
+	}
+	pendingViews.Unlock()
+	return counts, err
+}
+
+// PopularText is a text in the "Most read" list
+type PopularText struct {
+	Path  string
+	Title string
+	Views int
+}
+
+// mostReadCount is how many texts the home page lists as most read
+const mostReadCount = 10
+
+// mostRead returns up to n of the texts opened most that r may read
+func mostRead(r *http.Request, n int) []PopularText {
+	if config.Stats.Off || staticSite {
+		return nil
+	}
+	counts, err := viewCounts()
+	if err != nil {
+		logf(r.Context(), "Error reading the views: %v", err)
+	}
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		if canRead(r, name) && textExists(name) {
+			names = append(names, name)
+		}
+	}
+	var texts []PopularText
+	for _, name := range mostCounted(names, counts, n) {
+		texts = append(texts, PopularText{Path: name, Title: displayTitle(name), Views: counts[name]})
+	}
+	return texts
+}
+
+// textExists reports whether a text is still in the corpus
+func textExists(name string) bool {
+	if !strings.HasSuffix(strings.ToLower(name), ".htm") {
+		return false
+	}
+	_, err := fs.Stat(corpus, name)
+	return err == nil
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// jsonFile keeps a value in memory and persists it as a JSON file in the
+// data directory. It is loaded on first use. With a state store, such as
+// the SQLite database kept by default, the value is kept there instead, and
+// read afresh every time, since other replicas or commands change it. A
+// jsonFile without a name keeps nothing: it reads as the zero value and
+// forgets what is saved.
+type jsonFile[T any] struct {
+	name string // file name inside config.DataDir
+
+	mu     sync.Mutex
+	shared *sync.Mutex // held instead of mu if set, by files made afresh for each use
+	loaded bool
+	value  T
+}
+
+// lock takes the file's lock and returns what releases it
+func (f *jsonFile[T]) lock() func() {
+	mu := &f.mu
+	if f.shared != nil {
+		mu = f.shared
+	}
+	mu.Lock()
+	return mu.Unlock
+}
+
+func (f *jsonFile[T]) path() string {
+	return filepath.Join(config.DataDir, f.name)
+}
+
+// where names where the value is kept, for messages
+func (f *jsonFile[T]) where() string {
+	if store, _ := sharedState(); store != nil {
+		return f.name + " in the " + config.State.Backend + " state store"
+	}
+	return f.path()
+}
+
+// load reads the file once; a missing file leaves the zero value
+func (f *jsonFile[T]) load() error {
+	if f.loaded {
+		return nil
+	}
+	data, err := os.ReadFile(f.path())
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &f.value); err != nil {
+			return fmt.Errorf("%s: %w", f.path(), err)
+		}
+	}
+	f.loaded = true
+	return nil
+}
+
+// readFile returns the data file, or nil if there is none
+func (f *jsonFile[T]) readFile() ([]byte, error) {
+	data, err := os.ReadFile(f.path())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	return data, err
+}
+
+// decode reads a value kept in the shared state store
+func (f *jsonFile[T]) decode(data []byte) (*T, error) {
+	v := new(T)
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, v); err != nil {
+			return nil, fmt.Errorf("state %s: %w", f.name, err)
+		}
+	}
+	return v, nil
+}
+
+// loadShared reads the value from the shared state store. A value it does
+// not hold yet is taken from the data file of the first replica to read
+// it, so that moving to a shared store keeps what users saved.
+func (f *jsonFile[T]) loadShared(store StateStore) (*T, error) {
+	data, ok, err := store.Load(f.name)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		err = store.Update(f.name, func(old []byte, ok bool) ([]byte, error) {
+			if ok {
+				data = old
+				return old, nil
+			}
+			data, err = f.readFile()
+			return data, err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return f.decode(data)
+}
+
+// Read calls fn with the current value. fn must not keep references to it.
+func (f *jsonFile[T]) Read(fn func(v *T)) error {
+	defer f.lock()()
+
+	if f.name == "" {
+		fn(new(T))
+		return nil
+	}
+
+	if store, err := sharedState(); err != nil {
+		return err
+	} else if store != nil {
+		v, err := f.loadShared(store)
+		if err != nil {
+			return err
+		}
+		fn(v)
+		return nil
+	}
+	if err := f.load(); err != nil {
+		return err
+	}
+	fn(&f.value)
+	return nil
+}
+
+// Update calls fn with the current value and saves the result. With a
+// shared state store fn is called again if another replica changed the
+// value meanwhile.
+func (f *jsonFile[T]) Update(fn func(v *T) error) error {
+	defer f.lock()()
+
+	if f.name == "" {
+		return fn(new(T))
+	}
+
+	if store, err := sharedState(); err != nil {
+		return err
+	} else if store != nil {
+		return store.Update(f.name, func(old []byte, ok bool) ([]byte, error) {
+			if !ok {
+				var err error
+				if old, err = f.readFile(); err != nil {
+					return nil, err
+				}
+			}
+			v, err := f.decode(old)
+			if err != nil {
+				return nil, err
+			}
+			if err := fn(v); err != nil {
+				return nil, err
+			}
+			return json.MarshalIndent(v, "", "  ")
+		})
+	}
+	if err := f.load(); err != nil {
+		return err
+	}
+	if err := fn(&f.value); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(f.value, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(f.path(), data)
+}
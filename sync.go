@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// GitSource is a git repository a folder of texts is kept in step with by
+// the sync command, such as a community-maintained Tipiṭaka
+type GitSource struct {
+	Repo   string `json:"repo"`   // URL or path to clone
+	Branch string `json:"branch"` // defaults to the repository's own
+}
+
+// syncTarget is a folder sync keeps up to date
+type syncTarget struct {
+	name string // the library, or "" for the GRETIL texts
+	dir  string
+	git  *GitSource
+}
+
+// syncTargets returns the folders with a git source, only those of the
+// named libraries if any are named
+func syncTargets(names []string) ([]syncTarget, error) {
+	if len(config.Libraries) == 0 {
+		if len(names) > 0 {
+			return nil, errors.New("no libraries are configured")
+		}
+		if config.Git == nil {
+			return nil, errors.New(`no git repository is configured; set "git" in the config file`)
+		}
+		return []syncTarget{{dir: baseDir, git: config.Git}}, nil
+	}
+
+	var targets []syncTarget
+	for _, l := range config.Libraries {
+		named := len(names) == 0
+		for _, n := range names {
+			named = named || n == l.Name
+		}
+		if named && l.Git != nil {
+			targets = append(targets, syncTarget{name: l.Name, dir: l.Dir, git: l.Git})
+		}
+	}
+	for _, n := range names {
+		found := false
+		for _, t := range targets {
+			found = found || t.name == n
+		}
+		if !found {
+			return nil, fmt.Errorf("library %q has no git repository configured", n)
+		}
+	}
+	if len(targets) == 0 {
+		return nil, errors.New("no library has a git repository configured")
+	}
+	return targets, nil
+}
+
+// runSync implements the sync command: it clones or pulls the configured
+// git repositories of texts and reports what changed
+func runSync(args []string) error {
+	flags := flag.NewFlagSet("sync", flag.ExitOnError)
+	list := flags.Bool("l", false, "list every changed text, not only the counts")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: palireader sync [-l] [library...]")
+		fmt.Fprintln(flags.Output(), "\nClones the git repository configured for the texts, or for each library,")
+		fmt.Fprintln(flags.Output(), "or pulls its new commits, so that several installations read the same")
+		fmt.Fprintln(flags.Output(), "versions. The texts that changed are reported and the word index is")
+		fmt.Fprintln(flags.Output(), "rebuilt to check them. A running server re-indexes on SIGHUP.")
+		fmt.Fprintln(flags.Output(), "\nFlags:")
+		flags.PrintDefaults()
+	}
+	names := parseInterspersed(flags, args)
+
+	targets, err := syncTargets(names)
+	if err != nil {
+		return err
+	}
+	changed := false
+	for _, t := range targets {
+		label := t.dir
+		if t.name != "" {
+			label = t.name
+		}
+		n, err := syncRepo(t, label, *list)
+		if err != nil {
+			return fmt.Errorf("%s: %w", label, err)
+		}
+		changed = changed || n > 0
+	}
+	if !changed {
+		return nil
+	}
+
+	// The texts are read afresh, as the index command does,
+	if corpus, err = openCorpus(config.Libraries); err != nil {
+		return err
+	}
+	// and the index is kept for the server to read on re-indexing
+	start := time.Now()
+	idx, err := storedCorpusIndex()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Indexed %d texts (%d word forms) in %s\n",
+		len(idx.Paths), len(idx.forms), time.Since(start).Round(time.Millisecond))
+	fmt.Println("Send a running server SIGHUP to re-index, or restart it")
+	return nil
+}
+
+// syncRepo clones or pulls one folder, reporting the texts that changed,
+// and returns how many did
+func syncRepo(t syncTarget, label string, list bool) (int, error) {
+	if entries, err := os.ReadDir(t.dir); errors.Is(err, fs.ErrNotExist) || (err == nil && len(entries) == 0) {
+		cloneArgs := []string{"clone", "--quiet"}
+		if t.git.Branch != "" {
+			cloneArgs = append(cloneArgs, "--branch", t.git.Branch)
+		}
+		if err := runGit("", append(cloneArgs, "--", t.git.Repo, t.dir)...); err != nil {
+			return 0, err
+		}
+		files, err := git(t.dir, "ls-files", "--", "*.htm")
+		if err != nil {
+			return 0, err
+		}
+		n := len(strings.Fields(files))
+		fmt.Printf("%s: cloned %s, %d texts\n", label, t.git.Repo, n)
+		return n, nil
+	}
+
+	if _, err := git(t.dir, "rev-parse", "--show-toplevel"); err != nil {
+		return 0, fmt.Errorf("%s is not a git checkout; move it away to clone %s", t.dir, t.git.Repo)
+	}
+	old, err := git(t.dir, "rev-parse", "HEAD")
+	if err != nil {
+		return 0, err
+	}
+	pullArgs := []string{"pull", "--quiet", "--ff-only", t.git.Repo}
+	if t.git.Branch != "" {
+		pullArgs = append(pullArgs, t.git.Branch)
+	}
+	if err := runGit(t.dir, pullArgs...); err != nil {
+		return 0, err
+	}
+	head, err := git(t.dir, "rev-parse", "HEAD")
+	if err != nil {
+		return 0, err
+	}
+	if head == old {
+		fmt.Printf("%s: up to date at %.12s\n", label, head)
+		return 0, nil
+	}
+
+	diff, err := git(t.dir, "diff", "--name-status", "-M", old, head, "--", "*.htm")
+	if err != nil {
+		return 0, err
+	}
+	counts := make(map[string]int)
+	var lines []string
+	scanner := bufio.NewScanner(strings.NewReader(diff))
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) < 2 {
+			continue
+		}
+		status := fields[0][:1]
+		counts[status]++
+		lines = append(lines, fmt.Sprintf("  %s %s", status, strings.Join(fields[1:], " -> ")))
+	}
+	n := len(lines)
+	fmt.Printf("%s: %.12s..%.12s, %d texts added, %d changed, %d renamed, %d deleted\n",
+		label, old, head, counts["A"], counts["M"], counts["R"], counts["D"])
+	if list {
+		for _, line := range lines {
+			fmt.Println(line)
+		}
+	}
+	return n, nil
+}
+
+// git runs a git command in dir and returns its trimmed output
+func git(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("git %s: %s", args[0], msg)
+		}
+		return "", fmt.Errorf("git %s: %w", args[0], err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// runGit runs a git command in dir ("" for the current folder) with its
+// output going to the terminal
+func runGit(dir string, args ...string) error {
+	name := args[0]
+	if dir != "" {
+		args = append([]string{"-C", dir}, args...)
+	}
+	cmd := exec.Command("git", args...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("git %s failed", name)
+	}
+	return nil
+}
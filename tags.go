@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// textTags holds the tags each reader gave texts, such as "for class" or
+// "needs proofreading", sorted, by corpus name
+var textTags = &userFile[map[string][]string]{name: "tags.json"}
+
+const (
+	// maxTagLength bounds a tag, in characters
+	maxTagLength = 40
+	// maxTextTags caps the tags of one text
+	maxTextTags = 20
+	// maxTaggedTexts caps how many texts a reader can tag
+	maxTaggedTexts = 5000
+)
+
+var errTooManyTagged = fmt.Errorf("you have tagged %d texts already; untag some first", maxTaggedTexts)
+
+// TagPage is what the tags pages show: every tag with how many texts have
+// it, or the texts of one
+type TagPage struct {
+	Tag    string
+	Counts []TagCount
+	Texts  []TaggedText
+}
+
+// TagCount is a tag and how many texts have it
+type TagCount struct {
+	Tag   string
+	Count int
+}
+
+// TaggedText is a text with its tags
+type TaggedText struct {
+	Path  string
+	Title string
+	Tags  []string
+}
+
+// cleanTags turns what the reader typed, tags separated by commas, into
+// tags: lower-case, spaces collapsed, without repeats, sorted
+func cleanTags(list string) []string {
+	var tags []string
+	for _, tag := range strings.Split(list, ",") {
+		tag = strings.ToLower(strings.Join(strings.Fields(tag), " "))
+		tag = strings.Trim(tag, "/")
+		if tag == "" || utf8.RuneCountInString(tag) > maxTagLength || slices.Contains(tags, tag) {
+			continue
+		}
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	return tags[:min(len(tags), maxTextTags)]
+}
+
+// tagsOf returns the tags the reader gave texts, for the chips of a page
+func tagsOf(r *http.Request) map[string][]string {
+	if config.Private || staticSite {
+		return nil
+	}
+	tags := make(map[string][]string)
+	textTags.of(r).Read(func(all *map[string][]string) {
+		for name, list := range *all {
+			tags[name] = slices.Clone(list)
+		}
+	})
+	return tags
+}
+
+// handleTags lists the reader's tags (GET /tags), the texts with one
+// (GET /tags/for class), or sets the tags of a text (POST /tags with path
+// and tags separated by commas, answered with the tags as saved)
+func handleTags(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		saveTags(w, r)
+		return
+	}
+
+	tag := strings.Trim(strings.TrimPrefix(r.URL.Path, "/tags"), "/")
+	page := &TagPage{Tag: tag}
+	counts := make(map[string]int)
+	err := textTags.of(r).Read(func(all *map[string][]string) {
+		for name, tags := range *all {
+			if !canRead(r, name) || !textExists(name) {
+				continue
+			}
+			for _, t := range tags {
+				counts[t]++
+			}
+			if tag != "" && slices.Contains(tags, tag) {
+				page.Texts = append(page.Texts, TaggedText{Path: name, Title: displayTitle(name), Tags: slices.Clone(tags)})
+			}
+		}
+	})
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if tag != "" && len(page.Texts) == 0 {
+		httpError(w, r, "No text is tagged "+tag, http.StatusNotFound)
+		return
+	}
+	for t, n := range counts {
+		page.Counts = append(page.Counts, TagCount{Tag: t, Count: n})
+	}
+	sort.Slice(page.Counts, func(i, j int) bool { return page.Counts[i].Tag < page.Counts[j].Tag })
+	sort.Slice(page.Texts, func(i, j int) bool { return page.Texts[i].Path < page.Texts[j].Path })
+
+	data := PageData{Title: "Tags", TagPage: page}
+	if tag != "" {
+		data.Title = "Tagged " + tag
+	}
+	err = templatesFor(r).ExecuteTemplate(w, "tags", data)
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func saveTags(w http.ResponseWriter, r *http.Request) {
+	name, err := corpusName(resolveSlugs(r.FormValue("path")))
+	if err != nil || !textExists(name) || !canRead(r, name) {
+		writeAPIError(w, r, http.StatusBadRequest, "Unknown text", map[string]string{"field": "path"})
+		return
+	}
+	tags := cleanTags(r.FormValue("tags"))
+	err = textTags.of(r).Update(func(all *map[string][]string) error {
+		if *all == nil {
+			*all = make(map[string][]string)
+		}
+		_, tagged := (*all)[name]
+		switch {
+		case len(tags) == 0:
+			delete(*all, name)
+		case !tagged && len(*all) >= maxTaggedTexts:
+			return errTooManyTagged
+		default:
+			(*all)[name] = tags
+		}
+		return nil
+	})
+	if errors.Is(err, errTooManyTagged) {
+		writeAPIError(w, r, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+	if err != nil {
+		logf(r.Context(), "Error saving tags: %v", err)
+		writeAPIError(w, r, http.StatusInternalServerError, "Cannot save tags", nil)
+		return
+	}
+	if tags == nil {
+		tags = []string{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tags)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+)
+
+// themeTemplate is a template file of the theme directory, parsed after the
+// built-in templates so that its definitions replace theirs
+type themeTemplate struct {
+	name    string
+	content string
+}
+
+// themeTemplates are the templates of the theme directory, if one is set
+var themeTemplates []themeTemplate
+
+// loadTheme reads a theme directory: the *.html files of its templates/
+// directory, which redefine templates such as "logo", "footer-note" or the
+// whole "header", the files of its static/ directory, which replace the
+// assets of the same name or add to them, and the catalogs of its locales/
+// directory. A theme.css there is linked after the built-in stylesheet.
+func loadTheme(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "templates", "*.html"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		themeTemplates = append(themeTemplates, themeTemplate{name: filepath.Base(file), content: string(content)})
+	}
+
+	entries, err := os.ReadDir(filepath.Join(dir, "static"))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		content, err := os.ReadFile(filepath.Join(dir, "static", e.Name()))
+		if err != nil {
+			return err
+		}
+		contentType, ok := assetTypes[path.Ext(e.Name())]
+		if !ok {
+			contentType = mime.TypeByExtension(path.Ext(e.Name()))
+		}
+		staticAssets[e.Name()] = staticAsset{ContentType: contentType, Content: content}
+	}
+	staticAssets = fingerprintAssets(staticAssets)
+
+	if _, err := os.Stat(filepath.Join(dir, "locales")); err == nil {
+		if themeCatalogs, err = readCatalogs(os.DirFS(filepath.Join(dir, "locales"))); err != nil {
+			return fmt.Errorf("%s: %w", filepath.Join(dir, "locales"), err)
+		}
+	}
+	return nil
+}
+
+// parseThemeTemplates adds the theme's templates to the built-in ones
+func parseThemeTemplates(set *template.Template) error {
+	for _, t := range themeTemplates {
+		if _, err := set.New(t.name).Parse(t.content); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// themeStylesheet returns the URL path of the theme's stylesheet, or "" if
+// the theme has none
+func themeStylesheet() string {
+	if _, ok := staticAssets["theme.css"]; !ok {
+		return ""
+	}
+	return assetPath("theme.css")
+}
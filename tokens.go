@@ -0,0 +1,306 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// APIToken lets a script or app call the server as the user who made it,
+// for the scopes it was given, without their password. Only the hash of
+// the token is kept; the token itself is shown once, when made.
+type APIToken struct {
+	ID      string    `json:"id"`
+	User    string    `json:"user"`
+	Name    string    `json:"name"`
+	Hash    string    `json:"hash"` // hex SHA-256 of the whole token
+	Scopes  []string  `json:"scopes"`
+	Created time.Time `json:"created"`
+	Expires time.Time `json:"expires,omitzero"` // never if zero
+	Used    time.Time `json:"used,omitzero"`    // to the hour
+}
+
+var apiTokens = &jsonFile[[]APIToken]{name: "tokens.json"}
+
+// TokenScope is what a token may be allowed to do: call the endpoints under
+// Paths, a path ending in / standing for those below it. A token with the
+// scope may call them with any method, and with the scope followed by
+// ":read" only with GET and HEAD.
+type TokenScope struct {
+	Name  string
+	Label string
+	Paths []string
+}
+
+// tokenScopes are the scopes tokens can have. "texts" covers every page
+// not listed under another scope, reading only.
+var tokenScopes = []TokenScope{
+	{"texts", "Texts, search and the dictionary", nil},
+	{"bookmarks", "Bookmarks, favorites and tags", []string{"/bookmarks", "/favorites", "/tags", "/tags/"}},
+	{"vocab", "Saved and looked-up words, reviews and known words", []string{"/vocab", "/lookups", "/history", "/review", "/known", "/export/flashcards"}},
+	{"progress", "Reading log and statistics", []string{"/reading", "/stats"}},
+	{"annotations", "Notes and highlights", []string{"/annotations/"}},
+	{"account", "Export and import of all your data", []string{"/account/"}},
+}
+
+// tokenlessPaths are never open to tokens, only to a reader logged in, so
+// that a token cannot make more tokens or act as an admin
+var tokenlessPaths = []string{"/tokens", "/settings", "/admin/", "/login", "/login/", "/logout", "/invite/", "/language"}
+
+const (
+	tokenPrefix = "prt_"
+	// maxTokens caps how many tokens one user can have
+	maxTokens = 20
+)
+
+var (
+	errInvalidToken = errors.New("the token is invalid, expired or revoked")
+	errTokenScope   = errors.New("the token is not allowed to do this")
+)
+
+// matchPaths reports whether path is one of paths or below one ending in /
+func matchPaths(path string, paths []string) bool {
+	for _, p := range paths {
+		if path == p || strings.HasSuffix(p, "/") && strings.HasPrefix(path, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// requiredScope returns the scope a token needs to make the request, or ""
+// if no token may
+func requiredScope(r *http.Request) string {
+	if matchPaths(r.URL.Path, tokenlessPaths) {
+		return ""
+	}
+	read := r.Method == http.MethodGet || r.Method == http.MethodHead
+	for _, s := range tokenScopes[1:] {
+		if matchPaths(r.URL.Path, s.Paths) {
+			if read {
+				return s.Name + ":read"
+			}
+			return s.Name
+		}
+	}
+	if read {
+		return "texts:read"
+	}
+	return ""
+}
+
+// allows reports whether the token's scopes cover scope
+func (t *APIToken) allows(scope string) bool {
+	if scope == "" {
+		return false
+	}
+	name, _ := strings.CutSuffix(scope, ":read")
+	return slices.Contains(t.Scopes, scope) || slices.Contains(t.Scopes, name)
+}
+
+// requestToken returns the token of the request's Authorization header: nil
+// without one, and an error if it is not valid or not allowed to make the
+// request
+func requestToken(r *http.Request) (*APIToken, error) {
+	value, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return nil, nil
+	}
+	rest, ok := strings.CutPrefix(strings.TrimSpace(value), tokenPrefix)
+	id, _, _ := strings.Cut(rest, "_")
+	if !ok || id == "" {
+		return nil, errInvalidToken
+	}
+	sum := sha256.Sum256([]byte(strings.TrimSpace(value)))
+	hash := hex.EncodeToString(sum[:])
+
+	var token *APIToken
+	apiTokens.Read(func(all *[]APIToken) {
+		for _, t := range *all {
+			if t.ID == id && subtle.ConstantTimeCompare([]byte(t.Hash), []byte(hash)) == 1 {
+				token = &t
+			}
+		}
+	})
+	// Removing a user from the config ends their tokens, as their sessions
+	if token == nil || !token.Expires.IsZero() && time.Now().After(token.Expires) || !knownUser(token.User) {
+		return nil, errInvalidToken
+	}
+	if !token.allows(requiredScope(r)) {
+		return token, errTokenScope
+	}
+	return token, nil
+}
+
+// tokenUser returns the user whose token authorizes the request, and
+// whether the request came with a token at all
+func tokenUser(r *http.Request) (string, bool) {
+	token, err := requestToken(r)
+	if token == nil || err != nil {
+		return "", token != nil || err != nil
+	}
+	return token.User, true
+}
+
+// checkToken answers a request whose token is not valid or not allowed to
+// make it, reporting whether it did. Tokens that pass are marked as used.
+func checkToken(w http.ResponseWriter, r *http.Request) bool {
+	token, err := requestToken(r)
+	switch {
+	case errors.Is(err, errInvalidToken):
+		w.Header().Set("WWW-Authenticate", `Bearer realm="Pali Reader", error="invalid_token"`)
+		writeAPIError(w, r, http.StatusUnauthorized, "Invalid token", nil)
+		return true
+	case errors.Is(err, errTokenScope):
+		scope := requiredScope(r)
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="Pali Reader", error="insufficient_scope", scope=%q`, scope))
+		writeAPIError(w, r, http.StatusForbidden, "The token does not have the scope for this", map[string]string{"scope": scope})
+		return true
+	}
+	// Recorded at most once an hour, not to write on every call
+	if token != nil && time.Since(token.Used) > time.Hour {
+		err := apiTokens.Update(func(all *[]APIToken) error {
+			if i := slices.IndexFunc(*all, func(t APIToken) bool { return t.ID == token.ID }); i >= 0 {
+				(*all)[i].Used = time.Now().UTC().Truncate(time.Hour)
+			}
+			return nil
+		})
+		if err != nil {
+			logf(r.Context(), "Error recording the use of token %s: %v", token.ID, err)
+		}
+	}
+	return false
+}
+
+// newToken makes a token for user with the scopes, valid for days (forever
+// if 0), returning it with what is kept of it
+func newToken(user, name string, scopes []string, days int) (string, APIToken, error) {
+	if name == "" {
+		return "", APIToken{}, errors.New("name the token after what will use it")
+	}
+	if len(scopes) == 0 {
+		return "", APIToken{}, errors.New("give the token at least one scope")
+	}
+	for _, s := range scopes {
+		base, _ := strings.CutSuffix(s, ":read")
+		if !slices.ContainsFunc(tokenScopes, func(ts TokenScope) bool { return ts.Name == base }) || s == "texts" {
+			return "", APIToken{}, fmt.Errorf("unknown scope %q", s)
+		}
+	}
+	id, secret := make([]byte, 8), make([]byte, 32)
+	rand.Read(id)
+	rand.Read(secret)
+	value := tokenPrefix + hex.EncodeToString(id) + "_" + hex.EncodeToString(secret)
+	sum := sha256.Sum256([]byte(value))
+	token := APIToken{
+		ID:      hex.EncodeToString(id),
+		User:    user,
+		Name:    name,
+		Hash:    hex.EncodeToString(sum[:]),
+		Scopes:  scopes,
+		Created: time.Now().UTC(),
+	}
+	if days > 0 {
+		token.Expires = token.Created.Add(time.Duration(days) * 24 * time.Hour)
+	}
+	err := apiTokens.Update(func(all *[]APIToken) error {
+		// Expired tokens go
+		now := time.Now()
+		*all = slices.DeleteFunc(*all, func(t APIToken) bool {
+			return !t.Expires.IsZero() && now.After(t.Expires)
+		})
+		mine := 0
+		for _, t := range *all {
+			if t.User == user {
+				mine++
+			}
+		}
+		if mine >= maxTokens {
+			return fmt.Errorf("you have %d tokens already; revoke one first", maxTokens)
+		}
+		*all = append(*all, token)
+		return nil
+	})
+	return value, token, err
+}
+
+// revokeToken deletes one of user's tokens
+func revokeToken(user, id string) error {
+	return apiTokens.Update(func(all *[]APIToken) error {
+		i := slices.IndexFunc(*all, func(t APIToken) bool { return t.ID == id && t.User == user })
+		if i < 0 {
+			return errors.New("no such token")
+		}
+		*all = slices.Delete(*all, i, i+1)
+		return nil
+	})
+}
+
+// TokensPage is the tokens of a user as the tokens page shows them
+type TokensPage struct {
+	Tokens []APIToken
+	Scopes []TokenScope
+	New    string // the token just made, shown this once
+}
+
+// handleTokens lists the tokens of the logged-in reader with a form to make
+// one (GET), or makes or revokes one (POST)
+func handleTokens(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	if user == "" {
+		http.Redirect(w, r, sitePath("/login?next="+url.QueryEscape("/tokens")), http.StatusSeeOther)
+		return
+	}
+	page := &TokensPage{Scopes: tokenScopes}
+	data := PageData{Title: "API tokens", Tokens: page}
+
+	if r.Method == http.MethodPost {
+		var err error
+		switch r.FormValue("action") {
+		case "revoke":
+			err = revokeToken(user, r.FormValue("id"))
+			data.Notice = "Token revoked."
+		default:
+			var scopes []string
+			for _, s := range tokenScopes {
+				switch r.FormValue("scope-" + s.Name) {
+				case "read":
+					scopes = append(scopes, s.Name+":read")
+				case "write":
+					scopes = append(scopes, s.Name)
+				}
+			}
+			days, _ := strconv.Atoi(r.FormValue("days"))
+			var token APIToken
+			page.New, token, err = newToken(user, strings.TrimSpace(r.FormValue("name")), scopes, max(days, 0))
+			if err == nil {
+				logf(r.Context(), "%s made the token %s (%s)", user, token.ID, strings.Join(token.Scopes, " "))
+			}
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			data.Notice = err.Error()
+		}
+	}
+
+	apiTokens.Read(func(all *[]APIToken) {
+		for _, t := range *all {
+			if t.User == user {
+				page.Tokens = append(page.Tokens, t)
+			}
+		}
+	})
+	err := templatesFor(r).ExecuteTemplate(w, "tokens", data)
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusInternalServerError)
+	}
+}
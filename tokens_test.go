@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequiredScope(t *testing.T) {
+	tests := []struct {
+		method, path string
+		scope        string
+	}{
+		{http.MethodGet, "/read/gretil/x.htm", "texts:read"},
+		{http.MethodHead, "/search", "texts:read"},
+		{http.MethodPost, "/search", ""},
+		{http.MethodGet, "/bookmarks", "bookmarks:read"},
+		{http.MethodPost, "/bookmarks", "bookmarks"},
+		{http.MethodGet, "/bookmarksx", "texts:read"},
+		{http.MethodDelete, "/annotations/42", "annotations"},
+		{http.MethodGet, "/export/flashcards", "vocab:read"},
+		{http.MethodGet, "/account/export", "account:read"},
+		// Tokens can neither make tokens nor act as an admin
+		{http.MethodGet, "/tokens", ""},
+		{http.MethodPost, "/tokens", ""},
+		{http.MethodGet, "/admin/upload", ""},
+		{http.MethodPost, "/settings", ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.path, nil)
+		if scope := requiredScope(r); scope != tt.scope {
+			t.Errorf("requiredScope(%s %s) = %q; want %q", tt.method, tt.path, scope, tt.scope)
+		}
+	}
+}
+
+func TestTokenAllows(t *testing.T) {
+	token := &APIToken{Scopes: []string{"bookmarks", "vocab:read", "texts:read"}}
+	tests := []struct {
+		scope string
+		ok    bool
+	}{
+		{"bookmarks", true},
+		{"bookmarks:read", true},
+		{"vocab:read", true},
+		{"vocab", false},
+		{"texts:read", true},
+		{"annotations:read", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if ok := token.allows(tt.scope); ok != tt.ok {
+			t.Errorf("allows(%q) = %v; want %v", tt.scope, ok, tt.ok)
+		}
+	}
+}
+
+func TestRequestToken(t *testing.T) {
+	useConfig(t, Config{DataDir: t.TempDir(), Auth: &AuthConfig{Users: map[string]string{"ann": "a"}}})
+	saved := apiTokens
+	apiTokens = &jsonFile[[]APIToken]{name: "tokens.json"}
+	t.Cleanup(func() { apiTokens = saved })
+
+	for _, scopes := range [][]string{nil, {"texts"}, {"tokens"}, {"bookmarks:write"}} {
+		if _, _, err := newToken("ann", "script", scopes, 0); err == nil {
+			t.Errorf("newToken with scopes %q made a token", scopes)
+		}
+	}
+	if _, _, err := newToken("ann", "", []string{"bookmarks"}, 0); err == nil {
+		t.Error("newToken made a token with no name")
+	}
+
+	value, token, err := newToken("ann", "script", []string{"bookmarks:read"}, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := func(method, path, value string) (*APIToken, error) {
+		r := httptest.NewRequest(method, path, nil)
+		r.Header.Set("Authorization", "Bearer "+value)
+		return requestToken(r)
+	}
+	if got, err := request(http.MethodGet, "/bookmarks", value); err != nil || got.User != "ann" {
+		t.Errorf("reading bookmarks = %v, %v; want ann's token", got, err)
+	}
+	for _, path := range []string{"/read/gretil/x.htm", "/tokens"} {
+		if _, err := request(http.MethodGet, path, value); !errors.Is(err, errTokenScope) {
+			t.Errorf("GET %s = %v; want %v", path, err, errTokenScope)
+		}
+	}
+	if _, err := request(http.MethodPost, "/bookmarks", value); !errors.Is(err, errTokenScope) {
+		t.Errorf("saving a bookmark = %v; want %v", err, errTokenScope)
+	}
+	for _, bad := range []string{"", "prt_", tokenPrefix + token.ID + "_00", value + "0", "prt_0000000000000000" + value[len(tokenPrefix)+16:]} {
+		if _, err := request(http.MethodGet, "/bookmarks", bad); !errors.Is(err, errInvalidToken) {
+			t.Errorf("token %q = %v; want %v", bad, err, errInvalidToken)
+		}
+	}
+
+	if err := revokeToken("bob", token.ID); err == nil {
+		t.Error("bob revoked ann's token")
+	}
+	if err := revokeToken("ann", token.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := request(http.MethodGet, "/bookmarks", value); !errors.Is(err, errInvalidToken) {
+		t.Errorf("revoked token = %v; want %v", err, errInvalidToken)
+	}
+}
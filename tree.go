@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TreeNode is a folder or text as /api/tree lists it. Children is nil for
+// texts and for folders below the depth asked for, which the sidebar asks
+// for in turn when they are opened.
+type TreeNode struct {
+	Name     string      `json:"name"`
+	Path     string      `json:"path"`
+	Title    string      `json:"title"`
+	ID       string      `json:"id,omitempty"`
+	URL      string      `json:"url"`
+	Folder   bool        `json:"folder,omitempty"`
+	Children []*TreeNode `json:"children,omitempty"`
+}
+
+const (
+	// maxTreeDepth bounds how many levels one call to /api/tree lists
+	maxTreeDepth = 3
+	// maxTreeListings caps the subtree listings kept
+	maxTreeListings = 2000
+)
+
+// treeListings keeps the subtrees /api/tree listed, by corpus path and
+// depth, while the watcher sees no change, or for titleIndexAge without one
+var treeListings = struct {
+	sync.Mutex
+	generation uint64
+	entries    map[string]treeListing
+}{entries: make(map[string]treeListing)}
+
+type treeListing struct {
+	node  *TreeNode
+	built time.Time
+}
+
+// subtree lists the folder at the corpus path dir, depth levels down
+func subtree(dir string, depth int) *TreeNode {
+	generation, enabled := corpusCache.state()
+	key := dir + "\x00" + strconv.Itoa(depth)
+	treeListings.Lock()
+	if treeListings.generation != generation {
+		clear(treeListings.entries)
+		treeListings.generation = generation
+	}
+	cached, ok := treeListings.entries[key]
+	treeListings.Unlock()
+	if ok && (enabled || time.Since(cached.built) < titleIndexAge) {
+		return cached.node
+	}
+
+	tree := buildFileTree(dir)
+	node := treeNode(tree)
+	if dir == "" {
+		node.Name, node.Path, node.URL = "", "", sitePath("/")
+	}
+	node.Children = []*TreeNode{}
+	for _, child := range tree.Children {
+		if child.IsDir && depth > 1 {
+			node.Children = append(node.Children, subtree(child.Path, depth-1))
+		} else {
+			node.Children = append(node.Children, treeNode(child))
+		}
+	}
+
+	treeListings.Lock()
+	defer treeListings.Unlock()
+	if treeListings.generation == generation {
+		if len(treeListings.entries) >= maxTreeListings {
+			clear(treeListings.entries)
+		}
+		treeListings.entries[key] = treeListing{node, time.Now()}
+	}
+	return node
+}
+
+func treeNode(f *FileInfo) *TreeNode {
+	return &TreeNode{
+		Name:   f.Name,
+		Path:   f.Path,
+		Title:  f.DisplayName(),
+		ID:     f.ID,
+		URL:    sitePath("/read/" + slugPath(f.Path)),
+		Folder: f.IsDir,
+	}
+}
+
+// expandTo returns a copy of node with the folders on the way to the
+// corpus path target listed too, so that the sidebar opens on the page
+// shown with one call
+func expandTo(node *TreeNode, target string) *TreeNode {
+	copied := *node
+	copied.Children = slices.Clone(node.Children)
+	for i, child := range copied.Children {
+		if !child.Folder || target != child.Path && !strings.HasPrefix(target, child.Path+"/") {
+			continue
+		}
+		if child.Children == nil {
+			listed := *child
+			listed.Children = subtree(child.Path, 1).Children
+			child = &listed
+		}
+		copied.Children[i] = expandTo(child, target)
+	}
+	return &copied
+}
+
+// readableTree returns a copy of node without what the request may not
+// read. The cached listings are shared, so they are never changed.
+func readableTree(r *http.Request, node *TreeNode) *TreeNode {
+	copied := *node
+	if node.Children == nil {
+		return &copied
+	}
+	copied.Children = []*TreeNode{}
+	for _, child := range node.Children {
+		if canRead(r, child.Path) {
+			copied.Children = append(copied.Children, readableTree(r, child))
+		}
+	}
+	return &copied
+}
+
+// handleTreeAPI lists a folder of the corpus as JSON for the contents
+// sidebar: /api/tree?path=digha-nikaya&depth=1, with a corpus or slug path,
+// the top of the corpus without one, down to depth levels (1 by default).
+// With open=<path> the folders on the way to that path are listed as well.
+func handleTreeAPI(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	dir, err := corpusName(resolveSlugs(query.Get("path")))
+	if err != nil {
+		writeAPIError(w, r, http.StatusBadRequest, "Invalid path", map[string]string{"field": "path"})
+		return
+	}
+	if dir == "." {
+		dir = ""
+	}
+	depth := 1
+	if d := query.Get("depth"); d != "" {
+		depth, err = strconv.Atoi(d)
+		if err != nil || depth < 1 || depth > maxTreeDepth {
+			writeAPIError(w, r, http.StatusBadRequest, "The depth is from 1 to "+strconv.Itoa(maxTreeDepth), map[string]string{"field": "depth"})
+			return
+		}
+	}
+	if dir != "" {
+		if info, err := fs.Stat(corpus, dir); err != nil || !info.IsDir() || !canRead(r, dir) {
+			writeAPIError(w, r, http.StatusNotFound, "Folder not found", nil)
+			return
+		}
+	}
+
+	node := subtree(dir, depth)
+	if open := query.Get("open"); open != "" {
+		target, err := corpusName(resolveSlugs(open))
+		if err != nil {
+			writeAPIError(w, r, http.StatusBadRequest, "Invalid path", map[string]string{"field": "open"})
+			return
+		}
+		node = expandTo(node, target)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	// Listings differ by login where some folders are protected
+	w.Header().Set("Cache-Control", "private, max-age=60")
+	json.NewEncoder(w).Encode(readableTree(r, node))
+}
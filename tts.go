@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TTSConfig selects the speech synthesizer behind the reader's Listen
+// button. Provider "command" runs a local program, such as espeak-ng or a
+// wrapper around Piper, that reads the text on stdin and writes the audio
+// to stdout; provider "http" posts to an OpenAI-compatible /audio/speech
+// endpoint.
+type TTSConfig struct {
+	Provider  string   `json:"provider"`
+	Command   []string `json:"command"`
+	URL       string   `json:"url"`
+	Model     string   `json:"model"`
+	Voice     string   `json:"voice"`
+	APIKeyEnv string   `json:"apiKeyEnv"`
+	Format    string   `json:"format"`   // of the audio: mp3, wav, ogg, opus or flac; mp3 if unset
+	CacheDir  string   `json:"cacheDir"` // dataDir/tts if unset
+}
+
+// audioTypes are the content types of the audio formats a synthesizer may
+// write
+var audioTypes = map[string]string{
+	"mp3":  "audio/mpeg",
+	"wav":  "audio/wav",
+	"ogg":  "audio/ogg",
+	"opus": "audio/ogg",
+	"flac": "audio/flac",
+}
+
+// maxSpeechChars caps the text of one paragraph sent to the synthesizer,
+// which is as much as speech APIs take at once
+const maxSpeechChars = 4000
+
+// speechTimeout is how long synthesizing one paragraph may take
+const speechTimeout = 2 * time.Minute
+
+// speeches holds a slot for each paragraph being synthesized
+var speeches = make(chan struct{}, 2)
+
+// Synthesizer turns text into audio in the configured format
+type Synthesizer interface {
+	Synthesize(ctx context.Context, text string) ([]byte, error)
+}
+
+// validate checks the section and fills in its defaults
+func (c *TTSConfig) validate() error {
+	if c.Format == "" {
+		c.Format = "mp3"
+	}
+	if _, ok := audioTypes[c.Format]; !ok {
+		return fmt.Errorf("tts: unknown format %q", c.Format)
+	}
+	_, err := newSynthesizer(c)
+	return err
+}
+
+// newSynthesizer returns the synthesizer described by the config
+func newSynthesizer(c *TTSConfig) (Synthesizer, error) {
+	switch c.Provider {
+	case "http":
+		if c.URL == "" {
+			return nil, errors.New("tts: the http provider needs a url")
+		}
+		return &httpSynthesizer{config: c, client: &http.Client{Timeout: speechTimeout}}, nil
+	case "command":
+		if len(c.Command) == 0 {
+			return nil, errors.New("tts: the command provider needs a command")
+		}
+		return &commandSynthesizer{command: c.Command}, nil
+	}
+	return nil, fmt.Errorf("tts: unknown provider %q", c.Provider)
+}
+
+// httpSynthesizer calls an OpenAI-compatible /audio/speech endpoint
+type httpSynthesizer struct {
+	config *TTSConfig
+	client *http.Client
+}
+
+func (s *httpSynthesizer) Synthesize(ctx context.Context, text string) ([]byte, error) {
+	body, err := json.Marshal(map[string]any{
+		"model":           s.config.Model,
+		"voice":           s.config.Voice,
+		"input":           text,
+		"response_format": s.config.Format,
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.config.APIKeyEnv != "" {
+		req.Header.Set("Authorization", "Bearer "+os.Getenv(s.config.APIKeyEnv))
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("speech request failed: %s", resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// commandSynthesizer runs a local program once per paragraph
+type commandSynthesizer struct {
+	command []string
+}
+
+func (s *commandSynthesizer) Synthesize(ctx context.Context, text string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, s.command[0], s.command[1:]...)
+	cmd.Stdin = strings.NewReader(text)
+	cmd.Stderr = os.Stderr
+	audio, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", s.command[0], err)
+	}
+	if len(audio) == 0 {
+		return nil, fmt.Errorf("%s wrote no audio", s.command[0])
+	}
+	return audio, nil
+}
+
+func speechCacheDir() string {
+	if config.TTS.CacheDir != "" {
+		return config.TTS.CacheDir
+	}
+	return filepath.Join(config.DataDir, "tts")
+}
+
+// speechKey names the audio of a text as the synthesizer configured speaks
+// it, so that another voice or model is not served from the cache
+func speechKey(text string) string {
+	c := config.TTS
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%s\x00%s\x00%s\x00%s\x00",
+		c.Provider, strings.Join(c.Command, "\x01"), c.URL, c.Model, c.Voice, c.Format)
+	io.WriteString(h, text)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// speechText cuts a paragraph down to what the synthesizer takes, at a space
+func speechText(text string) string {
+	if len(text) <= maxSpeechChars {
+		return text
+	}
+	if i := strings.LastIndexByte(text[:maxSpeechChars], ' '); i > 0 {
+		return text[:i]
+	}
+	return strings.ToValidUTF8(text[:maxSpeechChars], "")
+}
+
+// handleListen serves a paragraph of a text (?p=N, as in its #pN anchor)
+// read aloud, synthesizing it the first time and keeping the audio on disk
+// by the hash of the text. An empty paragraph has no audio: 204 No Content.
+func handleListen(w http.ResponseWriter, r *http.Request) {
+	filePath := strings.TrimPrefix(r.URL.Path, "/listen/")
+	name, err := corpusName(filePath)
+	if err != nil {
+		httpError(w, r, "Invalid path", http.StatusBadRequest)
+		return
+	}
+	content, err := fs.ReadFile(corpus, name)
+	if err != nil {
+		httpError(w, r, "File not found", http.StatusNotFound)
+		return
+	}
+	paras := paragraphs(name, string(content))
+	p, err := strconv.Atoi(r.URL.Query().Get("p"))
+	if err != nil || p < 0 || p >= len(paras) {
+		httpError(w, r, "No such paragraph", http.StatusNotFound)
+		return
+	}
+	text := speechText(paras[p])
+	if !containsLetter(text) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	key := speechKey(text)
+	cached := filepath.Join(speechCacheDir(), key+"."+config.TTS.Format)
+	audio, err := os.ReadFile(cached)
+	if err != nil {
+		if audio, err = synthesize(w, r, text, cached); err != nil {
+			return
+		}
+	}
+	w.Header().Set("Content-Type", audioTypes[config.TTS.Format])
+	w.Header().Set("ETag", `"`+key+`"`)
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(audio))
+}
+
+// synthesize reads the text aloud and keeps the audio at path, answering
+// the request with what went wrong if it could not
+func synthesize(w http.ResponseWriter, r *http.Request, text, path string) ([]byte, error) {
+	if err := takeQuota(w, r, "speech"); err != nil {
+		httpError(w, r, err.Error(), http.StatusTooManyRequests)
+		return nil, err
+	}
+	select {
+	case speeches <- struct{}{}:
+		defer func() { <-speeches }()
+	case <-r.Context().Done():
+		refundQuota(r, "speech")
+		return nil, r.Context().Err()
+	}
+	synth, err := newSynthesizer(config.TTS)
+	if err != nil {
+		refundQuota(r, "speech")
+		httpError(w, r, err.Error(), http.StatusInternalServerError)
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), speechTimeout)
+	defer cancel()
+	audio, err := synth.Synthesize(ctx, text)
+	if err != nil {
+		refundQuota(r, "speech")
+		logf(r.Context(), "Error synthesizing speech: %v", err)
+		httpError(w, r, "The speech synthesizer could not read this paragraph", http.StatusBadGateway)
+		return nil, err
+	}
+	if err := writeFileAtomic(path, audio); err != nil {
+		logf(r.Context(), "Error keeping speech: %v", err)
+	}
+	return audio, nil
+}
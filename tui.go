@@ -0,0 +1,536 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/term"
+)
+
+// Keys the terminal reader understands, beyond plain characters
+const (
+	keyUp = iota + utf8.MaxRune + 1
+	keyDown
+	keyLeft
+	keyRight
+	keyPageUp
+	keyPageDown
+	keyHome
+	keyEnd
+	keyEnter
+	keyBackspace
+	keyEscape
+)
+
+// tuiLine is one wrapped line of a text with the rune spans of its words
+type tuiLine struct {
+	text  []rune
+	words [][2]int
+}
+
+// tui is the state of the terminal reader
+type tui struct {
+	out           *bufio.Writer
+	width, height int
+
+	// File browser
+	dir     string
+	entries []*FileInfo
+	cursor  int
+	offset  int
+
+	// Reading view; path is empty while browsing
+	path    string
+	content string
+	wrapped int // width the lines were wrapped to
+	lines   []tuiLine
+	top     int
+	sel     [2]int // line and word of the selected word, -1 when none
+
+	panel  []string // lookup results shown below the text
+	prompt *[]rune  // lookup prompt being typed, if any
+	status string
+}
+
+// runTUI reads the corpus in the terminal
+func runTUI(args []string) error {
+	flags := flag.NewFlagSet("tui", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: palireader tui [text]")
+		fmt.Fprintln(flags.Output(), "\nBrowse and read the corpus in the terminal. Select words with ←/→ and")
+		fmt.Fprintln(flags.Output(), "press Enter to look them up, or press / to look up any word.")
+	}
+	flags.Parse(args)
+
+	if !term.IsTerminal(int(os.Stdin.Fd())) || !term.IsTerminal(int(os.Stdout.Fd())) {
+		return errors.New("the terminal reader needs an interactive terminal")
+	}
+	if err := loadConfiguredDictionary(); err != nil {
+		return fmt.Errorf("loading dictionary: %w", err)
+	}
+
+	t := &tui{out: bufio.NewWriter(os.Stdout)}
+	t.openDir("")
+	if flags.NArg() > 0 {
+		path, err := findText(flags.Arg(0))
+		if err != nil {
+			return err
+		}
+		t.openDir(filepath.ToSlash(filepath.Dir(path)))
+		if err := t.openText(path); err != nil {
+			return err
+		}
+	}
+
+	state, err := term.MakeRaw(int(os.Stdin.Fd()))
+	if err != nil {
+		return err
+	}
+	// Use the alternate screen and hide the cursor while running
+	fmt.Fprint(t.out, "\x1b[?1049h\x1b[?25l")
+	defer func() {
+		fmt.Fprint(t.out, "\x1b[?25h\x1b[?1049l")
+		t.out.Flush()
+		term.Restore(int(os.Stdin.Fd()), state)
+	}()
+
+	input := bufio.NewReader(os.Stdin)
+	for {
+		t.render()
+		key, err := readKey(input)
+		if err != nil {
+			return err
+		}
+		if !t.handleKey(key) {
+			return nil
+		}
+	}
+}
+
+// readKey reads one key press, decoding the escape sequences of special keys
+func readKey(r *bufio.Reader) (rune, error) {
+	c, _, err := r.ReadRune()
+	if err != nil {
+		return 0, err
+	}
+	switch c {
+	case '\r', '\n':
+		return keyEnter, nil
+	case 127, 8:
+		return keyBackspace, nil
+	case 27:
+	default:
+		return c, nil
+	}
+
+	// A lone Escape arrives without anything buffered after it
+	if r.Buffered() == 0 {
+		return keyEscape, nil
+	}
+	if b, _ := r.ReadByte(); b != '[' && b != 'O' {
+		return keyEscape, nil
+	}
+	seq := ""
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		seq += string(b)
+		if b >= 0x40 && b <= 0x7e {
+			break
+		}
+	}
+	switch seq {
+	case "A":
+		return keyUp, nil
+	case "B":
+		return keyDown, nil
+	case "C":
+		return keyRight, nil
+	case "D":
+		return keyLeft, nil
+	case "H", "1~":
+		return keyHome, nil
+	case "F", "4~":
+		return keyEnd, nil
+	case "5~":
+		return keyPageUp, nil
+	case "6~":
+		return keyPageDown, nil
+	}
+	return 0, nil
+}
+
+func (t *tui) openDir(dir string) {
+	if _, err := corpusName(dir); err != nil {
+		t.status = err.Error()
+		return
+	}
+	t.dir = dir
+	t.entries = buildFileTree(dir).Children
+	t.cursor, t.offset = 0, 0
+}
+
+func (t *tui) openText(path string) error {
+	name, err := corpusName(path)
+	if err != nil {
+		return err
+	}
+	content, err := fs.ReadFile(corpus, name)
+	if err != nil {
+		return err
+	}
+	t.path = filepath.ToSlash(path)
+	t.content = string(content)
+	t.top = 0
+	t.panel = nil
+	t.layout()
+	return nil
+}
+
+// layout wraps the paragraphs of the open text to the terminal width
+func (t *tui) layout() {
+	t.updateSize()
+	t.wrapped = t.width
+	t.lines = nil
+	width := max(t.width-2, 20)
+	for i, text := range paragraphs(t.path, t.content) {
+		if text == "" {
+			continue
+		}
+		if i > 0 && len(t.lines) > 0 {
+			t.lines = append(t.lines, tuiLine{})
+		}
+		var line []rune
+		for _, word := range strings.Fields(text) {
+			w := []rune(word)
+			if len(line) > 0 && len(line)+1+len(w) > width {
+				t.lines = append(t.lines, newTUILine(line))
+				line = nil
+			}
+			if len(line) > 0 {
+				line = append(line, ' ')
+			}
+			line = append(line, w...)
+		}
+		t.lines = append(t.lines, newTUILine(line))
+	}
+	t.sel = [2]int{-1, -1}
+	t.selectVisible()
+}
+
+func newTUILine(text []rune) tuiLine {
+	l := tuiLine{text: text}
+	for i := 0; i < len(text); {
+		if !isWordChar(text[i]) {
+			i++
+			continue
+		}
+		start := i
+		for i < len(text) && isWordChar(text[i]) {
+			i++
+		}
+		if containsLetter(string(text[start:i])) {
+			l.words = append(l.words, [2]int{start, i})
+		}
+	}
+	return l
+}
+
+func (t *tui) updateSize() {
+	w, h, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil {
+		w, h = 80, 24
+	}
+	t.width, t.height = w, h
+}
+
+// textRows is how many rows the text or file list can use
+func (t *tui) textRows() int {
+	return max(t.height-2-len(t.panel), 1)
+}
+
+// selectVisible keeps the selected word on screen
+func (t *tui) selectVisible() {
+	rows := t.textRows()
+	if t.sel[0] >= t.top && t.sel[0] < t.top+rows {
+		return
+	}
+	for i := t.top; i < len(t.lines) && i < t.top+rows; i++ {
+		if len(t.lines[i].words) > 0 {
+			t.sel = [2]int{i, 0}
+			return
+		}
+	}
+	t.sel = [2]int{-1, -1}
+}
+
+// moveWord selects the previous (-1) or next (+1) word, scrolling to it
+func (t *tui) moveWord(step int) {
+	line, word := t.sel[0], t.sel[1]+step
+	if line < 0 {
+		return
+	}
+	for line >= 0 && line < len(t.lines) {
+		if word >= 0 && word < len(t.lines[line].words) {
+			t.sel = [2]int{line, word}
+			if line < t.top {
+				t.top = line
+			} else if line >= t.top+t.textRows() {
+				t.top = line - t.textRows() + 1
+			}
+			return
+		}
+		line += step
+		if step < 0 && line >= 0 {
+			word = len(t.lines[line].words) - 1
+		} else {
+			word = 0
+		}
+	}
+}
+
+func (t *tui) scroll(lines int) {
+	t.top = max(min(t.top+lines, len(t.lines)-t.textRows()), 0)
+	t.selectVisible()
+}
+
+func (t *tui) selectedWord() string {
+	if t.sel[0] < 0 {
+		return ""
+	}
+	l := t.lines[t.sel[0]]
+	span := l.words[t.sel[1]]
+	return cleanWord(string(l.text[span[0]:span[1]]))
+}
+
+// lookup fills the panel with what is known about word
+func (t *tui) lookup(word string) {
+	word = cleanWord(strings.TrimSpace(word))
+	if word == "" {
+		return
+	}
+	t.panel = []string{"── " + word}
+	var glosses []string
+	if dictionary != nil {
+		glosses = dictionary.Lookup(word)
+	}
+	for i, g := range glosses {
+		if i == 3 {
+			t.panel = append(t.panel, fmt.Sprintf("   … %d more", len(glosses)-3))
+			break
+		}
+		t.panel = append(t.panel, "   "+g)
+	}
+	if len(glosses) == 0 {
+		t.panel = append(t.panel, "   Not in the local dictionary.")
+	}
+	for _, p := range config.Providers {
+		if !strings.HasPrefix(p.URL, "/") {
+			t.panel = append(t.panel, "   "+p.LookupURL(word))
+			break
+		}
+	}
+	t.selectVisible()
+}
+
+// handleKey reacts to a key press, returning false to quit
+func (t *tui) handleKey(key rune) bool {
+	t.status = ""
+
+	if t.prompt != nil {
+		switch key {
+		case keyEnter:
+			t.lookup(string(*t.prompt))
+			t.prompt = nil
+		case keyEscape:
+			t.prompt = nil
+		case keyBackspace:
+			if n := len(*t.prompt); n > 0 {
+				*t.prompt = (*t.prompt)[:n-1]
+			}
+		default:
+			if key <= utf8.MaxRune && key >= ' ' {
+				*t.prompt = append(*t.prompt, key)
+			}
+		}
+		return true
+	}
+
+	switch key {
+	case 'q':
+		if t.path == "" {
+			return false
+		}
+		t.path, t.panel = "", nil
+		return true
+	case 3: // Ctrl-C
+		return false
+	case '/':
+		t.prompt = &[]rune{}
+		return true
+	case keyEscape:
+		t.panel = nil
+		return true
+	}
+
+	if t.path == "" {
+		t.browseKey(key)
+	} else {
+		t.readingKey(key)
+	}
+	return true
+}
+
+func (t *tui) browseKey(key rune) {
+	rows := t.textRows()
+	switch key {
+	case keyUp, 'k':
+		t.cursor = max(t.cursor-1, 0)
+	case keyDown, 'j':
+		t.cursor = min(t.cursor+1, len(t.entries)-1)
+	case keyPageUp:
+		t.cursor = max(t.cursor-rows, 0)
+	case keyPageDown, ' ':
+		t.cursor = min(t.cursor+rows, len(t.entries)-1)
+	case keyEnter, keyRight, 'l':
+		if len(t.entries) == 0 {
+			return
+		}
+		entry := t.entries[t.cursor]
+		if entry.IsDir {
+			t.openDir(filepath.ToSlash(entry.Path))
+		} else if err := t.openText(entry.Path); err != nil {
+			t.status = err.Error()
+		}
+	case keyBackspace, keyLeft, 'h':
+		if t.dir != "" {
+			name := filepath.Base(t.dir)
+			t.openDir(filepath.ToSlash(filepath.Dir(t.dir)))
+			if t.dir == "." {
+				t.openDir("")
+			}
+			// Put the cursor back on the directory we came from
+			for i, e := range t.entries {
+				if e.Name == name {
+					t.cursor = i
+				}
+			}
+		}
+	}
+	if t.cursor < t.offset {
+		t.offset = t.cursor
+	} else if t.cursor >= t.offset+rows {
+		t.offset = t.cursor - rows + 1
+	}
+}
+
+func (t *tui) readingKey(key rune) {
+	rows := t.textRows()
+	switch key {
+	case keyUp, 'k':
+		t.scroll(-1)
+	case keyDown, 'j':
+		t.scroll(1)
+	case keyPageUp, 'b':
+		t.scroll(-rows)
+	case keyPageDown, ' ':
+		t.scroll(rows)
+	case keyHome, 'g':
+		t.scroll(-len(t.lines))
+	case keyEnd, 'G':
+		t.scroll(len(t.lines))
+	case keyLeft, 'h':
+		t.moveWord(-1)
+	case keyRight, 'l':
+		t.moveWord(1)
+	case keyEnter:
+		t.lookup(t.selectedWord())
+	case keyBackspace:
+		t.path, t.panel = "", nil
+	}
+}
+
+// render redraws the whole screen
+func (t *tui) render() {
+	t.updateSize()
+	if t.path != "" && t.width != t.wrapped {
+		t.layout()
+		t.scroll(0)
+	}
+	out := t.out
+	fmt.Fprint(out, "\x1b[H\x1b[2J")
+
+	title := "Pali Reader — " + t.dir
+	help := "↑↓ move  Enter open  ← up  / look up  q quit"
+	if t.path != "" {
+		title = "Pali Reader — " + t.path
+		help = "↑↓ scroll  ←→ select word  Enter look up  / look up  q back"
+	}
+	fmt.Fprintf(out, "\x1b[7m%s\x1b[0m\r\n", padRunes(title, t.width))
+
+	rows := t.textRows()
+	for row := 0; row < rows; row++ {
+		if t.path == "" {
+			i := t.offset + row
+			if i < len(t.entries) {
+				e := t.entries[i]
+				name := e.Name
+				if e.IsDir {
+					name += "/"
+				}
+				if i == t.cursor {
+					fmt.Fprintf(out, "\x1b[7m %s\x1b[0m", truncateRunes(name, t.width-2))
+				} else {
+					fmt.Fprintf(out, " %s", truncateRunes(name, t.width-2))
+				}
+			}
+		} else if i := t.top + row; i < len(t.lines) {
+			t.renderLine(i)
+		}
+		fmt.Fprint(out, "\r\n")
+	}
+
+	for _, line := range t.panel {
+		fmt.Fprintf(out, "\x1b[2m%s\x1b[0m\r\n", truncateRunes(line, t.width-1))
+	}
+
+	switch {
+	case t.prompt != nil:
+		fmt.Fprintf(out, "Look up: %s\x1b[7m \x1b[0m", string(*t.prompt))
+	case t.status != "":
+		fmt.Fprint(out, truncateRunes(t.status, t.width-1))
+	default:
+		fmt.Fprintf(out, "\x1b[2m%s\x1b[0m", truncateRunes(help, t.width-1))
+	}
+	out.Flush()
+}
+
+func (t *tui) renderLine(i int) {
+	l := t.lines[i]
+	fmt.Fprint(t.out, " ")
+	if i != t.sel[0] {
+		fmt.Fprint(t.out, string(l.text))
+		return
+	}
+	span := l.words[t.sel[1]]
+	fmt.Fprintf(t.out, "%s\x1b[7m%s\x1b[0m%s",
+		string(l.text[:span[0]]), string(l.text[span[0]:span[1]]), string(l.text[span[1]:]))
+}
+
+// padRunes pads or cuts s to exactly width runes
+func padRunes(s string, width int) string {
+	n := utf8.RuneCountInString(s)
+	if n > width {
+		return string([]rune(s)[:width])
+	}
+	return s + strings.Repeat(" ", width-n)
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// userFile is a jsonFile kept apart for each user, in users/<user>/ of the
+// data directory, and for each browser whose reader is not logged in, in
+// visitors/<id>/, so that readers sharing an instance never see each
+// other's words and places. The one file in the data directory is what
+// commands run without -user read and change.
+type userFile[T any] struct {
+	name string // file name inside each user's directory
+
+	mu    sync.Mutex
+	files map[string]*jsonFile[T] // by user, "" for the shared file
+
+	// visitors is held while a visitor's file is read or changed
+	visitors sync.Mutex
+}
+
+// of returns the file of whoever made the request. A browser that never
+// saved anything has no visitor ID yet, and nothing to read.
+func (u *userFile[T]) of(r *http.Request) *jsonFile[T] {
+	owner := dataOwner(r)
+	if owner == "" {
+		return &jsonFile[T]{}
+	}
+	return u.forUser(owner)
+}
+
+// forUser returns the file of user, of a visitor as dataOwner names them,
+// or the shared one for ""
+func (u *userFile[T]) forUser(user string) *jsonFile[T] {
+	if id, ok := strings.CutPrefix(user, visitorPrefix); ok {
+		// Made afresh each time rather than kept for every browser that
+		// came by, so they take turns on one lock instead of their own
+		return &jsonFile[T]{name: path.Join("visitors", id, u.name), shared: &u.visitors}
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.files == nil {
+		u.files = make(map[string]*jsonFile[T])
+	}
+	f := u.files[user]
+	if f == nil {
+		f = &jsonFile[T]{name: u.name}
+		if user != "" {
+			f.name = path.Join("users", userDirName(user), u.name)
+		}
+		u.files[user] = f
+	}
+	return f
+}
+
+const (
+	visitorCookie = "palireader_visitor"
+	// visitorPrefix marks the visitors among the owners of data. No user
+	// name has a colon, which basic auth and signups do not allow.
+	visitorPrefix = "visitor:"
+)
+
+// visitorIDPattern is what the ID of a visitor looks like: 128 random bits
+var visitorIDPattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+type visitorKey struct{}
+
+// withVisitors gives a browser whose reader is not logged in a visitor ID
+// of its own, in a signed cookie, when it first saves anything. Merely
+// reading leaves no cookie behind, and neither does anything on a private
+// site. Requests from other sites get none, so that they cannot replace a
+// visitor's cookie.
+func withVisitors(next http.Handler) http.Handler {
+	crossOrigin := http.NewCrossOriginProtection()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		saves := r.Method != http.MethodGet && r.Method != http.MethodHead
+		if saves && !config.Private && !staticSite && visitorID(r) == "" && currentUser(r) == "" && crossOrigin.Check(r) == nil {
+			if id, err := startVisit(w, r); err != nil {
+				logf(r.Context(), "Error signing visitor cookie: %v", err)
+			} else {
+				r = r.WithContext(context.WithValue(r.Context(), visitorKey{}, id))
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// startVisit sets a visitor cookie with a new ID, returning the ID
+func startVisit(w http.ResponseWriter, r *http.Request) (string, error) {
+	b := make([]byte, 16)
+	rand.Read(b)
+	id := hex.EncodeToString(b)
+	value, err := sign(id)
+	if err != nil {
+		return "", err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     visitorCookie,
+		Value:    value,
+		Path:     sitePath("/"),
+		MaxAge:   400 * 24 * 60 * 60, // as long as browsers keep cookies
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return id, nil
+}
+
+// visitorID returns the visitor ID of the request's browser, or "" if it
+// has none
+func visitorID(r *http.Request) string {
+	if id, ok := r.Context().Value(visitorKey{}).(string); ok {
+		return id
+	}
+	c, err := r.Cookie(visitorCookie)
+	if err != nil {
+		return ""
+	}
+	if id, ok := verify(c.Value); ok && visitorIDPattern.MatchString(id) {
+		return id
+	}
+	return ""
+}
+
+// dataOwner returns whose data the request reads and saves: the user
+// logged in, or else the visitor of the browser, as visitorPrefix and
+// their ID, or "" for a browser without a visitor ID
+func dataOwner(r *http.Request) string {
+	if user := currentUser(r); user != "" {
+		return user
+	}
+	if id := visitorID(r); id != "" {
+		return visitorPrefix + id
+	}
+	return ""
+}
+
+// userDirName is the name of a user's directory: the user name, escaped
+// so that it is one safe path element
+func userDirName(user string) string {
+	name := url.PathEscape(user)
+	if strings.HasPrefix(name, ".") {
+		name = "%2E" + name[1:]
+	}
+	return name
+}
+
+// checkUser returns an error if the -user given to a command names nobody
+// who can log in
+func checkUser(user string) error {
+	if user == "" {
+		return nil
+	}
+	if config.Auth == nil {
+		return errors.New("-user needs logins, set with \"auth\" in the config file")
+	}
+	if !knownUser(user) {
+		return fmt.Errorf("no user %q", user)
+	}
+	return nil
+}
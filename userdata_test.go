@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWithVisitors(t *testing.T) {
+	useConfig(t, Config{DataDir: t.TempDir()})
+	var owner string
+	handler := withVisitors(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		owner = dataOwner(r)
+	}))
+	visit := func(method, path string) *http.Cookie {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		for _, c := range w.Result().Cookies() {
+			if c.Name == visitorCookie {
+				return c
+			}
+		}
+		return nil
+	}
+
+	// Reading leaves nothing behind
+	for _, path := range []string{"/", "/read/gretil/x.htm", "/search?q=dhamma"} {
+		if c := visit(http.MethodGet, path); c != nil || owner != "" {
+			t.Errorf("GET %s set a visitor cookie", path)
+		}
+	}
+	c := visit(http.MethodPost, "/bookmarks")
+	if c == nil {
+		t.Fatal("saving a bookmark set no visitor cookie")
+	}
+	if id, ok := verify(c.Value); !ok || owner != visitorPrefix+id {
+		t.Errorf("the bookmark was saved for %q, with the cookie %q", owner, c.Value)
+	}
+
+	// A visitor keeps their ID
+	r := httptest.NewRequest(http.MethodPost, "/bookmarks", nil)
+	r.AddCookie(c)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if len(w.Result().Cookies()) > 0 {
+		t.Error("a visitor was given another cookie")
+	}
+
+	config.Private = true
+	if c := visit(http.MethodPost, "/settings"); c != nil || owner != "" {
+		t.Error("a private site set a visitor cookie")
+	}
+}
@@ -0,0 +1,10 @@
+package main
+
+// truncateRunes shortens s to at most n runes, adding an ellipsis if cut
+func truncateRunes(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n]) + "…"
+}
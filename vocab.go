@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// vocabulary holds the words explicitly saved while reading
+var vocabulary = &userFile[[]Lookup]{name: "vocab.json"}
+
+// maxVocabulary caps how many words a reader can save
+const maxVocabulary = 20000
+
+var errVocabularyFull = fmt.Errorf("you have saved %d words already", maxVocabulary)
+
+// VocabGroup lists the saved words found in one text
+type VocabGroup struct {
+	Path  string
+	Title string
+	Words []VocabWord
+}
+
+// VocabWord is a saved word with how often it was saved from the text
+type VocabWord struct {
+	Word  string
+	Gloss string
+	Count int
+}
+
+// handleVocab saves a word (POST) or lists the saved words by text (GET)
+func handleVocab(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		saveVocabWord(w, r)
+		return
+	}
+
+	groups, err := vocabGroups(r)
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := PageData{
+		Title:       "Vocabulary",
+		VocabGroups: groups,
+	}
+	err = templatesFor(r).ExecuteTemplate(w, "vocab", data)
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func saveVocabWord(w http.ResponseWriter, r *http.Request) {
+	word := cleanWord(strings.TrimSpace(r.FormValue("word")))
+	if word == "" || !containsLetter(word) {
+		writeAPIError(w, r, http.StatusBadRequest, "Missing word", map[string]string{"field": "word"})
+		return
+	}
+
+	entry := Lookup{
+		Word:   word,
+		Source: sourcePath(r.FormValue("source")),
+		Time:   time.Now().UTC(),
+	}
+	err := vocabulary.of(r).Update(func(list *[]Lookup) error {
+		if len(*list) >= maxVocabulary {
+			return errVocabularyFull
+		}
+		*list = append(*list, entry)
+		return nil
+	})
+	if errors.Is(err, errVocabularyFull) {
+		writeAPIError(w, r, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+	if err != nil {
+		logf(r.Context(), "Error saving word: %v", err)
+		writeAPIError(w, r, http.StatusInternalServerError, "Cannot save word", nil)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// vocabGroups groups the words the reader saved by the text they were saved
+// from, texts and words in alphabetical order
+func vocabGroups(r *http.Request) ([]VocabGroup, error) {
+	byPath := make(map[string]map[string]int)
+	err := vocabulary.of(r).Read(func(list *[]Lookup) {
+		for _, entry := range *list {
+			path, _, _ := strings.Cut(entry.Source, "#")
+			if byPath[path] == nil {
+				byPath[path] = make(map[string]int)
+			}
+			byPath[path][entry.Word]++
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make([]VocabGroup, 0, len(byPath))
+	for path, counts := range byPath {
+		group := VocabGroup{Path: path, Title: "Elsewhere"}
+		if path != "" {
+			group.Title = textTitle(path)
+		}
+		for word, count := range counts {
+			vw := VocabWord{Word: word, Count: count}
+			if dictionary != nil {
+				vw.Gloss = strings.Join(dictionary.Lookup(word), "; ")
+			}
+			group.Words = append(group.Words, vw)
+		}
+		sort.Slice(group.Words, func(i, j int) bool {
+			return paliLess(group.Words[i].Word, group.Words[j].Word)
+		})
+		groups = append(groups, group)
+	}
+
+	// Words saved outside any text come last
+	sort.Slice(groups, func(i, j int) bool {
+		if (groups[i].Path == "") != (groups[j].Path == "") {
+			return groups[j].Path == ""
+		}
+		return groups[i].Path < groups[j].Path
+	})
+	return groups, nil
+}
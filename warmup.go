@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/fs"
+	"log"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WarmupConfig processes the texts read most and fetches the dictionary
+// entries looked up most before the server starts listening, so that the
+// first readers after a restart wait no longer than the others
+type WarmupConfig struct {
+	Texts int `json:"texts"` // how many texts to process the first page of, 20 if unset
+	Words int `json:"words"` // how many upstream dictionary entries to fetch, 100 if unset
+}
+
+// warmUp processes the first page of the most read texts into the page
+// cache, and fetches the upstream entries of the most looked-up words into
+// the dictionary cache. The local dictionary is loaded before it anyway.
+func warmUp(c *WarmupConfig) {
+	start := time.Now()
+	texts, words := 0, 0
+	if sharedCache == nil && !corpusCache.enabled {
+		log.Println("Warm-up: pages are not cached without a watched corpus or a \"cache\" section")
+	} else {
+		for _, name := range popularTexts(c.Texts) {
+			if err := warmText(name); err != nil {
+				log.Printf("Warm-up: %s: %v", name, err)
+				continue
+			}
+			texts++
+		}
+	}
+	if config.DictProxy != nil {
+		for _, word := range popularWords(c.Words) {
+			upstream := strings.ReplaceAll(config.DictProxy.Upstream, "{word}", url.QueryEscape(word))
+			if _, _, err := cachedDefinition(upstream); err != nil {
+				log.Printf("Warm-up: looking up %q: %v", word, err)
+				continue
+			}
+			words++
+		}
+	}
+	log.Printf("Warmed up %d texts and %d dictionary entries in %s",
+		texts, words, time.Since(start).Round(time.Millisecond))
+}
+
+// warmText processes the first page of a text as a reader would see it
+// and caches it
+func warmText(name string) error {
+	info, err := fs.Stat(corpus, name)
+	if err != nil {
+		return err
+	}
+	_, generation, _ := corpusCache.pageStarts(name)
+	if _, ok := renderedPage(name, info, 1); ok {
+		return nil
+	}
+	content, err := fs.ReadFile(corpus, name)
+	if err != nil {
+		return err
+	}
+	body := extractBody(string(content))
+	starts := textPages(paragraphBreak.Split(body, -1))
+	corpusCache.storePageStarts(name, starts, generation)
+	from, to, _ := pageRange(starts, 1)
+	var processed strings.Builder
+	err = eachProcessed(name, body, from, to, func(chunk string) error {
+		processed.WriteString(chunk)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	keepRenderedPage(name, info, 1, processed.String(), generation)
+	return nil
+}
+
+// popularTexts returns up to n texts, the most read first: by the views
+// counted, or without any by the reader pages served in the access log,
+// when it is written to a file, or else by the texts words were looked up in
+func popularTexts(n int) []string {
+	counts, err := viewCounts()
+	if err != nil {
+		log.Printf("Warm-up: reading the views: %v", err)
+	}
+	if l := config.AccessLog; l != nil && l.File != "" && l.File != "-" && len(counts) == 0 {
+		if err := countReads(l, counts); err != nil {
+			log.Printf("Warm-up: reading the access log: %v", err)
+		}
+	}
+	if len(counts) == 0 {
+		err := lookups.forUser("").Read(func(list *[]Lookup) {
+			for _, l := range *list {
+				if name, _, _ := strings.Cut(l.Source, "#"); name != "" {
+					counts[name]++
+				}
+			}
+		})
+		if err != nil {
+			log.Printf("Warm-up: reading the lookups: %v", err)
+		}
+	}
+
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		if strings.HasSuffix(strings.ToLower(name), ".htm") {
+			names = append(names, name)
+		}
+	}
+	return mostCounted(names, counts, n)
+}
+
+// countReads counts the reader pages served in the access log by text
+func countReads(c *AccessLogConfig, counts map[string]int) error {
+	f, err := os.Open(c.File)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e accessEntry
+		if c.Format == "json" {
+			if json.Unmarshal(scanner.Bytes(), &e) != nil {
+				continue
+			}
+		} else {
+			e.Method = logfmtValue(scanner.Text(), "method")
+			e.Path = logfmtValue(scanner.Text(), "path")
+			e.Status, _ = strconv.Atoi(logfmtValue(scanner.Text(), "status"))
+		}
+		if e.Method != "GET" || e.Status != 200 {
+			continue
+		}
+		p, _, _ := strings.Cut(strings.TrimPrefix(e.Path, config.BasePath), "?")
+		if rest, ok := strings.CutPrefix(p, "/read/"); ok && rest != "" {
+			if rest, err := url.PathUnescape(rest); err == nil {
+				counts[resolveSlugs(rest)]++
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+// logfmtValue returns the value of key in a logfmt line, unquoted
+func logfmtValue(line, key string) string {
+	for line != "" {
+		k, rest, ok := strings.Cut(line, "=")
+		if !ok {
+			return ""
+		}
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			quoted, err := strconv.QuotedPrefix(rest)
+			if err != nil {
+				return ""
+			}
+			value, _ = strconv.Unquote(quoted)
+			rest = rest[len(quoted):]
+		} else {
+			value, rest, _ = strings.Cut(rest, " ")
+		}
+		if k == key {
+			return value
+		}
+		line = strings.TrimLeft(rest, " ")
+	}
+	return ""
+}
+
+// popularWords returns up to n of the words looked up most
+func popularWords(n int) []string {
+	counts := make(map[string]int)
+	err := lookups.forUser("").Read(func(list *[]Lookup) {
+		for _, l := range *list {
+			counts[l.Word]++
+		}
+	})
+	if err != nil {
+		log.Printf("Warm-up: reading the lookups: %v", err)
+	}
+	words := make([]string, 0, len(counts))
+	for word := range counts {
+		words = append(words, word)
+	}
+	return mostCounted(words, counts, n)
+}
+
+// mostCounted sorts keys by their counts, the highest first, and keeps n
+func mostCounted(keys []string, counts map[string]int, n int) []string {
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	return keys[:min(n, len(keys))]
+}
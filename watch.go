@@ -0,0 +1,260 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// corpusCache keeps the folder listings and processed texts of the corpus
+// while a watcher removes whatever changes on disk. Without a watcher
+// nothing is cached.
+var corpusCache = &contentCache{
+	trees:      make(map[string]*FileInfo),
+	texts:      make(map[string]*cachedText),
+	slugTables: make(map[string]*slugTable),
+}
+
+type contentCache struct {
+	mu         sync.RWMutex
+	enabled    bool
+	trees      map[string]*FileInfo   // by corpus name, "." for the root
+	texts      map[string]*cachedText // by corpus name
+	slugTables map[string]*slugTable  // slugs of a folder's entries by its corpus name
+
+	// generation counts the invalidations, so that what was read before
+	// one is not stored after it
+	generation uint64
+}
+
+// state returns the generation of the cache, and whether a watcher keeps
+// it, for what is kept elsewhere while the corpus is unchanged
+func (c *contentCache) state() (generation uint64, enabled bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.generation, c.enabled
+}
+
+// tree returns the cached listing of a folder, or the generation to store
+// a fresh one with
+func (c *contentCache) tree(name string) (*FileInfo, uint64, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	tree, ok := c.trees[name]
+	return tree, c.generation, ok
+}
+
+func (c *contentCache) storeTree(name string, tree *FileInfo, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.enabled && c.generation == generation {
+		c.trees[name] = tree
+	}
+}
+
+// cachedText is what the cache keeps of a text: where its pages start and
+// the pages processed so far
+type cachedText struct {
+	starts []int
+	pages  map[int]string // by number, from 1
+}
+
+// pageStarts returns where the pages of a cached text start, or the
+// generation to store them with
+func (c *contentCache) pageStarts(name string) ([]int, uint64, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if t, ok := c.texts[name]; ok {
+		return t.starts, c.generation, true
+	}
+	return nil, c.generation, false
+}
+
+func (c *contentCache) storePageStarts(name string, starts []int, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.enabled && c.generation == generation {
+		c.texts[name] = &cachedText{starts: starts, pages: make(map[int]string)}
+	}
+}
+
+// page returns the cached processed page n of a text
+func (c *contentCache) page(name string, n int) (string, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if t, ok := c.texts[name]; ok {
+		page, ok := t.pages[n]
+		return page, ok
+	}
+	return "", false
+}
+
+// storePage keeps page n of a text whose page starts are cached
+func (c *contentCache) storePage(name string, n int, page string, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if t, ok := c.texts[name]; ok && c.enabled && c.generation == generation {
+		t.pages[n] = page
+	}
+}
+
+// slugs returns the cached slugs of a folder, or the generation to store
+// fresh ones with
+func (c *contentCache) slugs(name string) (*slugTable, uint64, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	table, ok := c.slugTables[name]
+	return table, c.generation, ok
+}
+
+func (c *contentCache) storeSlugs(name string, table *slugTable, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.enabled && c.generation == generation {
+		c.slugTables[name] = table
+	}
+}
+
+// invalidate forgets the entry at name, everything below it and the
+// listing of its folder; "." forgets everything
+func (c *contentCache) invalidate(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	below := func(key string) bool {
+		return name == "." || key == name || strings.HasPrefix(key, name+"/")
+	}
+	delete(c.trees, path.Dir(name))
+	delete(c.slugTables, path.Dir(name))
+	for key := range c.trees {
+		if below(key) {
+			delete(c.trees, key)
+		}
+	}
+	for key := range c.slugTables {
+		if below(key) {
+			delete(c.slugTables, key)
+		}
+	}
+	for key := range c.texts {
+		if below(key) {
+			delete(c.texts, key)
+		}
+	}
+}
+
+// watchRoot is a folder on disk holding part of the corpus
+type watchRoot struct {
+	dir    string // on disk
+	prefix string // corpus path of dir, "" for the whole corpus
+}
+
+// watchedRoots returns the folders holding the corpus. Archives do not
+// change under the server, so they are left out.
+func watchedRoots() []watchRoot {
+	if len(config.Libraries) == 0 {
+		return []watchRoot{{dir: baseDir}}
+	}
+	var roots []watchRoot
+	for _, l := range config.Libraries {
+		if !isArchive(l.Dir) {
+			roots = append(roots, watchRoot{dir: filepath.Join(l.Dir, filepath.FromSlash(strings.Trim(l.Root, "/"))), prefix: l.Name})
+		}
+	}
+	return roots
+}
+
+// watchCorpus starts caching the corpus and watches its folders for changes,
+// so that edited texts show up at once. If the folders cannot be watched the
+// corpus is read afresh for every request, as before. It reports whether the
+// corpus is watched.
+func watchCorpus() bool {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Println("Not caching the corpus, cannot watch it:", err)
+		return false
+	}
+	roots := watchedRoots()
+	addTree := func(dir string) error {
+		return filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+			if err != nil || !d.IsDir() {
+				return err
+			}
+			return watcher.Add(p)
+		})
+	}
+	for _, root := range roots {
+		if err := addTree(root.dir); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Println("Not caching the corpus, cannot watch it:", err)
+			watcher.Close()
+			return false
+		}
+	}
+
+	corpusCache.mu.Lock()
+	corpusCache.enabled = true
+	corpusCache.mu.Unlock()
+	startChangeLog()
+
+	// corpusNameOf returns the corpus name of a file on disk
+	corpusNameOf := func(file string) (string, bool) {
+		for _, root := range roots {
+			rel, err := filepath.Rel(root.dir, file)
+			if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				continue
+			}
+			name := path.Join(root.prefix, filepath.ToSlash(rel))
+			if name == "" {
+				name = "."
+			}
+			return name, true
+		}
+		return "", false
+	}
+
+	go func() {
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if event.Op == fsnotify.Chmod {
+					continue
+				}
+				if event.Has(fsnotify.Create) {
+					// New folders need watching too
+					if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+						addTree(event.Name)
+					}
+				}
+				if name, ok := corpusNameOf(event.Name); ok {
+					if isMetaFile(name) {
+						// The folder's name shows in the listing above it
+						name = path.Dir(name)
+					}
+					corpusCache.invalidate(name)
+					corpusChanges.publish(name)
+					corpusChangeLog.changed(name)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				// Events may have been lost, so start over
+				log.Println("Watching the corpus:", err)
+				corpusCache.invalidate(".")
+				corpusChanges.publish(".")
+				corpusChangeLog.changed(".")
+			}
+		}
+	}()
+	return true
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// textWork identifies the canonical work a text of the corpus belongs to,
+// in the schemes other editions use: the SuttaCentral ID, the book of the
+// Chaṭṭha Saṅgāyana edition on tipitaka.org where the text's volume matches
+// one, and the text's folder on Ancient Buddhist Texts if it has one.
+type textWork struct {
+	UID  string
+	CSCD string
+	ABT  string
+}
+
+// workPrefixes maps the start of a text's file name to its work, the first
+// matching prefix winning. GRETIL names the volumes of a work alike, as in
+// dighan1u.htm, dighn1ou.htm and dighn1pu.htm for the first volume of the
+// Dīgha Nikāya.
+var workPrefixes = []struct {
+	prefixes []string
+	work     textWork
+}{
+	// Vinaya
+	{[]string{"paraji", "vin3s1"}, textWork{UID: "pli-tv-bu-vb", CSCD: "vin01m"}},
+	{[]string{"pacitt", "vin4s2"}, textWork{UID: "pli-tv-bu-vb", CSCD: "vin02m1"}},
+	{[]string{"mahavg", "vin1ma"}, textWork{UID: "pli-tv-kd", CSCD: "vin02m2"}},
+	{[]string{"cullav", "vin2cu"}, textWork{UID: "pli-tv-kd", CSCD: "vin02m3"}},
+	{[]string{"pariva", "vin5pa"}, textWork{UID: "pli-tv-pvr", CSCD: "vin02m4"}},
+
+	// Sutta
+	{[]string{"dighan1", "dighn1"}, textWork{UID: "dn", CSCD: "s0101m"}},
+	{[]string{"dighan2", "dighn2"}, textWork{UID: "dn", CSCD: "s0102m"}},
+	{[]string{"dighan3", "dighn3"}, textWork{UID: "dn", CSCD: "s0103m"}},
+	{[]string{"majj"}, textWork{UID: "mn"}},
+	{[]string{"samyut1", "samyu1"}, textWork{UID: "sn", CSCD: "s0301m"}},
+	{[]string{"samyut2", "samyu2"}, textWork{UID: "sn", CSCD: "s0302m"}},
+	{[]string{"samyut3", "samyu3"}, textWork{UID: "sn", CSCD: "s0303m"}},
+	{[]string{"samyut4", "samyu4"}, textWork{UID: "sn", CSCD: "s0304m"}},
+	{[]string{"samyut5", "samyu5"}, textWork{UID: "sn", CSCD: "s0305m"}},
+	{[]string{"angu"}, textWork{UID: "an"}},
+	{[]string{"khudd", "khudp"}, textWork{UID: "kp", CSCD: "s0501m", ABT: "Khuddakapatha"}},
+	{[]string{"dhamma", "dhampd"}, textWork{UID: "dhp", CSCD: "s0502m", ABT: "Dhammapada"}},
+	{[]string{"udana"}, textWork{UID: "ud", CSCD: "s0503m", ABT: "Udana"}},
+	{[]string{"itivu"}, textWork{UID: "iti", CSCD: "s0504m"}},
+	{[]string{"suttani", "sutnip"}, textWork{UID: "snp", CSCD: "s0505m"}},
+	{[]string{"vimanav", "vimvat"}, textWork{UID: "vv", CSCD: "s0506m"}},
+	{[]string{"petav", "petvat"}, textWork{UID: "pv", CSCD: "s0507m"}},
+	{[]string{"therag"}, textWork{UID: "thag", CSCD: "s0508m1"}},
+	{[]string{"therig"}, textWork{UID: "thig", CSCD: "s0508m2"}},
+	{[]string{"apadan"}, textWork{UID: "tha-ap"}},
+	{[]string{"buddhav", "budvms"}, textWork{UID: "bv", CSCD: "s0510m1"}},
+	{[]string{"cariyap", "carpit"}, textWork{UID: "cp", CSCD: "s0510m2"}},
+	{[]string{"jatak"}, textWork{UID: "ja"}},
+	{[]string{"mahanid", "nidde1"}, textWork{UID: "mnd", CSCD: "s0512m"}},
+	{[]string{"cullani", "nidde2"}, textWork{UID: "cnd", CSCD: "s0513m"}},
+	{[]string{"patis"}, textWork{UID: "ps", CSCD: "s0514m"}},
+
+	// Abhidhamma
+	{[]string{"dhams"}, textWork{UID: "ds", CSCD: "abh01m"}},
+	{[]string{"vibhan"}, textWork{UID: "vb", CSCD: "abh02m"}},
+	{[]string{"dhatuk"}, textWork{UID: "dt", CSCD: "abh03m1"}},
+	{[]string{"puggal", "pugpan"}, textWork{UID: "pp", CSCD: "abh03m2"}},
+	{[]string{"kathav"}, textWork{UID: "kv", CSCD: "abh03m3"}},
+	{[]string{"yamak"}, textWork{UID: "ya"}},
+	{[]string{"pattha", "patti", "patduk"}, textWork{UID: "patthana"}},
+
+	// Paracanonical
+	{[]string{"milind"}, textWork{UID: "mil", CSCD: "s0517m"}},
+	{[]string{"nettip"}, textWork{UID: "ne", CSCD: "s0515m"}},
+	{[]string{"petako"}, textWork{UID: "pe", CSCD: "s0516m"}},
+}
+
+// workOf returns the work the text at path belongs to
+func workOf(path string) (textWork, bool) {
+	// Commentaries share the names of the texts they comment on
+	if rel := textPath(path); !strings.HasPrefix(rel, "1_tipit/") && !strings.HasPrefix(rel, "2_parcan/") {
+		return textWork{}, false
+	}
+	name := strings.ToLower(textTitle(path))
+	for _, w := range workPrefixes {
+		for _, prefix := range w.prefixes {
+			if strings.HasPrefix(name, prefix) {
+				return w.work, true
+			}
+		}
+	}
+	return textWork{}, false
+}
+
+// EditionSite is another edition or translation of the texts. URL is a
+// template in which {uid}, {cscd} and {abt} are replaced by the IDs of the
+// text's work; the site is only offered for texts whose work has them all.
+type EditionSite struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+// ExternalLink is a link to another edition of the text being read
+type ExternalLink struct {
+	Name string
+	URL  string
+}
+
+var editionPlaceholder = regexp.MustCompile(`\{(uid|cscd|abt)\}`)
+
+// editionLinks returns the configured editions of the text at path
+func editionLinks(path string) []ExternalLink {
+	work, ok := workOf(path)
+	if !ok {
+		return nil
+	}
+	ids := map[string]string{"uid": work.UID, "cscd": work.CSCD, "abt": work.ABT}
+
+	var links []ExternalLink
+	for _, site := range config.Editions {
+		missing := false
+		link := editionPlaceholder.ReplaceAllStringFunc(site.URL, func(m string) string {
+			id := ids[strings.Trim(m, "{}")]
+			missing = missing || id == ""
+			return url.PathEscape(id)
+		})
+		if !missing {
+			links = append(links, ExternalLink{Name: site.Name, URL: link})
+		}
+	}
+	return links
+}